@@ -0,0 +1,358 @@
+// Package client is a typed client for the chirpy API.
+//
+//	c := client.New("http://localhost:8080")
+//	if _, err := c.Login(ctx, "me@example.com", "password"); err != nil {
+//		return err
+//	}
+//	chirp, err := c.CreateChirp(ctx, client.CreateChirpRequest{Body: "hello"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// the request and response bodies are shared with the server
+type (
+	UserRequest         = apitypes.UserRequest
+	UserResponse        = apitypes.UserResponse
+	UserProfile         = apitypes.UserProfile
+	LoginResponse       = apitypes.LoginResponse
+	CreateChirpRequest  = apitypes.CreateChirpRequest
+	ChirpVerdict        = apitypes.ValidateChirpResponse
+	Chirp               = apitypes.Chirp
+	DeleteChirpResponse = apitypes.DeleteChirpResponse
+	ChirpList           = apitypes.ListEnvelope[apitypes.Chirp]
+	Warning             = apitypes.Warning
+	Limits              = apitypes.LimitsResponse
+)
+
+// error codes the API sends in Error.Code
+const (
+	ErrorCodeIdentityAlreadyLinked = apitypes.ErrorCodeIdentityAlreadyLinked
+	ErrorCodeLastLoginMethod       = apitypes.ErrorCodeLastLoginMethod
+	ErrorCodeEmailDomainNotAllowed = apitypes.ErrorCodeEmailDomainNotAllowed
+	ErrorCodeVersionConflict       = apitypes.ErrorCodeVersionConflict
+	ErrorCodeJSONTooDeep           = apitypes.ErrorCodeJSONTooDeep
+	ErrorCodeJSONTooManyFields     = apitypes.ErrorCodeJSONTooManyFields
+	ErrorCodeDeprecatedField       = apitypes.ErrorCodeDeprecatedField
+	ErrorCodeEmailTaken            = apitypes.ErrorCodeEmailTaken
+)
+
+// ErrNotLoggedIn is returned by calls that need a login before Login was called
+var ErrNotLoggedIn = errors.New("client: not logged in")
+
+// Error is a non-2xx response from the API.
+// Code is set when the API sent a machine readable error code.
+type Error struct {
+	StatusCode int
+	Message    string
+	Code       string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("chirpy: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("chirpy: %d: %s", e.StatusCode, e.Message)
+}
+
+// Client talks to one chirpy server. it keeps the tokens of the last
+// login and is safe for concurrent use.
+type Client struct {
+	baseURL string
+	// HTTPClient sends the requests, http.DefaultClient when nil
+	HTTPClient *http.Client
+
+	mux          *sync.Mutex
+	refreshMux   *sync.Mutex // held for a whole refresh
+	token        string
+	refreshToken string
+}
+
+// New returns a client for the server at baseURL
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		mux:        &sync.Mutex{},
+		refreshMux: &sync.Mutex{},
+	}
+}
+
+// Tokens returns the current access and refresh tokens
+func (c *Client) Tokens() (string, string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.token, c.refreshToken
+}
+
+// SetTokens makes the client use tokens from an earlier login
+func (c *Client) SetTokens(token, refreshToken string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.token = token
+	c.refreshToken = refreshToken
+}
+
+// Login logs in and keeps the tokens for later calls
+func (c *Client) Login(ctx context.Context, email, password string) (LoginResponse, error) {
+	var resp LoginResponse
+	err := c.do(ctx, "POST", "/api/login", "", UserRequest{Email: email, Password: password}, &resp)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	c.SetTokens(resp.Token, resp.RefreshToken)
+	return resp, nil
+}
+
+// CreateUser signs up a new user
+func (c *Client) CreateUser(ctx context.Context, email, password string) (UserResponse, error) {
+	var resp UserResponse
+	err := c.do(ctx, "POST", "/api/users", "", UserRequest{Email: email, Password: password}, &resp)
+	return resp, err
+}
+
+// Refresh exchanges the refresh token for a new access token and refresh
+// token, the old refresh token stops working
+func (c *Client) Refresh(ctx context.Context) error {
+	_, refreshToken := c.Tokens()
+	return c.refresh(ctx, refreshToken)
+}
+
+// refresh rotates seen, the refresh token the caller last saw. refreshes
+// run one at a time, and one whose token another refresh already rotated
+// returns without sending it: the server takes a rotated token for a
+// stolen one and ends the session.
+func (c *Client) refresh(ctx context.Context, seen string) error {
+	c.refreshMux.Lock()
+	defer c.refreshMux.Unlock()
+
+	_, refreshToken := c.Tokens()
+	if refreshToken == "" {
+		return ErrNotLoggedIn
+	}
+	if refreshToken != seen {
+		return nil
+	}
+	var resp apitypes.RefreshResponse
+	err := c.do(ctx, "POST", "/api/refresh", refreshToken, nil, &resp)
+	if err != nil {
+		return err
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.token = resp.Token
+	c.refreshToken = resp.RefreshToken
+	return nil
+}
+
+// Revoke revokes the refresh token, the access token stays valid until it expires
+func (c *Client) Revoke(ctx context.Context) error {
+	_, refreshToken := c.Tokens()
+	if refreshToken == "" {
+		return ErrNotLoggedIn
+	}
+	return c.do(ctx, "POST", "/api/revoke", refreshToken, nil, nil)
+}
+
+// CreateChirp posts a chirp as the logged-in user
+func (c *Client) CreateChirp(ctx context.Context, req CreateChirpRequest) (Chirp, error) {
+	var chirp Chirp
+	err := c.authed(ctx, "POST", "/api/chirps", req, &chirp)
+	return chirp, err
+}
+
+// ValidateChirp checks a chirp the way CreateChirp would without creating it
+func (c *Client) ValidateChirp(ctx context.Context, req CreateChirpRequest) (ChirpVerdict, error) {
+	var verdict ChirpVerdict
+	err := c.authed(ctx, "POST", "/api/chirps/validate", req, &verdict)
+	return verdict, err
+}
+
+// GetChirp fetches one chirp
+func (c *Client) GetChirp(ctx context.Context, ID int) (Chirp, error) {
+	var chirp Chirp
+	err := c.do(ctx, "GET", "/api/chirps/"+strconv.Itoa(ID), "", nil, &chirp)
+	return chirp, err
+}
+
+// GetUser fetches the public profile of one user
+func (c *Client) GetUser(ctx context.Context, ID int) (UserProfile, error) {
+	var user UserProfile
+	err := c.do(ctx, "GET", "/api/users/"+strconv.Itoa(ID), "", nil, &user)
+	return user, err
+}
+
+// ListUsers returns the public profiles of every user sorted by ID
+func (c *Client) ListUsers(ctx context.Context) ([]UserProfile, error) {
+	var users []UserProfile
+	err := c.do(ctx, "GET", "/api/users", "", nil, &users)
+	return users, err
+}
+
+// ListChirpsOptions filters and pages GET /api/chirps. zero values are left out.
+type ListChirpsOptions struct {
+	AuthorID int
+	Lang     string
+	SinceID  int    // only chirps with a higher ID
+	Query    string // only chirps containing it, ignoring case
+	Desc     bool   // newest first
+	Limit    int
+	Offset   int
+	Fields   []string // only these chirp fields, all of them when empty
+}
+
+// ListChirps returns one page of chirps
+func (c *Client) ListChirps(ctx context.Context, opts ListChirpsOptions) (ChirpList, error) {
+	q := url.Values{}
+	q.Set("envelope", "true")
+	if opts.AuthorID != 0 {
+		q.Set("author_id", strconv.Itoa(opts.AuthorID))
+	}
+	if opts.Lang != "" {
+		q.Set("lang", opts.Lang)
+	}
+	if opts.SinceID != 0 {
+		q.Set("since_id", strconv.Itoa(opts.SinceID))
+	}
+	if opts.Query != "" {
+		q.Set("q", opts.Query)
+	}
+	if opts.Desc {
+		q.Set("sort", "desc")
+	}
+	if opts.Limit != 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset != 0 {
+		q.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if len(opts.Fields) != 0 {
+		q.Set("fields", strings.Join(opts.Fields, ","))
+	}
+
+	var list ChirpList
+	err := c.do(ctx, "GET", "/api/chirps?"+q.Encode(), "", nil, &list)
+	return list, err
+}
+
+// DeleteChirp deletes one of the logged-in user's chirps.
+// the response carries the token to undo the deletion.
+func (c *Client) DeleteChirp(ctx context.Context, ID int) (DeleteChirpResponse, error) {
+	var resp DeleteChirpResponse
+	err := c.authed(ctx, "DELETE", "/api/chirps/"+strconv.Itoa(ID), nil, &resp)
+	return resp, err
+}
+
+// UpdateChirp replaces the body of a chirp of the logged-in user
+func (c *Client) UpdateChirp(ctx context.Context, ID int, body string) (Chirp, error) {
+	var chirp Chirp
+	err := c.authed(ctx, "PUT", "/api/chirps/"+strconv.Itoa(ID), apitypes.UpdateChirpRequest{Body: body}, &chirp)
+	return chirp, err
+}
+
+// UpdateUser changes the logged-in user's email and password
+func (c *Client) UpdateUser(ctx context.Context, email, password string) (UserResponse, error) {
+	var resp UserResponse
+	err := c.authed(ctx, "PUT", "/api/users", UserRequest{Email: email, Password: password}, &resp)
+	return resp, err
+}
+
+// Limits returns the limits the server enforces on the logged-in user
+func (c *Client) Limits(ctx context.Context) (Limits, error) {
+	var limits Limits
+	err := c.authed(ctx, "GET", "/api/users/me/limits", nil, &limits)
+	return limits, err
+}
+
+// authed sends a request with the access token. on a 401 it refreshes
+// the access token once and retries.
+func (c *Client) authed(ctx context.Context, method, path string, body, out any) error {
+	token, refreshToken := c.Tokens()
+	if token == "" {
+		return ErrNotLoggedIn
+	}
+
+	err := c.do(ctx, method, path, token, body, out)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || refreshToken == "" {
+		return err
+	}
+	if refreshErr := c.refresh(ctx, refreshToken); refreshErr != nil {
+		return err
+	}
+	token, _ = c.Tokens()
+	return c.do(ctx, method, path, token, body, out)
+}
+
+// do sends one request and decodes a 2xx json response into out when given
+func (c *Client) do(ctx context.Context, method, path, token string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return newError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// newError reads an error response, which is either a json
+// apitypes.ErrorResponse or a plain text message
+func newError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(b)),
+	}
+	var errResp apitypes.ErrorResponse
+	if json.Unmarshal(b, &errResp) == nil && errResp.Error != "" {
+		apiErr.Message = errResp.Error
+		apiErr.Code = errResp.ErrorCode
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
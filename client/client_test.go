@@ -0,0 +1,180 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/client"
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/api"
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+const testPassword = "correct horse battery staple"
+
+// testServer is the chirpy API in-process on a json database in a temp
+// dir, counting the refreshes it answers
+type testServer struct {
+	*httptest.Server
+	clock     *clock.Fake
+	refreshes *atomic.Int64
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-jwt-secret")
+	t.Setenv("JWT_EXPIRY_SECONDS", "60")
+	t.Setenv("RATE_LIMIT_LOGIN_PER_MINUTE", "0")
+	t.Setenv("RATE_LIMIT_CHIRPS_PER_MINUTE", "0")
+	for _, key := range []string{"JWT_SECRETS", "DATABASE_URL", "PLATFORM", "CORS_ALLOWED_ORIGINS", "TRUST_PROXY", "MAX_INFLIGHT_REQUESTS"} {
+		t.Setenv(key, "")
+	}
+
+	dir := t.TempDir()
+	db, err := database.NewDB(filepath.Join(dir, "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	srv, err := api.NewServer(db, api.Config{DataDir: dir, Clock: clk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &testServer{clock: clk, refreshes: &atomic.Int64{}}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/refresh" {
+			ts.refreshes.Add(1)
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		ts.Close()
+		db.Close()
+	})
+	return ts
+}
+
+// loggedIn returns a client logged in as a new user
+func (ts *testServer) loggedIn(t *testing.T, email string) *client.Client {
+	t.Helper()
+	c := client.New(ts.URL)
+	if _, err := c.CreateUser(context.Background(), email, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(context.Background(), email, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClientChirps(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	c := ts.loggedIn(t, "alice@example.com")
+
+	chirp, err := c.CreateChirp(ctx, client.CreateChirpRequest{Body: "hello from the client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetChirp(ctx, chirp.ID)
+	if err != nil || got.Body != chirp.Body {
+		t.Fatalf("GetChirp = %+v, %v", got, err)
+	}
+	edited, err := c.UpdateChirp(ctx, chirp.ID, "edited")
+	if err != nil || edited.Body != "edited" {
+		t.Fatalf("UpdateChirp = %+v, %v", edited, err)
+	}
+	list, err := c.ListChirps(ctx, client.ListChirpsOptions{AuthorID: chirp.AuthorID})
+	if err != nil || len(list.Items) != 1 || list.Items[0].ID != chirp.ID {
+		t.Fatalf("ListChirps = %+v, %v", list, err)
+	}
+	if _, err := c.DeleteChirp(ctx, chirp.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.GetChirp(ctx, chirp.ID)
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("GetChirp of a deleted chirp: %v, want a 404 *client.Error", err)
+	}
+	if _, err := client.New(ts.URL).CreateChirp(ctx, client.CreateChirpRequest{Body: "x"}); !errors.Is(err, client.ErrNotLoggedIn) {
+		t.Fatalf("CreateChirp without a login: %v, want client.ErrNotLoggedIn", err)
+	}
+}
+
+func TestClientErrorCode(t *testing.T) {
+	ts := newTestServer(t)
+	ts.loggedIn(t, "alice@example.com")
+
+	_, err := client.New(ts.URL).CreateUser(context.Background(), "ALICE@example.com", testPassword)
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict || apiErr.Code != client.ErrorCodeEmailTaken {
+		t.Fatalf("CreateUser with a taken email: %v, want 409 %s", err, client.ErrorCodeEmailTaken)
+	}
+}
+
+func TestClientRefreshesExpiredToken(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	c := ts.loggedIn(t, "alice@example.com")
+	token, refreshToken := c.Tokens()
+
+	ts.clock.Advance(2 * time.Minute)
+	if _, err := c.Limits(ctx); err != nil {
+		t.Fatal(err)
+	}
+	newToken, newRefreshToken := c.Tokens()
+	if newToken == token || newRefreshToken == refreshToken || ts.refreshes.Load() != 1 {
+		t.Fatalf("after a 401 the client sent %d refreshes and kept its tokens: %v", ts.refreshes.Load(), newToken == token)
+	}
+
+	if err := c.Revoke(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ts.clock.Advance(2 * time.Minute)
+	_, err := c.Limits(ctx)
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("a call after the session was revoked: %v, want a 401 *client.Error", err)
+	}
+}
+
+func TestClientConcurrentRefreshIsSingleFlight(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	c := ts.loggedIn(t, "alice@example.com")
+
+	ts.clock.Advance(2 * time.Minute)
+	const calls = 10
+	errs := make([]error, calls)
+	var wg sync.WaitGroup
+	for i := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = c.CreateChirp(ctx, client.CreateChirpRequest{Body: "racing"})
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := ts.refreshes.Load(); n != 1 {
+		t.Fatalf("%d calls that got a 401 at once sent %d refreshes, want 1", calls, n)
+	}
+
+	// the session survived, past the server's grace window too
+	ts.clock.Advance(2 * time.Minute)
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,274 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Backup is every user, identity and chirp of a store with the ID counters,
+// what Export returns and Import replaces the store with. chirps include
+// the tombstones.
+type Backup struct {
+	Users       []User     `json:"users"`
+	Identities  []Identity `json:"identities"`
+	Chirps      []Chirp    `json:"chirps"`
+	NextUserID  int        `json:"next_user_id"`
+	NextChirpID int        `json:"next_chirp_id"`
+	// Redacted backups have no password hashes, refresh tokens or totp
+	// secrets. they can't be imported, every account would be locked out.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// ErrInvalidBackup is returned by Import for backups that don't hold
+// together, wrapped with what is wrong
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// newBackup sorts the records by ID, so the same data always exports the
+// same, and redacts them unless includeSecrets
+func newBackup(users []User, identities []Identity, chirps []Chirp, nextUserID, nextChirpID int, includeSecrets bool) Backup {
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
+	sort.Slice(identities, func(i, j int) bool {
+		return identityKey(identities[i].Provider, identities[i].ProviderUserID) < identityKey(identities[j].Provider, identities[j].ProviderUserID)
+	})
+	b := Backup{Users: users, Identities: identities, Chirps: chirps, NextUserID: nextUserID, NextChirpID: nextChirpID}
+	if !includeSecrets {
+		for i := range b.Users {
+			user := &b.Users[i]
+			user.Password = nil
+			user.revokeSessions()
+			user.TOTPSecret = ""
+			user.TOTPPendingSecret = ""
+			user.TOTPBackupCodes = nil
+		}
+		b.Redacted = true
+	}
+	return b
+}
+
+// validate checks that IDs and emails are unique, emails ignoring case,
+// and that every chirp, identity and account link refers to a user of the
+// backup. chirps of deleted accounts
+// have author ID 0. it also catches the counters up with the IDs.
+func (b *Backup) validate() error {
+	if b.Redacted {
+		return fmt.Errorf("%w: secrets are redacted, export with include_secrets=true", ErrInvalidBackup)
+	}
+	users := make(map[int]User, len(b.Users))
+	emails := make(map[string]int, len(b.Users)) // user ID by lowercased email
+	for _, user := range b.Users {
+		if user.ID <= 0 {
+			return fmt.Errorf("%w: user ID %d", ErrInvalidBackup, user.ID)
+		}
+		if _, ok := users[user.ID]; ok {
+			return fmt.Errorf("%w: user %d is there twice", ErrInvalidBackup, user.ID)
+		}
+		if user.Email == "" {
+			return fmt.Errorf("%w: user %d has no email", ErrInvalidBackup, user.ID)
+		}
+		email := strings.ToLower(user.Email)
+		if other, ok := emails[email]; ok {
+			return fmt.Errorf("%w: users %d and %d have the same email", ErrInvalidBackup, other, user.ID)
+		}
+		emails[email] = user.ID
+		users[user.ID] = user
+		b.NextUserID = max(b.NextUserID, user.ID)
+	}
+	for _, user := range b.Users {
+		for _, linked := range user.LinkedAccounts {
+			if _, ok := users[linked]; !ok || linked == user.ID {
+				return fmt.Errorf("%w: user %d links unknown user %d", ErrInvalidBackup, user.ID, linked)
+			}
+		}
+	}
+
+	identities := make(map[string]struct{}, len(b.Identities))
+	for _, identity := range b.Identities {
+		key := identityKey(identity.Provider, identity.ProviderUserID)
+		if _, ok := identities[key]; ok {
+			return fmt.Errorf("%w: identity %s is there twice", ErrInvalidBackup, key)
+		}
+		if _, ok := users[identity.UserID]; !ok {
+			return fmt.Errorf("%w: identity %s belongs to unknown user %d", ErrInvalidBackup, key, identity.UserID)
+		}
+		identities[key] = struct{}{}
+	}
+
+	chirps := make(map[int]bool, len(b.Chirps))
+	for _, chirp := range b.Chirps {
+		if chirp.ID <= 0 {
+			return fmt.Errorf("%w: chirp ID %d", ErrInvalidBackup, chirp.ID)
+		}
+		if chirps[chirp.ID] {
+			return fmt.Errorf("%w: chirp %d is there twice", ErrInvalidBackup, chirp.ID)
+		}
+		if _, ok := users[chirp.AuthorID]; !ok && chirp.AuthorID != 0 {
+			return fmt.Errorf("%w: chirp %d has unknown author %d", ErrInvalidBackup, chirp.ID, chirp.AuthorID)
+		}
+		chirps[chirp.ID] = true
+		b.NextChirpID = max(b.NextChirpID, chirp.ID)
+	}
+	return nil
+}
+
+// Export returns a copy of the database file as a Backup. it holds the
+// read lock while it copies, so no write lands halfway through.
+func (db *DB) Export(includeSecrets bool) (Backup, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	c, err := db.loadFile()
+	if err != nil {
+		return Backup{}, err
+	}
+	users := make([]User, 0, len(c.users.Users))
+	for _, user := range c.users.clone().Users {
+		users = append(users, user)
+	}
+	identities := make([]Identity, 0, len(c.users.Identities))
+	for _, identity := range c.users.Identities {
+		identities = append(identities, identity)
+	}
+	chirps := make([]Chirp, 0, len(c.chirps.Chirps))
+	for _, chirp := range c.chirps.Chirps {
+		chirps = append(chirps, chirp)
+	}
+	return newBackup(users, identities, chirps, c.users.NextID, c.chirps.NextID, includeSecrets), nil
+}
+
+// Import replaces every user, identity and chirp with the ones of b in a
+// single write of the database file. b is validated first, an invalid
+// backup is ErrInvalidBackup and leaves the file as it was.
+func (db *DB) Import(b Backup) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if err := b.validate(); err != nil {
+		return err
+	}
+	c, err := db.loadFile()
+	if err != nil {
+		return err
+	}
+	chirps := DBStructure{Chirps: make(map[int]Chirp, len(b.Chirps)), NextID: b.NextChirpID, Revision: c.chirps.Revision}
+	chirps.touch(db.now())
+	for _, chirp := range b.Chirps {
+		chirps.Chirps[chirp.ID] = chirp
+	}
+	users := DBUserStructure{
+		Users:      make(map[int]User, len(b.Users)),
+		Identities: make(map[string]Identity, len(b.Identities)),
+		NextID:     b.NextUserID,
+	}
+	for _, user := range b.Users {
+		users.Users[user.ID] = user
+	}
+	for _, identity := range b.Identities {
+		users.Identities[identityKey(identity.Provider, identity.ProviderUserID)] = identity
+	}
+	err = db.saveFile(cachedFile{chirps: newCachedChirps(chirps), users: users.clone()})
+	if err != nil {
+		return err
+	}
+	db.emit(Event{Type: CollectionReset, At: db.now()})
+	return nil
+}
+
+// Export returns the users, identities and chirps as a Backup. it holds the
+// write lock while it reads, so no write lands halfway through.
+func (s *SQLiteStore) Export(includeSecrets bool) (Backup, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	users, err := s.GetUser()
+	if err != nil {
+		return Backup{}, err
+	}
+	chirps, err := queryChirps(s.db, `SELECT `+chirpColumns+` FROM chirps`)
+	if err != nil {
+		return Backup{}, err
+	}
+	rows, err := s.db.Query(`SELECT provider, provider_user_id, user_id FROM identities`)
+	if err != nil {
+		return Backup{}, err
+	}
+	defer rows.Close()
+	identities := make([]Identity, 0)
+	for rows.Next() {
+		var identity Identity
+		if err := rows.Scan(&identity.Provider, &identity.ProviderUserID, &identity.UserID); err != nil {
+			return Backup{}, err
+		}
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return Backup{}, err
+	}
+
+	next := map[string]int{}
+	seqRows, err := s.db.Query(`SELECT name, seq FROM sqlite_sequence WHERE name IN ('users', 'chirps')`)
+	if err != nil {
+		return Backup{}, err
+	}
+	defer seqRows.Close()
+	for seqRows.Next() {
+		var name string
+		var seq int
+		if err := seqRows.Scan(&name, &seq); err != nil {
+			return Backup{}, err
+		}
+		next[name] = seq
+	}
+	if err := seqRows.Err(); err != nil {
+		return Backup{}, err
+	}
+	return newBackup(users, identities, chirps, next["users"], next["chirps"], includeSecrets), nil
+}
+
+// Import replaces every user, identity and chirp with the ones of b in one
+// transaction. b is validated first, an invalid backup is ErrInvalidBackup
+// and leaves the store as it was.
+func (s *SQLiteStore) Import(b Backup) error {
+	if err := b.validate(); err != nil {
+		return err
+	}
+	return s.write(func(tx *sql.Tx) ([]Event, error) {
+		for _, table := range []string{"users", "identities", "chirps"} {
+			if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+				return nil, err
+			}
+		}
+		for _, user := range b.Users {
+			if _, err := insertUser(tx, user, true); err != nil {
+				return nil, err
+			}
+		}
+		for _, identity := range b.Identities {
+			_, err := tx.Exec(`INSERT INTO identities (provider, provider_user_id, user_id) VALUES (?, ?, ?)`,
+				identity.Provider, identity.ProviderUserID, identity.UserID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, chirp := range b.Chirps {
+			if _, err := insertChirp(tx, chirp, true); err != nil {
+				return nil, err
+			}
+		}
+		// inserting with IDs moved the counters, they are set last
+		for table, next := range map[string]int{"users": b.NextUserID, "chirps": b.NextChirpID} {
+			_, err := tx.Exec(`DELETE FROM sqlite_sequence WHERE name = ?`, table)
+			if err != nil {
+				return nil, err
+			}
+			_, err = tx.Exec(`INSERT INTO sqlite_sequence (name, seq) VALUES (?, ?)`, table, next)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return []Event{{Type: CollectionReset, At: s.now()}}, nil
+	})
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+func TestImportRejectsDuplicates(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		alice := User{ID: 1, Email: "alice@example.com", Password: []byte("hash")}
+		tests := []struct {
+			name   string
+			backup Backup
+		}{
+			{"same email", Backup{Users: []User{alice, {ID: 2, Email: "alice@example.com", Password: []byte("hash")}}}},
+			{"email in another case", Backup{Users: []User{alice, {ID: 2, Email: "Alice@Example.COM", Password: []byte("hash")}}}},
+			{"same user ID", Backup{Users: []User{alice, {ID: 1, Email: "bob@example.com", Password: []byte("hash")}}}},
+			{"same identity", Backup{Users: []User{alice}, Identities: []Identity{
+				{Provider: "github", ProviderUserID: "42", UserID: 1},
+				{Provider: "github", ProviderUserID: "42", UserID: 1},
+			}}},
+		}
+		for _, tt := range tests {
+			if err := s.Import(tt.backup); !errors.Is(err, ErrInvalidBackup) {
+				t.Errorf("%s: Import = %v, want ErrInvalidBackup", tt.name, err)
+			}
+		}
+		if users, err := s.GetUser(); err != nil || len(users) != 0 {
+			t.Fatalf("users after the rejected imports = %v, %v", users, err)
+		}
+
+		// the same identity ID with another provider is a different identity
+		err := s.Import(Backup{Users: []User{alice, {ID: 2, Email: "bob@example.com", Password: []byte("hash")}}, Identities: []Identity{
+			{Provider: "github", ProviderUserID: "42", UserID: 1},
+			{Provider: "google", ProviderUserID: "42", UserID: 2},
+		}})
+		if err != nil {
+			t.Fatalf("Import: %v", err)
+		}
+	})
+}
+
+func TestSQLiteEmailsAreUnique(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "chirpy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	mustUser(t, s, "alice@example.com")
+
+	// the index catches what slips past the checks of the store
+	_, err = s.db.Exec(`INSERT INTO users (email, email_key) VALUES (?, ?)`, "ALICE@example.com", "alice@example.com")
+	if err == nil {
+		t.Fatal("a second row with the same email_key was inserted")
+	}
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"maps"
+	"slices"
+	"sort"
+)
+
+// fileCache is the parsed database file. the first load fills it and every
+// write replaces it, so reads don't parse the file again. a failed write
+// drops it and the next load reads what really is on disk.
+// loads hand out clones, so a method that changes what it loaded and then
+// fails can't leave the change in the cache.
+type fileCache struct {
+	structure any
+}
+
+// cached returns the cached structure, nil if there is none
+func (db *DB) cached() any {
+	c := db.cache.Load()
+	if c == nil {
+		return nil
+	}
+	return c.structure
+}
+
+func (db *DB) setCache(structure any) {
+	db.cache.Store(&fileCache{structure: structure})
+}
+
+// dropCache makes the next load read the file
+func (db *DB) dropCache() {
+	db.cache.Store(nil)
+}
+
+// cachedChirps is what the chirps are cached as: the chirps plus the live
+// ones sorted by ID, the order queries walk them in. it is built once per
+// write and never changed after, so readers holding the read lock can use
+// it without copying.
+type cachedChirps struct {
+	DBStructure
+	live []Chirp
+}
+
+func newCachedChirps(dbStructure DBStructure) cachedChirps {
+	live := make([]Chirp, 0, len(dbStructure.Chirps))
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.DeletedAt == nil {
+			live = append(live, chirp)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].ID < live[j].ID })
+	return cachedChirps{DBStructure: dbStructure, live: live}
+}
+
+// readChirps returns the cached chirps, loading the file first if needed.
+// it is shared: callers hold the lock and must not change it.
+func (db *DB) readChirps() (cachedChirps, error) {
+	c, err := db.loadFile()
+	if err != nil {
+		return cachedChirps{}, err
+	}
+	return c.chirps, nil
+}
+
+// clone copies the chirps map. chirps hold no slices and their time
+// pointers are only ever replaced, so the values are copied as they are.
+func (s DBStructure) clone() DBStructure {
+	return DBStructure{Chirps: maps.Clone(s.Chirps), NextID: s.NextID, Revision: s.Revision, ModifiedAt: s.ModifiedAt}
+}
+
+// clone deep copies the users and identities
+func (s DBUserStructure) clone() DBUserStructure {
+	users := make(IDMap[User], len(s.Users))
+	for id, user := range s.Users {
+		users[id] = user.clone()
+	}
+	return DBUserStructure{
+		Users:      users,
+		Identities: maps.Clone(s.Identities),
+		NextID:     s.NextID,
+	}
+}
+
+// clone copies the slices of one user, for lookups that hand out a single
+// user without copying all of them
+func (u User) clone() User {
+	u.Password = slices.Clone(u.Password)
+	u.LinkedAccounts = slices.Clone(u.LinkedAccounts)
+	u.Sessions = slices.Clone(u.Sessions)
+	u.TOTPBackupCodes = slices.Clone(u.TOTPBackupCodes)
+	return u
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+func TestStoreConcurrentCreateAndGetChirps(t *testing.T) {
+	const writers, readers, perWriter = 4, 4, 15
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		author := mustUser(t, s, "author@example.com")
+
+		done := make(chan struct{})
+		var writing, reading sync.WaitGroup
+		for w := range writers {
+			writing.Add(1)
+			go func() {
+				defer writing.Done()
+				for i := range perWriter {
+					if _, err := s.CreateChirp(fmt.Sprintf("chirp %d of writer %d", i, w), author.ID, "en", "web"); err != nil {
+						t.Errorf("CreateChirp: %v", err)
+						return
+					}
+				}
+			}()
+		}
+		for range readers {
+			reading.Add(1)
+			go func() {
+				defer reading.Done()
+				seen := 0
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					chirps, err := s.GetChirps()
+					if err != nil {
+						t.Errorf("GetChirps: %v", err)
+						return
+					}
+					ids := chirpIDs(chirps)
+					if !slices.IsSorted(ids) || len(slices.Compact(slices.Clone(ids))) != len(ids) {
+						t.Errorf("GetChirps returned IDs %v, want them sorted and unique", ids)
+						return
+					}
+					if len(chirps) < seen {
+						t.Errorf("GetChirps returned %d chirps after %d", len(chirps), seen)
+						return
+					}
+					seen = len(chirps)
+					// what a reader gets is its own, the race detector
+					// catches this when it shares the cache
+					for i := range chirps {
+						chirps[i].Body = ""
+					}
+				}
+			}()
+		}
+		writing.Wait()
+		close(done)
+		reading.Wait()
+
+		chirps, err := s.GetChirps()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chirps) != writers*perWriter {
+			t.Fatalf("got %d chirps, want %d", len(chirps), writers*perWriter)
+		}
+		for _, chirp := range chirps {
+			if chirp.Body == "" {
+				t.Fatalf("chirp %d lost its body to a reader", chirp.ID)
+			}
+		}
+	})
+}
+
+func TestDBCacheMatchesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	author := mustUser(t, db, "author@example.com")
+	for i := range 5 {
+		mustChirp(t, db, author.ID, fmt.Sprintf("chirp %d", i))
+	}
+	if err := db.DeleteDB(author.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := db.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := reopened.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(chirpIDs(cached), chirpIDs(fromFile)) || !slices.Equal(chirpIDs(cached), []int{1, 3, 4, 5}) {
+		t.Fatalf("cached chirps %v, in the file %v, want 1, 3, 4, 5", chirpIDs(cached), chirpIDs(fromFile))
+	}
+}
+
+// countLoads counts the file reads of db
+func countLoads(db *DB) *int {
+	loads := new(int)
+	db.SetFileObserver(func(op string, _ time.Duration, _ int, _ error) {
+		if op == "load_file" {
+			*loads++
+		}
+	})
+	return loads
+}
+
+func TestSideDBsCached(t *testing.T) {
+	dir := t.TempDir()
+	follows, err := NewFollowDB(filepath.Join(dir, "followDatabase.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists, err := NewListDB(filepath.Join(dir, "listDatabase.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := NewJobDB(filepath.Join(dir, "jobDatabase.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	followLoads, listLoads, jobLoads := countLoads(follows), countLoads(lists), countLoads(jobs)
+
+	if _, err := follows.AddFollow(1, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	list, err := lists.CreateList(1, "friends", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lists.AddListMember(list.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobs.EnqueueJob("purge", []byte(`{}`), testEpoch); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if visible, err := follows.FollowingOf(3, 1); err != nil || !slices.Equal(visible, []int{2}) {
+			t.Fatalf("FollowingOf = %v, %v", visible, err)
+		}
+		got, err := lists.GetList(list.ID)
+		if err != nil || !slices.Equal(got.Members, []int{2}) {
+			t.Fatalf("GetList = %+v, %v", got, err)
+		}
+		// what a reader gets is its own
+		got.Members[0] = 99
+		pending, err := jobs.GetJobs(JobPending)
+		if err != nil || len(pending) != 1 {
+			t.Fatalf("GetJobs = %+v, %v", pending, err)
+		}
+		pending[0].Payload[0] = 'x'
+	}
+	if *followLoads != 0 || *listLoads != 0 || *jobLoads != 0 {
+		t.Fatalf("files were read %d, %d and %d times, want every read from the cache", *followLoads, *listLoads, *jobLoads)
+	}
+
+	// a reopened database reads each file once and finds the writes
+	reopened, err := NewListDB(filepath.Join(dir, "listDatabase.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loads := countLoads(reopened)
+	for range 2 {
+		if got, err := reopened.GetList(list.ID); err != nil || !slices.Equal(got.Members, []int{2}) {
+			t.Fatalf("GetList after reopening = %+v, %v", got, err)
+		}
+	}
+	if *loads != 1 {
+		t.Fatalf("reopened list file was read %d times, want once", *loads)
+	}
+	reopenedJobs, err := NewJobDB(filepath.Join(dir, "jobDatabase.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := reopenedJobs.GetJobs(JobPending); err != nil || len(pending) != 1 || string(pending[0].Payload) != `{}` {
+		t.Fatalf("jobs after reopening = %+v, %v", pending, err)
+	}
+}
+
+func TestGetUserByIDCopies(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := mustUser(t, db, "alice@example.com")
+	user, err := db.GetUserByID(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Password[0] ^= 0xff
+	byEmail, err := db.GetUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := db.GetUserByID(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(again.Password, alice.Password) || !slices.Equal(byEmail.Password, alice.Password) {
+		t.Fatal("changing a looked up user changed the cached one")
+	}
+	if _, err := db.GetUserByID(99); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("GetUserByID of a missing user: %v", err)
+	}
+}
@@ -1,43 +1,62 @@
 package database
 
 import (
-	"encoding/json"
 	"errors"
-	"io/fs"
-	"os"
-	"sort"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/clock"
 )
 
 type Chirp struct {
-	AuthorID int    `json:"author_id"`
-	Body     string `json:"body"`
-	ID       int    `json:"id"`
+	AuthorID  int        `json:"author_id"`
+	Body      string     `json:"body"`
+	ID        int        `json:"id"`
+	Lang      string     `json:"lang,omitempty"`       // BCP-47 tag, unset on chirps created before it was tracked
+	CreatedAt *time.Time `json:"created_at,omitempty"` // unset on chirps created before it was tracked
+	UpdatedAt *time.Time `json:"updated_at,omitempty"` // set once the body was edited
+	BumpedAt  *time.Time `json:"bumped_at,omitempty"`  // last bump, only feeds order by it
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	SourceURL string     `json:"source_url,omitempty"` // set on chirps mirrored from another instance
+	Source    string     `json:"source,omitempty"`     // the client it was posted with, unset before it was tracked
 }
 
+var (
+	// ErrChirpNotFound is returned for chirps that don't exist or are deleted
+	ErrChirpNotFound = errors.New("chirp not found")
+	// ErrNotChirpAuthor is returned when someone else tries to change a chirp
+	ErrNotChirpAuthor = errors.New("forbidden")
+	// ErrBumpTooSoon is returned when a chirp is bumped again within the cooldown
+	ErrBumpTooSoon = errors.New("chirp was bumped too recently")
+)
+
 type DB struct {
-	path string
-	mux  *sync.RWMutex
-}
-type DBStructure struct {
-	Chirps map[int]Chirp `json:"chirps"`
+	path     string
+	mux      *sync.RWMutex
+	observer FileObserver
+	events   *Bus
+	degraded atomic.Bool // set while writes fail with ENOSPC
+	clock    clock.Clock // the wall clock when nil
+	indent   string      // of the file, compact when empty
+	cache    atomic.Pointer[fileCache]
 }
 
-// NewDB creates database connection and creates database file if does not exist.
-func NewDB(path string) (*DB, error) {
-	db := &DB{
-		path: path,
-		mux:  &sync.RWMutex{},
-	}
-	err := db.ensureDB()
-	if err != nil {
-		return nil, err
-	}
-	return db, nil
+// FileObserver is called after every read or write of the database file
+// with the operation ("load_file" or "write_file"), how long it took,
+// the size of the file contents and the error if any.
+type FileObserver func(op string, duration time.Duration, size int, err error)
+type DBStructure struct {
+	Chirps IDMap[Chirp] `json:"chirps"`
+	NextID int          `json:"next_chirp_id"` // the last ID handed out, IDs are never reused
+	// Revision and ModifiedAt are the ChirpRevision, stamped by writeDB
+	Revision   int        `json:"chirp_revision,omitempty"`
+	ModifiedAt *time.Time `json:"chirps_modified_at,omitempty"`
 }
 
 // create a new chirp and saves it to disk
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
+func (db *DB) CreateChirp(body string, authorID int, lang string, source string) (Chirp, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
@@ -46,98 +65,229 @@ func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
 	if err != nil {
 		return Chirp{}, err
 	}
-	nextID := len(dbStructure.Chirps) + 1
+	if err := db.requireAuthor(authorID); err != nil {
+		return Chirp{}, err
+	}
+	nextID := dbStructure.newID()
 
+	now := db.now()
 	dbStructure.Chirps[nextID] = Chirp{
-		AuthorID: authorID,
-		Body:     body,
-		ID:       nextID,
+		AuthorID:  authorID,
+		Body:      body,
+		ID:        nextID,
+		Lang:      lang,
+		CreatedAt: &now,
+		Source:    source,
 	}
 	err = db.writeDB(dbStructure)
 	if err != nil {
 		return Chirp{}, err
 	}
+	db.emit(db.chirpEvents(ChirpCreated, []Chirp{dbStructure.Chirps[nextID]})...)
 
 	return dbStructure.Chirps[nextID], nil
 }
 
-// GetChirps returns all chirps in the database
-func (db *DB) GetChirps() ([]Chirp, error) {
+// ImportChirps saves a batch of chirps for authorID in a single write,
+// keeping each chirp's body and creation time and assigning new IDs in order.
+func (db *DB) ImportChirps(authorID int, chirps []Chirp) ([]Chirp, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
-	// load current db and range all data in map to slice sort by ID and return
 	dbStructure, err := db.loadDB()
 	if err != nil {
 		return nil, err
 	}
+	if err := db.requireAuthor(authorID); err != nil {
+		return nil, err
+	}
 
-	chirps := make([]Chirp, 0, len(dbStructure.Chirps))
-	for _, chirp := range dbStructure.Chirps {
-		chirps = append(chirps, chirp)
+	imported := make([]Chirp, 0, len(chirps))
+	for _, chirp := range chirps {
+		chirp.ID = dbStructure.newID()
+		chirp.AuthorID = authorID
+		chirp.DeletedAt = nil
+		dbStructure.Chirps[chirp.ID] = chirp
+		imported = append(imported, chirp)
+	}
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return nil, err
 	}
-	sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
-	return chirps, nil
+	db.emit(db.chirpEvents(ChirpCreated, imported)...)
+	return imported, nil
 }
 
-// ensureDB creates a new database file if it doesn't exist
-func (db *DB) ensureDB() error {
-	_, err := os.ReadFile(db.path)
-	if errors.Is(err, fs.ErrNotExist) {
-		dbStructure := DBStructure{
-			Chirps: make(map[int]Chirp),
+// MirrorChirps saves chirps mirrored from another instance for authorID in
+// a single write. chirps whose SourceURL is already stored, deleted or not,
+// are skipped so a sync can be repeated safely.
+func (db *DB) MirrorChirps(authorID int, chirps []Chirp) ([]Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.requireAuthor(authorID); err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.SourceURL != "" {
+			known[chirp.SourceURL] = true
 		}
-		return db.writeDB(dbStructure)
 	}
 
-	return nil
-}
+	mirrored := make([]Chirp, 0, len(chirps))
+	for _, chirp := range chirps {
+		if chirp.SourceURL == "" || known[chirp.SourceURL] {
+			continue
+		}
+		known[chirp.SourceURL] = true
+		chirp.ID = dbStructure.newID()
+		chirp.AuthorID = authorID
+		chirp.DeletedAt = nil
+		dbStructure.Chirps[chirp.ID] = chirp
+		mirrored = append(mirrored, chirp)
+	}
+	if len(mirrored) == 0 {
+		return mirrored, nil
+	}
 
-// loadDB reads the database file into memory
-func (db *DB) loadDB() (DBStructure, error) {
-	file, err := os.ReadFile(db.path)
+	err = db.writeDB(dbStructure)
 	if err != nil {
-		return DBStructure{}, err
+		return nil, err
 	}
+	db.emit(db.chirpEvents(ChirpCreated, mirrored)...)
+	return mirrored, nil
+}
 
-	var database DBStructure
-	err = json.Unmarshal(file, &database)
+// DeleteChirpsByAuthor tombstones every live chirp of authorID and
+// returns how many it deleted
+func (db *DB) DeleteChirpsByAuthor(authorID int) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
 	if err != nil {
-		return DBStructure{}, err
+		return 0, err
+	}
+	now := db.now()
+	deleted := make([]Chirp, 0)
+	for id, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID != authorID || chirp.DeletedAt != nil {
+			continue
+		}
+		chirp.DeletedAt = &now
+		dbStructure.Chirps[id] = chirp
+		deleted = append(deleted, chirp)
+	}
+	if len(deleted) == 0 {
+		return 0, nil
 	}
-	return database, nil
 
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return 0, err
+	}
+	db.emit(db.chirpEvents(ChirpDeleted, deleted)...)
+	return len(deleted), nil
 }
 
-// writeDB writes the database file to disk
-func (db *DB) writeDB(dbStructure DBStructure) error {
-	file, err := json.Marshal(dbStructure)
+// requireAuthor returns ErrUserNotFound unless the user with authorID
+// exists. chirps and users share the file, so checking under the write lock
+// keeps a chirp from being written for an account deleted since its author
+// was looked up.
+func (db *DB) requireAuthor(authorID int) error {
+	c, err := db.loadFile()
 	if err != nil {
 		return err
 	}
+	if _, ok := c.users.Users[authorID]; !ok {
+		return ErrUserNotFound
+	}
+	return nil
+}
 
-	err = os.WriteFile(db.path, file, 0644)
+// newID hands out the next chirp ID. the counter is persisted because
+// compaction removes old tombstones, so neither the count nor the highest
+// ID in use can tell which IDs were already given out.
+func (dbStructure *DBStructure) newID() int {
+	dbStructure.NextID++
+	return dbStructure.NextID
+}
+
+// inferNextID catches the counter up with the chirps, for files written
+// before it was stored
+func (dbStructure *DBStructure) inferNextID() {
+	dbStructure.NextID = max(dbStructure.NextID, dbStructure.Chirps.maxID())
+}
+
+// GetChirps returns all live chirps in the database
+func (db *DB) GetChirps() ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	cached, err := db.readChirps()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return slices.Clone(cached.live), nil
+}
 
-	return nil
+// SetClock makes the database stamp records with c instead of the wall clock
+func (db *DB) SetClock(c clock.Clock) {
+	db.clock = c
+}
+
+// now is the time records are stamped with
+func (db *DB) now() time.Time {
+	if db.clock == nil {
+		return time.Now()
+	}
+	return db.clock.Now()
+}
+
+// SetFileObserver registers f to be called after every file load and write.
+// it must be set before the database is used.
+func (db *DB) SetFileObserver(f FileObserver) {
+	db.observer = f
+}
+
+// observe reports a file operation to the observer if one is set
+func (db *DB) observe(op string, start time.Time, size int, err error) {
+	if db.observer != nil {
+		db.observer(op, time.Since(start), size, err)
+	}
+}
 
+// Close waits for the read or write in progress and blocks every later one,
+// so the process can exit without cutting a file write short. the database
+// can't be used after.
+func (db *DB) Close() {
+	db.mux.Lock()
 }
 
 // get chirpy from id
 func (db *DB) GetChirpyFromID(ID int) (Chirp, error) {
-	chirps, err := db.GetChirps()
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	cached, err := db.readChirps()
 	if err != nil {
 		return Chirp{}, err
 	}
-	if len(chirps) < ID || ID <= 0 {
-		return Chirp{}, errors.New("invalid ID")
+	chirp, ok := cached.Chirps[ID]
+	if !ok || chirp.DeletedAt != nil {
+		return Chirp{}, ErrChirpNotFound
 	}
-	return chirps[ID-1], nil
+	return chirp, nil
 }
 
-// delete chirpy from id
+// delete chirpy from id.
+// the chirp is tombstoned so it can be restored with RestoreChirp.
 func (db *DB) DeleteDB(authorID int, ID int) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
@@ -147,22 +297,149 @@ func (db *DB) DeleteDB(authorID int, ID int) error {
 		return err
 	}
 
-	if chirp, ok := dbStructure.Chirps[ID]; ok {
-		if chirp.AuthorID != authorID {
-			return errors.New("forbidden")
-		}
-	} else {
-		return errors.New("invalid chirpy ID")
+	chirp, ok := dbStructure.Chirps[ID]
+	if !ok || chirp.DeletedAt != nil {
+		return ErrChirpNotFound
+	}
+	if chirp.AuthorID != authorID {
+		return ErrNotChirpAuthor
 	}
 
-	delete(dbStructure.Chirps, ID)
+	now := db.now()
+	chirp.DeletedAt = &now
+	dbStructure.Chirps[ID] = chirp
 	err = db.writeDB(dbStructure)
 	if err != nil {
 		return err
 	}
+	db.emit(db.chirpEvents(ChirpDeleted, []Chirp{chirp})...)
 	return nil
 }
 
+// UpdateChirp replaces the body of the live chirp with ID. only its author
+// can, others get ErrNotChirpAuthor.
+func (db *DB) UpdateChirp(authorID int, ID int, body string) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, ok := dbStructure.Chirps[ID]
+	if !ok || chirp.DeletedAt != nil {
+		return Chirp{}, ErrChirpNotFound
+	}
+	if chirp.AuthorID != authorID {
+		return Chirp{}, ErrNotChirpAuthor
+	}
+
+	now := db.now()
+	chirp.Body = body
+	chirp.UpdatedAt = &now
+	dbStructure.Chirps[ID] = chirp
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
+	db.emit(db.chirpEvents(ChirpUpdated, []Chirp{chirp})...)
+	return chirp, nil
+}
+
+// BumpChirp stamps the chirp with ID as bumped now, so feeds show it
+// again. it fails with ErrBumpTooSoon when the last bump is less than
+// cooldown ago. edits and the global list never look at the stamp.
+func (db *DB) BumpChirp(authorID int, ID int, cooldown time.Duration) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, ok := dbStructure.Chirps[ID]
+	if !ok || chirp.DeletedAt != nil {
+		return Chirp{}, ErrChirpNotFound
+	}
+	if chirp.AuthorID != authorID {
+		return Chirp{}, ErrNotChirpAuthor
+	}
+
+	now := db.now()
+	if chirp.BumpedAt != nil && now.Sub(*chirp.BumpedAt) < cooldown {
+		return Chirp{}, ErrBumpTooSoon
+	}
+	chirp.BumpedAt = &now
+	dbStructure.Chirps[ID] = chirp
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
+	db.emit(db.chirpEvents(ChirpBumped, []Chirp{chirp})...)
+	return chirp, nil
+}
+
+// restore a tombstoned chirpy exactly as it was before deletion
+func (db *DB) RestoreChirp(authorID int, ID int) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, ok := dbStructure.Chirps[ID]
+	if !ok || chirp.DeletedAt == nil {
+		return Chirp{}, errors.New("chirpy is not deleted")
+	}
+	if chirp.AuthorID != authorID {
+		return Chirp{}, ErrNotChirpAuthor
+	}
+	// the chirps of a deleted account stay tombstoned
+	if err := db.requireAuthor(authorID); err != nil {
+		return Chirp{}, err
+	}
+
+	chirp.DeletedAt = nil
+	dbStructure.Chirps[ID] = chirp
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
+	db.emit(db.chirpEvents(ChirpRestored, []Chirp{chirp})...)
+	return chirp, nil
+}
+
+// ChirpActivity counts the live chirps of authorID per UTC day from since
+// onwards, keyed by the start of the day. chirps from before creation
+// times were tracked are not counted.
+func (db *DB) ChirpActivity(authorID int, since time.Time) (map[time.Time]int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	cached, err := db.readChirps()
+	if err != nil {
+		return nil, err
+	}
+
+	since = since.UTC().Truncate(24 * time.Hour)
+	counts := make(map[time.Time]int)
+	for _, chirp := range cached.live {
+		if chirp.AuthorID != authorID || chirp.CreatedAt == nil {
+			continue
+		}
+		day := chirp.CreatedAt.UTC().Truncate(24 * time.Hour)
+		if day.Before(since) {
+			continue
+		}
+		counts[day]++
+	}
+	return counts, nil
+}
+
 // get chirps by auther id
 func (db *DB) GetChirpsByAuthorID(autherID int) ([]Chirp, error) {
 	chirps, err := db.GetChirps()
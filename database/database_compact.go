@@ -0,0 +1,167 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// compactRetries is how many times compaction starts over when the file
+// changes while the compacted copy is being built
+const compactRetries = 3
+
+var errCompactConflict = errors.New("database file kept changing during compaction")
+
+// CompactReport describes the result of compacting one database file
+type CompactReport struct {
+	File        string `json:"file"`
+	BytesBefore int    `json:"bytes_before"`
+	BytesAfter  int    `json:"bytes_after"`
+	Reclaimed   int    `json:"bytes_reclaimed"`
+	Removed     int    `json:"records_removed"`
+	Compacted   bool   `json:"compacted"`
+}
+
+// CompactChirps drops tombstoned chirps deleted before cutoff. tombstones
+// are never served, so dropping them doesn't emit events.
+func (db *DB) CompactChirps(cutoff time.Time) (CompactReport, error) {
+	return db.compact(func(file []byte) ([]byte, int, error) {
+		var dbStructure dbFile
+		err := json.Unmarshal(file, &dbStructure)
+		if err != nil {
+			return nil, 0, err
+		}
+		// before the highest tombstone goes, so its ID isn't handed out again
+		dbStructure.DBStructure.inferNextID()
+
+		removed := 0
+		for id, chirp := range dbStructure.Chirps {
+			if chirp.DeletedAt != nil && chirp.DeletedAt.Before(cutoff) {
+				delete(dbStructure.Chirps, id)
+				removed++
+			}
+		}
+		if removed == 0 {
+			return nil, 0, nil
+		}
+		compacted, err := db.encode(dbStructure)
+		return compacted, removed, err
+	})
+}
+
+// DeletedChirpsBefore returns the tombstoned chirps deleted before cutoff,
+// the ones CompactChirps would drop, sorted by ID
+func (db *DB) DeletedChirpsBefore(cutoff time.Time) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	chirps := make([]Chirp, 0)
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.DeletedAt != nil && chirp.DeletedAt.Before(cutoff) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
+	return chirps, nil
+}
+
+// CompactUsers clears refresh tokens that expired reports as no longer usable
+func (db *DB) CompactUsers(expired func(token string) bool) (CompactReport, error) {
+	return db.compact(func(file []byte) ([]byte, int, error) {
+		var dbStructure dbFile
+		err := json.Unmarshal(file, &dbStructure)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		removed := 0
+		for id, user := range dbStructure.Users {
+			if n := user.compactSessions(expired); n > 0 {
+				dbStructure.Users[id] = user
+				removed += n
+			}
+		}
+		if removed == 0 {
+			return nil, 0, nil
+		}
+		compacted, err := db.encode(dbStructure)
+		return compacted, removed, err
+	})
+}
+
+// compact rewrites the database file with the output of transform.
+// transform works from a snapshot taken under the read lock and returns
+// nil when there is nothing to reclaim. the write lock is only held to
+// check the file hasn't changed since the snapshot and rename the
+// compacted copy over it, otherwise compaction starts over.
+func (db *DB) compact(transform func(file []byte) ([]byte, int, error)) (CompactReport, error) {
+	report := CompactReport{File: filepath.Base(db.path)}
+
+	for attempt := 0; attempt < compactRetries; attempt++ {
+		db.mux.RLock()
+		snapshot, err := os.ReadFile(db.path)
+		db.mux.RUnlock()
+		if err != nil {
+			return report, err
+		}
+		report.BytesBefore = len(snapshot)
+		report.BytesAfter = len(snapshot)
+
+		compacted, removed, err := transform(snapshot)
+		if err != nil {
+			return report, err
+		}
+		if compacted == nil {
+			return report, nil
+		}
+
+		tmp, err := db.writeTemp(compacted, ".compact-*")
+		if err != nil {
+			return report, err
+		}
+
+		swapped, err := db.swapIfUnchanged(snapshot, tmp)
+		if err != nil || !swapped {
+			os.Remove(tmp)
+		}
+		if err != nil {
+			return report, err
+		}
+		if swapped {
+			report.BytesAfter = len(compacted)
+			report.Reclaimed = report.BytesBefore - report.BytesAfter
+			report.Removed = removed
+			report.Compacted = true
+			return report, nil
+		}
+	}
+	return report, errCompactConflict
+}
+
+// swapIfUnchanged renames tmp over the database file if it still holds snapshot
+func (db *DB) swapIfUnchanged(snapshot []byte, tmp string) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	current, err := os.ReadFile(db.path)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(current, snapshot) {
+		return false, nil
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		return false, err
+	}
+	syncDir(filepath.Dir(db.path))
+	db.dropCache()
+	return true, nil
+}
@@ -0,0 +1,463 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+// maxDivergences is how many divergences a MigrationReport keeps, the
+// latest ones
+const maxDivergences = 100
+
+// kinds of Divergence
+const (
+	DivergenceWriteFailed   = "write_failed"   // the secondary failed a write the primary made
+	DivergenceWriteMismatch = "write_mismatch" // both wrote, with different results
+	DivergenceReadMismatch  = "read_mismatch"  // a verified read differed
+)
+
+// Divergence is a call the secondary store didn't answer like the primary
+type Divergence struct {
+	At     time.Time `json:"at"`
+	Op     string    `json:"op"`
+	Kind   string    `json:"kind"`
+	Detail string    `json:"detail"`
+}
+
+// MigrationReport is what a DualStore saw of the secondary since it was
+// opened
+type MigrationReport struct {
+	Primary          string       `json:"primary"`
+	Secondary        string       `json:"secondary"`
+	Writes           int          `json:"writes"`
+	FailedWrites     int          `json:"failed_writes"`
+	MismatchedWrites int          `json:"mismatched_writes"`
+	VerifiedReads    int          `json:"verified_reads"`
+	MismatchedReads  int          `json:"mismatched_reads"`
+	Divergences      []Divergence `json:"divergences"`
+}
+
+// DualStore writes to a primary and a secondary store and reads from the
+// primary, so a server can move to another backend without a cutover:
+// copy the data over, run with both, then make the secondary the primary.
+// writes are serialized so both stores apply them in the same order and
+// hand out the same IDs, and each write sees one time so both stamp the
+// same. what the secondary does differently is recorded in the report and
+// never returned to callers. only the primary publishes events.
+type DualStore struct {
+	primary     Store
+	secondary   Store
+	verifyEvery int // every how many reads are checked against the secondary, 0 for none
+
+	// writes is held for every write, and shared by verified reads so no
+	// write lands between the two reads
+	writes *sync.RWMutex
+	clock  clock.Clock
+	frozen atomic.Pointer[time.Time] // the time of the running write
+
+	mux    *sync.Mutex
+	reads  int
+	report MigrationReport
+}
+
+// NewDualStore wraps primary and secondary, named in the report. with
+// verifyEvery above 0 every verifyEvery-th read is also made against the
+// secondary and compared. the secondary should start as a copy of the
+// primary, see the migrate command.
+func NewDualStore(primary Store, primaryName string, secondary Store, secondaryName string, verifyEvery int) *DualStore {
+	d := &DualStore{
+		primary:     primary,
+		secondary:   secondary,
+		verifyEvery: verifyEvery,
+		writes:      &sync.RWMutex{},
+		clock:       clock.Real{},
+		mux:         &sync.Mutex{},
+		report:      MigrationReport{Primary: primaryName, Secondary: secondaryName, Divergences: make([]Divergence, 0)},
+	}
+	primary.SetClock(dualClock{d})
+	secondary.SetClock(dualClock{d})
+	return d
+}
+
+// dualClock is the clock of both stores of a DualStore, stopped at the
+// time of the running write
+type dualClock struct {
+	d *DualStore
+}
+
+func (c dualClock) Now() time.Time {
+	if now := c.d.frozen.Load(); now != nil {
+		return *now
+	}
+	return c.d.clock.Now()
+}
+
+func (c dualClock) NewTimer(d time.Duration) clock.Timer { return c.d.clock.NewTimer(d) }
+
+// Report returns a copy of what was recorded so far
+func (d *DualStore) Report() MigrationReport {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	report := d.report
+	report.Divergences = append(make([]Divergence, 0, len(d.report.Divergences)), d.report.Divergences...)
+	return report
+}
+
+// diverged records a divergence of op, dropping the oldest past maxDivergences
+func (d *DualStore) diverged(op, kind, detail string) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	switch kind {
+	case DivergenceWriteFailed:
+		d.report.FailedWrites++
+	case DivergenceWriteMismatch:
+		d.report.MismatchedWrites++
+	case DivergenceReadMismatch:
+		d.report.MismatchedReads++
+	}
+	d.report.Divergences = append(d.report.Divergences, Divergence{At: d.clock.Now(), Op: op, Kind: kind, Detail: detail})
+	if n := len(d.report.Divergences); n > maxDivergences {
+		d.report.Divergences = append(d.report.Divergences[:0], d.report.Divergences[n-maxDivergences:]...)
+	}
+}
+
+// verifyNext counts a read and reports whether it is one to verify
+func (d *DualStore) verifyNext() bool {
+	if d.verifyEvery <= 0 {
+		return false
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.reads++
+	if d.reads%d.verifyEvery != 0 {
+		return false
+	}
+	d.report.VerifiedReads++
+	return true
+}
+
+// differs describes how the secondary's result of a call differs from the
+// primary's, "" when it doesn't. errors only have to agree on being there,
+// the backends word them differently.
+func differs(want any, wantErr error, got any, gotErr error) string {
+	if wantErr != nil || gotErr != nil {
+		if (wantErr == nil) != (gotErr == nil) {
+			return fmt.Sprintf("primary: %v, secondary: %v", errorOrOK(wantErr), errorOrOK(gotErr))
+		}
+		return ""
+	}
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		return err.Error()
+	}
+	gotJSON, err := json.Marshal(got)
+	if err != nil {
+		return err.Error()
+	}
+	if string(wantJSON) == string(gotJSON) {
+		return ""
+	}
+	return fmt.Sprintf("primary: %s, secondary: %s", truncate(wantJSON), truncate(gotJSON))
+}
+
+func errorOrOK(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return err.Error()
+}
+
+// truncate shortens a result for a divergence detail
+func truncate(b []byte) string {
+	const max = 200
+	if len(b) > max {
+		return string(b[:max]) + "..."
+	}
+	return string(b)
+}
+
+// dualWrite makes a write on both stores at the same time. it goes to the
+// secondary even when the primary failed, some writes like a refresh token
+// reuse change the store and still return an error.
+func dualWrite[T any](d *DualStore, op string, write func(s Store) (T, error)) (T, error) {
+	return dualWriteAs(d, op, write, func(result T) any { return result })
+}
+
+// dualWriteAs is dualWrite comparing only view of the results, for results
+// that describe the backend as much as the data
+func dualWriteAs[T any](d *DualStore, op string, write func(s Store) (T, error), view func(T) any) (T, error) {
+	d.writes.Lock()
+	defer d.writes.Unlock()
+	now := d.clock.Now()
+	d.frozen.Store(&now)
+	defer d.frozen.Store(nil)
+
+	result, err := write(d.primary)
+	secondary, secondaryErr := write(d.secondary)
+	d.mux.Lock()
+	d.report.Writes++
+	d.mux.Unlock()
+	if err == nil && secondaryErr != nil {
+		d.diverged(op, DivergenceWriteFailed, secondaryErr.Error())
+	} else if detail := differs(view(result), err, view(secondary), secondaryErr); detail != "" {
+		d.diverged(op, DivergenceWriteMismatch, detail)
+	}
+	return result, err
+}
+
+// dualWriteErr is dualWrite for writes without a result
+func dualWriteErr(d *DualStore, op string, write func(s Store) error) error {
+	_, err := dualWrite(d, op, func(s Store) (struct{}, error) {
+		return struct{}{}, write(s)
+	})
+	return err
+}
+
+// dualRead reads from the primary, and from the secondary too when it is
+// a read to verify
+func dualRead[T any](d *DualStore, op string, read func(s Store) (T, error)) (T, error) {
+	if !d.verifyNext() {
+		return read(d.primary)
+	}
+	d.writes.RLock()
+	result, err := read(d.primary)
+	secondary, secondaryErr := read(d.secondary)
+	d.writes.RUnlock()
+	if detail := differs(result, err, secondary, secondaryErr); detail != "" {
+		d.diverged(op, DivergenceReadMismatch, detail)
+	}
+	return result, err
+}
+
+// compactedRecords is what compactions of both stores agree on, the files
+// and their sizes are of the backend
+func compactedRecords(r CompactReport) any {
+	return r.Removed
+}
+
+// chirpPage is the result of QueryChirps as one value
+type chirpPage struct {
+	Chirps []Chirp
+	Total  int
+}
+
+func (d *DualStore) CreateChirp(body string, authorID int, lang string, source string) (Chirp, error) {
+	return dualWrite(d, "CreateChirp", func(s Store) (Chirp, error) { return s.CreateChirp(body, authorID, lang, source) })
+}
+
+func (d *DualStore) ImportChirps(authorID int, chirps []Chirp) ([]Chirp, error) {
+	return dualWrite(d, "ImportChirps", func(s Store) ([]Chirp, error) { return s.ImportChirps(authorID, chirps) })
+}
+
+func (d *DualStore) MirrorChirps(authorID int, chirps []Chirp) ([]Chirp, error) {
+	return dualWrite(d, "MirrorChirps", func(s Store) ([]Chirp, error) { return s.MirrorChirps(authorID, chirps) })
+}
+
+func (d *DualStore) GetChirps() ([]Chirp, error) {
+	return dualRead(d, "GetChirps", func(s Store) ([]Chirp, error) { return s.GetChirps() })
+}
+
+func (d *DualStore) GetChirpyFromID(ID int) (Chirp, error) {
+	return dualRead(d, "GetChirpyFromID", func(s Store) (Chirp, error) { return s.GetChirpyFromID(ID) })
+}
+
+func (d *DualStore) GetChirpsByAuthorID(authorID int) ([]Chirp, error) {
+	return dualRead(d, "GetChirpsByAuthorID", func(s Store) ([]Chirp, error) { return s.GetChirpsByAuthorID(authorID) })
+}
+
+func (d *DualStore) QueryChirps(q ChirpQuery) ([]Chirp, int, error) {
+	page, err := dualRead(d, "QueryChirps", func(s Store) (chirpPage, error) {
+		chirps, total, err := s.QueryChirps(q)
+		return chirpPage{chirps, total}, err
+	})
+	return page.Chirps, page.Total, err
+}
+
+func (d *DualStore) ChirpActivity(authorID int, since time.Time) (map[time.Time]int, error) {
+	return dualRead(d, "ChirpActivity", func(s Store) (map[time.Time]int, error) { return s.ChirpActivity(authorID, since) })
+}
+
+func (d *DualStore) DeleteDB(authorID int, ID int) error {
+	return dualWriteErr(d, "DeleteDB", func(s Store) error { return s.DeleteDB(authorID, ID) })
+}
+
+func (d *DualStore) DeleteChirpsByAuthor(authorID int) (int, error) {
+	return dualWrite(d, "DeleteChirpsByAuthor", func(s Store) (int, error) { return s.DeleteChirpsByAuthor(authorID) })
+}
+
+func (d *DualStore) BumpChirp(authorID int, ID int, cooldown time.Duration) (Chirp, error) {
+	return dualWrite(d, "BumpChirp", func(s Store) (Chirp, error) { return s.BumpChirp(authorID, ID, cooldown) })
+}
+
+func (d *DualStore) UpdateChirp(authorID int, ID int, body string) (Chirp, error) {
+	return dualWrite(d, "UpdateChirp", func(s Store) (Chirp, error) { return s.UpdateChirp(authorID, ID, body) })
+}
+
+func (d *DualStore) RestoreChirp(authorID int, ID int) (Chirp, error) {
+	return dualWrite(d, "RestoreChirp", func(s Store) (Chirp, error) { return s.RestoreChirp(authorID, ID) })
+}
+
+func (d *DualStore) DeletedChirpsBefore(cutoff time.Time) ([]Chirp, error) {
+	return dualRead(d, "DeletedChirpsBefore", func(s Store) ([]Chirp, error) { return s.DeletedChirpsBefore(cutoff) })
+}
+
+func (d *DualStore) CompactChirps(cutoff time.Time) (CompactReport, error) {
+	return dualWriteAs(d, "CompactChirps", func(s Store) (CompactReport, error) { return s.CompactChirps(cutoff) }, compactedRecords)
+}
+
+func (d *DualStore) ResetChirps() (int, error) {
+	return dualWrite(d, "ResetChirps", func(s Store) (int, error) { return s.ResetChirps() })
+}
+
+func (d *DualStore) ChirpStats() (CollectionStats, error) {
+	return dualRead(d, "ChirpStats", func(s Store) (CollectionStats, error) { return s.ChirpStats() })
+}
+
+func (d *DualStore) ChirpRevision() (ChirpRevision, error) {
+	return dualRead(d, "ChirpRevision", func(s Store) (ChirpRevision, error) { return s.ChirpRevision() })
+}
+
+func (d *DualStore) CreateUser(email string, password []byte) (User, error) {
+	return dualWrite(d, "CreateUser", func(s Store) (User, error) { return s.CreateUser(email, password) })
+}
+
+func (d *DualStore) GetUser() ([]User, error) {
+	return dualRead(d, "GetUser", func(s Store) ([]User, error) { return s.GetUser() })
+}
+
+func (d *DualStore) GetUserByID(ID int) (User, error) {
+	return dualRead(d, "GetUserByID", func(s Store) (User, error) { return s.GetUserByID(ID) })
+}
+
+func (d *DualStore) GetUserByEmail(email string) (User, error) {
+	return dualRead(d, "GetUserByEmail", func(s Store) (User, error) { return s.GetUserByEmail(email) })
+}
+
+func (d *DualStore) UpdateUserDB(ID int, email string, password []byte, expectedVersion *int) (User, error) {
+	return dualWrite(d, "UpdateUserDB", func(s Store) (User, error) { return s.UpdateUserDB(ID, email, password, expectedVersion) })
+}
+
+func (d *DualStore) UpgradeUser(ID int) error {
+	return dualWriteErr(d, "UpgradeUser", func(s Store) error { return s.UpgradeUser(ID) })
+}
+
+func (d *DualStore) DeleteUser(ID int, chirps AuthorChirps) error {
+	return dualWriteErr(d, "DeleteUser", func(s Store) error { return s.DeleteUser(ID, chirps) })
+}
+
+func (d *DualStore) StoreToken(ID int, token string, family string, expiresAt time.Time) error {
+	return dualWriteErr(d, "StoreToken", func(s Store) error { return s.StoreToken(ID, token, family, expiresAt) })
+}
+
+func (d *DualStore) AddSession(ID int, token string, family string, expiresAt time.Time) error {
+	return dualWriteErr(d, "AddSession", func(s Store) error { return s.AddSession(ID, token, family, expiresAt) })
+}
+
+func (d *DualStore) RotateToken(ID int, presented string, family string, next string) error {
+	return dualWriteErr(d, "RotateToken", func(s Store) error { return s.RotateToken(ID, presented, family, next) })
+}
+
+func (d *DualStore) RevokeToken(ID int) error {
+	return dualWriteErr(d, "RevokeToken", func(s Store) error { return s.RevokeToken(ID) })
+}
+
+func (d *DualStore) RevokeSession(ID int, family string) error {
+	return dualWriteErr(d, "RevokeSession", func(s Store) error { return s.RevokeSession(ID, family) })
+}
+
+func (d *DualStore) LinkUsers(ID int, linkedID int) error {
+	return dualWriteErr(d, "LinkUsers", func(s Store) error { return s.LinkUsers(ID, linkedID) })
+}
+
+func (d *DualStore) UnlinkUsers(ID int, linkedID int) error {
+	return dualWriteErr(d, "UnlinkUsers", func(s Store) error { return s.UnlinkUsers(ID, linkedID) })
+}
+
+func (d *DualStore) SetOAuthProvider(ID int, provider string) (User, error) {
+	return dualWrite(d, "SetOAuthProvider", func(s Store) (User, error) { return s.SetOAuthProvider(ID, provider) })
+}
+
+func (d *DualStore) LinkIdentity(userID int, provider, providerUserID string) error {
+	return dualWriteErr(d, "LinkIdentity", func(s Store) error { return s.LinkIdentity(userID, provider, providerUserID) })
+}
+
+func (d *DualStore) GetUserByIdentity(provider, providerUserID string) (User, error) {
+	return dualRead(d, "GetUserByIdentity", func(s Store) (User, error) { return s.GetUserByIdentity(provider, providerUserID) })
+}
+
+func (d *DualStore) UnlinkIdentity(userID int, provider string) error {
+	return dualWriteErr(d, "UnlinkIdentity", func(s Store) error { return s.UnlinkIdentity(userID, provider) })
+}
+
+func (d *DualStore) SetPendingTOTP(ID int, secret string) error {
+	return dualWriteErr(d, "SetPendingTOTP", func(s Store) error { return s.SetPendingTOTP(ID, secret) })
+}
+
+func (d *DualStore) EnableTOTP(ID int, backupCodeHashes []string) error {
+	return dualWriteErr(d, "EnableTOTP", func(s Store) error { return s.EnableTOTP(ID, backupCodeHashes) })
+}
+
+func (d *DualStore) DisableTOTP(ID int) error {
+	return dualWriteErr(d, "DisableTOTP", func(s Store) error { return s.DisableTOTP(ID) })
+}
+
+func (d *DualStore) UseBackupCode(ID int, hash string) (bool, error) {
+	return dualWrite(d, "UseBackupCode", func(s Store) (bool, error) { return s.UseBackupCode(ID, hash) })
+}
+
+func (d *DualStore) SetProtected(ID int, protected bool) (User, error) {
+	return dualWrite(d, "SetProtected", func(s Store) (User, error) { return s.SetProtected(ID, protected) })
+}
+
+func (d *DualStore) ProtectedUserIDs() ([]int, error) {
+	return dualRead(d, "ProtectedUserIDs", func(s Store) ([]int, error) { return s.ProtectedUserIDs() })
+}
+
+func (d *DualStore) CompactUsers(expired func(token string) bool) (CompactReport, error) {
+	return dualWriteAs(d, "CompactUsers", func(s Store) (CompactReport, error) { return s.CompactUsers(expired) }, compactedRecords)
+}
+
+func (d *DualStore) ResetUsers() (int, error) {
+	return dualWrite(d, "ResetUsers", func(s Store) (int, error) { return s.ResetUsers() })
+}
+
+func (d *DualStore) UserStats() (CollectionStats, error) {
+	return dualRead(d, "UserStats", func(s Store) (CollectionStats, error) { return s.UserStats() })
+}
+
+func (d *DualStore) Export(includeSecrets bool) (Backup, error) {
+	return dualRead(d, "Export", func(s Store) (Backup, error) { return s.Export(includeSecrets) })
+}
+
+func (d *DualStore) Import(b Backup) error {
+	return dualWriteErr(d, "Import", func(s Store) error { return s.Import(b) })
+}
+
+// SetClock sets the clock both stores run on, call it before the store is used
+func (d *DualStore) SetClock(c clock.Clock) {
+	d.clock = c
+}
+
+// SetEventBus publishes the events of the primary on b, the secondary's
+// would be the same events again
+func (d *DualStore) SetEventBus(b *Bus) {
+	d.primary.SetEventBus(b)
+}
+
+// StorageDegraded reports the primary, writes the secondary fails are in
+// the report
+func (d *DualStore) StorageDegraded() bool {
+	return d.primary.StorageDegraded()
+}
+
+func (d *DualStore) Close() {
+	d.primary.Close()
+	d.secondary.Close()
+}
+
+var _ Store = (*DualStore)(nil)
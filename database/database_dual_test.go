@@ -0,0 +1,171 @@
+package database
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// openPair opens a new json store and a new SQLite store
+func openPair(t *testing.T) (*DB, *SQLiteStore) {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "chirpy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return db, s
+}
+
+// exported is the full export of s as json
+func exported(t *testing.T, s Store) string {
+	t.Helper()
+	backup, err := s.Export(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestDualStoreKeepsBothInStep(t *testing.T) {
+	db, s := openPair(t)
+	pairs := map[string][2]Store{"json first": {db, s}, "sqlite first": {s, db}}
+	for name, pair := range pairs {
+		t.Run(name, func(t *testing.T) {
+			if _, err := pair[0].ResetUsers(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := pair[1].ResetUsers(); err != nil {
+				t.Fatal(err)
+			}
+			// on the wall clock, both stores have to stamp the same times
+			d := NewDualStore(pair[0], "primary", pair[1], "secondary", 1)
+			rec := recordEvents(d)
+			alice := mustUser(t, d, "alice@example.com")
+			bob := mustUser(t, d, "bob@example.com")
+			chirp := mustChirp(t, d, alice.ID, "first")
+			mustChirp(t, d, bob.ID, "second")
+			if _, err := d.UpdateChirp(alice.ID, chirp.ID, "first, edited"); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.DeleteDB(alice.ID, chirp.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.StoreToken(bob.ID, "token", "login", time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.LinkUsers(alice.ID, bob.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.DeleteUser(alice.ID, AnonymizeAuthorChirps); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := d.GetChirps(); err != nil {
+				t.Fatal(err)
+			}
+
+			if primary, secondary := exported(t, pair[0]), exported(t, pair[1]); primary != secondary {
+				t.Fatalf("stores differ:\nprimary:   %s\nsecondary: %s", primary, secondary)
+			}
+			report := d.Report()
+			if len(report.Divergences) > 0 || report.Writes != 9 || report.VerifiedReads != 1 {
+				t.Fatalf("report = %+v, want 9 writes, 1 verified read and no divergences", report)
+			}
+			// one event per change, not one per store
+			rec.wait(t, 6)
+			time.Sleep(10 * time.Millisecond)
+			rec.mux.Lock()
+			defer rec.mux.Unlock()
+			seen := make(map[Event]bool)
+			for _, e := range rec.events {
+				if seen[e] {
+					t.Fatalf("event %+v was published twice", e)
+				}
+				seen[e] = true
+			}
+		})
+	}
+}
+
+// failingChirps is a store that can't write chirps
+type failingChirps struct {
+	Store
+}
+
+func (failingChirps) CreateChirp(body string, authorID int, lang string, source string) (Chirp, error) {
+	return Chirp{}, ErrStorageUnavailable
+}
+
+func TestDualStoreRecordsDivergences(t *testing.T) {
+	db, s := openPair(t)
+	d := NewDualStore(db, "json", s, "sqlite", 1)
+
+	// a user only the secondary has shifts the IDs it hands out
+	mustUser(t, s, "mallory@example.com")
+	alice := mustUser(t, d, "alice@example.com")
+	if alice.ID != 1 {
+		t.Fatalf("alice got ID %d of the secondary, want 1 of the primary", alice.ID)
+	}
+	if _, err := d.GetUserByEmail("mallory@example.com"); err == nil {
+		t.Fatal("read mallory from the secondary")
+	}
+	checkDivergences(t, d.Report(), "CreateUser write_mismatch", "GetUserByEmail read_mismatch")
+	if report := d.Report(); report.Primary != "json" || report.Secondary != "sqlite" || report.Writes != 1 ||
+		report.MismatchedWrites != 1 || report.MismatchedReads != 1 || report.FailedWrites != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	// a secondary that fails doesn't fail the write
+	d = NewDualStore(db, "json", failingChirps{s}, "sqlite", 1)
+	if _, err := d.CreateChirp("still works", alice.ID, "en", "web"); err != nil {
+		t.Fatalf("write with a failing secondary: %v", err)
+	}
+	checkDivergences(t, d.Report(), "CreateChirp write_failed")
+	if report := d.Report(); report.FailedWrites != 1 || report.Divergences[0].Detail != ErrStorageUnavailable.Error() {
+		t.Fatalf("report = %+v", report)
+	}
+}
+
+// checkDivergences fails the test unless report has the divergences, as
+// "<op> <kind>", in order
+func checkDivergences(t *testing.T, report MigrationReport, want ...string) {
+	t.Helper()
+	var got []string
+	for _, divergence := range report.Divergences {
+		got = append(got, divergence.Op+" "+divergence.Kind)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("divergences = %q, want %q", got, want)
+	}
+}
+
+func TestDualStoreVerifiesSampledReads(t *testing.T) {
+	db, s := openPair(t)
+	d := NewDualStore(db, "json", s, "sqlite", 3)
+	mustUser(t, s, "mallory@example.com")
+	for range 7 {
+		d.GetUserByEmail("mallory@example.com")
+	}
+	if report := d.Report(); report.VerifiedReads != 2 || report.MismatchedReads != 2 {
+		t.Fatalf("report = %+v, want 2 of 7 reads verified", report)
+	}
+
+	// only the latest divergences are kept, all are counted
+	d = NewDualStore(db, "json", s, "sqlite", 1)
+	for range maxDivergences + 10 {
+		d.GetUserByEmail("mallory@example.com")
+	}
+	if report := d.Report(); report.MismatchedReads != maxDivergences+10 || len(report.Divergences) != maxDivergences {
+		t.Fatalf("%d mismatched reads, %d divergences kept", report.MismatchedReads, len(report.Divergences))
+	}
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// IDMap is a collection keyed by record ID. it serializes in ID order, so
+// the same records always give the same file and backups diff cleanly.
+type IDMap[T any] map[int]T
+
+func (m IDMap[T]) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+	IDs := m.ids()
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, ID := range IDs {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('"')
+		buf.WriteString(strconv.Itoa(ID))
+		buf.WriteString(`":`)
+		record, err := json.Marshal(m[ID])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(record)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// ids returns the IDs of the collection in order
+func (m IDMap[T]) ids() []int {
+	IDs := make([]int, 0, len(m))
+	for ID := range m {
+		IDs = append(IDs, ID)
+	}
+	sort.Ints(IDs)
+	return IDs
+}
+
+// maxID is the highest ID in the collection, 0 when it is empty
+func (m IDMap[T]) maxID() int {
+	highest := 0
+	for ID := range m {
+		highest = max(highest, ID)
+	}
+	return highest
+}
+
+// SetIndent makes the database write its file indented with indent, or
+// compact when it is empty. it must be set before the database is used.
+func (db *DB) SetIndent(indent string) {
+	db.indent = indent
+}
+
+// encode serializes a database file
+func (db *DB) encode(v any) ([]byte, error) {
+	if db.indent == "" {
+		return json.Marshal(v)
+	}
+	file, err := json.MarshalIndent(v, "", db.indent)
+	if err != nil {
+		return nil, err
+	}
+	return append(file, '\n'), nil
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+// rewrite loads the database file at path and writes the same content back
+func rewrite(t *testing.T, path, indent string) []byte {
+	t.Helper()
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetIndent(indent)
+	db.mux.Lock()
+	users, err := db.loadUserDB()
+	if err == nil {
+		err = db.writeUserDB(users)
+	}
+	db.mux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestDatabaseFileIsStable(t *testing.T) {
+	for name, indent := range map[string]string{"compact": "", "spaces": "  ", "tabs": "\t"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "database.json")
+			db, err := NewDB(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.SetIndent(indent)
+			db.SetClock(clock.NewFake(testEpoch))
+			// enough records that map order would show
+			for i := range 30 {
+				user := mustUser(t, db, fmt.Sprintf("user-%d@example.com", i))
+				mustChirp(t, db, user.ID, fmt.Sprintf("chirp %d", i))
+			}
+			if err := db.LinkIdentity(3, "github", "octocat"); err != nil {
+				t.Fatal(err)
+			}
+			written, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range 5 {
+				if got := rewrite(t, path, indent); !bytes.Equal(got, written) {
+					t.Fatalf("rewrite %d changed the file:\n%s\nwant:\n%s", i, got, written)
+				}
+			}
+			// a copy of the file encodes the same
+			copied := filepath.Join(dir, "copy.json")
+			if err := os.WriteFile(copied, written, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if got := rewrite(t, copied, indent); !bytes.Equal(got, written) {
+				t.Fatalf("rewriting a copy changed it:\n%s\nwant:\n%s", got, written)
+			}
+
+			if first, tenth := bytes.Index(written, []byte(`"2":`)), bytes.Index(written, []byte(`"10":`)); first < 0 || tenth < first {
+				t.Fatalf("records are not in ID order:\n%s", written)
+			}
+			if compact := !bytes.Contains(written, []byte("\n")); compact != (indent == "") {
+				t.Fatalf("indent %q wrote:\n%s", indent, written)
+			}
+		})
+	}
+}
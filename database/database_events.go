@@ -0,0 +1,165 @@
+package database
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventType names a persisted change
+type EventType string
+
+const (
+	UserCreated   EventType = "user.created"
+	UserUpdated   EventType = "user.updated" // email or password changed
+	UserUpgraded  EventType = "user.upgraded"
+	UserDeleted   EventType = "user.deleted"
+	ChirpCreated  EventType = "chirp.created"
+	ChirpDeleted  EventType = "chirp.deleted"
+	ChirpRestored EventType = "chirp.restored"
+	ChirpUpdated  EventType = "chirp.updated" // body edited
+	ChirpBumped   EventType = "chirp.bumped"
+	// ChirpAnonymized is published when the author of a chirp deleted their
+	// account and the chirp was kept, UserID is the former author
+	ChirpAnonymized EventType = "chirp.anonymized"
+	// CollectionReset is published when every record of a collection was deleted
+	CollectionReset EventType = "collection.reset"
+	// EventsDropped is delivered to a subscriber that fell behind and lost
+	// events, so it can rebuild whatever it derives from them
+	EventsDropped EventType = "events.dropped"
+)
+
+// Event is a change the database persisted
+type Event struct {
+	Type    EventType
+	UserID  int // the user, or the author of the chirp
+	ChirpID int // chirp events only
+	At      time.Time
+}
+
+// Bus delivers database events to subscribers. every subscriber has its
+// own buffer and goroutine, so a slow subscriber doesn't hold up writes
+// or other subscribers, and sees events in the order they were persisted.
+// a full buffer drops the event instead of blocking the write.
+// synchronous subscribers run inside the write instead, for derived state
+// that must not be stale once the write returned.
+type Bus struct {
+	mux   *sync.Mutex
+	subs  []*subscription
+	syncs []func(Event)
+}
+
+type subscription struct {
+	name    string
+	events  chan Event
+	lost    bool // events were dropped and EventsDropped is not delivered yet
+	dropped int
+}
+
+func NewBus() *Bus {
+	return &Bus{mux: &sync.Mutex{}}
+}
+
+// Subscribe calls handle for every event published from now on, one at a
+// time from a goroutine of its own. subscribe at startup, before the
+// databases are used.
+func (b *Bus) Subscribe(name string, buffer int, handle func(Event)) {
+	s := &subscription{name: name, events: make(chan Event, buffer)}
+	b.mux.Lock()
+	b.subs = append(b.subs, s)
+	b.mux.Unlock()
+
+	go func() {
+		for e := range s.events {
+			handle(e)
+		}
+	}()
+}
+
+// SubscribeSync calls handle for every event published from now on, in
+// the write that persisted it and before the write returns. handle runs
+// under the write lock of the database, so it must be quick and must not
+// touch a database. subscribe at startup, before the databases are used.
+func (b *Bus) SubscribeSync(handle func(Event)) {
+	b.mux.Lock()
+	b.syncs = append(b.syncs, handle)
+	b.mux.Unlock()
+}
+
+// Dropped returns how many events each subscriber lost to a full buffer
+func (b *Bus) Dropped() map[string]int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	dropped := make(map[string]int, len(b.subs))
+	for _, s := range b.subs {
+		dropped[s.name] += s.dropped
+	}
+	return dropped
+}
+
+// publish hands events to the synchronous subscribers and queues them for
+// every other subscriber without blocking. callers hold the write lock of
+// the database that persisted them, which keeps the events of one entity
+// in order.
+func (b *Bus) publish(events ...Event) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for _, handle := range b.syncs {
+		for _, e := range events {
+			handle(e)
+		}
+	}
+	for _, s := range b.subs {
+		for _, e := range events {
+			if s.lost && !s.send(Event{Type: EventsDropped, At: e.At}) {
+				s.dropped++
+				continue
+			}
+			s.lost = false
+			if !s.send(e) {
+				s.dropped++
+				s.lost = true
+			}
+		}
+	}
+}
+
+func (s *subscription) send(e Event) bool {
+	select {
+	case s.events <- e:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetEventBus makes the database publish its changes to b.
+// it must be set before the database is used.
+func (db *DB) SetEventBus(b *Bus) {
+	db.events = b
+}
+
+// emit publishes events if a bus is set. callers hold the write lock and
+// only emit after the change was written.
+func (db *DB) emit(events ...Event) {
+	if db.events != nil && len(events) > 0 {
+		db.events.publish(events...)
+	}
+}
+
+// chirpEvents returns an event of type t for every chirp
+func (db *DB) chirpEvents(t EventType, chirps []Chirp) []Event {
+	return chirpEvents(t, chirps, db.now())
+}
+
+// chirpEvents returns an event of type t at now for every chirp, in ID order
+func chirpEvents(t EventType, chirps []Chirp, now time.Time) []Event {
+	events := make([]Event, 0, len(chirps))
+	for _, chirp := range chirps {
+		events = append(events, Event{Type: t, UserID: chirp.AuthorID, ChirpID: chirp.ID, At: now})
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ChirpID < events[j].ChirpID })
+	return events
+}
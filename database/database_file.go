@@ -0,0 +1,234 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// dbFile is the database file: the chirps and the users with their
+// identities side by side. both are loaded and written together, so a
+// change that touches both, like deleting a user with their chirps, is one
+// write that happens whole or not at all.
+type dbFile struct {
+	DBStructure
+	DBUserStructure
+}
+
+// cachedFile is what the database file is cached as, see fileCache
+type cachedFile struct {
+	chirps cachedChirps
+	users  DBUserStructure
+}
+
+// NewDB opens the database file at path, which holds the chirps and the
+// users, and creates it if it does not exist.
+func NewDB(path string) (*DB, error) {
+	db := &DB{
+		path: path,
+		mux:  &sync.RWMutex{},
+	}
+	err := db.ensureDB()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// ensureDB creates a new database file if it doesn't exist
+func (db *DB) ensureDB() error {
+	_, err := os.ReadFile(db.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return db.saveFile(cachedFile{
+			chirps: newCachedChirps(DBStructure{Chirps: make(map[int]Chirp)}),
+			users: DBUserStructure{
+				Users:      make(map[int]User),
+				Identities: make(map[string]Identity),
+			},
+		})
+	}
+	return err
+}
+
+// loadFile returns the cached database file, reading it first if needed.
+// it is shared: callers hold the lock and must not change it.
+func (db *DB) loadFile() (cachedFile, error) {
+	if c, ok := db.cached().(cachedFile); ok {
+		return c, nil
+	}
+	start := time.Now()
+	file, err := os.ReadFile(db.path)
+	db.observe("load_file", start, len(file), err)
+	if err != nil {
+		return cachedFile{}, err
+	}
+
+	var database dbFile
+	// an empty file is an empty database, crashes used to leave them behind
+	if len(bytes.TrimSpace(file)) > 0 {
+		err = json.Unmarshal(file, &database)
+		if err != nil {
+			return cachedFile{}, err
+		}
+	}
+	if database.Chirps == nil {
+		database.Chirps = make(map[int]Chirp)
+	}
+	if database.Users == nil {
+		database.Users = make(map[int]User)
+	}
+	// files written before identities existed have no section for them
+	if database.Identities == nil {
+		database.Identities = make(map[string]Identity)
+	}
+	database.DBStructure.inferNextID()
+	database.DBUserStructure.inferNextID()
+	c := cachedFile{chirps: newCachedChirps(database.DBStructure), users: database.DBUserStructure}
+	db.setCache(c)
+	return c, nil
+}
+
+// saveFile writes c as the database file and caches it. c must not be
+// changed after.
+func (db *DB) saveFile(c cachedFile) error {
+	file, err := db.encode(dbFile{DBStructure: c.chirps.DBStructure, DBUserStructure: c.users})
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = db.writeFile(file)
+	db.observe("write_file", start, len(file), err)
+	if err != nil {
+		db.dropCache()
+		return err
+	}
+	db.setCache(c)
+	return nil
+}
+
+// loadDB returns a copy of the chirps in the database file
+func (db *DB) loadDB() (DBStructure, error) {
+	c, err := db.loadFile()
+	if err != nil {
+		return DBStructure{}, err
+	}
+	return c.chirps.DBStructure.clone(), nil
+}
+
+// writeDB writes the database file with dbStructure as its chirps, as the
+// revision after the file's
+func (db *DB) writeDB(dbStructure DBStructure) error {
+	c, err := db.loadFile()
+	if err != nil {
+		return err
+	}
+	dbStructure.Revision = c.chirps.Revision
+	dbStructure.touch(db.now())
+	return db.saveFile(cachedFile{chirps: newCachedChirps(dbStructure.clone()), users: c.users})
+}
+
+// loadUserDB returns a copy of the users and identities in the database file
+func (db *DB) loadUserDB() (DBUserStructure, error) {
+	c, err := db.loadFile()
+	if err != nil {
+		return DBUserStructure{}, err
+	}
+	return c.users.clone(), nil
+}
+
+// writeUserDB writes the database file with dbUserStructure as its users
+func (db *DB) writeUserDB(dbUserStructure DBUserStructure) error {
+	c, err := db.loadFile()
+	if err != nil {
+		return err
+	}
+	return db.saveFile(cachedFile{chirps: c.chirps, users: dbUserStructure.clone()})
+}
+
+// MigrateSplitFiles merges the chirps and users files the database was kept
+// in before into a new database file at path. it does nothing when path
+// already exists or neither old file does. the old files are renamed with a
+// .migrated suffix after the merged file is written, a crash in between
+// leaves them behind unused. it reports whether it merged anything.
+func MigrateSplitFiles(path, chirpsPath, usersPath string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	// the old files both stored their counter as next_id
+	var chirps struct {
+		Chirps IDMap[Chirp] `json:"chirps"`
+		NextID int          `json:"next_id"`
+	}
+	var users struct {
+		Users      IDMap[User]         `json:"users"`
+		Identities map[string]Identity `json:"identities"`
+		NextID     int                 `json:"next_id"`
+	}
+	found := false
+	for _, old := range []struct {
+		path string
+		into any
+	}{{chirpsPath, &chirps}, {usersPath, &users}} {
+		file, err := os.ReadFile(old.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		found = true
+		if len(bytes.TrimSpace(file)) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(file, old.into); err != nil {
+			return false, err
+		}
+	}
+	if !found {
+		return false, nil
+	}
+
+	merged := dbFile{
+		DBStructure: DBStructure{Chirps: chirps.Chirps, NextID: chirps.NextID},
+		DBUserStructure: DBUserStructure{
+			Users:      users.Users,
+			Identities: users.Identities,
+			NextID:     users.NextID,
+		},
+	}
+	if merged.Chirps == nil {
+		merged.Chirps = make(map[int]Chirp)
+	}
+	if merged.Users == nil {
+		merged.Users = make(map[int]User)
+	}
+	merged.DBStructure.inferNextID()
+	merged.DBUserStructure.inferNextID()
+
+	db := &DB{path: path, mux: &sync.RWMutex{}}
+	file, err := db.encode(merged)
+	if err != nil {
+		return false, err
+	}
+	err = db.writeFile(file)
+	if err != nil {
+		return false, err
+	}
+	for _, old := range []string{chirpsPath, usersPath} {
+		err := os.Rename(old, old+".migrated")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return true, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,538 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrFollowNotFound = errors.New("follow not found")
+	ErrFollowSelf     = errors.New("cannot follow yourself")
+	ErrFollowsHidden  = errors.New("followers are hidden")
+)
+
+// Follow is an edge of the follow graph. pending edges wait for the
+// followee to approve them and don't count as following yet.
+type Follow struct {
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	Pending    bool      `json:"pending,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// HashtagFollow is a hashtag a user follows, Tag is lowercase without the #
+type HashtagFollow struct {
+	UserID    int       `json:"user_id"`
+	Tag       string    `json:"tag"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type DBFollowStructure struct {
+	Follows  []Follow        `json:"follows"`
+	Hashtags []HashtagFollow `json:"hashtags,omitempty"`
+	// Hidden are the users who only show their followers and follows to
+	// themselves, kept sorted
+	Hidden []int `json:"hidden,omitempty"`
+}
+
+// NewFollowDB creates the follows database and creates its file if it does not exist.
+func NewFollowDB(path string) (*DB, error) {
+	db := &DB{
+		path: path,
+		mux:  &sync.RWMutex{},
+	}
+	err := db.ensureFollowDB()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// AddFollow makes followerID follow followeeID, pending approval when
+// pending is set. following again returns the edge as it is, so a request
+// never downgrades an approved follow.
+func (db *DB) AddFollow(followerID, followeeID int, pending bool) (Follow, error) {
+	if followerID == followeeID {
+		return Follow{}, ErrFollowSelf
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadFollowDB()
+	if err != nil {
+		return Follow{}, err
+	}
+	if i := dbStructure.find(followerID, followeeID); i >= 0 {
+		return dbStructure.Follows[i], nil
+	}
+	follow := Follow{FollowerID: followerID, FolloweeID: followeeID, Pending: pending, CreatedAt: db.now()}
+	dbStructure.Follows = append(dbStructure.Follows, follow)
+
+	err = db.writeFollowDB(dbStructure)
+	if err != nil {
+		return Follow{}, err
+	}
+	return follow, nil
+}
+
+// AddFollows makes followerID follow every followee of follows in one
+// write, each pending when its Pending is set. it returns the edges as
+// stored, in order, and which of them existed before, see AddFollow.
+func (db *DB) AddFollows(followerID int, follows []Follow) ([]Follow, []bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadFollowDB()
+	if err != nil {
+		return nil, nil, err
+	}
+	stored := make([]Follow, len(follows))
+	existed := make([]bool, len(follows))
+	added := false
+	for i, follow := range follows {
+		if follow.FolloweeID == followerID {
+			return nil, nil, ErrFollowSelf
+		}
+		if j := dbStructure.find(followerID, follow.FolloweeID); j >= 0 {
+			stored[i], existed[i] = dbStructure.Follows[j], true
+			continue
+		}
+		stored[i] = Follow{FollowerID: followerID, FolloweeID: follow.FolloweeID, Pending: follow.Pending, CreatedAt: db.now()}
+		dbStructure.Follows = append(dbStructure.Follows, stored[i])
+		added = true
+	}
+	if !added {
+		return stored, existed, nil
+	}
+
+	err = db.writeFollowDB(dbStructure)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stored, existed, nil
+}
+
+// RemoveFollow deletes the edge from followerID to followeeID, pending or
+// not. removing a missing edge is a no-op.
+func (db *DB) RemoveFollow(followerID, followeeID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadFollowDB()
+	if err != nil {
+		return err
+	}
+	i := dbStructure.find(followerID, followeeID)
+	if i < 0 {
+		return nil
+	}
+	dbStructure.Follows = slices.Delete(dbStructure.Follows, i, i+1)
+	return db.writeFollowDB(dbStructure)
+}
+
+// GetFollow returns the edge from followerID to followeeID or ErrFollowNotFound
+func (db *DB) GetFollow(followerID, followeeID int) (Follow, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.readFollowDB()
+	if err != nil {
+		return Follow{}, err
+	}
+	i := dbStructure.find(followerID, followeeID)
+	if i < 0 {
+		return Follow{}, ErrFollowNotFound
+	}
+	return dbStructure.Follows[i], nil
+}
+
+// FollowRequests returns the pending follows of followeeID, oldest first
+func (db *DB) FollowRequests(followeeID int) ([]Follow, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.readFollowDB()
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]Follow, 0)
+	for _, follow := range dbStructure.Follows {
+		if follow.FolloweeID == followeeID && follow.Pending {
+			requests = append(requests, follow)
+		}
+	}
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].CreatedAt.Before(requests[j].CreatedAt) })
+	return requests, nil
+}
+
+// ApproveFollow turns the pending follow of followerID into a follow. it
+// returns ErrFollowNotFound when there is no pending request.
+func (db *DB) ApproveFollow(followeeID, followerID int) (Follow, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadFollowDB()
+	if err != nil {
+		return Follow{}, err
+	}
+	i := dbStructure.find(followerID, followeeID)
+	if i < 0 || !dbStructure.Follows[i].Pending {
+		return Follow{}, ErrFollowNotFound
+	}
+	dbStructure.Follows[i].Pending = false
+
+	err = db.writeFollowDB(dbStructure)
+	if err != nil {
+		return Follow{}, err
+	}
+	return dbStructure.Follows[i], nil
+}
+
+// RejectFollow deletes the pending follow of followerID. it returns
+// ErrFollowNotFound when there is no pending request, approved follows
+// can only be ended by the follower.
+func (db *DB) RejectFollow(followeeID, followerID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadFollowDB()
+	if err != nil {
+		return err
+	}
+	i := dbStructure.find(followerID, followeeID)
+	if i < 0 || !dbStructure.Follows[i].Pending {
+		return ErrFollowNotFound
+	}
+	dbStructure.Follows = slices.Delete(dbStructure.Follows, i, i+1)
+	return db.writeFollowDB(dbStructure)
+}
+
+// Following returns the IDs followerID follows, without pending requests,
+// sorted. it is for followerID's own use and ignores SetFollowsVisible,
+// others go through FollowingOf.
+func (db *DB) Following(followerID int) ([]int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.readFollowDB()
+	if err != nil {
+		return nil, err
+	}
+	return dbStructure.following(followerID), nil
+}
+
+// FollowingOf returns the IDs userID follows like Following, as viewerID
+// may see them. it returns ErrFollowsHidden when userID hides them from
+// anyone but themselves.
+func (db *DB) FollowingOf(viewerID, userID int) ([]int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.readFollowDB()
+	if err != nil {
+		return nil, err
+	}
+	if !dbStructure.visibleTo(viewerID, userID) {
+		return nil, ErrFollowsHidden
+	}
+	return dbStructure.following(userID), nil
+}
+
+// Followers returns the IDs that follow userID, without pending requests,
+// sorted. like FollowingOf it returns ErrFollowsHidden when viewerID may
+// not see them.
+func (db *DB) Followers(viewerID, userID int) ([]int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.readFollowDB()
+	if err != nil {
+		return nil, err
+	}
+	if !dbStructure.visibleTo(viewerID, userID) {
+		return nil, ErrFollowsHidden
+	}
+	IDs := make([]int, 0)
+	for _, follow := range dbStructure.Follows {
+		if follow.FolloweeID == userID && !follow.Pending {
+			IDs = append(IDs, follow.FollowerID)
+		}
+	}
+	slices.Sort(IDs)
+	return IDs, nil
+}
+
+// FollowCounts returns how many users follow userID and how many it
+// follows, pending requests left out. like FollowingOf it returns
+// ErrFollowsHidden when viewerID may not see them.
+func (db *DB) FollowCounts(viewerID, userID int) (followers int, following int, err error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.readFollowDB()
+	if err != nil {
+		return 0, 0, err
+	}
+	if !dbStructure.visibleTo(viewerID, userID) {
+		return 0, 0, ErrFollowsHidden
+	}
+	for _, follow := range dbStructure.Follows {
+		if follow.Pending {
+			continue
+		}
+		if follow.FolloweeID == userID {
+			followers++
+		}
+		if follow.FollowerID == userID {
+			following++
+		}
+	}
+	return followers, following, nil
+}
+
+// SetFollowsVisible shows the followers and follows of userID to everyone,
+// or only to userID
+func (db *DB) SetFollowsVisible(userID int, visible bool) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadFollowDB()
+	if err != nil {
+		return err
+	}
+	i, hidden := slices.BinarySearch(dbStructure.Hidden, userID)
+	if hidden != visible {
+		return nil
+	}
+	if visible {
+		dbStructure.Hidden = slices.Delete(dbStructure.Hidden, i, i+1)
+	} else {
+		dbStructure.Hidden = slices.Insert(dbStructure.Hidden, i, userID)
+	}
+	return db.writeFollowDB(dbStructure)
+}
+
+// FollowsVisible reports whether everyone may see the followers and
+// follows of userID, the default
+func (db *DB) FollowsVisible(userID int) (bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.readFollowDB()
+	if err != nil {
+		return false, err
+	}
+	_, hidden := slices.BinarySearch(dbStructure.Hidden, userID)
+	return !hidden, nil
+}
+
+// FollowHashtag makes userID follow tag, following again is a no-op
+func (db *DB) FollowHashtag(userID int, tag string) (HashtagFollow, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadFollowDB()
+	if err != nil {
+		return HashtagFollow{}, err
+	}
+	if i := dbStructure.findHashtag(userID, tag); i >= 0 {
+		return dbStructure.Hashtags[i], nil
+	}
+	follow := HashtagFollow{UserID: userID, Tag: tag, CreatedAt: db.now()}
+	dbStructure.Hashtags = append(dbStructure.Hashtags, follow)
+
+	err = db.writeFollowDB(dbStructure)
+	if err != nil {
+		return HashtagFollow{}, err
+	}
+	return follow, nil
+}
+
+// UnfollowHashtag stops userID following tag. unfollowing a tag that
+// isn't followed is a no-op.
+func (db *DB) UnfollowHashtag(userID int, tag string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadFollowDB()
+	if err != nil {
+		return err
+	}
+	i := dbStructure.findHashtag(userID, tag)
+	if i < 0 {
+		return nil
+	}
+	dbStructure.Hashtags = slices.Delete(dbStructure.Hashtags, i, i+1)
+	return db.writeFollowDB(dbStructure)
+}
+
+// FollowedHashtags returns the tags userID follows, sorted
+func (db *DB) FollowedHashtags(userID int) ([]string, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.readFollowDB()
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0)
+	for _, follow := range dbStructure.Hashtags {
+		if follow.UserID == userID {
+			tags = append(tags, follow.Tag)
+		}
+	}
+	slices.Sort(tags)
+	return tags, nil
+}
+
+// CountFollows returns how many follows, follow requests and hashtag
+// follows there are
+func (db *DB) CountFollows() (int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.readFollowDB()
+	if err != nil {
+		return 0, err
+	}
+	return len(dbStructure.Follows) + len(dbStructure.Hashtags), nil
+}
+
+// RemoveUserFromFollows deletes every edge from or to userID, the
+// hashtags it follows and its visibility setting, for when the user is
+// deleted
+func (db *DB) RemoveUserFromFollows(userID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadFollowDB()
+	if err != nil {
+		return err
+	}
+	dbStructure.Follows = slices.DeleteFunc(dbStructure.Follows, func(follow Follow) bool {
+		return follow.FollowerID == userID || follow.FolloweeID == userID
+	})
+	dbStructure.Hashtags = slices.DeleteFunc(dbStructure.Hashtags, func(follow HashtagFollow) bool {
+		return follow.UserID == userID
+	})
+	if i, hidden := slices.BinarySearch(dbStructure.Hidden, userID); hidden {
+		dbStructure.Hidden = slices.Delete(dbStructure.Hidden, i, i+1)
+	}
+	return db.writeFollowDB(dbStructure)
+}
+
+// find returns the index of the edge from followerID to followeeID, -1 when there is none
+func (s DBFollowStructure) find(followerID, followeeID int) int {
+	return slices.IndexFunc(s.Follows, func(follow Follow) bool {
+		return follow.FollowerID == followerID && follow.FolloweeID == followeeID
+	})
+}
+
+// following returns the IDs followerID follows, without pending requests, sorted
+func (s DBFollowStructure) following(followerID int) []int {
+	IDs := make([]int, 0)
+	for _, follow := range s.Follows {
+		if follow.FollowerID == followerID && !follow.Pending {
+			IDs = append(IDs, follow.FolloweeID)
+		}
+	}
+	slices.Sort(IDs)
+	return IDs
+}
+
+// visibleTo reports whether viewerID, 0 when anonymous, may see the
+// followers and follows of userID. users always see their own.
+func (s DBFollowStructure) visibleTo(viewerID, userID int) bool {
+	if viewerID != 0 && viewerID == userID {
+		return true
+	}
+	_, hidden := slices.BinarySearch(s.Hidden, userID)
+	return !hidden
+}
+
+// findHashtag returns the index of userID's follow of tag, -1 when there is none
+func (s DBFollowStructure) findHashtag(userID int, tag string) int {
+	return slices.IndexFunc(s.Hashtags, func(follow HashtagFollow) bool {
+		return follow.UserID == userID && follow.Tag == tag
+	})
+}
+
+// ensureFollowDB creates a new database file if it doesn't exist
+func (db *DB) ensureFollowDB() error {
+	_, err := os.ReadFile(db.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return db.writeFollowDB(DBFollowStructure{Follows: []Follow{}})
+	}
+
+	return nil
+}
+
+// readFollowDB returns the cached follows, reading the file first if
+// needed. visibility checks run on most reads, so they get the cache
+// without a copy. it is shared: callers hold the lock and must not
+// change it.
+func (db *DB) readFollowDB() (DBFollowStructure, error) {
+	if c, ok := db.cached().(DBFollowStructure); ok {
+		return c, nil
+	}
+	start := time.Now()
+	file, err := os.ReadFile(db.path)
+	db.observe("load_file", start, len(file), err)
+	if err != nil {
+		return DBFollowStructure{}, err
+	}
+
+	var database DBFollowStructure
+	err = json.Unmarshal(file, &database)
+	if err != nil {
+		return DBFollowStructure{}, err
+	}
+	if database.Follows == nil {
+		database.Follows = []Follow{}
+	}
+	db.setCache(database)
+	return database, nil
+}
+
+// loadFollowDB returns a copy of the follows to change and write back
+func (db *DB) loadFollowDB() (DBFollowStructure, error) {
+	dbStructure, err := db.readFollowDB()
+	if err != nil {
+		return DBFollowStructure{}, err
+	}
+	return dbStructure.clone(), nil
+}
+
+// clone copies the follows, hashtags and hidden users
+func (s DBFollowStructure) clone() DBFollowStructure {
+	return DBFollowStructure{
+		Follows:  slices.Clone(s.Follows),
+		Hashtags: slices.Clone(s.Hashtags),
+		Hidden:   slices.Clone(s.Hidden),
+	}
+}
+
+// writeFollowDB writes the database file to disk
+func (db *DB) writeFollowDB(dbFollowStructure DBFollowStructure) error {
+	file, err := db.encode(dbFollowStructure)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = db.writeFile(file)
+	db.observe("write_file", start, len(file), err)
+	if err != nil {
+		db.dropCache()
+		return err
+	}
+	db.setCache(dbFollowStructure.clone())
+
+	return nil
+}
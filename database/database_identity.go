@@ -0,0 +1,120 @@
+package database
+
+import (
+	"errors"
+)
+
+// Identity attaches an external oauth account to a local user
+type Identity struct {
+	Provider       string `json:"provider"`
+	ProviderUserID string `json:"provider_user_id"`
+	UserID         int    `json:"user_id"`
+}
+
+var (
+	// ErrIdentityTaken is returned when the identity belongs to another user
+	ErrIdentityTaken = errors.New("identity is linked to another account")
+	// ErrLastLoginMethod is returned when unlinking would lock the user out
+	ErrLastLoginMethod = errors.New("cannot remove the last login method")
+)
+
+func identityKey(provider, providerUserID string) string {
+	return provider + ":" + providerUserID
+}
+
+// LinkIdentity attaches the provider identity to the user.
+// linking the same identity to the same user again is a no-op.
+func (db *DB) LinkIdentity(userID int, provider, providerUserID string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadUserDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := dbStructure.Users[userID]; !ok {
+		return errors.New("invalid user id")
+	}
+
+	key := identityKey(provider, providerUserID)
+	if identity, ok := dbStructure.Identities[key]; ok {
+		if identity.UserID != userID {
+			return ErrIdentityTaken
+		}
+		return nil
+	}
+	// one identity per provider and user
+	for _, identity := range dbStructure.Identities {
+		if identity.UserID == userID && identity.Provider == provider {
+			return ErrIdentityTaken
+		}
+	}
+
+	dbStructure.Identities[key] = Identity{
+		Provider:       provider,
+		ProviderUserID: providerUserID,
+		UserID:         userID,
+	}
+	return db.writeUserDB(dbStructure)
+}
+
+// GetUserByIdentity returns the user the provider identity is linked to
+func (db *DB) GetUserByIdentity(provider, providerUserID string) (User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadUserDB()
+	if err != nil {
+		return User{}, err
+	}
+	identity, ok := dbStructure.Identities[identityKey(provider, providerUserID)]
+	if !ok {
+		return User{}, errors.New("identity not found")
+	}
+	user, ok := dbStructure.Users[identity.UserID]
+	if !ok {
+		return User{}, errors.New("identity not found")
+	}
+	return user, nil
+}
+
+// UnlinkIdentity detaches the user's identity for provider.
+// it fails with ErrLastLoginMethod when the user would have no password
+// and no other identity left to log in with.
+func (db *DB) UnlinkIdentity(userID int, provider string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadUserDB()
+	if err != nil {
+		return err
+	}
+	user, ok := dbStructure.Users[userID]
+	if !ok {
+		return errors.New("invalid user id")
+	}
+
+	var key string
+	methods := 0
+	if !user.NoPassword {
+		methods++
+	}
+	for k, identity := range dbStructure.Identities {
+		if identity.UserID != userID {
+			continue
+		}
+		methods++
+		if identity.Provider == provider {
+			key = k
+		}
+	}
+	if key == "" {
+		return errors.New("identity not found")
+	}
+	if methods <= 1 {
+		return ErrLastLoginMethod
+	}
+
+	delete(dbStructure.Identities, key)
+	return db.writeUserDB(dbStructure)
+}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// RepairUser recomputes the user's linked accounts from both sides of each
+// link and returns what it changed. links are written to both users at
+// once, so a link the other user doesn't have, to a user that doesn't
+// exist or to the user itself is a half-written link and is dropped, on
+// whichever side it is.
+func (db *DB) RepairUser(ID int) ([]string, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadUserDB()
+	if err != nil {
+		return nil, err
+	}
+	user, ok := dbStructure.Users[ID]
+	if !ok {
+		return nil, errors.New("invalid user id")
+	}
+	linked, others, fixes := userLinks(dbStructure, user)
+	if len(fixes) == 0 {
+		return fixes, nil
+	}
+
+	for _, otherID := range others {
+		other := dbStructure.Users[otherID]
+		other.LinkedAccounts = slices.DeleteFunc(other.LinkedAccounts, func(l int) bool { return l == ID })
+		dbStructure.Users[otherID] = other
+	}
+	user.LinkedAccounts = linked
+	dbStructure.Users[ID] = user
+	err = db.writeUserDB(dbStructure)
+	if err != nil {
+		return nil, err
+	}
+	return fixes, nil
+}
+
+// UserProblems returns what RepairUser would fix without changing anything
+func (db *DB) UserProblems(ID int) ([]string, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadUserDB()
+	if err != nil {
+		return nil, err
+	}
+	user, ok := dbStructure.Users[ID]
+	if !ok {
+		return nil, errors.New("invalid user id")
+	}
+	_, _, fixes := userLinks(dbStructure, user)
+	return fixes, nil
+}
+
+// userLinks returns the links of user that are intact, the users with a
+// link to user it doesn't have back, and a description of every bad link
+func userLinks(dbStructure DBUserStructure, user User) ([]int, []int, []string) {
+	fixes := make([]string, 0)
+	var linked, seen []int
+	for _, linkedID := range user.LinkedAccounts {
+		other, ok := dbStructure.Users[linkedID]
+		duplicate := slices.Contains(seen, linkedID)
+		seen = append(seen, linkedID)
+		switch {
+		case duplicate:
+			fixes = append(fixes, fmt.Sprintf("duplicate link to user %d", linkedID))
+		case linkedID == user.ID:
+			fixes = append(fixes, "link to itself")
+		case !ok:
+			fixes = append(fixes, fmt.Sprintf("link to missing user %d", linkedID))
+		case !slices.Contains(other.LinkedAccounts, user.ID):
+			fixes = append(fixes, fmt.Sprintf("link to user %d, who doesn't link back", linkedID))
+		default:
+			linked = append(linked, linkedID)
+		}
+	}
+
+	others := make([]int, 0)
+	for otherID, other := range dbStructure.Users {
+		if otherID != user.ID && slices.Contains(other.LinkedAccounts, user.ID) && !slices.Contains(linked, otherID) {
+			others = append(others, otherID)
+		}
+	}
+	sort.Ints(others)
+	for _, otherID := range others {
+		fixes = append(fixes, fmt.Sprintf("link from user %d, who isn't linked back", otherID))
+	}
+	return linked, others, fixes
+}
+
+// GetIdentitiesOfUser returns the oauth identities linked to the user, sorted by provider
+func (db *DB) GetIdentitiesOfUser(userID int) ([]Identity, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadUserDB()
+	if err != nil {
+		return nil, err
+	}
+	identities := make([]Identity, 0)
+	for _, identity := range dbStructure.Identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Provider < identities[j].Provider })
+	return identities, nil
+}
+
+// GetListsWithMember returns the lists the user is a member of, sorted by ID
+func (db *DB) GetListsWithMember(userID int) ([]List, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadListDB()
+	if err != nil {
+		return nil, err
+	}
+	lists := make([]List, 0)
+	for _, list := range dbStructure.Lists {
+		if slices.Contains(list.Members, userID) {
+			lists = append(lists, list)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].ID < lists[j].ID })
+	return lists, nil
+}
+
+// GetStoredChirp returns the chirp as stored, tombstoned or not
+func (db *DB) GetStoredChirp(ID int) (Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, ok := dbStructure.Chirps[ID]
+	if !ok {
+		return Chirp{}, errors.New("invalid ID")
+	}
+	return chirp, nil
+}
+
+// CountChirpsByAuthor returns how many live and tombstoned chirps authorID has
+func (db *DB) CountChirpsByAuthor(authorID int) (int, int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return 0, 0, err
+	}
+	live, deleted := 0, 0
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID != authorID {
+			continue
+		}
+		if chirp.DeletedAt != nil {
+			deleted++
+		} else {
+			live++
+		}
+	}
+	return live, deleted, nil
+}
+
+// Verify checks the invariants every write keeps and returns a description
+// of each violation, none for a consistent file: no ID is in use twice or
+// above its counter, emails are unique ignoring case, live chirps have an
+// author, identities belong to a user, links are on both sides and no user
+// has more sessions than maxSessions.
+func (db *DB) Verify() ([]string, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	chirps, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	users, err := db.loadUserDB()
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make([]string, 0)
+	for _, id := range chirps.Chirps.ids() {
+		chirp := chirps.Chirps[id]
+		switch {
+		case chirp.ID != id:
+			problems = append(problems, fmt.Sprintf("chirp %d is stored as %d", chirp.ID, id))
+		case id > chirps.NextID:
+			problems = append(problems, fmt.Sprintf("chirp %d is above next_chirp_id %d", id, chirps.NextID))
+		}
+		if _, ok := users.Users[chirp.AuthorID]; !ok && chirp.DeletedAt == nil && chirp.AuthorID != 0 {
+			problems = append(problems, fmt.Sprintf("live chirp %d of missing user %d", id, chirp.AuthorID))
+		}
+	}
+
+	emails := make(map[string]int)
+	for _, id := range users.Users.ids() {
+		user := users.Users[id]
+		switch {
+		case user.ID != id:
+			problems = append(problems, fmt.Sprintf("user %d is stored as %d", user.ID, id))
+		case id > users.NextID:
+			problems = append(problems, fmt.Sprintf("user %d is above next_user_id %d", id, users.NextID))
+		}
+		key := strings.ToLower(user.Email)
+		if other, ok := emails[key]; ok {
+			problems = append(problems, fmt.Sprintf("users %d and %d share the email %s", other, id, user.Email))
+		} else {
+			emails[key] = id
+		}
+		if len(user.Sessions) > maxSessions {
+			problems = append(problems, fmt.Sprintf("user %d has %d sessions, more than %d", id, len(user.Sessions), maxSessions))
+		}
+		_, _, fixes := userLinks(users, user)
+		for _, fix := range fixes {
+			problems = append(problems, fmt.Sprintf("user %d: %s", id, fix))
+		}
+	}
+
+	keys := make([]string, 0, len(users.Identities))
+	for key := range users.Identities {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, ok := users.Users[users.Identities[key].UserID]; !ok {
+			problems = append(problems, fmt.Sprintf("identity %s of missing user %d", key, users.Identities[key].UserID))
+		}
+	}
+	return problems, nil
+}
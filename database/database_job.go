@@ -0,0 +1,250 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDead    = "dead"
+)
+
+type Job struct {
+	ID        int             `json:"id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	RunAt     time.Time       `json:"run_at"`
+	Attempts  int             `json:"attempts"`
+	Status    string          `json:"status"`
+	LastError string          `json:"last_error,omitempty"`
+}
+
+type DBJobStructure struct {
+	Jobs   IDMap[Job] `json:"jobs"`
+	NextID int        `json:"next_id"`
+}
+
+// NewJobDB creates the job queue database and creates its file if it does not exist.
+// jobs left running by a previous process are put back to pending so they run again.
+func NewJobDB(path string) (*DB, error) {
+	db := &DB{
+		path: path,
+		mux:  &sync.RWMutex{},
+	}
+	err := db.ensureJobDB()
+	if err != nil {
+		return nil, err
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	dbStructure, err := db.loadJobDB()
+	if err != nil {
+		return nil, err
+	}
+	for id, job := range dbStructure.Jobs {
+		if job.Status == JobRunning {
+			job.Status = JobPending
+			dbStructure.Jobs[id] = job
+		}
+	}
+	err = db.writeJobDB(dbStructure)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// EnqueueJob persists a new pending job
+func (db *DB) EnqueueJob(jobType string, payload json.RawMessage, runAt time.Time) (Job, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadJobDB()
+	if err != nil {
+		return Job{}, err
+	}
+	dbStructure.NextID++
+	job := Job{
+		ID:      dbStructure.NextID,
+		Type:    jobType,
+		Payload: payload,
+		RunAt:   runAt,
+		Status:  JobPending,
+	}
+	dbStructure.Jobs[job.ID] = job
+
+	err = db.writeJobDB(dbStructure)
+	if err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ClaimDueJobs marks up to limit pending jobs whose run time has passed as
+// running and returns them, oldest first.
+func (db *DB) ClaimDueJobs(now time.Time, limit int) ([]Job, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadJobDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var due []Job
+	for _, job := range dbStructure.Jobs {
+		if job.Status == JobPending && !job.RunAt.After(now) {
+			due = append(due, job)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].RunAt.Before(due[j].RunAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for i := range due {
+		due[i].Status = JobRunning
+		due[i].Attempts++
+		dbStructure.Jobs[due[i].ID] = due[i]
+	}
+	err = db.writeJobDB(dbStructure)
+	if err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// CompleteJob removes a job that ran successfully
+func (db *DB) CompleteJob(ID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadJobDB()
+	if err != nil {
+		return err
+	}
+	delete(dbStructure.Jobs, ID)
+	return db.writeJobDB(dbStructure)
+}
+
+// FailJob records a failed run. the job is retried at retryAt, or moved to
+// the dead-letter list when dead is true.
+func (db *DB) FailJob(ID int, jobErr error, retryAt time.Time, dead bool) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadJobDB()
+	if err != nil {
+		return err
+	}
+	job, ok := dbStructure.Jobs[ID]
+	if !ok {
+		return errors.New("invalid job id")
+	}
+	job.LastError = jobErr.Error()
+	job.RunAt = retryAt
+	job.Status = JobPending
+	if dead {
+		job.Status = JobDead
+	}
+	dbStructure.Jobs[ID] = job
+	return db.writeJobDB(dbStructure)
+}
+
+// GetJobs returns every job with the given status sorted by ID
+func (db *DB) GetJobs(status string) ([]Job, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadJobDB()
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]Job, 0)
+	for _, job := range dbStructure.Jobs {
+		if job.Status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, nil
+}
+
+// ensureJobDB creates a new database file if it doesn't exist
+func (db *DB) ensureJobDB() error {
+	_, err := os.ReadFile(db.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		dbJobStructure := DBJobStructure{
+			Jobs: make(map[int]Job),
+		}
+		return db.writeJobDB(dbJobStructure)
+	}
+
+	return nil
+}
+
+// loadJobDB returns a copy of the jobs, from the cache once the file was read
+func (db *DB) loadJobDB() (DBJobStructure, error) {
+	if c, ok := db.cached().(DBJobStructure); ok {
+		return c.clone(), nil
+	}
+	start := time.Now()
+	file, err := os.ReadFile(db.path)
+	db.observe("load_file", start, len(file), err)
+	if err != nil {
+		return DBJobStructure{}, err
+	}
+
+	var database DBJobStructure
+	err = json.Unmarshal(file, &database)
+	if err != nil {
+		return DBJobStructure{}, err
+	}
+	if database.Jobs == nil {
+		database.Jobs = make(map[int]Job)
+	}
+	db.setCache(database.clone())
+	return database, nil
+}
+
+// clone copies the jobs and their payloads
+func (s DBJobStructure) clone() DBJobStructure {
+	jobs := maps.Clone(s.Jobs)
+	for id, job := range jobs {
+		job.Payload = slices.Clone(job.Payload)
+		jobs[id] = job
+	}
+	return DBJobStructure{Jobs: jobs, NextID: s.NextID}
+}
+
+// writeJobDB writes the database file to disk
+func (db *DB) writeJobDB(dbJobStructure DBJobStructure) error {
+	file, err := db.encode(dbJobStructure)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = db.writeFile(file)
+	db.observe("write_file", start, len(file), err)
+	if err != nil {
+		db.dropCache()
+		return err
+	}
+	db.setCache(dbJobStructure.clone())
+
+	return nil
+}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrListNotFound = errors.New("list not found")
+
+// List is a curated set of users whose chirps make up a feed
+type List struct {
+	ID          int       `json:"id"`
+	OwnerID     int       `json:"owner_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Private     bool      `json:"private"`
+	Members     []int     `json:"members"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type DBListStructure struct {
+	Lists  IDMap[List] `json:"lists"`
+	NextID int         `json:"next_id"`
+}
+
+// NewListDB creates the lists database and creates its file if it does not exist.
+func NewListDB(path string) (*DB, error) {
+	db := &DB{
+		path: path,
+		mux:  &sync.RWMutex{},
+	}
+	err := db.ensureListDB()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// create a new empty list owned by ownerID
+func (db *DB) CreateList(ownerID int, name, description string, private bool) (List, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadListDB()
+	if err != nil {
+		return List{}, err
+	}
+	dbStructure.NextID++
+	list := List{
+		ID:          dbStructure.NextID,
+		OwnerID:     ownerID,
+		Name:        name,
+		Description: description,
+		Private:     private,
+		Members:     []int{},
+		CreatedAt:   db.now(),
+	}
+	dbStructure.Lists[list.ID] = list
+
+	err = db.writeListDB(dbStructure)
+	if err != nil {
+		return List{}, err
+	}
+	return list, nil
+}
+
+// get list from id
+func (db *DB) GetList(ID int) (List, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadListDB()
+	if err != nil {
+		return List{}, err
+	}
+	list, ok := dbStructure.Lists[ID]
+	if !ok {
+		return List{}, ErrListNotFound
+	}
+	return list, nil
+}
+
+// get every list owned by ownerID sorted by ID
+func (db *DB) GetListsByOwner(ownerID int) ([]List, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadListDB()
+	if err != nil {
+		return nil, err
+	}
+	lists := make([]List, 0)
+	for _, list := range dbStructure.Lists {
+		if list.OwnerID == ownerID {
+			lists = append(lists, list)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].ID < lists[j].ID })
+	return lists, nil
+}
+
+// add a member to a list. adding an existing member is a no-op.
+func (db *DB) AddListMember(ID int, userID int) (List, error) {
+	return db.updateList(ID, func(list *List) {
+		if !slices.Contains(list.Members, userID) {
+			list.Members = append(list.Members, userID)
+		}
+	})
+}
+
+// remove a member from a list. removing a non-member is a no-op.
+func (db *DB) RemoveListMember(ID int, userID int) (List, error) {
+	return db.updateList(ID, func(list *List) {
+		list.Members = slices.DeleteFunc(list.Members, func(id int) bool { return id == userID })
+	})
+}
+
+// RemoveUserFromLists deletes the lists a user owns and takes them out of
+// every other list, for when the user is deleted
+func (db *DB) RemoveUserFromLists(userID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadListDB()
+	if err != nil {
+		return err
+	}
+	for id, list := range dbStructure.Lists {
+		if list.OwnerID == userID {
+			delete(dbStructure.Lists, id)
+			continue
+		}
+		list.Members = slices.DeleteFunc(list.Members, func(member int) bool { return member == userID })
+		dbStructure.Lists[id] = list
+	}
+	return db.writeListDB(dbStructure)
+}
+
+// updateList loads the list, applies update and writes the result under the lock
+func (db *DB) updateList(ID int, update func(list *List)) (List, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadListDB()
+	if err != nil {
+		return List{}, err
+	}
+	list, ok := dbStructure.Lists[ID]
+	if !ok {
+		return List{}, ErrListNotFound
+	}
+	update(&list)
+	dbStructure.Lists[ID] = list
+
+	err = db.writeListDB(dbStructure)
+	if err != nil {
+		return List{}, err
+	}
+	return list, nil
+}
+
+// ensureListDB creates a new database file if it doesn't exist
+func (db *DB) ensureListDB() error {
+	_, err := os.ReadFile(db.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		dbListStructure := DBListStructure{
+			Lists: make(map[int]List),
+		}
+		return db.writeListDB(dbListStructure)
+	}
+
+	return nil
+}
+
+// loadListDB returns a copy of the lists, from the cache once the file was read
+func (db *DB) loadListDB() (DBListStructure, error) {
+	if c, ok := db.cached().(DBListStructure); ok {
+		return c.clone(), nil
+	}
+	start := time.Now()
+	file, err := os.ReadFile(db.path)
+	db.observe("load_file", start, len(file), err)
+	if err != nil {
+		return DBListStructure{}, err
+	}
+
+	var database DBListStructure
+	err = json.Unmarshal(file, &database)
+	if err != nil {
+		return DBListStructure{}, err
+	}
+	if database.Lists == nil {
+		database.Lists = make(map[int]List)
+	}
+	db.setCache(database.clone())
+	return database, nil
+}
+
+// clone copies the lists and their members
+func (s DBListStructure) clone() DBListStructure {
+	lists := maps.Clone(s.Lists)
+	for id, list := range lists {
+		list.Members = slices.Clone(list.Members)
+		lists[id] = list
+	}
+	return DBListStructure{Lists: lists, NextID: s.NextID}
+}
+
+// writeListDB writes the database file to disk
+func (db *DB) writeListDB(dbListStructure DBListStructure) error {
+	file, err := db.encode(dbListStructure)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = db.writeFile(file)
+	db.observe("write_file", start, len(file), err)
+	if err != nil {
+		db.dropCache()
+		return err
+	}
+	db.setCache(dbListStructure.clone())
+
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ErrLocked is returned by LockDir when another process holds the lock
+var ErrLocked = errors.New("data directory is locked by another process")
+
+// DirLock is the lock of a data directory, see LockDir
+type DirLock struct {
+	file *os.File
+}
+
+// LockDir takes the exclusive lock on the data directory dir. the server
+// holds it while serving so offline tools can tell the files are in use.
+func LockDir(dir string) (*DirLock, error) {
+	file, err := os.OpenFile(filepath.Join(dir, "chirpy.lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return &DirLock{file: file}, nil
+}
+
+// Unlock releases the lock
+func (l *DirLock) Unlock() error {
+	return l.file.Close()
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package database
+
+import "errors"
+
+// ErrLocked is returned by LockDir when another process holds the lock
+var ErrLocked = errors.New("data directory is locked by another process")
+
+// DirLock is the lock of a data directory, see LockDir
+type DirLock struct{}
+
+// LockDir is a no-op where flock isn't available, offline tools can't
+// tell whether a server is using the files
+func LockDir(dir string) (*DirLock, error) {
+	return &DirLock{}, nil
+}
+
+// Unlock releases the lock
+func (l *DirLock) Unlock() error {
+	return nil
+}
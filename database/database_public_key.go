@@ -0,0 +1,201 @@
+package database
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrPublicKeyNotFound = errors.New("public key not found")
+
+// PublicKey is an admin-minted read-only key for embeds. only the hash of
+// the key is stored, it is shown once when it is created.
+type PublicKey struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	KeyHash        string     `json:"key_hash"` // sha256, hex
+	Prefix         string     `json:"prefix"`   // start of the key, to tell keys apart
+	AllowedOrigins []string   `json:"allowed_origins"`
+	PerMinute      int        `json:"per_minute"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+}
+
+type DBPublicKeyStructure struct {
+	Keys   IDMap[PublicKey] `json:"keys"`
+	NextID int              `json:"next_id"`
+}
+
+// NewPublicKeyDB creates the public key database and creates its file if it does not exist.
+func NewPublicKeyDB(path string) (*DB, error) {
+	db := &DB{
+		path: path,
+		mux:  &sync.RWMutex{},
+	}
+	err := db.ensurePublicKeyDB()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// CreatePublicKey stores a new key by its hash
+func (db *DB) CreatePublicKey(name, keyHash, prefix string, allowedOrigins []string, perMinute int) (PublicKey, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadPublicKeyDB()
+	if err != nil {
+		return PublicKey{}, err
+	}
+	dbStructure.NextID++
+	key := PublicKey{
+		ID:             dbStructure.NextID,
+		Name:           name,
+		KeyHash:        keyHash,
+		Prefix:         prefix,
+		AllowedOrigins: allowedOrigins,
+		PerMinute:      perMinute,
+		CreatedAt:      db.now(),
+	}
+	dbStructure.Keys[key.ID] = key
+
+	err = db.writePublicKeyDB(dbStructure)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	return key, nil
+}
+
+// GetPublicKeys returns every key, revoked ones included, sorted by ID
+func (db *DB) GetPublicKeys() ([]PublicKey, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadPublicKeyDB()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]PublicKey, 0, len(dbStructure.Keys))
+	for _, key := range dbStructure.Keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+// GetPublicKeyByHash returns the key with keyHash, revoked or not
+func (db *DB) GetPublicKeyByHash(keyHash string) (PublicKey, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadPublicKeyDB()
+	if err != nil {
+		return PublicKey{}, err
+	}
+	for _, key := range dbStructure.Keys {
+		if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(keyHash)) == 1 {
+			return key, nil
+		}
+	}
+	return PublicKey{}, ErrPublicKeyNotFound
+}
+
+// RevokePublicKey stops a key from being accepted. revoking it again keeps
+// the first revocation time.
+func (db *DB) RevokePublicKey(ID int) (PublicKey, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadPublicKeyDB()
+	if err != nil {
+		return PublicKey{}, err
+	}
+	key, ok := dbStructure.Keys[ID]
+	if !ok {
+		return PublicKey{}, ErrPublicKeyNotFound
+	}
+	if key.RevokedAt != nil {
+		return key, nil
+	}
+	now := db.now()
+	key.RevokedAt = &now
+	dbStructure.Keys[ID] = key
+
+	err = db.writePublicKeyDB(dbStructure)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	return key, nil
+}
+
+// clone copies the keys and their origins
+func (s DBPublicKeyStructure) clone() DBPublicKeyStructure {
+	keys := maps.Clone(s.Keys)
+	for id, key := range keys {
+		key.AllowedOrigins = slices.Clone(key.AllowedOrigins)
+		keys[id] = key
+	}
+	return DBPublicKeyStructure{Keys: keys, NextID: s.NextID}
+}
+
+// ensurePublicKeyDB creates a new database file if it doesn't exist
+func (db *DB) ensurePublicKeyDB() error {
+	_, err := os.ReadFile(db.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return db.writePublicKeyDB(DBPublicKeyStructure{Keys: make(map[int]PublicKey)})
+	}
+
+	return nil
+}
+
+// loadPublicKeyDB reads the database file into memory. every request made
+// with a key looks it up, so the file is cached like the users and chirps.
+func (db *DB) loadPublicKeyDB() (DBPublicKeyStructure, error) {
+	if c, ok := db.cached().(DBPublicKeyStructure); ok {
+		return c.clone(), nil
+	}
+	start := time.Now()
+	file, err := os.ReadFile(db.path)
+	db.observe("load_file", start, len(file), err)
+	if err != nil {
+		return DBPublicKeyStructure{}, err
+	}
+
+	var database DBPublicKeyStructure
+	err = json.Unmarshal(file, &database)
+	if err != nil {
+		return DBPublicKeyStructure{}, err
+	}
+	if database.Keys == nil {
+		database.Keys = make(map[int]PublicKey)
+	}
+	db.setCache(database.clone())
+	return database, nil
+}
+
+// writePublicKeyDB writes the database file to disk
+func (db *DB) writePublicKeyDB(dbStructure DBPublicKeyStructure) error {
+	file, err := db.encode(dbStructure)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = db.writeFile(file)
+	db.observe("write_file", start, len(file), err)
+	if err != nil {
+		db.dropCache()
+		return err
+	}
+	db.setCache(dbStructure.clone())
+
+	return nil
+}
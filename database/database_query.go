@@ -0,0 +1,210 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// a hashtag starts a word with # and runs to the first character that
+// isn't a letter, digit or underscore
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&])#([\p{L}\p{N}_]+)`)
+
+// ChirpHashtags returns the hashtags of body lowercased without the #, in
+// order of first use
+func ChirpHashtags(body string) []string {
+	var tags []string
+	for _, m := range hashtagPattern.FindAllStringSubmatch(body, -1) {
+		tag := strings.ToLower(m[1])
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ChirpQuery selects, orders and pages live chirps. zero values don't filter.
+type ChirpQuery struct {
+	AuthorIDs   []int     // only chirps of these authors, every author when nil
+	Hashtags    []string  // chirps with one of these tags pass AuthorIDs too, see ChirpHashtags
+	HiddenIDs   []int     // never chirps of these authors
+	Lang        string    // canonical lang tag, en also matches en-GB
+	DefaultLang string    // lang of chirps stored without one
+	SinceID     int       // only chirps with a higher ID
+	Since       time.Time // only chirps created at or after Since
+	Before      time.Time // only chirps created before Before
+	Text        string    // only chirps whose body contains Text, ignoring case
+	Desc        bool      // newest first
+	ByActivity  bool      // newest bump or creation first, for feeds. ties go to the higher ID
+	Limit       int       // at most Limit chirps, every match when 0
+	Offset      int
+}
+
+// Validate checks the query against the limit cap and for contradictions
+func (q ChirpQuery) Validate(maxLimit int) error {
+	if q.Limit < 0 {
+		return errors.New("invalid limit")
+	}
+	if q.Limit > maxLimit {
+		return fmt.Errorf("limit must be at most %d", maxLimit)
+	}
+	if q.Offset < 0 {
+		return errors.New("invalid offset")
+	}
+	if q.SinceID < 0 {
+		return errors.New("invalid since_id")
+	}
+	if !q.Since.IsZero() && !q.Before.IsZero() && !q.Since.Before(q.Before) {
+		return errors.New("since must be before before")
+	}
+	return nil
+}
+
+// matches reports whether a live chirp passes every filter of the query.
+// text is q.Text lowercased. time filters leave out chirps from before
+// creation times were tracked.
+func (q ChirpQuery) matches(chirp Chirp, authors, hidden map[int]bool, text string) bool {
+	if authors != nil && !authors[chirp.AuthorID] && !q.tagged(chirp) {
+		return false
+	}
+	if hidden[chirp.AuthorID] {
+		return false
+	}
+	if text != "" && !strings.Contains(strings.ToLower(chirp.Body), text) {
+		return false
+	}
+	if chirp.ID <= q.SinceID {
+		return false
+	}
+	if q.Lang != "" {
+		lang := chirp.Lang
+		if lang == "" {
+			lang = q.DefaultLang
+		}
+		if lang != q.Lang && !strings.HasPrefix(lang, q.Lang+"-") {
+			return false
+		}
+	}
+	if !q.Since.IsZero() || !q.Before.IsZero() {
+		if chirp.CreatedAt == nil {
+			return false
+		}
+		if !q.Since.IsZero() && chirp.CreatedAt.Before(q.Since) {
+			return false
+		}
+		if !q.Before.IsZero() && !chirp.CreatedAt.Before(q.Before) {
+			return false
+		}
+	}
+	return true
+}
+
+// tagged reports whether the chirp has one of the hashtags of the query
+func (q ChirpQuery) tagged(chirp Chirp) bool {
+	if len(q.Hashtags) == 0 {
+		return false
+	}
+	for _, tag := range ChirpHashtags(chirp.Body) {
+		if slices.Contains(q.Hashtags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorSet returns AuthorIDs as a set, nil when every author passes
+func (q ChirpQuery) authorSet() map[int]bool {
+	if q.AuthorIDs == nil {
+		return nil
+	}
+	authors := make(map[int]bool, len(q.AuthorIDs))
+	for _, id := range q.AuthorIDs {
+		authors[id] = true
+	}
+	return authors
+}
+
+// activity is when a chirp was last created or bumped, what ByActivity orders by
+func (chirp Chirp) activity() time.Time {
+	var at time.Time
+	if chirp.CreatedAt != nil {
+		at = *chirp.CreatedAt
+	}
+	if chirp.BumpedAt != nil && chirp.BumpedAt.After(at) {
+		at = *chirp.BumpedAt
+	}
+	return at
+}
+
+// QueryChirps returns the page of live chirps the query selects, ordered
+// by ID unless ByActivity is set, and how many chirps match in total. it walks the cached live
+// chirps in order once and only copies the page, text search included,
+// so an index can replace the walk without changing callers.
+func (db *DB) QueryChirps(q ChirpQuery) ([]Chirp, int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	cached, err := db.readChirps()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	authors := q.authorSet()
+	var hidden map[int]bool
+	if len(q.HiddenIDs) > 0 {
+		hidden = make(map[int]bool, len(q.HiddenIDs))
+		for _, id := range q.HiddenIDs {
+			hidden[id] = true
+		}
+	}
+	text := strings.ToLower(q.Text)
+	if q.ByActivity {
+		matches := make([]Chirp, 0)
+		for _, chirp := range cached.live {
+			if q.matches(chirp, authors, hidden, text) {
+				matches = append(matches, chirp)
+			}
+		}
+		page, total := q.pageByActivity(matches)
+		return page, total, nil
+	}
+	page := make([]Chirp, 0, min(max(q.Limit, 0), len(cached.live)))
+	total := 0
+	for i := range cached.live {
+		chirp := cached.live[i]
+		if q.Desc {
+			chirp = cached.live[len(cached.live)-1-i]
+		}
+		if !q.matches(chirp, authors, hidden, text) {
+			continue
+		}
+		if total >= q.Offset && (q.Limit == 0 || len(page) < q.Limit) {
+			page = append(page, chirp)
+		}
+		total++
+	}
+	return page, total, nil
+}
+
+// pageByActivity orders matches by activity, newest first, and returns the
+// page of the query and the number of matches. unlike the ID order it has
+// to see every match before it can cut the page. matches is reordered.
+func (q ChirpQuery) pageByActivity(matches []Chirp) ([]Chirp, int) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i].activity(), matches[j].activity()
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return matches[i].ID > matches[j].ID
+	})
+	start := min(q.Offset, len(matches))
+	end := len(matches)
+	if q.Limit > 0 {
+		end = min(start+q.Limit, end)
+	}
+	return matches[start:end], len(matches)
+}
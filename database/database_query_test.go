@@ -0,0 +1,107 @@
+package database
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChirpQueryValidate(t *testing.T) {
+	const maxLimit = 200
+	since := testEpoch
+	tests := []struct {
+		name string
+		q    ChirpQuery
+		err  string // empty when the query is valid
+	}{
+		{"empty", ChirpQuery{}, ""},
+		{"at the cap", ChirpQuery{Limit: maxLimit}, ""},
+		{"over the cap", ChirpQuery{Limit: maxLimit + 1}, "limit must be at most 200"},
+		{"negative limit", ChirpQuery{Limit: -1}, "invalid limit"},
+		{"negative offset", ChirpQuery{Offset: -1}, "invalid offset"},
+		{"offset without a limit", ChirpQuery{Offset: 10}, ""},
+		{"negative since_id", ChirpQuery{SinceID: -1}, "invalid since_id"},
+		{"since alone", ChirpQuery{Since: since}, ""},
+		{"before alone", ChirpQuery{Before: since}, ""},
+		{"since before before", ChirpQuery{Since: since, Before: since.Add(time.Second)}, ""},
+		{"since equals before", ChirpQuery{Since: since, Before: since}, "since must be before before"},
+		{"since after before", ChirpQuery{Since: since.Add(time.Hour), Before: since}, "since must be before before"},
+		{"every filter", ChirpQuery{
+			AuthorIDs: []int{1}, HiddenIDs: []int{2}, Lang: "en", SinceID: 3, Since: since, Before: since.Add(time.Hour),
+			Text: "go", Desc: true, Limit: 10, Offset: 5,
+		}, ""},
+		{"limit checked first", ChirpQuery{Limit: -1, Offset: -1}, "invalid limit"},
+	}
+	for _, tt := range tests {
+		err := tt.q.Validate(maxLimit)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: Validate = %v, want nil", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: Validate = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestChirpQueryMatches(t *testing.T) {
+	created := testEpoch
+	chirp := Chirp{ID: 5, AuthorID: 1, Body: "Going Home", Lang: "en-GB", CreatedAt: &created}
+	legacy := Chirp{ID: 5, AuthorID: 1, Body: "Going Home"}
+	tests := []struct {
+		name    string
+		q       ChirpQuery
+		chirp   Chirp
+		authors map[int]bool
+		hidden  map[int]bool
+		want    bool
+	}{
+		{"no filters", ChirpQuery{}, chirp, nil, nil, true},
+		{"author", ChirpQuery{}, chirp, map[int]bool{1: true}, nil, true},
+		{"other author", ChirpQuery{}, chirp, map[int]bool{2: true}, nil, false},
+		{"hidden", ChirpQuery{}, chirp, nil, map[int]bool{1: true}, false},
+		{"hidden wins over author", ChirpQuery{}, chirp, map[int]bool{1: true}, map[int]bool{1: true}, false},
+		{"text ignores case", ChirpQuery{Text: "HOME"}, chirp, nil, nil, true},
+		{"text missing", ChirpQuery{Text: "away"}, chirp, nil, nil, false},
+		{"since_id below", ChirpQuery{SinceID: 4}, chirp, nil, nil, true},
+		{"since_id equal", ChirpQuery{SinceID: 5}, chirp, nil, nil, false},
+		{"lang prefix", ChirpQuery{Lang: "en"}, chirp, nil, nil, true},
+		{"lang exact", ChirpQuery{Lang: "en-GB"}, chirp, nil, nil, true},
+		{"lang only a string prefix", ChirpQuery{Lang: "e"}, chirp, nil, nil, false},
+		{"other lang", ChirpQuery{Lang: "de"}, chirp, nil, nil, false},
+		{"default lang", ChirpQuery{Lang: "de", DefaultLang: "de"}, legacy, nil, nil, true},
+		{"other default lang", ChirpQuery{Lang: "de", DefaultLang: "en"}, legacy, nil, nil, false},
+		{"since at creation", ChirpQuery{Since: created}, chirp, nil, nil, true},
+		{"since after creation", ChirpQuery{Since: created.Add(time.Second)}, chirp, nil, nil, false},
+		{"before at creation", ChirpQuery{Before: created}, chirp, nil, nil, false},
+		{"before after creation", ChirpQuery{Before: created.Add(time.Second)}, chirp, nil, nil, true},
+		{"time filter without created_at", ChirpQuery{Before: created}, legacy, nil, nil, false},
+		{"tag of another author", ChirpQuery{Hashtags: []string{"home"}}, Chirp{ID: 5, AuthorID: 1, Body: "Going #Home"}, map[int]bool{2: true}, nil, true},
+		{"other tag", ChirpQuery{Hashtags: []string{"away"}}, Chirp{ID: 5, AuthorID: 1, Body: "Going #Home"}, map[int]bool{2: true}, nil, false},
+		{"hidden wins over a tag", ChirpQuery{Hashtags: []string{"home"}}, Chirp{ID: 5, AuthorID: 1, Body: "Going #Home"}, map[int]bool{2: true}, map[int]bool{1: true}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.q.matches(tt.chirp, tt.authors, tt.hidden, strings.ToLower(tt.q.Text)); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChirpHashtags(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"no tags", nil},
+		{"#go", []string{"go"}},
+		{"learning #GoLang, #go_1 and #golang again", []string{"golang", "go_1"}},
+		{"(#café) ends at the bracket", []string{"café"}},
+		{"not in a word: me#go, &#39; or a lone #", nil},
+		{"#one#two", []string{"one"}},
+	}
+	for _, tt := range tests {
+		if got := ChirpHashtags(tt.body); !slices.Equal(got, tt.want) {
+			t.Errorf("ChirpHashtags(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
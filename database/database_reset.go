@@ -0,0 +1,93 @@
+package database
+
+// ResetChirps deletes every chirp, tombstones included, so IDs start over
+// at 1. it returns how many chirps were removed.
+func (db *DB) ResetChirps() (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	err = db.writeDB(DBStructure{Chirps: make(map[int]Chirp)})
+	if err != nil {
+		return 0, err
+	}
+	db.emit(Event{Type: CollectionReset, At: db.now()})
+	return len(dbStructure.Chirps), nil
+}
+
+// ResetUsers deletes every user and linked identity, so IDs start over at 1.
+// data of other collections that refers to users has to be reset with them.
+// it returns how many users were removed.
+func (db *DB) ResetUsers() (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadUserDB()
+	if err != nil {
+		return 0, err
+	}
+	err = db.writeUserDB(DBUserStructure{
+		Users:      make(map[int]User),
+		Identities: make(map[string]Identity),
+	})
+	if err != nil {
+		return 0, err
+	}
+	db.emit(Event{Type: CollectionReset, At: db.now()})
+	return len(dbStructure.Users), nil
+}
+
+// ResetLists deletes every list and its ID counter. it returns how many
+// lists were removed.
+func (db *DB) ResetLists() (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadListDB()
+	if err != nil {
+		return 0, err
+	}
+	err = db.writeListDB(DBListStructure{Lists: make(map[int]List)})
+	if err != nil {
+		return 0, err
+	}
+	return len(dbStructure.Lists), nil
+}
+
+// ResetFollows deletes every follow, follow request and hashtag follow,
+// and the visibility settings. it returns how many follows were removed.
+func (db *DB) ResetFollows() (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadFollowDB()
+	if err != nil {
+		return 0, err
+	}
+	err = db.writeFollowDB(DBFollowStructure{Follows: []Follow{}})
+	if err != nil {
+		return 0, err
+	}
+	return len(dbStructure.Follows) + len(dbStructure.Hashtags), nil
+}
+
+// ResetSyndicationSources deletes every source and its ID counter. pending
+// pull jobs of the sources end on their next run. it returns how many
+// sources were removed.
+func (db *DB) ResetSyndicationSources() (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadSyndicationDB()
+	if err != nil {
+		return 0, err
+	}
+	err = db.writeSyndicationDB(DBSyndicationStructure{Sources: make(map[int]SyndicationSource)})
+	if err != nil {
+		return 0, err
+	}
+	return len(dbStructure.Sources), nil
+}
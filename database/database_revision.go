@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ChirpRevision identifies a state of the chirps. Revision goes up on every
+// write of the chirps, resets and imports included, and is persisted, so a
+// revision seen before is never reused for other data. ModifiedAt is when
+// the chirps last changed, nil before the first write.
+type ChirpRevision struct {
+	Revision   int
+	ModifiedAt *time.Time
+}
+
+// touch stamps s as the next revision
+func (s *DBStructure) touch(now time.Time) {
+	s.Revision++
+	s.ModifiedAt = &now
+}
+
+// ChirpRevision returns the current revision of the chirps
+func (db *DB) ChirpRevision() (ChirpRevision, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	c, err := db.readChirps()
+	if err != nil {
+		return ChirpRevision{}, err
+	}
+	return ChirpRevision{Revision: c.Revision, ModifiedAt: c.ModifiedAt}, nil
+}
+
+// changesChirps reports whether a write with events changed what is served
+// of the chirps
+func changesChirps(events []Event) bool {
+	for _, event := range events {
+		switch event.Type {
+		case ChirpCreated, ChirpDeleted, ChirpRestored, ChirpUpdated, ChirpBumped, ChirpAnonymized, CollectionReset:
+			return true
+		}
+	}
+	return false
+}
+
+// touchChirps bumps the chirp revision within tx
+func (s *SQLiteStore) touchChirps(tx *sql.Tx) error {
+	_, err := tx.Exec(`UPDATE chirp_revision SET revision = revision + 1, modified_at = ? WHERE id = 1`, s.now().UnixNano())
+	return err
+}
+
+// ChirpRevision returns the current revision of the chirps
+func (s *SQLiteStore) ChirpRevision() (ChirpRevision, error) {
+	var rev ChirpRevision
+	var modifiedAt sql.NullInt64
+	err := s.db.QueryRow(`SELECT revision, modified_at FROM chirp_revision WHERE id = 1`).Scan(&rev.Revision, &modifiedAt)
+	if err != nil {
+		return ChirpRevision{}, err
+	}
+	rev.ModifiedAt = fromUnixNanos(modifiedAt)
+	return rev, nil
+}
@@ -0,0 +1,1578 @@
+package database
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/clock"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ErrStoreNotEmpty is returned when importing into a store that already
+// has users or chirps
+var ErrStoreNotEmpty = errors.New("the store already has users or chirps")
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL,
+	email_key TEXT NOT NULL, -- lowercased, emails match ignoring case
+	password BLOB,
+	refresh_token TEXT NOT NULL DEFAULT '',
+	refresh_token_expires_at INTEGER,
+	refresh_token_family TEXT NOT NULL DEFAULT '',
+	is_chirpy_red INTEGER NOT NULL DEFAULT 0,
+	version INTEGER NOT NULL DEFAULT 0,
+	linked_accounts TEXT NOT NULL DEFAULT '[]',
+	oauth_provider TEXT NOT NULL DEFAULT '',
+	no_password INTEGER NOT NULL DEFAULT 0,
+	totp_enabled INTEGER NOT NULL DEFAULT 0,
+	totp_secret TEXT NOT NULL DEFAULT '',
+	totp_pending_secret TEXT NOT NULL DEFAULT '',
+	totp_backup_codes TEXT NOT NULL DEFAULT '[]'
+);
+CREATE INDEX IF NOT EXISTS users_email_key ON users (email_key);
+
+CREATE TABLE IF NOT EXISTS identities (
+	provider TEXT NOT NULL,
+	provider_user_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	PRIMARY KEY (provider, provider_user_id)
+);
+CREATE INDEX IF NOT EXISTS identities_user_id ON identities (user_id);
+
+CREATE TABLE IF NOT EXISTS chirps (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	author_id INTEGER NOT NULL,
+	body TEXT NOT NULL,
+	lang TEXT NOT NULL DEFAULT '',
+	created_at INTEGER,
+	deleted_at INTEGER,
+	source_url TEXT NOT NULL DEFAULT '',
+	source TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS chirps_author_id ON chirps (author_id);
+CREATE INDEX IF NOT EXISTS chirps_source_url ON chirps (source_url) WHERE source_url != '';
+`
+
+// sqliteMigrations bring the schema up to date in order. user_version
+// counts the ones a database has applied.
+var sqliteMigrations = []string{
+	sqliteSchema,
+	`ALTER TABLE chirps ADD COLUMN updated_at INTEGER`,
+	`CREATE TABLE chirp_revision (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		revision INTEGER NOT NULL,
+		modified_at INTEGER
+	);
+	INSERT INTO chirp_revision (id, revision) VALUES (1, 0);`,
+	`ALTER TABLE users ADD COLUMN sessions TEXT NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE users ADD COLUMN protected INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX users_protected ON users (id) WHERE protected = 1;`,
+	`ALTER TABLE chirps ADD COLUMN bumped_at INTEGER`,
+	// emails are unique ignoring case, the index makes sure of it
+	`DROP INDEX users_email_key;
+	CREATE UNIQUE INDEX users_email_key ON users (email_key);`,
+}
+
+const (
+	userColumns = `id, email, password, refresh_token, refresh_token_expires_at, refresh_token_family,
+	is_chirpy_red, version, linked_accounts, oauth_provider, no_password,
+	totp_enabled, totp_secret, totp_pending_secret, totp_backup_codes, sessions, protected`
+	chirpColumns = `id, author_id, body, lang, created_at, deleted_at, source_url, source, updated_at, bumped_at`
+)
+
+// SQLiteStore keeps the chirps and users in a SQLite database, so a write
+// only changes the rows it touches. times are stored as unix nanoseconds.
+// writes are serialized and publish their events in commit order, like
+// the json database does.
+type SQLiteStore struct {
+	path     string
+	db       *sql.DB
+	mux      *sync.Mutex // held by writes
+	events   *Bus
+	degraded atomic.Bool // set while writes fail because the disk is full
+	clock    clock.Clock // the wall clock when nil
+}
+
+// OpenSQLite opens the SQLite database at path and creates it and its
+// tables if they don't exist
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// one connection, so transactions never wait on each other for the lock
+	db.SetMaxOpenConns(1)
+	err = migrateSQLite(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{path: path, db: db, mux: &sync.Mutex{}}, nil
+}
+
+// migrateSQLite applies the migrations the database doesn't have yet, each
+// in a transaction with the new user_version
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	err := db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(sqliteMigrations[version])
+		if err == nil {
+			_, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sqlite migration %d: %w", version+1, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetClock makes the store stamp records with c instead of the wall clock
+func (s *SQLiteStore) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+func (s *SQLiteStore) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
+// SetEventBus makes the store publish its changes to b.
+// it must be set before the store is used.
+func (s *SQLiteStore) SetEventBus(b *Bus) {
+	s.events = b
+}
+
+// StorageDegraded reports whether the last write failed because the disk was full
+func (s *SQLiteStore) StorageDegraded() bool {
+	return s.degraded.Load()
+}
+
+// Close waits for the write in progress and closes the database. the
+// store can't be used after.
+func (s *SQLiteStore) Close() {
+	s.mux.Lock()
+	s.db.Close()
+}
+
+// write runs fn in a transaction and publishes the events it returns once
+// the transaction is committed. a full disk or a lock that couldn't be
+// taken is ErrStorageUnavailable, like failed writes of the json database.
+func (s *SQLiteStore) write(fn func(tx *sql.Tx) ([]Event, error)) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return s.writeError(err)
+	}
+	events, err := fn(tx)
+	if err == nil && changesChirps(events) {
+		err = s.touchChirps(tx)
+	}
+	if err != nil {
+		tx.Rollback()
+		return s.writeError(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return s.writeError(err)
+	}
+	s.degraded.Store(false)
+	if s.events != nil && len(events) > 0 {
+		s.events.publish(events...)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) writeError(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_FULL:
+		s.degraded.Store(true)
+		return fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+	}
+	return err
+}
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func unixNanos(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNanos(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := time.Unix(0, n.Int64).UTC()
+	return &t
+}
+
+func scanChirp(row rowScanner) (Chirp, error) {
+	var chirp Chirp
+	var createdAt, deletedAt, updatedAt, bumpedAt sql.NullInt64
+	err := row.Scan(&chirp.ID, &chirp.AuthorID, &chirp.Body, &chirp.Lang, &createdAt, &deletedAt, &chirp.SourceURL, &chirp.Source, &updatedAt, &bumpedAt)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.CreatedAt = fromUnixNanos(createdAt)
+	chirp.DeletedAt = fromUnixNanos(deletedAt)
+	chirp.UpdatedAt = fromUnixNanos(updatedAt)
+	chirp.BumpedAt = fromUnixNanos(bumpedAt)
+	return chirp, nil
+}
+
+// queryChirps returns the chirps a query of chirpColumns selects
+func queryChirps(q interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}, query string, args ...any) ([]Chirp, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chirps := make([]Chirp, 0)
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
+
+// requireAuthor returns ErrUserNotFound unless the user with authorID
+// exists, so no chirp is written for an account deleted since its author
+// was looked up
+func requireAuthor(tx *sql.Tx, authorID int) error {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)`, authorID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// insertChirp stores chirp with a new ID, or with its own when keepID is set
+func insertChirp(tx *sql.Tx, chirp Chirp, keepID bool) (Chirp, error) {
+	var ID any
+	if keepID {
+		ID = chirp.ID
+	}
+	res, err := tx.Exec(`INSERT INTO chirps (`+chirpColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ID, chirp.AuthorID, chirp.Body, chirp.Lang, unixNanos(chirp.CreatedAt), unixNanos(chirp.DeletedAt), chirp.SourceURL, chirp.Source,
+		unixNanos(chirp.UpdatedAt), unixNanos(chirp.BumpedAt))
+	if err != nil {
+		return Chirp{}, err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.ID = int(newID)
+	return chirp, nil
+}
+
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	var expiresAt sql.NullInt64
+	var linked, codes, sessions string
+	err := row.Scan(&user.ID, &user.Email, &user.Password, &user.RefreshToken, &expiresAt, &user.RefreshTokenFamily,
+		&user.IsChirpyRed, &user.Version, &linked, &user.OAuthProvider, &user.NoPassword,
+		&user.TOTPEnabled, &user.TOTPSecret, &user.TOTPPendingSecret, &codes, &sessions, &user.Protected)
+	if err != nil {
+		return User{}, err
+	}
+	user.RefreshTokenExpiresAt = fromUnixNanos(expiresAt)
+	if err := json.Unmarshal([]byte(linked), &user.LinkedAccounts); err != nil {
+		return User{}, err
+	}
+	if err := json.Unmarshal([]byte(codes), &user.TOTPBackupCodes); err != nil {
+		return User{}, err
+	}
+	if err := json.Unmarshal([]byte(sessions), &user.Sessions); err != nil {
+		return User{}, err
+	}
+	// the json database has no empty lists, it leaves them out
+	if len(user.LinkedAccounts) == 0 {
+		user.LinkedAccounts = nil
+	}
+	if len(user.TOTPBackupCodes) == 0 {
+		user.TOTPBackupCodes = nil
+	}
+	if len(user.Sessions) == 0 {
+		user.Sessions = nil
+	}
+	return user, nil
+}
+
+// userValues are the values of userColumns for user
+func userValues(user User) ([]any, error) {
+	linked, err := json.Marshal(append([]int{}, user.LinkedAccounts...))
+	if err != nil {
+		return nil, err
+	}
+	codes, err := json.Marshal(append([]string{}, user.TOTPBackupCodes...))
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := json.Marshal(append([]Session{}, user.Sessions...))
+	if err != nil {
+		return nil, err
+	}
+	return []any{user.ID, user.Email, user.Password, user.RefreshToken, unixNanos(user.RefreshTokenExpiresAt), user.RefreshTokenFamily,
+		user.IsChirpyRed, user.Version, string(linked), user.OAuthProvider, user.NoPassword,
+		user.TOTPEnabled, user.TOTPSecret, user.TOTPPendingSecret, string(codes), string(sessions), user.Protected}, nil
+}
+
+// insertUser stores user with a new ID, or with its own when keepID is set
+func insertUser(tx *sql.Tx, user User, keepID bool) (User, error) {
+	values, err := userValues(user)
+	if err != nil {
+		return User{}, err
+	}
+	if !keepID {
+		values[0] = nil
+	}
+	res, err := tx.Exec(`INSERT INTO users (`+userColumns+`, email_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, append(values, strings.ToLower(user.Email))...)
+	if err != nil {
+		return User{}, err
+	}
+	ID, err := res.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	user.ID = int(ID)
+	return user, nil
+}
+
+func saveUser(tx *sql.Tx, user User) error {
+	values, err := userValues(user)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE users SET email = ?, password = ?, refresh_token = ?, refresh_token_expires_at = ?,
+		refresh_token_family = ?, is_chirpy_red = ?, version = ?, linked_accounts = ?, oauth_provider = ?,
+		no_password = ?, totp_enabled = ?, totp_secret = ?, totp_pending_secret = ?, totp_backup_codes = ?,
+		sessions = ?, protected = ?, email_key = ? WHERE id = ?`, append(values[1:], strings.ToLower(user.Email), user.ID)...)
+	return err
+}
+
+// getUser returns the user with ID, ok is false when there is none
+func getUser(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, ID int) (User, bool, error) {
+	user, err := scanUser(q.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, ID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, false, nil
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+	return user, true, nil
+}
+
+// userByEmail finds the user with email like DBUserStructure.userByEmail:
+// ignoring case, an exact match wins, then the oldest account
+func userByEmail(q interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}, email string) (User, bool, error) {
+	rows, err := q.Query(`SELECT `+userColumns+` FROM users WHERE email_key = ? ORDER BY id`, strings.ToLower(email))
+	if err != nil {
+		return User{}, false, err
+	}
+	defer rows.Close()
+	var found User
+	ok := false
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return User{}, false, err
+		}
+		if !ok || (user.Email == email && found.Email != email) {
+			found, ok = user, true
+		}
+	}
+	return found, ok, rows.Err()
+}
+
+// fileBytes is the size of the database, without the log of writes not
+// yet moved into it
+func (s *SQLiteStore) fileBytes() (int, error) {
+	var pages, pageSize int
+	err := s.db.QueryRow(`SELECT page_count, page_size FROM pragma_page_count(), pragma_page_size()`).Scan(&pages, &pageSize)
+	return pages * pageSize, err
+}
+
+// CreateChirp stores a new chirp
+func (s *SQLiteStore) CreateChirp(body string, authorID int, lang string, source string) (Chirp, error) {
+	var chirp Chirp
+	err := s.write(func(tx *sql.Tx) ([]Event, error) {
+		if err := requireAuthor(tx, authorID); err != nil {
+			return nil, err
+		}
+		now := s.now()
+		var err error
+		chirp, err = insertChirp(tx, Chirp{AuthorID: authorID, Body: body, Lang: lang, CreatedAt: &now, Source: source}, false)
+		if err != nil {
+			return nil, err
+		}
+		return chirpEvents(ChirpCreated, []Chirp{chirp}, now), nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// ImportChirps saves a batch of chirps for authorID in one transaction,
+// keeping each chirp's body and creation time and assigning new IDs in order.
+func (s *SQLiteStore) ImportChirps(authorID int, chirps []Chirp) ([]Chirp, error) {
+	imported := make([]Chirp, 0, len(chirps))
+	err := s.write(func(tx *sql.Tx) ([]Event, error) {
+		if err := requireAuthor(tx, authorID); err != nil {
+			return nil, err
+		}
+		for _, chirp := range chirps {
+			chirp.AuthorID = authorID
+			chirp.DeletedAt = nil
+			chirp, err := insertChirp(tx, chirp, false)
+			if err != nil {
+				return nil, err
+			}
+			imported = append(imported, chirp)
+		}
+		return chirpEvents(ChirpCreated, imported, s.now()), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// MirrorChirps saves chirps mirrored from another instance for authorID.
+// chirps whose SourceURL is already stored, deleted or not, are skipped so
+// a sync can be repeated safely.
+func (s *SQLiteStore) MirrorChirps(authorID int, chirps []Chirp) ([]Chirp, error) {
+	mirrored := make([]Chirp, 0, len(chirps))
+	err := s.write(func(tx *sql.Tx) ([]Event, error) {
+		if err := requireAuthor(tx, authorID); err != nil {
+			return nil, err
+		}
+		for _, chirp := range chirps {
+			if chirp.SourceURL == "" {
+				continue
+			}
+			var known bool
+			err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE source_url = ?)`, chirp.SourceURL).Scan(&known)
+			if err != nil {
+				return nil, err
+			}
+			if known {
+				continue
+			}
+			chirp.AuthorID = authorID
+			chirp.DeletedAt = nil
+			chirp, err = insertChirp(tx, chirp, false)
+			if err != nil {
+				return nil, err
+			}
+			mirrored = append(mirrored, chirp)
+		}
+		return chirpEvents(ChirpCreated, mirrored, s.now()), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mirrored, nil
+}
+
+// GetChirps returns all live chirps sorted by ID
+func (s *SQLiteStore) GetChirps() ([]Chirp, error) {
+	return queryChirps(s.db, `SELECT `+chirpColumns+` FROM chirps WHERE deleted_at IS NULL ORDER BY id`)
+}
+
+// GetChirpyFromID returns the live chirp with ID or ErrChirpNotFound
+func (s *SQLiteStore) GetChirpyFromID(ID int) (Chirp, error) {
+	chirp, err := scanChirp(s.db.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL`, ID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, ErrChirpNotFound
+	}
+	return chirp, err
+}
+
+// GetChirpsByAuthorID returns the live chirps of authorID sorted by ID
+func (s *SQLiteStore) GetChirpsByAuthorID(authorID int) ([]Chirp, error) {
+	return queryChirps(s.db, `SELECT `+chirpColumns+` FROM chirps WHERE author_id = ? AND deleted_at IS NULL ORDER BY id`, authorID)
+}
+
+// QueryChirps returns the page of live chirps the query selects, ordered
+// by ID unless ByActivity is set, and how many match in total. every filter
+// but the text search and the hashtags is SQL, those work like the json
+// database's and run on the rows the other filters leave. the activity order
+// is the json database's too, so it is applied to those rows in Go.
+func (s *SQLiteStore) QueryChirps(q ChirpQuery) ([]Chirp, int, error) {
+	where := []string{"deleted_at IS NULL", "id > ?"}
+	args := []any{q.SinceID}
+	var authors map[int]bool // set when the tags are matched in Go
+	if q.AuthorIDs != nil {
+		if len(q.AuthorIDs) == 0 && len(q.Hashtags) == 0 {
+			return make([]Chirp, 0), 0, nil
+		}
+		var match []string
+		if len(q.AuthorIDs) > 0 {
+			match = append(match, "author_id IN (?"+strings.Repeat(", ?", len(q.AuthorIDs)-1)+")")
+			for _, ID := range q.AuthorIDs {
+				args = append(args, ID)
+			}
+		}
+		// SQL only keeps the chirps that may have a tag, Go checks which
+		if len(q.Hashtags) > 0 {
+			authors = q.authorSet()
+			match = append(match, "instr(body, '#') > 0")
+		}
+		where = append(where, "("+strings.Join(match, " OR ")+")")
+	}
+	if len(q.HiddenIDs) > 0 {
+		where = append(where, "author_id NOT IN (?"+strings.Repeat(", ?", len(q.HiddenIDs)-1)+")")
+		for _, ID := range q.HiddenIDs {
+			args = append(args, ID)
+		}
+	}
+	if q.Lang != "" {
+		where = append(where, "(coalesce(nullif(lang, ''), ?) = ? OR substr(coalesce(nullif(lang, ''), ?), 1, ?) = ?)")
+		args = append(args, q.DefaultLang, q.Lang, q.DefaultLang, len(q.Lang)+1, q.Lang+"-")
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+	if !q.Before.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, q.Before.UnixNano())
+	}
+	order := "id"
+	if q.Desc {
+		order = "id DESC"
+	}
+	query := `SELECT ` + chirpColumns + ` FROM chirps WHERE ` + strings.Join(where, " AND ") + ` ORDER BY ` + order
+
+	if q.Text == "" && !q.ByActivity && authors == nil {
+		var total int
+		err := s.db.QueryRow(`SELECT count(*) FROM chirps WHERE `+strings.Join(where, " AND "), args...).Scan(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+		limit := q.Limit
+		if limit == 0 {
+			limit = -1 // no limit
+		}
+		page, err := queryChirps(s.db, query+` LIMIT ? OFFSET ?`, append(args, limit, q.Offset)...)
+		if err != nil {
+			return nil, 0, err
+		}
+		return page, total, nil
+	}
+
+	matches, err := queryChirps(s.db, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	text := strings.ToLower(q.Text)
+	if q.ByActivity {
+		matches = slices.DeleteFunc(matches, func(chirp Chirp) bool { return !q.matches(chirp, authors, nil, text) })
+		page, total := q.pageByActivity(matches)
+		return page, total, nil
+	}
+	page := make([]Chirp, 0, max(q.Limit, 0))
+	total := 0
+	for _, chirp := range matches {
+		if !q.matches(chirp, authors, nil, text) {
+			continue
+		}
+		if total >= q.Offset && (q.Limit == 0 || len(page) < q.Limit) {
+			page = append(page, chirp)
+		}
+		total++
+	}
+	return page, total, nil
+}
+
+// ChirpActivity counts the live chirps of authorID per UTC day from since
+// onwards, keyed by the start of the day
+func (s *SQLiteStore) ChirpActivity(authorID int, since time.Time) (map[time.Time]int, error) {
+	since = since.UTC().Truncate(24 * time.Hour)
+	chirps, err := queryChirps(s.db, `SELECT `+chirpColumns+` FROM chirps
+		WHERE author_id = ? AND deleted_at IS NULL AND created_at >= ?`, authorID, since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[time.Time]int)
+	for _, chirp := range chirps {
+		counts[chirp.CreatedAt.UTC().Truncate(24*time.Hour)]++
+	}
+	return counts, nil
+}
+
+// DeleteDB tombstones the chirp with ID so it can be restored with RestoreChirp
+func (s *SQLiteStore) DeleteDB(authorID int, ID int) error {
+	return s.write(func(tx *sql.Tx) ([]Event, error) {
+		chirp, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL`, ID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrChirpNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chirp.AuthorID != authorID {
+			return nil, ErrNotChirpAuthor
+		}
+		now := s.now()
+		_, err = tx.Exec(`UPDATE chirps SET deleted_at = ? WHERE id = ?`, now.UnixNano(), ID)
+		if err != nil {
+			return nil, err
+		}
+		return chirpEvents(ChirpDeleted, []Chirp{chirp}, now), nil
+	})
+}
+
+// DeleteChirpsByAuthor tombstones every live chirp of authorID and
+// returns how many it deleted
+func (s *SQLiteStore) DeleteChirpsByAuthor(authorID int) (int, error) {
+	deleted := 0
+	err := s.write(func(tx *sql.Tx) ([]Event, error) {
+		chirps, err := queryChirps(tx, `SELECT `+chirpColumns+` FROM chirps WHERE author_id = ? AND deleted_at IS NULL`, authorID)
+		if err != nil {
+			return nil, err
+		}
+		now := s.now()
+		_, err = tx.Exec(`UPDATE chirps SET deleted_at = ? WHERE author_id = ? AND deleted_at IS NULL`, now.UnixNano(), authorID)
+		if err != nil {
+			return nil, err
+		}
+		deleted = len(chirps)
+		return chirpEvents(ChirpDeleted, chirps, now), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// UpdateChirp replaces the body of the live chirp with ID. only its author
+// can, others get ErrNotChirpAuthor.
+func (s *SQLiteStore) UpdateChirp(authorID int, ID int, body string) (Chirp, error) {
+	var chirp Chirp
+	err := s.write(func(tx *sql.Tx) ([]Event, error) {
+		var err error
+		chirp, err = scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL`, ID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrChirpNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chirp.AuthorID != authorID {
+			return nil, ErrNotChirpAuthor
+		}
+		now := s.now()
+		_, err = tx.Exec(`UPDATE chirps SET body = ?, updated_at = ? WHERE id = ?`, body, now.UnixNano(), ID)
+		if err != nil {
+			return nil, err
+		}
+		chirp.Body = body
+		chirp.UpdatedAt = &now
+		return chirpEvents(ChirpUpdated, []Chirp{chirp}, now), nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// BumpChirp stamps the chirp with ID as bumped now, so feeds show it
+// again. it fails with ErrBumpTooSoon when the last bump is less than
+// cooldown ago. edits and the global list never look at the stamp.
+func (s *SQLiteStore) BumpChirp(authorID int, ID int, cooldown time.Duration) (Chirp, error) {
+	var chirp Chirp
+	err := s.write(func(tx *sql.Tx) ([]Event, error) {
+		var err error
+		chirp, err = scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL`, ID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrChirpNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chirp.AuthorID != authorID {
+			return nil, ErrNotChirpAuthor
+		}
+		now := s.now()
+		if chirp.BumpedAt != nil && now.Sub(*chirp.BumpedAt) < cooldown {
+			return nil, ErrBumpTooSoon
+		}
+		_, err = tx.Exec(`UPDATE chirps SET bumped_at = ? WHERE id = ?`, now.UnixNano(), ID)
+		if err != nil {
+			return nil, err
+		}
+		chirp.BumpedAt = &now
+		return chirpEvents(ChirpBumped, []Chirp{chirp}, now), nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// RestoreChirp restores a tombstoned chirp exactly as it was before deletion
+func (s *SQLiteStore) RestoreChirp(authorID int, ID int) (Chirp, error) {
+	var chirp Chirp
+	err := s.write(func(tx *sql.Tx) ([]Event, error) {
+		var err error
+		chirp, err = scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NOT NULL`, ID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("chirpy is not deleted")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chirp.AuthorID != authorID {
+			return nil, ErrNotChirpAuthor
+		}
+		if err := requireAuthor(tx, authorID); err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`UPDATE chirps SET deleted_at = NULL WHERE id = ?`, ID)
+		if err != nil {
+			return nil, err
+		}
+		chirp.DeletedAt = nil
+		return chirpEvents(ChirpRestored, []Chirp{chirp}, s.now()), nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// DeletedChirpsBefore returns the tombstoned chirps deleted before cutoff,
+// the ones CompactChirps would drop, sorted by ID
+func (s *SQLiteStore) DeletedChirpsBefore(cutoff time.Time) ([]Chirp, error) {
+	return queryChirps(s.db, `SELECT `+chirpColumns+` FROM chirps WHERE deleted_at < ? ORDER BY id`, cutoff.UnixNano())
+}
+
+// compact runs fn, which returns how many records it removed, and reports
+// the size of the database before and after. free pages are reclaimed
+// when anything was removed.
+func (s *SQLiteStore) compact(fn func(tx *sql.Tx) (int, error)) (CompactReport, error) {
+	report := CompactReport{File: filepath.Base(s.path)}
+	var err error
+	report.BytesBefore, err = s.fileBytes()
+	if err != nil {
+		return report, err
+	}
+	report.BytesAfter = report.BytesBefore
+
+	err = s.write(func(tx *sql.Tx) ([]Event, error) {
+		var err error
+		report.Removed, err = fn(tx)
+		return nil, err
+	})
+	if err != nil || report.Removed == 0 {
+		return report, err
+	}
+	s.mux.Lock()
+	_, err = s.db.Exec(`VACUUM`)
+	s.mux.Unlock()
+	if err != nil {
+		return report, err
+	}
+	report.BytesAfter, err = s.fileBytes()
+	if err != nil {
+		return report, err
+	}
+	report.Reclaimed = report.BytesBefore - report.BytesAfter
+	report.Compacted = true
+	return report, nil
+}
+
+// CompactChirps drops tombstoned chirps deleted before cutoff. IDs are
+// never handed out again, so they don't need to be kept.
+func (s *SQLiteStore) CompactChirps(cutoff time.Time) (CompactReport, error) {
+	return s.compact(func(tx *sql.Tx) (int, error) {
+		res, err := tx.Exec(`DELETE FROM chirps WHERE deleted_at < ?`, cutoff.UnixNano())
+		if err != nil {
+			return 0, err
+		}
+		removed, err := res.RowsAffected()
+		return int(removed), err
+	})
+}
+
+// ResetChirps deletes every chirp, tombstones included, so IDs start over
+// at 1. it returns how many chirps were removed.
+func (s *SQLiteStore) ResetChirps() (int, error) {
+	removed := 0
+	err := s.write(func(tx *sql.Tx) ([]Event, error) {
+		res, err := tx.Exec(`DELETE FROM chirps`)
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		removed = int(n)
+		_, err = tx.Exec(`DELETE FROM sqlite_sequence WHERE name = 'chirps'`)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{{Type: CollectionReset, At: s.now()}}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// ChirpStats returns the storage usage of the chirps, deleted chirps waiting
+// for the purge count as tombstoned. bytes is their size serialized as json.
+func (s *SQLiteStore) ChirpStats() (CollectionStats, error) {
+	chirps, err := queryChirps(s.db, `SELECT `+chirpColumns+` FROM chirps`)
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	records := make(IDMap[Chirp], len(chirps))
+	for _, chirp := range chirps {
+		records[chirp.ID] = chirp
+	}
+	stats, err := recordStats(records, func(chirp Chirp) bool { return chirp.DeletedAt != nil })
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	b, err := json.Marshal(records)
+	stats.Bytes = int64(len(b))
+	return stats, err
+}
+
+// CreateUser stores a new user, ErrUserExists when the email is taken
+func (s *SQLiteStore) CreateUser(email string, password []byte) (User, error) {
+	var user User
+	err := s.write(func(tx *sql.Tx) ([]Event, error) {
+		_, taken, err := userByEmail(tx, email)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, ErrUserExists
+		}
+		user, err = insertUser(tx, User{Email: email, Password: password}, false)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{{Type: UserCreated, UserID: user.ID, At: s.now()}}, nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// GetUser returns all users sorted by ID
+func (s *SQLiteStore) GetUser() ([]User, error) {
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetUserByID returns the user with ID or ErrUserNotFound
+func (s *SQLiteStore) GetUserByID(ID int) (User, error) {
+	user, ok, err := getUser(s.db, ID)
+	if err != nil {
+		return User{}, err
+	}
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// GetUserByEmail returns the user with email, ignoring case, or ErrUserNotFound
+func (s *SQLiteStore) GetUserByEmail(email string) (User, error) {
+	user, ok, err := userByEmail(s.db, email)
+	if err != nil {
+		return User{}, err
+	}
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// UpdateUserDB changes the email and password of the user. when
+// expectedVersion is set and the user's version differs nothing is written
+// and the current user is returned with ErrVersionConflict.
+func (s *SQLiteStore) UpdateUserDB(ID int, email string, password []byte, expectedVersion *int) (User, error) {
+	var user User
+	err := s.write(func(tx *sql.Tx) ([]Event, error) {
+		var ok bool
+		var err error
+		user, ok, err = getUser(tx, ID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrUserNotFound
+		}
+		if expectedVersion != nil && *expectedVersion != user.Version {
+			return nil, ErrVersionConflict
+		}
+		other, taken, err := userByEmail(tx, email)
+		if err != nil {
+			return nil, err
+		}
+		if taken && other.ID != ID {
+			return nil, ErrUserExists
+		}
+		user.Email = email
+		user.Password = password
+		user.NoPassword = false
+		user.Version++
+		err = saveUser(tx, user)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{{Type: UserUpdated, UserID: ID, At: s.now()}}, nil
+	})
+	if errors.Is(err, ErrVersionConflict) {
+		return user, err
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// UpgradeUser makes the user a chirpy red member
+func (s *SQLiteStore) UpgradeUser(ID int) error {
+	return s.write(func(tx *sql.Tx) ([]Event, error) {
+		res, err := tx.Exec(`UPDATE users SET is_chirpy_red = 1, version = version + 1 WHERE id = ?`, ID)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			if err == nil {
+				err = ErrUserNotFound
+			}
+			return nil, err
+		}
+		return []Event{{Type: UserUpgraded, UserID: ID, At: s.now()}}, nil
+	})
+}
+
+// DeleteUser removes the user with their refresh token, identities and
+// links from other accounts, and deletes or anonymizes their chirps, all in
+// one transaction. it returns ErrUserNotFound for unknown IDs.
+func (s *SQLiteStore) DeleteUser(ID int, chirps AuthorChirps) error {
+	return s.write(func(tx *sql.Tx) ([]Event, error) {
+		if _, ok, err := getUser(tx, ID); err != nil || !ok {
+			if err == nil {
+				err = ErrUserNotFound
+			}
+			return nil, err
+		}
+
+		now := s.now()
+		var events []Event
+		switch chirps {
+		case DeleteAuthorChirps:
+			changed, err := queryChirps(tx, `SELECT `+chirpColumns+` FROM chirps WHERE author_id = ? AND deleted_at IS NULL`, ID)
+			if err != nil {
+				return nil, err
+			}
+			_, err = tx.Exec(`UPDATE chirps SET deleted_at = ? WHERE author_id = ? AND deleted_at IS NULL`, now.UnixNano(), ID)
+			if err != nil {
+				return nil, err
+			}
+			events = chirpEvents(ChirpDeleted, changed, now)
+		case AnonymizeAuthorChirps:
+			changed, err := queryChirps(tx, `SELECT `+chirpColumns+` FROM chirps WHERE author_id = ?`, ID)
+			if err != nil {
+				return nil, err
+			}
+			_, err = tx.Exec(`UPDATE chirps SET author_id = 0 WHERE author_id = ?`, ID)
+			if err != nil {
+				return nil, err
+			}
+			events = chirpEvents(ChirpAnonymized, changed, now)
+		}
+
+		_, err := tx.Exec(`DELETE FROM users WHERE id = ?`, ID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`DELETE FROM identities WHERE user_id = ?`, ID)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := tx.Query(`SELECT ` + userColumns + ` FROM users WHERE linked_accounts != '[]'`)
+		if err != nil {
+			return nil, err
+		}
+		var linked []User
+		for rows.Next() {
+			user, err := scanUser(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if slices.Contains(user.LinkedAccounts, ID) {
+				linked = append(linked, user)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		for _, user := range linked {
+			user.LinkedAccounts = slices.DeleteFunc(user.LinkedAccounts, func(l int) bool { return l == ID })
+			if err := saveUser(tx, user); err != nil {
+				return nil, err
+			}
+		}
+		return append(events, Event{Type: UserDeleted, UserID: ID, At: now}), nil
+	})
+}
+
+// StoreToken stores the refresh token of the session family, valid until
+// expiresAt. it replaces the login session of the user.
+func (s *SQLiteStore) StoreToken(ID int, token string, family string, expiresAt time.Time) error {
+	return s.write(func(tx *sql.Tx) ([]Event, error) {
+		_, err := tx.Exec(`UPDATE users SET refresh_token = ?, refresh_token_expires_at = ?, refresh_token_family = ? WHERE id = ?`,
+			token, expiresAt.UnixNano(), family, ID)
+		return nil, err
+	})
+}
+
+// AddSession opens a session of family next to the user's login session,
+// valid until expiresAt
+func (s *SQLiteStore) AddSession(ID int, token string, family string, expiresAt time.Time) error {
+	return ignoreMissingUser(s.updateSessions(ID, func(user *User) error {
+		user.addSession(Session{Token: token, ExpiresAt: expiresAt, Family: family}, s.now())
+		return nil
+	}))
+}
+
+// RotateToken replaces the stored refresh token presented with next,
+// keeping its expiry. a stale token of a stored session revokes that
+// session with ErrRefreshTokenReused, any other token is
+// ErrRefreshTokenInvalid.
+func (s *SQLiteStore) RotateToken(ID int, presented string, family string, next string) error {
+	reused := false
+	err := s.updateSessions(ID, func(user *User) error {
+		err := user.rotateToken(presented, family, next)
+		if errors.Is(err, ErrRefreshTokenReused) {
+			reused = true
+			return nil
+		}
+		return err
+	})
+	if err == nil && reused {
+		return ErrRefreshTokenReused
+	}
+	return err
+}
+
+// RevokeToken ends every session of the user
+func (s *SQLiteStore) RevokeToken(ID int) error {
+	return s.write(func(tx *sql.Tx) ([]Event, error) {
+		_, err := tx.Exec(`UPDATE users SET refresh_token = '', refresh_token_expires_at = NULL, refresh_token_family = '',
+			sessions = '[]' WHERE id = ?`, ID)
+		return nil, err
+	})
+}
+
+// RevokeSession ends the session family of the user, whether it is the
+// login session or one opened by a token exchange
+func (s *SQLiteStore) RevokeSession(ID int, family string) error {
+	return ignoreMissingUser(s.updateSessions(ID, func(user *User) error {
+		user.revokeSession(family)
+		return nil
+	}))
+}
+
+// updateSessions applies update to the user with ID and saves it in one
+// transaction, ErrUserNotFound when there is none
+func (s *SQLiteStore) updateSessions(ID int, update func(user *User) error) error {
+	return s.write(func(tx *sql.Tx) ([]Event, error) {
+		user, ok, err := getUser(tx, ID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrUserNotFound
+		}
+		if err := update(&user); err != nil {
+			return nil, err
+		}
+		return nil, saveUser(tx, user)
+	})
+}
+
+// updateUsers loads the users with IDs, applies update and saves them in
+// one transaction. unknown IDs fail with "invalid user id".
+func (s *SQLiteStore) updateUsers(IDs []int, update func(users []*User) error) ([]User, error) {
+	users := make([]User, len(IDs))
+	err := s.write(func(tx *sql.Tx) ([]Event, error) {
+		ptrs := make([]*User, len(IDs))
+		for i, ID := range IDs {
+			user, ok, err := getUser(tx, ID)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, errors.New("invalid user id")
+			}
+			users[i] = user
+			ptrs[i] = &users[i]
+		}
+		if err := update(ptrs); err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			if err := saveUser(tx, user); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// updateUserRecord loads the user, applies update and saves the result
+func (s *SQLiteStore) updateUserRecord(ID int, update func(user *User) error) (User, error) {
+	users, err := s.updateUsers([]int{ID}, func(users []*User) error { return update(users[0]) })
+	if err != nil {
+		return User{}, err
+	}
+	return users[0], nil
+}
+
+// LinkUsers links two accounts to each other
+func (s *SQLiteStore) LinkUsers(ID int, linkedID int) error {
+	if ID == linkedID {
+		return errors.New("cannot link an account to itself")
+	}
+	_, err := s.updateUsers([]int{ID, linkedID}, func(users []*User) error {
+		if !slices.Contains(users[0].LinkedAccounts, linkedID) {
+			users[0].LinkedAccounts = append(users[0].LinkedAccounts, linkedID)
+		}
+		if !slices.Contains(users[1].LinkedAccounts, ID) {
+			users[1].LinkedAccounts = append(users[1].LinkedAccounts, ID)
+		}
+		return nil
+	})
+	return err
+}
+
+// UnlinkUsers unlinks two accounts from each other
+func (s *SQLiteStore) UnlinkUsers(ID int, linkedID int) error {
+	return s.write(func(tx *sql.Tx) ([]Event, error) {
+		user, ok, err := getUser(tx, ID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || !slices.Contains(user.LinkedAccounts, linkedID) {
+			return nil, errors.New("accounts are not linked")
+		}
+		user.LinkedAccounts = slices.DeleteFunc(user.LinkedAccounts, func(l int) bool { return l == linkedID })
+		if err := saveUser(tx, user); err != nil {
+			return nil, err
+		}
+
+		linked, ok, err := getUser(tx, linkedID)
+		if err != nil || !ok {
+			return nil, err
+		}
+		linked.LinkedAccounts = slices.DeleteFunc(linked.LinkedAccounts, func(l int) bool { return l == ID })
+		return nil, saveUser(tx, linked)
+	})
+}
+
+// SetOAuthProvider records the oauth provider an account was created with.
+// such accounts have no usable password until the user sets one.
+func (s *SQLiteStore) SetOAuthProvider(ID int, provider string) (User, error) {
+	return s.updateUserRecord(ID, func(user *User) error {
+		user.OAuthProvider = provider
+		user.NoPassword = true
+		return nil
+	})
+}
+
+// LinkIdentity attaches the provider identity to the user.
+// linking the same identity to the same user again is a no-op.
+func (s *SQLiteStore) LinkIdentity(userID int, provider, providerUserID string) error {
+	return s.write(func(tx *sql.Tx) ([]Event, error) {
+		if _, ok, err := getUser(tx, userID); err != nil || !ok {
+			if err == nil {
+				err = errors.New("invalid user id")
+			}
+			return nil, err
+		}
+
+		var owner int
+		err := tx.QueryRow(`SELECT user_id FROM identities WHERE provider = ? AND provider_user_id = ?`, provider, providerUserID).Scan(&owner)
+		if err == nil {
+			if owner != userID {
+				return nil, ErrIdentityTaken
+			}
+			return nil, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		// one identity per provider and user
+		var taken bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM identities WHERE user_id = ? AND provider = ?)`, userID, provider).Scan(&taken)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, ErrIdentityTaken
+		}
+
+		_, err = tx.Exec(`INSERT INTO identities (provider, provider_user_id, user_id) VALUES (?, ?, ?)`, provider, providerUserID, userID)
+		return nil, err
+	})
+}
+
+// GetUserByIdentity returns the user the provider identity is linked to
+func (s *SQLiteStore) GetUserByIdentity(provider, providerUserID string) (User, error) {
+	user, err := scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users
+		WHERE id = (SELECT user_id FROM identities WHERE provider = ? AND provider_user_id = ?)`, provider, providerUserID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New("identity not found")
+	}
+	return user, err
+}
+
+// UnlinkIdentity detaches the user's identity for provider.
+// it fails with ErrLastLoginMethod when the user would have no password
+// and no other identity left to log in with.
+func (s *SQLiteStore) UnlinkIdentity(userID int, provider string) error {
+	return s.write(func(tx *sql.Tx) ([]Event, error) {
+		user, ok, err := getUser(tx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errors.New("invalid user id")
+		}
+
+		var identities, forProvider int
+		err = tx.QueryRow(`SELECT count(*), count(CASE WHEN provider = ? THEN 1 END) FROM identities WHERE user_id = ?`,
+			provider, userID).Scan(&identities, &forProvider)
+		if err != nil {
+			return nil, err
+		}
+		if forProvider == 0 {
+			return nil, errors.New("identity not found")
+		}
+		methods := identities
+		if !user.NoPassword {
+			methods++
+		}
+		if methods <= 1 {
+			return nil, ErrLastLoginMethod
+		}
+
+		_, err = tx.Exec(`DELETE FROM identities WHERE user_id = ? AND provider = ?`, userID, provider)
+		return nil, err
+	})
+}
+
+// SetProtected turns protected mode of the user on or off
+func (s *SQLiteStore) SetProtected(ID int, protected bool) (User, error) {
+	return s.updateUserRecord(ID, func(user *User) error {
+		user.Protected = protected
+		return nil
+	})
+}
+
+// ProtectedUserIDs returns the IDs of the protected users in order
+func (s *SQLiteStore) ProtectedUserIDs() ([]int, error) {
+	rows, err := s.db.Query(`SELECT id FROM users WHERE protected = 1 ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	IDs := make([]int, 0)
+	for rows.Next() {
+		var ID int
+		if err := rows.Scan(&ID); err != nil {
+			return nil, err
+		}
+		IDs = append(IDs, ID)
+	}
+	return IDs, rows.Err()
+}
+
+// SetPendingTOTP stores a totp secret waiting for its first valid code
+func (s *SQLiteStore) SetPendingTOTP(ID int, secret string) error {
+	_, err := s.updateUserRecord(ID, func(user *User) error {
+		if user.TOTPEnabled {
+			return errors.New("totp is already enabled")
+		}
+		user.TOTPPendingSecret = secret
+		return nil
+	})
+	return err
+}
+
+// EnableTOTP activates the pending totp secret with a fresh set of hashed backup codes
+func (s *SQLiteStore) EnableTOTP(ID int, backupCodeHashes []string) error {
+	_, err := s.updateUserRecord(ID, func(user *User) error {
+		if user.TOTPPendingSecret == "" {
+			return errors.New("totp setup has not been started")
+		}
+		user.TOTPEnabled = true
+		user.TOTPSecret = user.TOTPPendingSecret
+		user.TOTPPendingSecret = ""
+		user.TOTPBackupCodes = backupCodeHashes
+		return nil
+	})
+	return err
+}
+
+// DisableTOTP turns off totp and drops the secret and backup codes
+func (s *SQLiteStore) DisableTOTP(ID int) error {
+	_, err := s.updateUserRecord(ID, func(user *User) error {
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.TOTPPendingSecret = ""
+		user.TOTPBackupCodes = nil
+		return nil
+	})
+	return err
+}
+
+// UseBackupCode consumes a backup code by its hash.
+// it reports whether the code was valid, a used code can't be used again.
+func (s *SQLiteStore) UseBackupCode(ID int, hash string) (bool, error) {
+	used := false
+	_, err := s.updateUserRecord(ID, func(user *User) error {
+		for i, stored := range user.TOTPBackupCodes {
+			if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+				user.TOTPBackupCodes = slices.Delete(user.TOTPBackupCodes, i, i+1)
+				used = true
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return used, nil
+}
+
+// CompactUsers clears refresh tokens that expired reports as no longer usable
+func (s *SQLiteStore) CompactUsers(expired func(token string) bool) (CompactReport, error) {
+	return s.compact(func(tx *sql.Tx) (int, error) {
+		rows, err := tx.Query(`SELECT ` + userColumns + ` FROM users WHERE refresh_token != '' OR sessions != '[]'`)
+		if err != nil {
+			return 0, err
+		}
+		var users []User
+		for rows.Next() {
+			user, err := scanUser(rows)
+			if err != nil {
+				rows.Close()
+				return 0, err
+			}
+			users = append(users, user)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		removed := 0
+		for _, user := range users {
+			n := user.compactSessions(expired)
+			if n == 0 {
+				continue
+			}
+			if err := saveUser(tx, user); err != nil {
+				return 0, err
+			}
+			removed += n
+		}
+		return removed, nil
+	})
+}
+
+// ResetUsers deletes every user and linked identity, so IDs start over at 1.
+// data of other collections that refers to users has to be reset with them.
+// it returns how many users were removed.
+func (s *SQLiteStore) ResetUsers() (int, error) {
+	removed := 0
+	err := s.write(func(tx *sql.Tx) ([]Event, error) {
+		res, err := tx.Exec(`DELETE FROM users`)
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		removed = int(n)
+		_, err = tx.Exec(`DELETE FROM identities`)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`DELETE FROM sqlite_sequence WHERE name = 'users'`)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{{Type: CollectionReset, At: s.now()}}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// UserStats returns the storage usage of the users. bytes is their size
+// serialized as json.
+func (s *SQLiteStore) UserStats() (CollectionStats, error) {
+	users, err := s.GetUser()
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	records := make(IDMap[User], len(users))
+	for _, user := range users {
+		records[user.ID] = user
+	}
+	stats, err := recordStats(records, nil)
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	b, err := json.Marshal(records)
+	stats.Bytes = int64(len(b))
+	return stats, err
+}
+
+// ImportFile copies the users, identities and chirps of the json database
+// into the store, keeping their IDs and the counters so no ID is handed out
+// twice. it fails with ErrStoreNotEmpty unless the store has no users and
+// no chirps. it returns how many users and chirps it copied.
+func (s *SQLiteStore) ImportFile(db *DB) (int, int, error) {
+	db.mux.RLock()
+	c, err := db.loadFile()
+	db.mux.RUnlock()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = s.write(func(tx *sql.Tx) ([]Event, error) {
+		var records int
+		err := tx.QueryRow(`SELECT (SELECT count(*) FROM users) + (SELECT count(*) FROM chirps)`).Scan(&records)
+		if err != nil {
+			return nil, err
+		}
+		if records > 0 {
+			return nil, ErrStoreNotEmpty
+		}
+
+		for _, user := range c.users.Users {
+			if _, err := insertUser(tx, user, true); err != nil {
+				return nil, err
+			}
+		}
+		for _, identity := range c.users.Identities {
+			_, err := tx.Exec(`INSERT INTO identities (provider, provider_user_id, user_id) VALUES (?, ?, ?)`,
+				identity.Provider, identity.ProviderUserID, identity.UserID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, chirp := range c.chirps.Chirps {
+			if _, err := insertChirp(tx, chirp, true); err != nil {
+				return nil, err
+			}
+		}
+
+		for table, next := range map[string]int{"users": c.users.NextID, "chirps": c.chirps.NextID} {
+			_, err := tx.Exec(`DELETE FROM sqlite_sequence WHERE name = ?`, table)
+			if err != nil {
+				return nil, err
+			}
+			_, err = tx.Exec(`INSERT INTO sqlite_sequence (name, seq) VALUES (?, ?)`, table, next)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(c.users.Users), len(c.chirps.Chirps), nil
+}
@@ -0,0 +1,240 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// CollectionStats is the storage usage of one database file
+type CollectionStats struct {
+	Records    int   `json:"records"`
+	Live       int   `json:"live"`
+	Tombstoned int   `json:"tombstoned"`
+	Bytes      int64 `json:"bytes"` // of the file on disk, or of their part of it for the chirps and users
+	// serialized size of the largest record and its ID
+	LargestRecordBytes int `json:"largest_record_bytes"`
+	LargestRecordID    int `json:"largest_record_id,omitempty"`
+}
+
+// recordStats counts the records and finds the largest one. it runs on a
+// loaded copy, outside the lock.
+func recordStats[T any](records IDMap[T], tombstoned func(T) bool) (CollectionStats, error) {
+	stats := CollectionStats{Records: len(records)}
+	for id, record := range records {
+		if tombstoned != nil && tombstoned(record) {
+			stats.Tombstoned++
+		} else {
+			stats.Live++
+		}
+		b, err := json.Marshal(record)
+		if err != nil {
+			return CollectionStats{}, err
+		}
+		if len(b) > stats.LargestRecordBytes {
+			stats.LargestRecordBytes = len(b)
+			stats.LargestRecordID = id
+		}
+	}
+	return stats, nil
+}
+
+// fileBytes is the size of the database file. renames replace it whole, so
+// it doesn't need the lock.
+func (db *DB) fileBytes() (int64, error) {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// sectionBytes is the serialized size of the part of the database file
+// that holds section, for the chirps and users that share the file
+func (db *DB) sectionBytes(section any) (int64, error) {
+	b, err := db.encode(section)
+	return int64(len(b)), err
+}
+
+// ChirpStats returns the storage usage of the chirps, deleted chirps waiting
+// for the purge count as tombstoned
+func (db *DB) ChirpStats() (CollectionStats, error) {
+	db.mux.RLock()
+	dbStructure, err := db.loadDB()
+	db.mux.RUnlock()
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	stats, err := recordStats(dbStructure.Chirps, func(chirp Chirp) bool { return chirp.DeletedAt != nil })
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	stats.Bytes, err = db.sectionBytes(dbStructure)
+	return stats, err
+}
+
+// UserStats returns the storage usage of the users
+func (db *DB) UserStats() (CollectionStats, error) {
+	db.mux.RLock()
+	dbStructure, err := db.loadUserDB()
+	db.mux.RUnlock()
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	stats, err := recordStats(dbStructure.Users, nil)
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	stats.Bytes, err = db.sectionBytes(dbStructure)
+	return stats, err
+}
+
+// ListStats returns the storage usage of the lists
+func (db *DB) ListStats() (CollectionStats, error) {
+	db.mux.RLock()
+	dbStructure, err := db.loadListDB()
+	db.mux.RUnlock()
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	stats, err := recordStats(dbStructure.Lists, nil)
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	stats.Bytes, err = db.fileBytes()
+	return stats, err
+}
+
+// SyndicationStats returns the storage usage of the syndication sources
+func (db *DB) SyndicationStats() (CollectionStats, error) {
+	db.mux.RLock()
+	dbStructure, err := db.loadSyndicationDB()
+	db.mux.RUnlock()
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	stats, err := recordStats(dbStructure.Sources, nil)
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	stats.Bytes, err = db.fileBytes()
+	return stats, err
+}
+
+// JobStats returns the storage usage of the job queue, dead jobs count as
+// tombstoned since nothing runs them again
+func (db *DB) JobStats() (CollectionStats, error) {
+	db.mux.RLock()
+	dbStructure, err := db.loadJobDB()
+	db.mux.RUnlock()
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	stats, err := recordStats(dbStructure.Jobs, func(job Job) bool { return job.Status == JobDead })
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	stats.Bytes, err = db.fileBytes()
+	return stats, err
+}
+
+// StorageSample is the file size of every collection at one point in time
+type StorageSample struct {
+	At    time.Time        `json:"at"`
+	Bytes map[string]int64 `json:"bytes"` // by collection name
+}
+
+type DBStorageStructure struct {
+	Samples []StorageSample `json:"samples"` // oldest first
+}
+
+// NewStorageDB creates the storage sample database and creates its file if it does not exist.
+func NewStorageDB(path string) (*DB, error) {
+	db := &DB{
+		path: path,
+		mux:  &sync.RWMutex{},
+	}
+	err := db.ensureStorageDB()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// RecordStorageSample appends sample and drops the samples taken before keepAfter
+func (db *DB) RecordStorageSample(sample StorageSample, keepAfter time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadStorageDB()
+	if err != nil {
+		return err
+	}
+	samples := []StorageSample{}
+	for _, s := range dbStructure.Samples {
+		if !s.At.Before(keepAfter) {
+			samples = append(samples, s)
+		}
+	}
+	dbStructure.Samples = append(samples, sample)
+	return db.writeStorageDB(dbStructure)
+}
+
+// GetStorageSamples returns the recorded samples, oldest first
+func (db *DB) GetStorageSamples() ([]StorageSample, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadStorageDB()
+	if err != nil {
+		return nil, err
+	}
+	return dbStructure.Samples, nil
+}
+
+// ensureStorageDB creates a new database file if it doesn't exist
+func (db *DB) ensureStorageDB() error {
+	_, err := os.ReadFile(db.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return db.writeStorageDB(DBStorageStructure{Samples: []StorageSample{}})
+	}
+
+	return nil
+}
+
+// loadStorageDB reads the database file into memory
+func (db *DB) loadStorageDB() (DBStorageStructure, error) {
+	start := time.Now()
+	file, err := os.ReadFile(db.path)
+	db.observe("load_file", start, len(file), err)
+	if err != nil {
+		return DBStorageStructure{}, err
+	}
+
+	var database DBStorageStructure
+	err = json.Unmarshal(file, &database)
+	if err != nil {
+		return DBStorageStructure{}, err
+	}
+	return database, nil
+}
+
+// writeStorageDB writes the database file to disk
+func (db *DB) writeStorageDB(dbStorageStructure DBStorageStructure) error {
+	file, err := db.encode(dbStorageStructure)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = db.writeFile(file)
+	db.observe("write_file", start, len(file), err)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"time"
+
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+// Store is the storage of the chirps and users the server runs on. *DB
+// keeps them in the json database file, *SQLiteStore in a SQLite database.
+// both hand out IDs that are never reused and publish the same events.
+type Store interface {
+	CreateChirp(body string, authorID int, lang string, source string) (Chirp, error)
+	ImportChirps(authorID int, chirps []Chirp) ([]Chirp, error)
+	MirrorChirps(authorID int, chirps []Chirp) ([]Chirp, error)
+	GetChirps() ([]Chirp, error)
+	GetChirpyFromID(ID int) (Chirp, error)
+	GetChirpsByAuthorID(authorID int) ([]Chirp, error)
+	QueryChirps(q ChirpQuery) ([]Chirp, int, error)
+	ChirpActivity(authorID int, since time.Time) (map[time.Time]int, error)
+	DeleteDB(authorID int, ID int) error
+	DeleteChirpsByAuthor(authorID int) (int, error)
+	UpdateChirp(authorID int, ID int, body string) (Chirp, error)
+	BumpChirp(authorID int, ID int, cooldown time.Duration) (Chirp, error)
+	RestoreChirp(authorID int, ID int) (Chirp, error)
+	DeletedChirpsBefore(cutoff time.Time) ([]Chirp, error)
+	CompactChirps(cutoff time.Time) (CompactReport, error)
+	ResetChirps() (int, error)
+	ChirpStats() (CollectionStats, error)
+	ChirpRevision() (ChirpRevision, error)
+
+	CreateUser(email string, password []byte) (User, error)
+	GetUser() ([]User, error)
+	GetUserByID(ID int) (User, error)
+	GetUserByEmail(email string) (User, error)
+	UpdateUserDB(ID int, email string, password []byte, expectedVersion *int) (User, error)
+	UpgradeUser(ID int) error
+	DeleteUser(ID int, chirps AuthorChirps) error
+	StoreToken(ID int, token string, family string, expiresAt time.Time) error
+	AddSession(ID int, token string, family string, expiresAt time.Time) error
+	RotateToken(ID int, presented string, family string, next string) error
+	RevokeToken(ID int) error
+	RevokeSession(ID int, family string) error
+	LinkUsers(ID int, linkedID int) error
+	UnlinkUsers(ID int, linkedID int) error
+	SetOAuthProvider(ID int, provider string) (User, error)
+	LinkIdentity(userID int, provider, providerUserID string) error
+	GetUserByIdentity(provider, providerUserID string) (User, error)
+	UnlinkIdentity(userID int, provider string) error
+	SetPendingTOTP(ID int, secret string) error
+	EnableTOTP(ID int, backupCodeHashes []string) error
+	DisableTOTP(ID int) error
+	UseBackupCode(ID int, hash string) (bool, error)
+	SetProtected(ID int, protected bool) (User, error)
+	ProtectedUserIDs() ([]int, error)
+	CompactUsers(expired func(token string) bool) (CompactReport, error)
+	ResetUsers() (int, error)
+	UserStats() (CollectionStats, error)
+
+	Export(includeSecrets bool) (Backup, error)
+	Import(b Backup) error
+
+	SetClock(c clock.Clock)
+	SetEventBus(b *Bus)
+	StorageDegraded() bool
+	Close()
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*SQLiteStore)(nil)
+)
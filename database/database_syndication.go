@@ -0,0 +1,209 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSourceNotFound = errors.New("syndication source not found")
+	ErrSourceExists   = errors.New("syndication source already exists")
+)
+
+// SyndicationSource is a remote chirpy author whose chirps are mirrored
+// to a local shadow account
+type SyndicationSource struct {
+	ID             int       `json:"id"`
+	BaseURL        string    `json:"base_url"`
+	RemoteAuthorID int       `json:"remote_author_id"`
+	LocalUserID    int       `json:"local_user_id"`
+	LastRemoteID   int       `json:"last_remote_id"` // highest remote chirp ID mirrored so far
+	Failures       int       `json:"failures"`       // failed syncs in a row
+	LastError      string    `json:"last_error,omitempty"`
+	NextSyncAt     time.Time `json:"next_sync_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type DBSyndicationStructure struct {
+	Sources IDMap[SyndicationSource] `json:"sources"`
+	NextID  int                      `json:"next_id"`
+}
+
+// NewSyndicationDB creates the syndication database and creates its file if it does not exist.
+func NewSyndicationDB(path string) (*DB, error) {
+	db := &DB{
+		path: path,
+		mux:  &sync.RWMutex{},
+	}
+	err := db.ensureSyndicationDB()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// create a new source. a remote author can only be registered once per base URL.
+func (db *DB) CreateSyndicationSource(baseURL string, remoteAuthorID, localUserID int) (SyndicationSource, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadSyndicationDB()
+	if err != nil {
+		return SyndicationSource{}, err
+	}
+	for _, source := range dbStructure.Sources {
+		if source.BaseURL == baseURL && source.RemoteAuthorID == remoteAuthorID {
+			return SyndicationSource{}, ErrSourceExists
+		}
+	}
+	dbStructure.NextID++
+	now := db.now()
+	source := SyndicationSource{
+		ID:             dbStructure.NextID,
+		BaseURL:        baseURL,
+		RemoteAuthorID: remoteAuthorID,
+		LocalUserID:    localUserID,
+		NextSyncAt:     now,
+		CreatedAt:      now,
+	}
+	dbStructure.Sources[source.ID] = source
+
+	err = db.writeSyndicationDB(dbStructure)
+	if err != nil {
+		return SyndicationSource{}, err
+	}
+	return source, nil
+}
+
+// get source from id
+func (db *DB) GetSyndicationSource(ID int) (SyndicationSource, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadSyndicationDB()
+	if err != nil {
+		return SyndicationSource{}, err
+	}
+	source, ok := dbStructure.Sources[ID]
+	if !ok {
+		return SyndicationSource{}, ErrSourceNotFound
+	}
+	return source, nil
+}
+
+// get every source sorted by ID
+func (db *DB) GetSyndicationSources() ([]SyndicationSource, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadSyndicationDB()
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]SyndicationSource, 0, len(dbStructure.Sources))
+	for _, source := range dbStructure.Sources {
+		sources = append(sources, source)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].ID < sources[j].ID })
+	return sources, nil
+}
+
+// UpdateSyndicationSource loads the source, applies update and writes the result under the lock
+func (db *DB) UpdateSyndicationSource(ID int, update func(source *SyndicationSource)) (SyndicationSource, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadSyndicationDB()
+	if err != nil {
+		return SyndicationSource{}, err
+	}
+	source, ok := dbStructure.Sources[ID]
+	if !ok {
+		return SyndicationSource{}, ErrSourceNotFound
+	}
+	update(&source)
+	dbStructure.Sources[ID] = source
+
+	err = db.writeSyndicationDB(dbStructure)
+	if err != nil {
+		return SyndicationSource{}, err
+	}
+	return source, nil
+}
+
+// delete a source and return it as it was
+func (db *DB) DeleteSyndicationSource(ID int) (SyndicationSource, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadSyndicationDB()
+	if err != nil {
+		return SyndicationSource{}, err
+	}
+	source, ok := dbStructure.Sources[ID]
+	if !ok {
+		return SyndicationSource{}, ErrSourceNotFound
+	}
+	delete(dbStructure.Sources, ID)
+
+	err = db.writeSyndicationDB(dbStructure)
+	if err != nil {
+		return SyndicationSource{}, err
+	}
+	return source, nil
+}
+
+// ensureSyndicationDB creates a new database file if it doesn't exist
+func (db *DB) ensureSyndicationDB() error {
+	_, err := os.ReadFile(db.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		dbSyndicationStructure := DBSyndicationStructure{
+			Sources: make(map[int]SyndicationSource),
+		}
+		return db.writeSyndicationDB(dbSyndicationStructure)
+	}
+
+	return nil
+}
+
+// loadSyndicationDB reads the database file into memory
+func (db *DB) loadSyndicationDB() (DBSyndicationStructure, error) {
+	start := time.Now()
+	file, err := os.ReadFile(db.path)
+	db.observe("load_file", start, len(file), err)
+	if err != nil {
+		return DBSyndicationStructure{}, err
+	}
+
+	var database DBSyndicationStructure
+	err = json.Unmarshal(file, &database)
+	if err != nil {
+		return DBSyndicationStructure{}, err
+	}
+	if database.Sources == nil {
+		database.Sources = make(map[int]SyndicationSource)
+	}
+	return database, nil
+}
+
+// writeSyndicationDB writes the database file to disk
+func (db *DB) writeSyndicationDB(dbSyndicationStructure DBSyndicationStructure) error {
+	file, err := db.encode(dbSyndicationStructure)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = db.writeFile(file)
+	db.observe("write_file", start, len(file), err)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -1,12 +1,12 @@
 package database
 
 import (
-	"encoding/json"
+	"crypto/subtle"
 	"errors"
-	"io/fs"
-	"os"
+	"slices"
 	"sort"
-	"sync"
+	"strings"
+	"time"
 )
 
 type User struct {
@@ -15,23 +15,95 @@ type User struct {
 	Password     []byte `json:"password"`
 	RefreshToken string `json:"refreshToken"`
 	IsChirpyRed  bool   `json:"is_chirpy_red"`
+	// when RefreshToken stops being accepted, unset on tokens stored before
+	// it was tracked, which count as expired
+	RefreshTokenExpiresAt *time.Time `json:"refresh_token_expires_at,omitempty"`
+	// login session RefreshToken belongs to, rotations keep it
+	RefreshTokenFamily string `json:"refresh_token_family,omitempty"`
+	// sessions opened by token exchanges, they never replace the login one
+	Sessions []Session `json:"sessions,omitempty"`
+	// Version goes up on every change of email, password or membership
+	Version int `json:"version"`
+	// IDs of accounts this user can exchange tokens for, stored on both sides
+	LinkedAccounts []int `json:"linked_accounts,omitempty"`
+
+	// provider the account was created with, empty for password signups
+	OAuthProvider string `json:"oauth_provider,omitempty"`
+	// NoPassword is set while an oauth-created account has no usable password
+	NoPassword bool `json:"no_password,omitempty"`
+
+	TOTPEnabled       bool     `json:"totp_enabled,omitempty"`
+	TOTPSecret        string   `json:"totp_secret,omitempty"`
+	TOTPPendingSecret string   `json:"totp_pending_secret,omitempty"`
+	TOTPBackupCodes   []string `json:"totp_backup_codes,omitempty"` // sha256 hashes
+
+	// Protected makes every chirp of the user followers-only and new
+	// followers wait for approval
+	Protected bool `json:"protected,omitempty"`
 }
 
+// maxSessions caps the sessions a user has besides the login one, opening
+// another drops the oldest
+const maxSessions = 10
+
+// Session is a refresh token session next to the login session of a user
+type Session struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Family    string    `json:"family"`
+}
+
+// ErrVersionConflict is returned when an update expected an older version of the user
+var ErrVersionConflict = errors.New("user was changed since it was read")
+
+// ErrUserNotFound is returned for IDs and emails without a user
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUserExists is returned when another user already has the email
+var ErrUserExists = errors.New("email already in use")
+
+var (
+	// ErrRefreshTokenInvalid is returned when rotating a token that isn't the stored one
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when rotating a token that was
+	// already rotated. the session's current token is revoked with it.
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
 type DBUserStructure struct {
-	Users map[int]User `json:"users"`
+	Users      IDMap[User]         `json:"users"`
+	Identities map[string]Identity `json:"identities,omitempty"`
+	NextID     int                 `json:"next_user_id"` // the last ID handed out, IDs are never reused
 }
 
-// NewDB creates database connection and creates database file if does not exist.
-func NewUserDB(path string) (*DB, error) {
-	db := &DB{
-		path: path,
-		mux:  &sync.RWMutex{},
-	}
-	err := db.ensureUserDB()
-	if err != nil {
-		return nil, err
+// newID hands out the next user ID
+func (dbStructure *DBUserStructure) newID() int {
+	dbStructure.NextID++
+	return dbStructure.NextID
+}
+
+// inferNextID catches the counter up with the users, for files written
+// before it was stored
+func (dbStructure *DBUserStructure) inferNextID() {
+	dbStructure.NextID = max(dbStructure.NextID, dbStructure.Users.maxID())
+}
+
+// userByEmail finds the user with email, ignoring case. files from before
+// emails were unique can hold the same email more than once: an exact match
+// wins, then the oldest account.
+func (dbStructure DBUserStructure) userByEmail(email string) (User, bool) {
+	var found User
+	ok := false
+	for _, user := range dbStructure.Users {
+		if !strings.EqualFold(user.Email, email) {
+			continue
+		}
+		exact, foundExact := user.Email == email, found.Email == email
+		if !ok || (exact && !foundExact) || (exact == foundExact && user.ID < found.ID) {
+			found, ok = user, true
+		}
 	}
-	return db, nil
+	return found, ok
 }
 
 // create a new chirp and saves it to disk
@@ -44,21 +116,25 @@ func (db *DB) CreateUser(body string, password []byte) (User, error) {
 	if err != nil {
 		return User{}, err
 	}
-	nextID := len(dbStructure.Users) + 1
+	if _, ok := dbStructure.userByEmail(body); ok {
+		return User{}, ErrUserExists
+	}
+	nextID := dbStructure.newID()
 
 	dbStructure.Users[nextID] = User{Email: body, ID: nextID, Password: password}
 	err = db.writeUserDB(dbStructure)
 	if err != nil {
 		return User{}, err
 	}
+	db.emit(Event{Type: UserCreated, UserID: nextID, At: db.now()})
 
 	return dbStructure.Users[nextID], nil
 }
 
-// GetChirps returns all chirps in the database
+// GetUser returns all users sorted by ID
 func (db *DB) GetUser() ([]User, error) {
-	db.mux.Lock()
-	defer db.mux.Unlock()
+	db.mux.RLock()
+	defer db.mux.RUnlock()
 
 	// load current db and range all data in map to slice sort by ID and return
 	dbStructure, err := db.loadUserDB()
@@ -74,64 +150,44 @@ func (db *DB) GetUser() ([]User, error) {
 	return users, nil
 }
 
+// GetUserByID returns the user with ID or ErrUserNotFound. IDs don't have
+// to be contiguous, so it looks the ID up instead of indexing.
 func (db *DB) GetUserByID(ID int) (User, error) {
-	users, err := db.GetUser()
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	// every authenticated request looks its user up, copy that one only
+	c, err := db.loadFile()
 	if err != nil {
 		return User{}, err
 	}
-	if len(users) < ID || ID <= 0 {
-		return User{}, errors.New("invalid ID")
+	user, ok := c.users.Users[ID]
+	if !ok {
+		return User{}, ErrUserNotFound
 	}
-	return users[ID-1], nil
+	return user.clone(), nil
 }
 
-// ensureDB creates a new database file if it doesn't exist
-func (db *DB) ensureUserDB() error {
-	_, err := os.ReadFile(db.path)
-	if errors.Is(err, fs.ErrNotExist) {
-		dbUserStructure := DBUserStructure{
-			Users: make(map[int]User),
-		}
-		return db.writeUserDB(dbUserStructure)
-	}
-
-	return nil
-}
+// GetUserByEmail returns the user with email, ignoring case, or ErrUserNotFound
+func (db *DB) GetUserByEmail(email string) (User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
 
-// loadDB reads the database file into memory
-func (db *DB) loadUserDB() (DBUserStructure, error) {
-	file, err := os.ReadFile(db.path)
+	c, err := db.loadFile()
 	if err != nil {
-		return DBUserStructure{}, err
+		return User{}, err
 	}
-
-	var database DBUserStructure
-	err = json.Unmarshal(file, &database)
-	if err != nil {
-		return DBUserStructure{}, err
+	user, ok := c.users.userByEmail(email)
+	if !ok {
+		return User{}, ErrUserNotFound
 	}
-	return database, nil
-
+	return user.clone(), nil
 }
 
-// writeDB writes the database file to disk
-func (db *DB) writeUserDB(dbUserStructure DBUserStructure) error {
-	file, err := json.Marshal(dbUserStructure)
-	if err != nil {
-		return err
-	}
-
-	err = os.WriteFile(db.path, file, 0644)
-	if err != nil {
-		return err
-	}
-
-	return nil
-
-}
-
-// updateUserDB updates existing user password
-func (db *DB) UpdateUserDB(ID int, body string, password []byte) (User, error) {
+// updateUserDB updates existing user password.
+// when expectedVersion is set and the user's version differs nothing is
+// written and the current user is returned with ErrVersionConflict.
+func (db *DB) UpdateUserDB(ID int, body string, password []byte, expectedVersion *int) (User, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
@@ -141,22 +197,32 @@ func (db *DB) UpdateUserDB(ID int, body string, password []byte) (User, error) {
 		return User{}, err
 	}
 
-	if user, ok := dbStructure.Users[ID]; ok {
-		user.Email = body
-		user.Password = password
-		dbStructure.Users[ID] = user
+	user, ok := dbStructure.Users[ID]
+	if !ok {
+		return User{}, ErrUserNotFound
 	}
+	if expectedVersion != nil && *expectedVersion != user.Version {
+		return user, ErrVersionConflict
+	}
+	if other, ok := dbStructure.userByEmail(body); ok && other.ID != ID {
+		return User{}, ErrUserExists
+	}
+	user.Email = body
+	user.Password = password
+	user.NoPassword = false
+	user.Version++
+	dbStructure.Users[ID] = user
 
 	err = db.writeUserDB(dbStructure)
 	if err != nil {
 		return User{}, err
 	}
-	return dbStructure.Users[ID], nil
-
+	db.emit(Event{Type: UserUpdated, UserID: ID, At: db.now()})
+	return user, nil
 }
 
 // upgrade user to red chirpy
-func (db *DB) UpgradeUser (ID int) error {
+func (db *DB) UpgradeUser(ID int) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
@@ -167,20 +233,81 @@ func (db *DB) UpgradeUser (ID int) error {
 
 	if user, ok := dbStructure.Users[ID]; ok {
 		user.IsChirpyRed = true
+		user.Version++
 		dbStructure.Users[ID] = user
 	} else {
-		return errors.New("invalid user id")
+		return ErrUserNotFound
 	}
 
 	err = db.writeUserDB(dbStructure)
 	if err != nil {
 		return err
 	}
+	db.emit(Event{Type: UserUpgraded, UserID: ID, At: db.now()})
 	return nil
 }
 
-// revoke refresh token
+// RevokeToken ends every session of the user
 func (db *DB) RevokeToken(ID int) error {
+	return ignoreMissingUser(db.updateSessions(ID, func(user *User) error {
+		user.revokeSessions()
+		return nil
+	}))
+}
+
+// RevokeSession ends the session family of the user, whether it is the
+// login session or one opened by a token exchange
+func (db *DB) RevokeSession(ID int, family string) error {
+	return ignoreMissingUser(db.updateSessions(ID, func(user *User) error {
+		user.revokeSession(family)
+		return nil
+	}))
+}
+
+// store refresh token of the session family to db, valid until expiresAt.
+// it replaces the login session of the user.
+func (db *DB) StoreToken(ID int, token string, family string, expiresAt time.Time) error {
+	return ignoreMissingUser(db.updateSessions(ID, func(user *User) error {
+		user.RefreshToken = token
+		user.RefreshTokenExpiresAt = &expiresAt
+		user.RefreshTokenFamily = family
+		return nil
+	}))
+}
+
+// AddSession opens a session of family next to the user's login session,
+// valid until expiresAt
+func (db *DB) AddSession(ID int, token string, family string, expiresAt time.Time) error {
+	return ignoreMissingUser(db.updateSessions(ID, func(user *User) error {
+		user.addSession(Session{Token: token, ExpiresAt: expiresAt, Family: family}, db.now())
+		return nil
+	}))
+}
+
+// RotateToken replaces the stored refresh token presented with next,
+// keeping its expiry. family is the session presented belongs to: a token
+// of a stored session that isn't its stored token was already rotated,
+// so someone else holds a copy and the session is revoked with
+// ErrRefreshTokenReused. any other token is ErrRefreshTokenInvalid.
+func (db *DB) RotateToken(ID int, presented string, family string, next string) error {
+	reused := false
+	err := db.updateSessions(ID, func(user *User) error {
+		err := user.rotateToken(presented, family, next)
+		if errors.Is(err, ErrRefreshTokenReused) {
+			reused = true
+			return nil
+		}
+		return err
+	})
+	if err == nil && reused {
+		return ErrRefreshTokenReused
+	}
+	return err
+}
+
+// updateSessions applies update to the user with ID and saves it,
+// ErrUserNotFound when there is none
+func (db *DB) updateSessions(ID int, update func(user *User) error) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
@@ -188,22 +315,153 @@ func (db *DB) RevokeToken(ID int) error {
 	if err != nil {
 		return err
 	}
+	user, ok := dbStructure.Users[ID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if err := update(&user); err != nil {
+		return err
+	}
+	dbStructure.Users[ID] = user
+	return db.writeUserDB(dbStructure)
+}
 
-	if user, ok := dbStructure.Users[ID]; ok {
+// ignoreMissingUser drops ErrUserNotFound, for writes that leave deleted
+// users alone like an UPDATE of no rows does
+func ignoreMissingUser(err error) error {
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	return err
+}
+
+// addSession opens s, dropping expired sessions and the oldest past
+// maxSessions
+func (user *User) addSession(s Session, now time.Time) {
+	sessions := slices.DeleteFunc(slices.Clone(user.Sessions), func(s Session) bool {
+		return !now.Before(s.ExpiresAt)
+	})
+	sessions = append(sessions, s)
+	if n := len(sessions) - maxSessions; n > 0 {
+		sessions = sessions[n:]
+	}
+	user.Sessions = sessions
+}
+
+// rotateToken rotates presented to next in whichever session holds it.
+// a stale token of a session revokes that session and is
+// ErrRefreshTokenReused, anything else ErrRefreshTokenInvalid.
+func (user *User) rotateToken(presented, family, next string) error {
+	if user.RefreshToken != "" && subtle.ConstantTimeCompare([]byte(user.RefreshToken), []byte(presented)) == 1 {
+		user.RefreshToken = next
+		user.RefreshTokenFamily = family
+		return nil
+	}
+	for i, s := range user.Sessions {
+		if subtle.ConstantTimeCompare([]byte(s.Token), []byte(presented)) == 1 {
+			user.Sessions = slices.Clone(user.Sessions)
+			user.Sessions[i].Token = next
+			return nil
+		}
+	}
+	if family == "" || !user.hasSession(family) {
+		return ErrRefreshTokenInvalid
+	}
+	user.revokeSession(family)
+	return ErrRefreshTokenReused
+}
+
+// hasSession reports whether family is one of the user's sessions
+func (user *User) hasSession(family string) bool {
+	if family == user.RefreshTokenFamily {
+		return true
+	}
+	return slices.ContainsFunc(user.Sessions, func(s Session) bool { return s.Family == family })
+}
+
+// revokeSession ends the session family
+func (user *User) revokeSession(family string) {
+	if family == user.RefreshTokenFamily {
 		user.RefreshToken = ""
-		dbStructure.Users[ID] = user
+		user.RefreshTokenExpiresAt = nil
+		user.RefreshTokenFamily = ""
 	}
+	user.Sessions = slices.DeleteFunc(slices.Clone(user.Sessions), func(s Session) bool { return s.Family == family })
+	if len(user.Sessions) == 0 {
+		user.Sessions = nil
+	}
+}
 
-	err = db.writeUserDB(dbStructure)
+// revokeSessions ends the login session and every other one
+func (user *User) revokeSessions() {
+	user.RefreshToken = ""
+	user.RefreshTokenExpiresAt = nil
+	user.RefreshTokenFamily = ""
+	user.Sessions = nil
+}
+
+// compactSessions ends the sessions whose token expired reports as no
+// longer usable and returns how many it ended
+func (user *User) compactSessions(expired func(token string) bool) int {
+	removed := 0
+	if user.RefreshToken != "" && expired(user.RefreshToken) {
+		user.RefreshToken = ""
+		user.RefreshTokenExpiresAt = nil
+		user.RefreshTokenFamily = ""
+		removed++
+	}
+	kept := make([]Session, 0, len(user.Sessions))
+	for _, s := range user.Sessions {
+		if expired(s.Token) {
+			removed++
+			continue
+		}
+		kept = append(kept, s)
+	}
+	user.Sessions = kept
+	if len(user.Sessions) == 0 {
+		user.Sessions = nil
+	}
+	return removed
+}
+
+// link two accounts to each other
+func (db *DB) LinkUsers(ID int, linkedID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if ID == linkedID {
+		return errors.New("cannot link an account to itself")
+	}
+
+	dbStructure, err := db.loadUserDB()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	user, ok := dbStructure.Users[ID]
+	if !ok {
+		return errors.New("invalid user id")
+	}
+	linked, ok := dbStructure.Users[linkedID]
+	if !ok {
+		return errors.New("invalid user id")
+	}
+
+	if !slices.Contains(user.LinkedAccounts, linkedID) {
+		user.LinkedAccounts = append(user.LinkedAccounts, linkedID)
+	}
+	if !slices.Contains(linked.LinkedAccounts, ID) {
+		linked.LinkedAccounts = append(linked.LinkedAccounts, ID)
+	}
+	dbStructure.Users[ID] = user
+	dbStructure.Users[linkedID] = linked
+
+	return db.writeUserDB(dbStructure)
 }
 
-// store refresh token to db
-func (db *DB) StoreToken(ID int, token string) error {
+// unlink two accounts from each other
+func (db *DB) UnlinkUsers(ID int, linkedID int) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
@@ -212,15 +470,140 @@ func (db *DB) StoreToken(ID int, token string) error {
 		return err
 	}
 
-	if user, ok := dbStructure.Users[ID]; ok {
-		user.RefreshToken = token
-		dbStructure.Users[ID] = user
+	user, ok := dbStructure.Users[ID]
+	if !ok || !slices.Contains(user.LinkedAccounts, linkedID) {
+		return errors.New("accounts are not linked")
+	}
+	user.LinkedAccounts = slices.DeleteFunc(user.LinkedAccounts, func(id int) bool { return id == linkedID })
+	dbStructure.Users[ID] = user
+
+	if linked, ok := dbStructure.Users[linkedID]; ok {
+		linked.LinkedAccounts = slices.DeleteFunc(linked.LinkedAccounts, func(id int) bool { return id == ID })
+		dbStructure.Users[linkedID] = linked
+	}
+
+	return db.writeUserDB(dbStructure)
+}
+
+// updateUserRecord loads the user, applies update and writes the result under the lock
+func (db *DB) updateUserRecord(ID int, update func(user *User) error) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadUserDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, ok := dbStructure.Users[ID]
+	if !ok {
+		return User{}, errors.New("invalid user id")
 	}
+	if err := update(&user); err != nil {
+		return User{}, err
+	}
+	dbStructure.Users[ID] = user
 
 	err = db.writeUserDB(dbStructure)
 	if err != nil {
-		return err
+		return User{}, err
 	}
+	return user, nil
+}
 
-	return nil
+// record the oauth provider an account was created with.
+// such accounts have no usable password until the user sets one.
+func (db *DB) SetOAuthProvider(ID int, provider string) (User, error) {
+	return db.updateUserRecord(ID, func(user *User) error {
+		user.OAuthProvider = provider
+		user.NoPassword = true
+		return nil
+	})
+}
+
+// SetProtected turns protected mode of the user on or off
+func (db *DB) SetProtected(ID int, protected bool) (User, error) {
+	return db.updateUserRecord(ID, func(user *User) error {
+		user.Protected = protected
+		return nil
+	})
+}
+
+// ProtectedUserIDs returns the IDs of the protected users in order
+func (db *DB) ProtectedUserIDs() ([]int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	// read the cached users without copying them, this runs on every list request
+	c, err := db.loadFile()
+	if err != nil {
+		return nil, err
+	}
+	IDs := make([]int, 0)
+	for ID, user := range c.users.Users {
+		if user.Protected {
+			IDs = append(IDs, ID)
+		}
+	}
+	slices.Sort(IDs)
+	return IDs, nil
+}
+
+// store a totp secret waiting for its first valid code
+func (db *DB) SetPendingTOTP(ID int, secret string) error {
+	_, err := db.updateUserRecord(ID, func(user *User) error {
+		if user.TOTPEnabled {
+			return errors.New("totp is already enabled")
+		}
+		user.TOTPPendingSecret = secret
+		return nil
+	})
+	return err
+}
+
+// activate the pending totp secret with a fresh set of hashed backup codes
+func (db *DB) EnableTOTP(ID int, backupCodeHashes []string) error {
+	_, err := db.updateUserRecord(ID, func(user *User) error {
+		if user.TOTPPendingSecret == "" {
+			return errors.New("totp setup has not been started")
+		}
+		user.TOTPEnabled = true
+		user.TOTPSecret = user.TOTPPendingSecret
+		user.TOTPPendingSecret = ""
+		user.TOTPBackupCodes = backupCodeHashes
+		return nil
+	})
+	return err
+}
+
+// turn off totp and drop the secret and backup codes
+func (db *DB) DisableTOTP(ID int) error {
+	_, err := db.updateUserRecord(ID, func(user *User) error {
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.TOTPPendingSecret = ""
+		user.TOTPBackupCodes = nil
+		return nil
+	})
+	return err
+}
+
+// UseBackupCode consumes a backup code by its hash.
+// it reports whether the code was valid, a used code can't be used again.
+func (db *DB) UseBackupCode(ID int, hash string) (bool, error) {
+	used := false
+	_, err := db.updateUserRecord(ID, func(user *User) error {
+		for i, stored := range user.TOTPBackupCodes {
+			if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+				user.TOTPBackupCodes = slices.Delete(user.TOTPBackupCodes, i, i+1)
+				used = true
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return used, nil
 }
@@ -0,0 +1,86 @@
+package database
+
+import "slices"
+
+// AuthorChirps is what DeleteUser does with the chirps of the user
+type AuthorChirps int
+
+const (
+	// DeleteAuthorChirps tombstones the live chirps, the purge removes them
+	// like any other deleted chirp
+	DeleteAuthorChirps AuthorChirps = iota
+	// AnonymizeAuthorChirps keeps every chirp with author ID 0
+	AnonymizeAuthorChirps
+)
+
+// DeleteUser removes the user with their refresh token, identities and
+// links from other accounts, and deletes or anonymizes their chirps. users
+// and chirps share the file, so all of it is one write. it returns
+// ErrUserNotFound for unknown IDs.
+func (db *DB) DeleteUser(ID int, chirps AuthorChirps) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadUserDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := dbStructure.Users[ID]; !ok {
+		return ErrUserNotFound
+	}
+
+	chirpStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	now := db.now()
+	changed := make([]Chirp, 0)
+	for id, chirp := range chirpStructure.Chirps {
+		if chirp.AuthorID != ID {
+			continue
+		}
+		switch chirps {
+		case DeleteAuthorChirps:
+			if chirp.DeletedAt != nil {
+				continue
+			}
+			chirp.DeletedAt = &now
+		case AnonymizeAuthorChirps:
+			chirp.AuthorID = 0
+		}
+		chirpStructure.Chirps[id] = chirp
+		changed = append(changed, chirp)
+	}
+
+	if len(changed) > 0 {
+		chirpStructure.touch(now)
+	}
+	delete(dbStructure.Users, ID)
+	for key, identity := range dbStructure.Identities {
+		if identity.UserID == ID {
+			delete(dbStructure.Identities, key)
+		}
+	}
+	for id, user := range dbStructure.Users {
+		if slices.Contains(user.LinkedAccounts, ID) {
+			user.LinkedAccounts = slices.DeleteFunc(user.LinkedAccounts, func(linked int) bool { return linked == ID })
+			dbStructure.Users[id] = user
+		}
+	}
+	err = db.saveFile(cachedFile{chirps: newCachedChirps(chirpStructure), users: dbStructure})
+	if err != nil {
+		return err
+	}
+
+	if chirps == DeleteAuthorChirps {
+		db.emit(db.chirpEvents(ChirpDeleted, changed)...)
+	} else {
+		events := db.chirpEvents(ChirpAnonymized, changed)
+		for i := range events {
+			events[i].UserID = ID // the former author
+		}
+		db.emit(events...)
+	}
+	db.emit(Event{Type: UserDeleted, UserID: ID, At: now})
+	return nil
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ErrStorageUnavailable is returned when a write keeps failing with an
+// error that is expected to clear up on its own, like a full disk
+var ErrStorageUnavailable = errors.New("storage temporarily unavailable")
+
+// how long to wait before retrying a transient write failure, plus jitter
+const (
+	writeRetryDelay  = 20 * time.Millisecond
+	writeRetryJitter = 30 * time.Millisecond
+)
+
+// isTransientWriteError reports whether a failed write is worth retrying
+func isTransientWriteError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.EBUSY) ||
+		errors.Is(err, syscall.ENOSPC) ||
+		errors.Is(err, syscall.ETIMEDOUT)
+}
+
+// writeFile writes the database file, retrying once after a short
+// jittered delay when the failure is transient. callers hold the write lock.
+// ENOSPC marks the storage as degraded until a write succeeds again.
+func (db *DB) writeFile(file []byte) error {
+	err := db.replaceFile(file)
+	if err != nil && isTransientWriteError(err) {
+		time.Sleep(writeRetryDelay + rand.N(writeRetryJitter))
+		err = db.replaceFile(file)
+	}
+
+	if err == nil {
+		db.degraded.Store(false)
+		return nil
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		db.degraded.Store(true)
+	}
+	if isTransientWriteError(err) {
+		return fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+	}
+	return err
+}
+
+// replaceFile writes file to a temp file next to the database file and
+// renames it over the database, so a crash leaves the old file or the new
+// one and never a partial write
+func (db *DB) replaceFile(file []byte) error {
+	tmp, err := db.writeTemp(file, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(db.path))
+	return nil
+}
+
+// writeTemp writes file to a new synced temp file in the directory of the
+// database file and returns its name
+func (db *DB) writeTemp(file []byte, pattern string) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), filepath.Base(db.path)+pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(file)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// syncDir makes a rename in dir durable. it is best effort, some systems
+// can't sync directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// StorageDegraded reports whether the last write failed because the disk was full
+func (db *DB) StorageDegraded() bool {
+	return db.degraded.Load()
+}
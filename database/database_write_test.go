@@ -0,0 +1,88 @@
+package database
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInterruptedWriteKeepsDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	author := mustUser(t, db, "author@example.com")
+	mustChirp(t, db, author.ID, "written before the crash")
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash halfway through the next write leaves a truncated temp file
+	// that was never renamed over the database
+	next := append(bytes.Clone(before), []byte(`{"half of the next write`)...)
+	tmp, err := db.writeTemp(next, ".tmp-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(tmp, int64(len(next)/2)); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, before) {
+		t.Fatalf("the database file changed before the rename:\n%s", after)
+	}
+	restarted, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirps, err := restarted.GetChirps()
+	if err != nil {
+		t.Fatalf("GetChirps after the crash: %v", err)
+	}
+	if len(chirps) != 1 || chirps[0].Body != "written before the crash" {
+		t.Fatalf("chirps after the crash = %+v, want the one written before", chirps)
+	}
+	// the next write replaces the file as a whole again
+	mustChirp(t, restarted, author.ID, "written after the crash")
+	if _, err := NewDB(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmptyDatabaseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := db.GetUser()
+	if err != nil || len(users) != 0 {
+		t.Fatalf("GetUser of an empty file = %v, %v, want no users", users, err)
+	}
+	chirps, err := db.GetChirps()
+	if err != nil || len(chirps) != 0 {
+		t.Fatalf("GetChirps of an empty file = %v, %v, want no chirps", chirps, err)
+	}
+
+	user := mustUser(t, db, "first@example.com")
+	if user.ID != 1 {
+		t.Fatalf("first user of an empty file has ID %d, want 1", user.ID)
+	}
+	reopened, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.GetUserByEmail("first@example.com"); err != nil {
+		t.Fatalf("user written to a formerly empty file: %v", err)
+	}
+}
@@ -0,0 +1,870 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+var testEpoch = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// backends opens a new, empty store of every implementation
+var backends = map[string]func(t *testing.T) Store{
+	"json": func(t *testing.T) Store {
+		db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	},
+	"sqlite": func(t *testing.T) Store {
+		s, err := OpenSQLite(filepath.Join(t.TempDir(), "chirpy.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+	// the json store mirrored to SQLite, every read checked against both
+	"dual": func(t *testing.T) Store {
+		db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := OpenSQLite(filepath.Join(t.TempDir(), "chirpy.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := NewDualStore(db, "json", s, "sqlite", 1)
+		t.Cleanup(func() {
+			for _, divergence := range d.Report().Divergences {
+				t.Errorf("divergence: %+v", divergence)
+			}
+		})
+		return d
+	},
+}
+
+// forEachStore runs test against a new store of every backend, with a fake
+// clock set to testEpoch
+func forEachStore(t *testing.T, test func(t *testing.T, s Store, clk *clock.Fake)) {
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			t.Cleanup(s.Close)
+			clk := clock.NewFake(testEpoch)
+			s.SetClock(clk)
+			test(t, s, clk)
+		})
+	}
+}
+
+// mustUser creates a user or fails the test
+func mustUser(t *testing.T, s Store, email string) User {
+	t.Helper()
+	user, err := s.CreateUser(email, []byte("hash of "+email))
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %v", email, err)
+	}
+	return user
+}
+
+// mustChirp creates a chirp or fails the test
+func mustChirp(t *testing.T, s Store, authorID int, body string) Chirp {
+	t.Helper()
+	chirp, err := s.CreateChirp(body, authorID, "en", "web")
+	if err != nil {
+		t.Fatalf("CreateChirp(%q): %v", body, err)
+	}
+	return chirp
+}
+
+// chirpIDs returns the IDs of chirps in order
+func chirpIDs(chirps []Chirp) []int {
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	return ids
+}
+
+// eventRecorder collects the events a store publishes
+type eventRecorder struct {
+	mux    *sync.Mutex
+	events []Event
+}
+
+func recordEvents(s Store) *eventRecorder {
+	rec := &eventRecorder{mux: &sync.Mutex{}}
+	bus := NewBus()
+	bus.Subscribe("test", 1024, func(e Event) {
+		rec.mux.Lock()
+		rec.events = append(rec.events, e)
+		rec.mux.Unlock()
+	})
+	s.SetEventBus(bus)
+	return rec
+}
+
+// wait returns the types of the first n events, failing the test when
+// they don't arrive in time
+func (rec *eventRecorder) wait(t *testing.T, n int) []EventType {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rec.mux.Lock()
+		if len(rec.events) >= n {
+			types := make([]EventType, 0, n)
+			for _, e := range rec.events[:n] {
+				types = append(types, e.Type)
+			}
+			rec.mux.Unlock()
+			return types
+		}
+		rec.mux.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d events, want %d", len(rec.events), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStoreUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		alice := mustUser(t, s, "alice@example.com")
+		bob := mustUser(t, s, "bob@example.com")
+		if alice.ID != 1 || bob.ID != 2 {
+			t.Fatalf("IDs = %d, %d, want 1, 2", alice.ID, bob.ID)
+		}
+
+		if _, err := s.CreateUser("ALICE@example.com", []byte("x")); !errors.Is(err, ErrUserExists) {
+			t.Fatalf("CreateUser with a taken email in another case: %v, want ErrUserExists", err)
+		}
+		got, err := s.GetUserByEmail("Alice@Example.com")
+		if err != nil || got.ID != alice.ID || string(got.Password) != "hash of alice@example.com" {
+			t.Fatalf("GetUserByEmail = %+v, %v, want alice", got, err)
+		}
+		if _, err := s.GetUserByEmail("nobody@example.com"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("GetUserByEmail of nobody: %v, want ErrUserNotFound", err)
+		}
+		if _, err := s.GetUserByID(99); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("GetUserByID(99): %v, want ErrUserNotFound", err)
+		}
+
+		users, err := s.GetUser()
+		if err != nil || len(users) != 2 || users[0].ID != alice.ID || users[1].ID != bob.ID {
+			t.Fatalf("GetUser = %+v, %v, want alice and bob in ID order", users, err)
+		}
+
+		stale := alice.Version
+		updated, err := s.UpdateUserDB(alice.ID, "alice@new.example.com", []byte("new hash"), &stale)
+		if err != nil || updated.Email != "alice@new.example.com" || updated.Version != stale+1 {
+			t.Fatalf("UpdateUserDB = %+v, %v", updated, err)
+		}
+		if _, err := s.UpdateUserDB(alice.ID, "alice@third.example.com", []byte("x"), &stale); !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("UpdateUserDB with a stale version: %v, want ErrVersionConflict", err)
+		}
+		if _, err := s.UpdateUserDB(alice.ID, "bob@example.com", []byte("x"), nil); !errors.Is(err, ErrUserExists) {
+			t.Fatalf("UpdateUserDB to bob's email: %v, want ErrUserExists", err)
+		}
+		if _, err := s.UpdateUserDB(99, "carol@example.com", []byte("x"), nil); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("UpdateUserDB of a missing user: %v, want ErrUserNotFound", err)
+		}
+		if _, err := s.GetUserByEmail("carol@example.com"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("GetUserByEmail after a failed update: %v, want ErrUserNotFound", err)
+		}
+
+		if err := s.UpgradeUser(bob.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.UpgradeUser(99); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("UpgradeUser(99): %v, want ErrUserNotFound", err)
+		}
+		got, _ = s.GetUserByID(bob.ID)
+		if !got.IsChirpyRed {
+			t.Fatal("UpgradeUser didn't make bob Chirpy Red")
+		}
+
+		carol := mustUser(t, s, "carol@example.com")
+		for _, ID := range []int{carol.ID, alice.ID} {
+			if got, err := s.SetProtected(ID, true); err != nil || !got.Protected {
+				t.Fatalf("SetProtected(%d) = %+v, %v", ID, got, err)
+			}
+		}
+		if IDs, err := s.ProtectedUserIDs(); err != nil || !slices.Equal(IDs, []int{alice.ID, carol.ID}) {
+			t.Fatalf("ProtectedUserIDs = %v, %v, want alice and carol", IDs, err)
+		}
+		if _, err := s.SetProtected(carol.ID, false); err != nil {
+			t.Fatal(err)
+		}
+		if IDs, err := s.ProtectedUserIDs(); err != nil || !slices.Equal(IDs, []int{alice.ID}) {
+			t.Fatalf("ProtectedUserIDs after unprotecting carol = %v, %v, want alice", IDs, err)
+		}
+		if got, _ := s.GetUserByID(alice.ID); !got.Protected || got.Version != updated.Version {
+			t.Fatalf("alice = %+v, want protected at version %d", got, updated.Version)
+		}
+	})
+}
+
+func TestDBInfersNextIDs(t *testing.T) {
+	// written before the counters were stored, with gaps left by deletions
+	path := filepath.Join(t.TempDir(), "database.json")
+	legacy := `{
+		"chirps": {"1": {"author_id": 1, "body": "kept", "id": 1}, "4": {"author_id": 3, "body": "also kept", "id": 4}},
+		"users": {"1": {"email": "first@example.com", "id": 1}, "3": {"email": "third@example.com", "id": 3}}
+	}`
+	if err := os.WriteFile(path, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// len+1 would have been 3 and 3, clobbering the third user and the last chirp
+	user := mustUser(t, db, "new@example.com")
+	if user.ID != 4 {
+		t.Fatalf("new user got ID %d, want 4", user.ID)
+	}
+	chirp := mustChirp(t, db, user.ID, "new")
+	if chirp.ID != 5 {
+		t.Fatalf("new chirp got ID %d, want 5", chirp.ID)
+	}
+	third, err := db.GetUserByID(3)
+	if err != nil || third.Email != "third@example.com" {
+		t.Fatalf("user 3 = %+v, %v, want third@example.com", third, err)
+	}
+	kept, err := db.GetChirpyFromID(4)
+	if err != nil || kept.Body != "also kept" {
+		t.Fatalf("chirp 4 = %+v, %v, want it kept", kept, err)
+	}
+}
+
+func TestStoreIDsAreNeverReused(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		first := mustUser(t, s, "first@example.com")
+		second := mustUser(t, s, "second@example.com")
+		third := mustUser(t, s, "third@example.com")
+		if err := s.DeleteUser(second.ID, DeleteAuthorChirps); err != nil {
+			t.Fatal(err)
+		}
+		fourth := mustUser(t, s, "fourth@example.com")
+		if fourth.ID != 4 {
+			t.Fatalf("user created after a deletion got ID %d, want 4", fourth.ID)
+		}
+		for _, user := range []User{first, third, fourth} {
+			got, err := s.GetUserByID(user.ID)
+			if err != nil || got.Email != user.Email {
+				t.Fatalf("user %d = %+v, %v, want %s", user.ID, got, err, user.Email)
+			}
+		}
+		if _, err := s.GetUserByID(second.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("deleted user %d: %v, want ErrUserNotFound", second.ID, err)
+		}
+		if users, err := s.GetUser(); err != nil || len(users) != 3 {
+			t.Fatalf("GetUser = %v, %v, want 3 users", users, err)
+		}
+
+		chirp := mustChirp(t, s, first.ID, "one")
+		if err := s.DeleteDB(first.ID, chirp.ID); err != nil {
+			t.Fatal(err)
+		}
+		clk.Advance(time.Hour)
+		if _, err := s.CompactChirps(clk.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if next := mustChirp(t, s, first.ID, "two"); next.ID != chirp.ID+1 {
+			t.Fatalf("chirp created after a purge got ID %d, want %d", next.ID, chirp.ID+1)
+		}
+
+		// a reset is the one way IDs start over
+		if _, err := s.ResetChirps(); err != nil {
+			t.Fatal(err)
+		}
+		if next := mustChirp(t, s, first.ID, "three"); next.ID != 1 {
+			t.Fatalf("chirp created after a reset got ID %d, want 1", next.ID)
+		}
+	})
+}
+
+func TestStoreChirps(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		alice := mustUser(t, s, "alice@example.com")
+		bob := mustUser(t, s, "bob@example.com")
+
+		first, err := s.CreateChirp("first", alice.ID, "de", "cron-bot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.ID != 1 || first.AuthorID != alice.ID || first.Lang != "de" || first.Source != "cron-bot" ||
+			first.CreatedAt == nil || !first.CreatedAt.Equal(testEpoch) {
+			t.Fatalf("CreateChirp = %+v", first)
+		}
+		clk.Advance(time.Minute)
+		second := mustChirp(t, s, bob.ID, "second")
+
+		chirps, err := s.GetChirps()
+		if err != nil || !slices.Equal(chirpIDs(chirps), []int{first.ID, second.ID}) {
+			t.Fatalf("GetChirps = %v, %v, want both in ID order", chirpIDs(chirps), err)
+		}
+		byAuthor, err := s.GetChirpsByAuthorID(bob.ID)
+		if err != nil || !slices.Equal(chirpIDs(byAuthor), []int{second.ID}) {
+			t.Fatalf("GetChirpsByAuthorID = %v, %v, want bob's", chirpIDs(byAuthor), err)
+		}
+
+		if err := s.DeleteDB(bob.ID, first.ID); !errors.Is(err, ErrNotChirpAuthor) {
+			t.Fatalf("DeleteDB by another user: %v, want ErrNotChirpAuthor", err)
+		}
+		if err := s.DeleteDB(alice.ID, 99); !errors.Is(err, ErrChirpNotFound) {
+			t.Fatalf("DeleteDB of a missing chirp: %v, want ErrChirpNotFound", err)
+		}
+		if _, err := s.UpdateChirp(bob.ID, first.ID, "stolen"); !errors.Is(err, ErrNotChirpAuthor) {
+			t.Fatalf("UpdateChirp by another user: %v, want ErrNotChirpAuthor", err)
+		}
+
+		clk.Advance(time.Minute)
+		edited, err := s.UpdateChirp(alice.ID, first.ID, "first, edited")
+		if err != nil || edited.Body != "first, edited" || edited.UpdatedAt == nil || !edited.UpdatedAt.Equal(clk.Now()) {
+			t.Fatalf("UpdateChirp = %+v, %v", edited, err)
+		}
+		if !edited.CreatedAt.Equal(testEpoch) {
+			t.Fatalf("UpdateChirp moved created_at to %v", edited.CreatedAt)
+		}
+
+		if err := s.DeleteDB(alice.ID, first.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetChirpyFromID(first.ID); !errors.Is(err, ErrChirpNotFound) {
+			t.Fatalf("GetChirpyFromID of a deleted chirp: %v, want ErrChirpNotFound", err)
+		}
+		if err := s.DeleteDB(alice.ID, first.ID); !errors.Is(err, ErrChirpNotFound) {
+			t.Fatalf("deleting a chirp twice: %v, want ErrChirpNotFound", err)
+		}
+		deleted, err := s.DeletedChirpsBefore(clk.Now().Add(time.Second))
+		if err != nil || !slices.Equal(chirpIDs(deleted), []int{first.ID}) {
+			t.Fatalf("DeletedChirpsBefore = %v, %v, want the deleted chirp", chirpIDs(deleted), err)
+		}
+
+		if _, err := s.RestoreChirp(bob.ID, first.ID); !errors.Is(err, ErrNotChirpAuthor) {
+			t.Fatalf("RestoreChirp by another user: %v, want ErrNotChirpAuthor", err)
+		}
+		restored, err := s.RestoreChirp(alice.ID, first.ID)
+		if err != nil || restored.Body != "first, edited" || restored.DeletedAt != nil {
+			t.Fatalf("RestoreChirp = %+v, %v", restored, err)
+		}
+		got, err := s.GetChirpyFromID(first.ID)
+		if err != nil || got.Body != restored.Body {
+			t.Fatalf("GetChirpyFromID after restore = %+v, %v", got, err)
+		}
+	})
+}
+
+func TestStoreQueryChirps(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		alice := mustUser(t, s, "alice@example.com")
+		bob := mustUser(t, s, "bob@example.com")
+		bodies := []string{"Going home", "go team", "nothing here", "ago", "Bob says hi"}
+		for i, body := range bodies {
+			author := alice.ID
+			if i == len(bodies)-1 {
+				author = bob.ID
+			}
+			if _, err := s.CreateChirp(body, author, "en", "web"); err != nil {
+				t.Fatal(err)
+			}
+			clk.Advance(time.Hour)
+		}
+		if _, err := s.CreateChirp("guten tag", bob.ID, "de", "web"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteDB(alice.ID, 3); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name  string
+			q     ChirpQuery
+			ids   []int
+			total int
+		}{
+			{"everything", ChirpQuery{}, []int{1, 2, 4, 5, 6}, 5},
+			{"author", ChirpQuery{AuthorIDs: []int{bob.ID}}, []int{5, 6}, 2},
+			{"hidden author", ChirpQuery{HiddenIDs: []int{bob.ID}}, []int{1, 2, 4}, 3},
+			{"hidden text", ChirpQuery{Text: "o", HiddenIDs: []int{alice.ID}}, []int{5}, 1},
+			{"author and hidden", ChirpQuery{AuthorIDs: []int{alice.ID, bob.ID}, HiddenIDs: []int{alice.ID}, Desc: true}, []int{6, 5}, 2},
+			{"text ignores case", ChirpQuery{Text: "GO"}, []int{1, 2, 4}, 3},
+			{"text and author", ChirpQuery{Text: "go", AuthorIDs: []int{bob.ID}}, []int{}, 0},
+			{"lang", ChirpQuery{Lang: "de"}, []int{6}, 1},
+			{"since_id", ChirpQuery{SinceID: 4}, []int{5, 6}, 2},
+			{"since and before", ChirpQuery{Since: testEpoch.Add(time.Hour), Before: testEpoch.Add(4 * time.Hour)}, []int{2, 4}, 2},
+			{"desc", ChirpQuery{Desc: true}, []int{6, 5, 4, 2, 1}, 5},
+			{"page", ChirpQuery{Limit: 2, Offset: 1}, []int{2, 4}, 5},
+			{"page desc", ChirpQuery{Desc: true, Limit: 2, Offset: 1}, []int{5, 4}, 5},
+			{"past the end", ChirpQuery{Limit: 2, Offset: 10}, []int{}, 5},
+		}
+		for _, tt := range tests {
+			chirps, total, err := s.QueryChirps(tt.q)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if !slices.Equal(chirpIDs(chirps), tt.ids) || total != tt.total {
+				t.Errorf("%s: got %v of %d, want %v of %d", tt.name, chirpIDs(chirps), total, tt.ids, tt.total)
+			}
+		}
+	})
+}
+
+func TestStoreQueryHashtags(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		alice := mustUser(t, s, "alice@example.com")
+		bob := mustUser(t, s, "bob@example.com")
+		carol := mustUser(t, s, "carol@example.com")
+		mustChirp(t, s, alice.ID, "no tags")                    // 1
+		mustChirp(t, s, bob.ID, "learning #GoLang today")       // 2
+		mustChirp(t, s, bob.ID, "#golangs is another tag")      // 3
+		mustChirp(t, s, carol.ID, "#rust and #golang")          // 4
+		mustChirp(t, s, carol.ID, "no tags but a # on its own") // 5
+		mustChirp(t, s, carol.ID, "mail me at me#golang")       // 6
+
+		tests := []struct {
+			name  string
+			q     ChirpQuery
+			ids   []int
+			total int
+		}{
+			{"author or tag", ChirpQuery{AuthorIDs: []int{alice.ID}, Hashtags: []string{"golang"}}, []int{1, 2, 4}, 3},
+			{"tag only", ChirpQuery{AuthorIDs: []int{}, Hashtags: []string{"rust", "golangs"}}, []int{3, 4}, 2},
+			{"no authors no tags", ChirpQuery{AuthorIDs: []int{}}, []int{}, 0},
+			{"tags without an author filter", ChirpQuery{Hashtags: []string{"rust"}}, []int{1, 2, 3, 4, 5, 6}, 6},
+			{"hidden wins over a tag", ChirpQuery{AuthorIDs: []int{}, Hashtags: []string{"golang"}, HiddenIDs: []int{carol.ID}}, []int{2}, 1},
+			{"page desc", ChirpQuery{AuthorIDs: []int{alice.ID}, Hashtags: []string{"golang"}, Desc: true, Limit: 1, Offset: 1}, []int{2}, 3},
+			{"by activity", ChirpQuery{AuthorIDs: []int{alice.ID}, Hashtags: []string{"golang"}, ByActivity: true}, []int{4, 2, 1}, 3},
+		}
+		for _, tt := range tests {
+			chirps, total, err := s.QueryChirps(tt.q)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if !slices.Equal(chirpIDs(chirps), tt.ids) || total != tt.total {
+				t.Errorf("%s: got %v of %d, want %v of %d", tt.name, chirpIDs(chirps), total, tt.ids, tt.total)
+			}
+		}
+	})
+}
+
+func TestStoreChirpOrdering(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		alice := mustUser(t, s, "alice@example.com")
+		bob := mustUser(t, s, "bob@example.com")
+		for _, body := range []string{"old", "middle", "new"} {
+			mustChirp(t, s, alice.ID, body)
+			clk.Advance(time.Hour)
+		}
+		order := func(q ChirpQuery) []int {
+			t.Helper()
+			chirps, _, err := s.QueryChirps(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return chirpIDs(chirps)
+		}
+		// edits never move a chirp, in any order or time window
+		if _, err := s.UpdateChirp(alice.ID, 1, "old, edited"); err != nil {
+			t.Fatal(err)
+		}
+		unmoved := []struct {
+			name string
+			q    ChirpQuery
+			ids  []int
+		}{
+			{"asc after an edit", ChirpQuery{}, []int{1, 2, 3}},
+			{"desc after an edit", ChirpQuery{Desc: true}, []int{3, 2, 1}},
+			{"since after an edit", ChirpQuery{Since: testEpoch.Add(time.Hour)}, []int{2, 3}},
+			{"before after an edit", ChirpQuery{Before: testEpoch.Add(time.Hour)}, []int{1}},
+			{"activity after an edit", ChirpQuery{ByActivity: true}, []int{3, 2, 1}},
+		}
+		for _, tt := range unmoved {
+			if got := order(tt.q); !slices.Equal(got, tt.ids) {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.ids)
+			}
+		}
+
+		bumped, err := s.BumpChirp(alice.ID, 1, 7*24*time.Hour)
+		if err != nil || bumped.BumpedAt == nil || !bumped.BumpedAt.Equal(clk.Now()) || !bumped.CreatedAt.Equal(testEpoch) {
+			t.Fatalf("BumpChirp = %+v, %v", bumped, err)
+		}
+		if got := order(ChirpQuery{ByActivity: true}); !slices.Equal(got, []int{1, 3, 2}) {
+			t.Fatalf("activity after a bump = %v, want the bumped chirp first", got)
+		}
+		if got := order(ChirpQuery{Desc: true}); !slices.Equal(got, []int{3, 2, 1}) {
+			t.Fatalf("desc after a bump = %v, want it unchanged", got)
+		}
+		// editing a bumped chirp keeps its place in the feed
+		clk.Advance(time.Hour)
+		if _, err := s.UpdateChirp(alice.ID, 3, "new, edited"); err != nil {
+			t.Fatal(err)
+		}
+		if got := order(ChirpQuery{ByActivity: true}); !slices.Equal(got, []int{1, 3, 2}) {
+			t.Fatalf("activity after editing = %v, want it unchanged", got)
+		}
+		page, total, err := s.QueryChirps(ChirpQuery{ByActivity: true, Limit: 1, Offset: 1})
+		if err != nil || !slices.Equal(chirpIDs(page), []int{3}) || total != 3 {
+			t.Fatalf("activity page = %v of %d, %v, want [3] of 3", chirpIDs(page), total, err)
+		}
+		if got := order(ChirpQuery{ByActivity: true, AuthorIDs: []int{bob.ID}}); len(got) != 0 {
+			t.Fatalf("activity of bob = %v, want none", got)
+		}
+
+		if _, err := s.BumpChirp(alice.ID, 1, 7*24*time.Hour); !errors.Is(err, ErrBumpTooSoon) {
+			t.Fatalf("bumping again right away: %v, want ErrBumpTooSoon", err)
+		}
+		if _, err := s.BumpChirp(bob.ID, 2, 7*24*time.Hour); !errors.Is(err, ErrNotChirpAuthor) {
+			t.Fatalf("BumpChirp by another user: %v, want ErrNotChirpAuthor", err)
+		}
+		if _, err := s.BumpChirp(alice.ID, 99, 7*24*time.Hour); !errors.Is(err, ErrChirpNotFound) {
+			t.Fatalf("BumpChirp of a missing chirp: %v, want ErrChirpNotFound", err)
+		}
+		if err := s.DeleteDB(alice.ID, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.BumpChirp(alice.ID, 2, 7*24*time.Hour); !errors.Is(err, ErrChirpNotFound) {
+			t.Fatalf("BumpChirp of a deleted chirp: %v, want ErrChirpNotFound", err)
+		}
+		clk.Advance(7 * 24 * time.Hour)
+		if _, err := s.BumpChirp(alice.ID, 3, 7*24*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if got := order(ChirpQuery{ByActivity: true}); !slices.Equal(got, []int{3, 1}) {
+			t.Fatalf("activity after a second bump = %v, want [3 1]", got)
+		}
+		if _, err := s.BumpChirp(alice.ID, 1, 7*24*time.Hour); err != nil {
+			t.Fatalf("bumping after the cooldown: %v", err)
+		}
+	})
+}
+
+func TestStoreRefreshTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		user := mustUser(t, s, "alice@example.com")
+		expiresAt := testEpoch.Add(24 * time.Hour)
+		if err := s.StoreToken(user.ID, "token-1", "family", expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := s.GetUserByID(user.ID)
+		if got.RefreshToken != "token-1" || got.RefreshTokenFamily != "family" || !got.RefreshTokenExpiresAt.Equal(expiresAt) {
+			t.Fatalf("stored token = %q %q %v", got.RefreshToken, got.RefreshTokenFamily, got.RefreshTokenExpiresAt)
+		}
+
+		if err := s.RotateToken(user.ID, "token-1", "family", "token-2"); err != nil {
+			t.Fatal(err)
+		}
+		got, _ = s.GetUserByID(user.ID)
+		if got.RefreshToken != "token-2" || !got.RefreshTokenExpiresAt.Equal(expiresAt) {
+			t.Fatalf("rotated token = %q expiring %v, want token-2 keeping the expiry", got.RefreshToken, got.RefreshTokenExpiresAt)
+		}
+
+		if err := s.RotateToken(user.ID, "someone else's", "other family", "x"); !errors.Is(err, ErrRefreshTokenInvalid) {
+			t.Fatalf("rotating an unknown token: %v, want ErrRefreshTokenInvalid", err)
+		}
+		if err := s.RotateToken(99, "token-2", "family", "x"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("rotating for a missing user: %v, want ErrUserNotFound", err)
+		}
+
+		// token-1 was rotated out, presenting it again revokes the session
+		if err := s.RotateToken(user.ID, "token-1", "family", "token-3"); !errors.Is(err, ErrRefreshTokenReused) {
+			t.Fatalf("reusing a rotated token: %v, want ErrRefreshTokenReused", err)
+		}
+		if err := s.RotateToken(user.ID, "token-2", "family", "token-3"); !errors.Is(err, ErrRefreshTokenInvalid) {
+			t.Fatalf("the session's current token after a reuse: %v, want ErrRefreshTokenInvalid", err)
+		}
+
+		if err := s.StoreToken(user.ID, "token-4", "new family", expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RevokeToken(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		got, _ = s.GetUserByID(user.ID)
+		if got.RefreshToken != "" || got.RefreshTokenExpiresAt != nil {
+			t.Fatalf("RevokeToken left %q expiring %v", got.RefreshToken, got.RefreshTokenExpiresAt)
+		}
+	})
+}
+
+func TestStoreLinksAndIdentities(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		alice := mustUser(t, s, "alice@example.com")
+		bob := mustUser(t, s, "bob@example.com")
+		if err := s.LinkUsers(alice.ID, bob.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.LinkUsers(alice.ID, alice.ID); err == nil {
+			t.Fatal("linking an account to itself succeeded")
+		}
+		for _, pair := range [][2]int{{alice.ID, bob.ID}, {bob.ID, alice.ID}} {
+			user, _ := s.GetUserByID(pair[0])
+			if !slices.Equal(user.LinkedAccounts, []int{pair[1]}) {
+				t.Fatalf("user %d links %v, want [%d]", pair[0], user.LinkedAccounts, pair[1])
+			}
+		}
+
+		if err := s.LinkIdentity(alice.ID, "github", "42"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.LinkIdentity(bob.ID, "github", "42"); !errors.Is(err, ErrIdentityTaken) {
+			t.Fatalf("linking a taken identity: %v, want ErrIdentityTaken", err)
+		}
+		got, err := s.GetUserByIdentity("github", "42")
+		if err != nil || got.ID != alice.ID {
+			t.Fatalf("GetUserByIdentity = %+v, %v, want alice", got, err)
+		}
+
+		if err := s.DeleteUser(alice.ID, DeleteAuthorChirps); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetUserByIdentity("github", "42"); err == nil {
+			t.Fatal("the identity of a deleted user still resolves")
+		}
+		got, _ = s.GetUserByID(bob.ID)
+		if len(got.LinkedAccounts) != 0 {
+			t.Fatalf("bob still links %v after alice was deleted", got.LinkedAccounts)
+		}
+		if err := s.DeleteUser(alice.ID, DeleteAuthorChirps); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("deleting a user twice: %v, want ErrUserNotFound", err)
+		}
+	})
+}
+
+func TestStoreDeleteUserChirps(t *testing.T) {
+	for name, mode := range map[string]AuthorChirps{"delete": DeleteAuthorChirps, "anonymize": AnonymizeAuthorChirps} {
+		t.Run(name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+				alice := mustUser(t, s, "alice@example.com")
+				bob := mustUser(t, s, "bob@example.com")
+				mine := mustChirp(t, s, alice.ID, "mine")
+				theirs := mustChirp(t, s, bob.ID, "theirs")
+
+				if err := s.DeleteUser(alice.ID, mode); err != nil {
+					t.Fatal(err)
+				}
+				chirps, err := s.GetChirps()
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := []int{theirs.ID}
+				if mode == AnonymizeAuthorChirps {
+					want = []int{mine.ID, theirs.ID}
+				}
+				if !slices.Equal(chirpIDs(chirps), want) {
+					t.Fatalf("chirps left = %v, want %v", chirpIDs(chirps), want)
+				}
+				if mode == AnonymizeAuthorChirps && chirps[0].AuthorID != 0 {
+					t.Fatalf("anonymized chirp has author %d, want 0", chirps[0].AuthorID)
+				}
+			})
+		})
+	}
+}
+
+func TestStoreEvents(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		rec := recordEvents(s)
+		user := mustUser(t, s, "alice@example.com")
+		chirp := mustChirp(t, s, user.ID, "hello")
+		if _, err := s.UpdateChirp(user.ID, chirp.ID, "hello again"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteDB(user.ID, chirp.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.RestoreChirp(user.ID, chirp.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.UpgradeUser(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		// a failed write publishes nothing
+		if err := s.DeleteDB(user.ID, 99); err == nil {
+			t.Fatal("deleting a missing chirp succeeded")
+		}
+		if _, err := s.UpdateUserDB(99, "carol@example.com", []byte("x"), nil); err == nil {
+			t.Fatal("updating a missing user succeeded")
+		}
+		if err := s.DeleteUser(user.ID, DeleteAuthorChirps); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []EventType{UserCreated, ChirpCreated, ChirpUpdated, ChirpDeleted, ChirpRestored, UserUpgraded, ChirpDeleted, UserDeleted}
+		if got := rec.wait(t, len(want)); !slices.Equal(got, want) {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestStoreChirpRevision(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		rev := func() ChirpRevision {
+			t.Helper()
+			r, err := s.ChirpRevision()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return r
+		}
+		if r := rev(); r.Revision != 0 || r.ModifiedAt != nil {
+			t.Fatalf("revision of a new store = %+v, want 0 and never modified", r)
+		}
+
+		user := mustUser(t, s, "alice@example.com")
+		if r := rev(); r.Revision != 0 {
+			t.Fatalf("creating a user moved the chirp revision to %d", r.Revision)
+		}
+
+		clk.Advance(time.Minute)
+		chirp := mustChirp(t, s, user.ID, "hello")
+		r := rev()
+		if r.Revision != 1 || r.ModifiedAt == nil || !r.ModifiedAt.Equal(clk.Now()) {
+			t.Fatalf("revision after a chirp = %+v, want 1 at %v", r, clk.Now())
+		}
+
+		seen := map[int]bool{r.Revision: true}
+		writes := []func() error{
+			func() error { _, err := s.UpdateChirp(user.ID, chirp.ID, "edited"); return err },
+			func() error { return s.DeleteDB(user.ID, chirp.ID) },
+			func() error { _, err := s.RestoreChirp(user.ID, chirp.ID); return err },
+			func() error { _, err := s.ResetChirps(); return err },
+			func() error { _, err := s.CreateChirp("after the reset", user.ID, "en", "web"); return err },
+		}
+		for i, write := range writes {
+			if err := write(); err != nil {
+				t.Fatalf("write %d: %v", i, err)
+			}
+			r := rev()
+			if seen[r.Revision] {
+				t.Fatalf("write %d reused revision %d", i, r.Revision)
+			}
+			seen[r.Revision] = true
+		}
+	})
+}
+
+func TestStoreResets(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		alice := mustUser(t, s, "alice@example.com")
+		mustUser(t, s, "bob@example.com")
+		mustChirp(t, s, alice.ID, "one")
+		mustChirp(t, s, alice.ID, "two")
+
+		if n, err := s.ResetChirps(); err != nil || n != 2 {
+			t.Fatalf("ResetChirps = %d, %v, want 2", n, err)
+		}
+		if n, err := s.ResetUsers(); err != nil || n != 2 {
+			t.Fatalf("ResetUsers = %d, %v, want 2", n, err)
+		}
+		users, err := s.GetUser()
+		if err != nil || len(users) != 0 {
+			t.Fatalf("users after ResetUsers = %v, %v", users, err)
+		}
+		if user := mustUser(t, s, "alice@example.com"); user.ID != 1 {
+			t.Fatalf("user created after a reset got ID %d, want 1", user.ID)
+		}
+	})
+}
+
+func TestStoreSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		user := mustUser(t, s, "alice@example.com")
+		expiresAt := testEpoch.Add(24 * time.Hour)
+		if err := s.StoreToken(user.ID, "login-1", "login", expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddSession(user.ID, "other-1", "other", expiresAt); err != nil {
+			t.Fatal(err)
+		}
+
+		// the sessions rotate independently
+		if err := s.RotateToken(user.ID, "other-1", "other", "other-2"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RotateToken(user.ID, "login-1", "login", "login-2"); err != nil {
+			t.Fatal(err)
+		}
+
+		// reusing a token of one session revokes only that session
+		if err := s.RotateToken(user.ID, "other-1", "other", "x"); !errors.Is(err, ErrRefreshTokenReused) {
+			t.Fatalf("reusing a rotated token: %v, want ErrRefreshTokenReused", err)
+		}
+		if err := s.RotateToken(user.ID, "other-2", "other", "x"); !errors.Is(err, ErrRefreshTokenInvalid) {
+			t.Fatalf("the revoked session's current token: %v, want ErrRefreshTokenInvalid", err)
+		}
+		got, _ := s.GetUserByID(user.ID)
+		if got.RefreshToken != "login-2" || len(got.Sessions) != 0 {
+			t.Fatalf("after the reuse login = %q, sessions = %+v", got.RefreshToken, got.Sessions)
+		}
+
+		if err := s.AddSession(user.ID, "other-3", "third", expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RevokeSession(user.ID, "login"); err != nil {
+			t.Fatal(err)
+		}
+		got, _ = s.GetUserByID(user.ID)
+		if got.RefreshToken != "" || len(got.Sessions) != 1 || got.Sessions[0].Token != "other-3" {
+			t.Fatalf("after RevokeSession login = %q, sessions = %+v", got.RefreshToken, got.Sessions)
+		}
+
+		// opening past maxSessions drops the oldest, and expired ones go first
+		clk.Advance(25 * time.Hour)
+		for i := range maxSessions + 2 {
+			family := "new-" + string(rune('a'+i))
+			if err := s.AddSession(user.ID, family+"-token", family, clk.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, _ = s.GetUserByID(user.ID)
+		if len(got.Sessions) != maxSessions || got.Sessions[0].Family != "new-c" {
+			t.Fatalf("sessions = %d starting with %q, want %d starting with new-c", len(got.Sessions), got.Sessions[0].Family, maxSessions)
+		}
+
+		if err := s.RevokeToken(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		got, _ = s.GetUserByID(user.ID)
+		if len(got.Sessions) != 0 {
+			t.Fatalf("RevokeToken left %d sessions", len(got.Sessions))
+		}
+		if err := s.AddSession(99, "x", "x", expiresAt); err != nil {
+			t.Fatalf("AddSession for a missing user: %v", err)
+		}
+	})
+}
+
+func TestStoreEventsSync(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, clk *clock.Fake) {
+		var got []EventType // no lock: handlers run inside the writes of this goroutine
+		bus := NewBus()
+		bus.SubscribeSync(func(e Event) { got = append(got, e.Type) })
+		s.SetEventBus(bus)
+
+		// every event is handled by the time the write returns
+		user := mustUser(t, s, "alice@example.com")
+		if !slices.Equal(got, []EventType{UserCreated}) {
+			t.Fatalf("events after CreateUser = %v", got)
+		}
+		chirp := mustChirp(t, s, user.ID, "hello")
+		if _, err := s.UpdateChirp(user.ID, chirp.ID, "hello again"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteDB(user.ID, chirp.ID); err != nil {
+			t.Fatal(err)
+		}
+		if want := []EventType{UserCreated, ChirpCreated, ChirpUpdated, ChirpDeleted}; !slices.Equal(got, want) {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+	})
+}
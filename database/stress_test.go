@@ -0,0 +1,243 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	stressWorkers  = 200
+	stressDuration = 2 * time.Second
+	stressShared   = 10 // emails every worker tries to sign up with
+)
+
+// stressAccount is a user a worker created and what it expects of it
+type stressAccount struct {
+	user    User
+	token   string
+	deleted bool
+	chirps  map[int]string // body by ID of the chirps it expects to be live
+	removed []int          // chirps it deleted
+}
+
+// stressWorker runs mixed operations against db until stop is closed. it
+// only changes its own accounts, so what it was told succeeded is what it
+// expects to find afterwards.
+type stressWorker struct {
+	id       int
+	db       *DB
+	rng      *rand.Rand
+	accounts []*stressAccount
+	shared   []atomic.Int64 // successful signups per shared email
+	ops      int
+}
+
+func (w *stressWorker) run(t *testing.T, stop <-chan struct{}) {
+	for n := 0; ; n++ {
+		select {
+		case <-stop:
+			w.ops = n
+			return
+		default:
+		}
+		if err := w.step(n); err != nil {
+			t.Errorf("worker %d: %v", w.id, err)
+			return
+		}
+	}
+}
+
+// live returns a random account that isn't deleted, nil when there is none
+func (w *stressWorker) live() *stressAccount {
+	var live []*stressAccount
+	for _, account := range w.accounts {
+		if !account.deleted {
+			live = append(live, account)
+		}
+	}
+	if len(live) == 0 {
+		return nil
+	}
+	return live[w.rng.Intn(len(live))]
+}
+
+func (w *stressWorker) step(n int) error {
+	account := w.live()
+	op := w.rng.Intn(10)
+	if account == nil {
+		op = 0
+	}
+	switch op {
+	case 0: // signup
+		email := fmt.Sprintf("worker-%d-%d@example.com", w.id, n)
+		user, err := w.db.CreateUser(email, []byte("hash"))
+		if err != nil {
+			return fmt.Errorf("signup: %w", err)
+		}
+		w.accounts = append(w.accounts, &stressAccount{user: user, chirps: make(map[int]string)})
+	case 1: // signup with an email every worker wants
+		i := w.rng.Intn(stressShared)
+		_, err := w.db.CreateUser(fmt.Sprintf("Shared-%d@example.com", i), []byte("hash"))
+		if err == nil {
+			w.shared[i].Add(1)
+		} else if !errors.Is(err, ErrUserExists) {
+			return fmt.Errorf("shared signup: %w", err)
+		}
+	case 2: // login
+		user, err := w.db.GetUserByEmail(account.user.Email)
+		if err != nil {
+			return fmt.Errorf("login of %d: %w", account.user.ID, err)
+		}
+		account.token = fmt.Sprintf("token-%d-%d", w.id, n)
+		err = w.db.StoreToken(user.ID, account.token, "family", time.Now().Add(time.Hour))
+		if err != nil {
+			return fmt.Errorf("storing the token of %d: %w", user.ID, err)
+		}
+	case 3: // refresh
+		if account.token == "" {
+			return nil
+		}
+		next := fmt.Sprintf("token-%d-%d", w.id, n)
+		if err := w.db.RotateToken(account.user.ID, account.token, "family", next); err != nil {
+			return fmt.Errorf("refresh of %d: %w", account.user.ID, err)
+		}
+		account.token = next
+	case 4, 5, 6: // post
+		body := fmt.Sprintf("chirp %d of worker %d", n, w.id)
+		chirp, err := w.db.CreateChirp(body, account.user.ID, "en", "web")
+		if err != nil {
+			return fmt.Errorf("post of %d: %w", account.user.ID, err)
+		}
+		account.chirps[chirp.ID] = body
+	case 7: // delete a chirp
+		for id := range account.chirps {
+			if err := w.db.DeleteDB(account.user.ID, id); err != nil {
+				return fmt.Errorf("deleting chirp %d: %w", id, err)
+			}
+			delete(account.chirps, id)
+			account.removed = append(account.removed, id)
+			break
+		}
+	case 8: // link two accounts of the worker
+		other := w.live()
+		if other == account {
+			return nil
+		}
+		if err := w.db.LinkUsers(account.user.ID, other.user.ID); err != nil {
+			return fmt.Errorf("linking %d and %d: %w", account.user.ID, other.user.ID, err)
+		}
+	case 9: // delete the account, now and then
+		if w.rng.Intn(4) != 0 {
+			return nil
+		}
+		if err := w.db.DeleteUser(account.user.ID, DeleteAuthorChirps); err != nil {
+			return fmt.Errorf("deleting user %d: %w", account.user.ID, err)
+		}
+		for id := range account.chirps {
+			account.removed = append(account.removed, id)
+		}
+		account.chirps = nil
+		account.deleted = true
+		// a request holding a token of the account can still arrive
+		if _, err := w.db.CreateChirp("too late", account.user.ID, "en", "web"); !errors.Is(err, ErrUserNotFound) {
+			return fmt.Errorf("post of deleted user %d: %v, want ErrUserNotFound", account.user.ID, err)
+		}
+		if len(account.removed) > 0 {
+			if _, err := w.db.RestoreChirp(account.user.ID, account.removed[0]); !errors.Is(err, ErrUserNotFound) {
+				return fmt.Errorf("restoring a chirp of deleted user %d: %v, want ErrUserNotFound", account.user.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// check fails the test for every acknowledged write db doesn't have
+func (w *stressWorker) check(t *testing.T, db *DB) {
+	t.Helper()
+	for _, account := range w.accounts {
+		user, err := db.GetUserByID(account.user.ID)
+		if account.deleted {
+			if !errors.Is(err, ErrUserNotFound) {
+				t.Errorf("deleted user %d: %v, want ErrUserNotFound", account.user.ID, err)
+			}
+		} else if err != nil || user.Email != account.user.Email {
+			t.Errorf("user %d = %+v, %v, want %s", account.user.ID, user, err, account.user.Email)
+		} else if account.token != "" && user.RefreshToken != account.token {
+			t.Errorf("refresh token of %d = %q, want %q", user.ID, user.RefreshToken, account.token)
+		}
+		for id, body := range account.chirps {
+			chirp, err := db.GetChirpyFromID(id)
+			if err != nil || chirp.Body != body || chirp.AuthorID != account.user.ID {
+				t.Errorf("chirp %d = %+v, %v, want %q by %d", id, chirp, err, body, account.user.ID)
+			}
+		}
+		for _, id := range account.removed {
+			if _, err := db.GetChirpyFromID(id); !errors.Is(err, ErrChirpNotFound) {
+				t.Errorf("deleted chirp %d: %v, want ErrChirpNotFound", id, err)
+			}
+		}
+	}
+}
+
+func TestStressFileStore(t *testing.T) {
+	if testing.Short() {
+		t.Skip("stress test")
+	}
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetIndent("")
+
+	shared := make([]atomic.Int64, stressShared)
+	workers := make([]*stressWorker, stressWorkers)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range workers {
+		workers[i] = &stressWorker{id: i, db: db, rng: rand.New(rand.NewSource(int64(i))), shared: shared}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers[i].run(t, stop)
+		}()
+	}
+	time.Sleep(stressDuration)
+	close(stop)
+	wg.Wait()
+
+	ops := 0
+	for _, w := range workers {
+		ops += w.ops
+	}
+	t.Logf("%d operations", ops)
+	for i := range shared {
+		if n := shared[i].Load(); n > 1 {
+			t.Errorf("Shared-%d@example.com was signed up %d times", i, n)
+		}
+	}
+
+	// what was acknowledged must be in memory and on disk
+	reopened, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, db := range map[string]*DB{"running": db, "reopened": reopened} {
+		problems, err := db.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, problem := range problems {
+			t.Errorf("%s: %s", name, problem)
+		}
+		for _, w := range workers {
+			w.check(t, db)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+const defaultChirpCacheSize = 1000
+
+// chirpCache is an LRU of rendered GET /api/chirps/{chirpID} responses.
+// it is invalidated synchronously from the database event bus, so every
+// write path is covered without the handlers knowing about the cache.
+type chirpCache struct {
+	mux   *sync.Mutex
+	size  int
+	order *list.List // front is the most recently used
+	items map[int]*list.Element
+	// gen goes up on every invalidation. a response rendered from a read
+	// that started before one is not cached, it may already be stale.
+	gen    uint64
+	hits   int
+	misses int
+}
+
+type chirpCacheEntry struct {
+	ID   int
+	resp []byte
+}
+
+// newChirpCache returns a cache holding up to size chirps, disabled when size is 0
+func newChirpCache(size int) *chirpCache {
+	return &chirpCache{
+		mux:   &sync.Mutex{},
+		size:  size,
+		order: list.New(),
+		items: make(map[int]*list.Element),
+	}
+}
+
+// chirpCacheSizeFromEnv reads CHIRP_CACHE_SIZE, falling back to defaultChirpCacheSize
+func chirpCacheSizeFromEnv(getenv func(string) string) (int, error) {
+	v := getenv("CHIRP_CACHE_SIZE")
+	if v == "" {
+		return defaultChirpCacheSize, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid CHIRP_CACHE_SIZE %q: must be 0 or more", v)
+	}
+	return n, nil
+}
+
+// get returns the cached response of chirp ID. on a miss it returns the
+// generation to hand to put once the response is rendered.
+func (c *chirpCache) get(ID int) ([]byte, uint64, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	el, ok := c.items[ID]
+	if !ok {
+		c.misses++
+		return nil, c.gen, false
+	}
+	c.hits++
+	c.order.MoveToFront(el)
+	return el.Value.(*chirpCacheEntry).resp, c.gen, true
+}
+
+// bypass counts a read the cache can't serve as a miss and returns the
+// generation to hand to put, like get on a miss
+func (c *chirpCache) bypass() uint64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.misses++
+	return c.gen
+}
+
+// put caches the response of chirp ID unless something was invalidated
+// since gen, evicting the least recently used chirp when full
+func (c *chirpCache) put(ID int, resp []byte, gen uint64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.size == 0 || gen != c.gen {
+		return
+	}
+	if el, ok := c.items[ID]; ok {
+		el.Value.(*chirpCacheEntry).resp = resp
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[ID] = c.order.PushFront(&chirpCacheEntry{ID: ID, resp: resp})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*chirpCacheEntry).ID)
+	}
+}
+
+// invalidate drops the cached chirp
+func (c *chirpCache) invalidate(ID int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.gen++
+	if el, ok := c.items[ID]; ok {
+		c.order.Remove(el)
+		delete(c.items, ID)
+	}
+}
+
+// clear drops every cached chirp
+func (c *chirpCache) clear() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.gen++
+	c.order.Init()
+	clear(c.items)
+}
+
+// writePrometheus writes the hit and miss counters and the current size
+func (c *chirpCache) writePrometheus(w io.Writer) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	fmt.Fprintln(w, "# HELP chirpy_chirp_cache_requests_total Single chirp reads by cache result.")
+	fmt.Fprintln(w, "# TYPE chirpy_chirp_cache_requests_total counter")
+	fmt.Fprintf(w, "chirpy_chirp_cache_requests_total{result=\"hit\"} %d\n", c.hits)
+	fmt.Fprintf(w, "chirpy_chirp_cache_requests_total{result=\"miss\"} %d\n", c.misses)
+	fmt.Fprintln(w, "# HELP chirpy_chirp_cache_entries Chirps in the read cache.")
+	fmt.Fprintln(w, "# TYPE chirpy_chirp_cache_entries gauge")
+	fmt.Fprintf(w, "chirpy_chirp_cache_entries %d\n", c.order.Len())
+}
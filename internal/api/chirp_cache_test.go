@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestChirpCacheLRU(t *testing.T) {
+	c := newChirpCache(2)
+	for _, ID := range []int{1, 2} {
+		_, gen, ok := c.get(ID)
+		if ok {
+			t.Fatalf("get(%d) hit an empty cache", ID)
+		}
+		c.put(ID, []byte{byte(ID)}, gen)
+	}
+	// 1 is used last, so 2 goes when 3 comes in
+	if resp, _, ok := c.get(1); !ok || !bytes.Equal(resp, []byte{1}) {
+		t.Fatalf("get(1) = %v, %v", resp, ok)
+	}
+	_, gen, _ := c.get(3)
+	c.put(3, []byte{3}, gen)
+	if _, _, ok := c.get(2); ok {
+		t.Fatal("get(2) hit after it was evicted")
+	}
+	if _, _, ok := c.get(3); !ok {
+		t.Fatal("get(3) missed")
+	}
+	if c.hits != 2 || c.misses != 4 || c.order.Len() != 2 {
+		t.Fatalf("hits %d, misses %d, entries %d, want 2, 4 and 2", c.hits, c.misses, c.order.Len())
+	}
+
+	// a response read before an invalidation is not cached
+	_, gen, _ = c.get(4)
+	c.invalidate(1)
+	c.put(4, []byte{4}, gen)
+	if _, _, ok := c.get(4); ok {
+		t.Fatal("a response rendered before an invalidation was cached")
+	}
+	if _, _, ok := c.get(1); ok {
+		t.Fatal("get(1) hit after it was invalidated")
+	}
+	c.clear()
+	if _, _, ok := c.get(3); ok || c.order.Len() != 0 {
+		t.Fatalf("entries after clear = %d", c.order.Len())
+	}
+
+	disabled := newChirpCache(0)
+	_, gen, _ = disabled.get(1)
+	disabled.put(1, []byte{1}, gen)
+	if _, _, ok := disabled.get(1); ok {
+		t.Fatal("a cache of size 0 cached a chirp")
+	}
+
+	var metrics strings.Builder
+	c.writePrometheus(&metrics)
+	for _, line := range []string{`chirpy_chirp_cache_requests_total{result="hit"} 2`, "chirpy_chirp_cache_entries 0"} {
+		if !strings.Contains(metrics.String(), line) {
+			t.Errorf("metrics miss %q:\n%s", line, metrics.String())
+		}
+	}
+}
+
+// storeReads returns how often the handlers read a chirp from the store
+func (ts *testServer) storeReads() int {
+	m := ts.api.dbMetrics
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if stats, ok := m.ops[[2]string{"database", "GetChirpyFromID"}]; ok {
+		return stats.count
+	}
+	return 0
+}
+
+// chirpNow reads GET /api/chirps/{ID} once and fails unless done accepts
+// it, writes invalidate the cache before they return
+func (ts *testServer) chirpNow(t *testing.T, ID int, done func(testResponse) bool) {
+	t.Helper()
+	if resp := ts.request(t, "GET", chirpPath(ID), nil); !done(resp) {
+		t.Fatalf("GET %s right after the write = %d %s", chirpPath(ID), resp.status, resp.body)
+	}
+}
+
+func TestChirpCacheInvalidation(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := start(t)
+			alice := ts.newUser(t, "alice@example.com")
+			chirp := ts.postChirp(t, alice.Token, "first draft")
+			hasBody := func(body string) func(testResponse) bool {
+				return func(resp testResponse) bool {
+					return resp.status == http.StatusOK && decode[apitypes.Chirp](t, resp).Body == body
+				}
+			}
+
+			// a hit doesn't read the store
+			ts.chirpNow(t, chirp.ID, hasBody("first draft"))
+			reads := ts.storeReads()
+			for range 3 {
+				ts.request(t, "GET", chirpPath(chirp.ID), nil).expect(t, http.StatusOK)
+			}
+			if got := ts.storeReads(); got != reads {
+				t.Fatalf("cache hits read the store %d times", got-reads)
+			}
+
+			// every write path drops the cached chirp
+			ts.request(t, "PUT", chirpPath(chirp.ID), apitypes.UpdateChirpRequest{Body: "second draft"}, bearer(alice.Token)...).expect(t, http.StatusOK)
+			ts.chirpNow(t, chirp.ID, hasBody("second draft"))
+
+			ts.request(t, "POST", chirpPath(chirp.ID)+"/bump", nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+			ts.chirpNow(t, chirp.ID, func(resp testResponse) bool {
+				return resp.status == http.StatusOK && decode[apitypes.Chirp](t, resp).BumpedAt != nil
+			})
+
+			deleted := decode[apitypes.DeleteChirpResponse](t, ts.request(t, "DELETE", chirpPath(chirp.ID), nil, bearer(alice.Token)...).expect(t, http.StatusOK))
+			ts.chirpNow(t, chirp.ID, func(resp testResponse) bool { return resp.status == http.StatusNotFound })
+
+			ts.request(t, "POST", chirpPath(chirp.ID)+"/undelete", map[string]string{"undo_token": deleted.UndoToken}, bearer(alice.Token)...).expect(t, http.StatusOK)
+			ts.chirpNow(t, chirp.ID, hasBody("second draft"))
+
+			ts.confirmed(t, "POST", "/admin/reset/chirps", nil, asAdmin()...).expect(t, http.StatusOK)
+			ts.chirpNow(t, chirp.ID, func(resp testResponse) bool { return resp.status == http.StatusNotFound })
+
+			metrics := string(ts.request(t, "GET", "/admin/metrics?format=prometheus", nil, asAdmin()...).expect(t, http.StatusOK).body)
+			if !strings.Contains(metrics, `chirpy_chirp_cache_requests_total{result="hit"}`) {
+				t.Fatalf("metrics miss the cache counters:\n%s", metrics)
+			}
+		})
+	}
+}
+
+func TestChirpCacheCountsServedHits(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	chirp := ts.postChirp(t, alice.Token, "hello")
+	counts := func() (int, int) {
+		c := ts.api.chirpCache
+		c.mux.Lock()
+		defer c.mux.Unlock()
+		return c.hits, c.misses
+	}
+
+	ts.request(t, "GET", chirpPath(chirp.ID), nil).expect(t, http.StatusOK)
+	ts.request(t, "GET", chirpPath(chirp.ID), nil).expect(t, http.StatusOK)
+	if hits, misses := counts(); hits != 1 || misses != 1 {
+		t.Fatalf("hits %d, misses %d, want 1 and 1", hits, misses)
+	}
+
+	// with a protected account around, anonymous reads skip the cache and
+	// are misses even though the chirp is cached
+	dave := ts.newUser(t, "dave@example.com")
+	ts.request(t, "PUT", "/api/users/me/privacy", apitypes.PrivacyRequest{Protected: true}, bearer(dave.Token)...).expect(t, http.StatusOK)
+	for range 2 {
+		ts.request(t, "GET", chirpPath(chirp.ID), nil).expect(t, http.StatusOK)
+	}
+	if hits, misses := counts(); hits != 1 || misses != 3 {
+		t.Fatalf("hits %d, misses %d after reads the cache didn't serve, want 1 and 3", hits, misses)
+	}
+}
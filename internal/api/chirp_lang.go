@@ -0,0 +1,48 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+const defaultChirpLang = "en"
+
+// chirpLangs is the allowlist of language tags a chirp can be tagged with,
+// keyed by lowercase tag and holding the canonical BCP-47 casing
+var chirpLangs = map[string]string{}
+
+func init() {
+	for _, tag := range []string{
+		"ar", "bn", "ca", "cs", "da", "de", "el", "en", "en-AU", "en-CA", "en-GB", "en-US",
+		"es", "es-419", "es-ES", "es-MX", "eu", "fa", "fi", "fr", "fr-CA", "fr-FR", "gl",
+		"he", "hi", "hu", "id", "it", "ja", "ko", "ms", "nb", "nl", "nn", "pl", "pt",
+		"pt-BR", "pt-PT", "ro", "ru", "sk", "sv", "sw", "ta", "th", "tl", "tr", "uk",
+		"ur", "vi", "zh", "zh-Hans", "zh-Hant", "zh-TW",
+	} {
+		chirpLangs[strings.ToLower(tag)] = tag
+	}
+}
+
+// parseChirpLang validates a language tag against the allowlist and
+// returns it in canonical casing
+func parseChirpLang(tag string) (string, error) {
+	canonical, ok := chirpLangs[strings.ToLower(strings.ReplaceAll(tag, "_", "-"))]
+	if !ok || tag == "" {
+		return "", fmt.Errorf("unsupported lang %q", tag)
+	}
+	return canonical, nil
+}
+
+// defaultLangFromEnv reads DEFAULT_LANG, the language of chirps created
+// without a lang, falling back to en
+func defaultLangFromEnv(getenv func(string) string) (string, error) {
+	v := getenv("DEFAULT_LANG")
+	if v == "" {
+		return defaultChirpLang, nil
+	}
+	lang, err := parseChirpLang(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid DEFAULT_LANG: %w", err)
+	}
+	return lang, nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestParseChirpLang(t *testing.T) {
+	for tag, want := range map[string]string{
+		"en": "en", "EN": "en", "en-gb": "en-GB", "en_GB": "en-GB", "zh-hant": "zh-Hant", "es-419": "es-419",
+	} {
+		if got, err := parseChirpLang(tag); err != nil || got != want {
+			t.Errorf("parseChirpLang(%q) = %q, %v, want %q", tag, got, err, want)
+		}
+	}
+	malformed := []string{
+		"", " ", "e", "english", "en-", "-en", "en--GB", "en GB", " en", "en-GB-oxendict", "x-klingon",
+		"i-default", "123", "en;q=0.9", "en,fr", "*", "<script>", "en\x00", strings.Repeat("en", 100),
+	}
+	for _, tag := range malformed {
+		if got, err := parseChirpLang(tag); err == nil || err.Error() != fmt.Sprintf("unsupported lang %q", tag) {
+			t.Errorf("parseChirpLang(%q) = %q, %v, want unsupported lang", tag, got, err)
+		}
+	}
+	if _, err := defaultLangFromEnv(func(string) string { return "english" }); err == nil {
+		t.Error("DEFAULT_LANG=english: no error")
+	}
+}
+
+func TestChirpLang(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("DEFAULT_LANG", "fr_ca")
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+
+	post := func(body, lang string) testResponse {
+		return ts.request(t, "POST", "/api/chirps", apitypes.CreateChirpRequest{Body: body, Lang: lang}, bearer(alice.Token)...)
+	}
+	for _, chirp := range [][2]string{{"hello", "en"}, {"cheerio", "EN-gb"}, {"bonjour", ""}, {"hola", "es"}} {
+		post(chirp[0], chirp[1]).expect(t, http.StatusCreated)
+	}
+	for _, lang := range []string{"english", "en-", "x-klingon", "en;q=0.9"} {
+		resp := post("tagged badly", lang).expect(t, http.StatusBadRequest)
+		if got := errorOf(t, resp); !strings.Contains(got, "unsupported lang") {
+			t.Fatalf("lang %q: %q", lang, got)
+		}
+	}
+
+	langs := func(query string) []string {
+		t.Helper()
+		page := decode[apitypes.ListEnvelope[apitypes.Chirp]](t, ts.request(t, "GET", "/api/chirps?envelope=true"+query, nil).expect(t, http.StatusOK))
+		var got []string
+		for _, chirp := range page.Items {
+			got = append(got, chirp.Body+":"+chirp.Lang)
+		}
+		return got
+	}
+	// tags are stored canonical, missing ones are the default
+	if got, want := langs(""), []string{"hello:en", "cheerio:en-GB", "bonjour:fr-CA", "hola:es"}; !slices.Equal(got, want) {
+		t.Fatalf("chirps = %q, want %q", got, want)
+	}
+	// a tag without a region matches its regional variants
+	for query, want := range map[string][]string{
+		"&lang=en":    {"hello:en", "cheerio:en-GB"},
+		"&lang=en-gb": {"cheerio:en-GB"},
+		"&lang=fr":    {"bonjour:fr-CA"},
+		"&lang=de":    nil,
+	} {
+		if got := langs(query); !slices.Equal(got, want) {
+			t.Errorf("chirps%s = %q, want %q", query, got, want)
+		}
+	}
+	for _, lang := range []string{"english", "en-", "*"} {
+		ts.request(t, "GET", "/api/chirps?lang="+lang, nil).expect(t, http.StatusBadRequest)
+	}
+}
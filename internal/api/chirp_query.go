@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// chirpQuery translates the query string of a chirp list endpoint into a
+// database.ChirpQuery. limit and offset are only read when paginate is set,
+// otherwise every match is returned.
+func (a *apiConfig) chirpQuery(r *http.Request, paginate bool) (database.ChirpQuery, error) {
+	params := r.URL.Query()
+	s := a.settings()
+	q := database.ChirpQuery{
+		DefaultLang: s.defaultLang,
+	}
+	var err error
+
+	switch params.Get("sort") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, errors.New("invalid sort, want asc or desc")
+	}
+
+	if v := params.Get("author_id"); v != "" {
+		authorID, err := strconv.Atoi(v)
+		if err != nil {
+			return q, errors.New("invalid author_id")
+		}
+		q.AuthorIDs = []int{authorID}
+	}
+	if v := params.Get("lang"); v != "" {
+		q.Lang, err = parseChirpLang(v)
+		if err != nil {
+			return q, err
+		}
+	}
+	// an empty q is no search
+	if v := params.Get("q"); v != "" {
+		if utf8.RuneCountInString(v) > maxChirpLength {
+			return q, fmt.Errorf("q must be at most %d characters", maxChirpLength)
+		}
+		q.Text = v
+	}
+	if v := params.Get("since_id"); v != "" {
+		q.SinceID, err = strconv.Atoi(v)
+		if err != nil {
+			return q, errors.New("invalid since_id")
+		}
+	}
+	if v := params.Get("since"); v != "" {
+		q.Since, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, errors.New("invalid since")
+		}
+	}
+	if v := params.Get("before"); v != "" {
+		q.Before, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, errors.New("invalid before")
+		}
+	}
+
+	if paginate {
+		// limit defaults to 50, or the cap if that is lower
+		q.Limit = min(defaultPageLimit, s.caps.maxLimit)
+		if v := params.Get("limit"); v != "" {
+			q.Limit, err = strconv.Atoi(v)
+			if err != nil || q.Limit <= 0 {
+				return q, errors.New("invalid limit")
+			}
+		}
+		if v := params.Get("offset"); v != "" {
+			q.Offset, err = strconv.Atoi(v)
+			if err != nil {
+				return q, errors.New("invalid offset")
+			}
+		}
+	}
+	return q, q.Validate(s.caps.maxLimit)
+}
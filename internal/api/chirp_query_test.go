@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+func TestChirpQueryTranslation(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	since := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	page := func(q database.ChirpQuery) database.ChirpQuery {
+		q.DefaultLang = defaultChirpLang
+		if q.Limit == 0 {
+			q.Limit = defaultPageLimit
+		}
+		return q
+	}
+	tests := []struct {
+		name     string
+		query    string
+		paginate bool
+		want     database.ChirpQuery
+		err      string // empty when the query string is valid
+	}{
+		{"nothing", "", false, database.ChirpQuery{DefaultLang: defaultChirpLang}, ""},
+		{"default page", "", true, page(database.ChirpQuery{}), ""},
+		{"page", "limit=10&offset=20", true, page(database.ChirpQuery{Limit: 10, Offset: 20}), ""},
+		{"page ignored without paginate", "limit=10&offset=20", false, database.ChirpQuery{DefaultLang: defaultChirpLang}, ""},
+		{"sort asc", "sort=asc", true, page(database.ChirpQuery{}), ""},
+		{"sort desc", "sort=desc", true, page(database.ChirpQuery{Desc: true}), ""},
+		{"author", "author_id=3", true, page(database.ChirpQuery{AuthorIDs: []int{3}}), ""},
+		{"lang in canonical casing", "lang=en_gb", true, page(database.ChirpQuery{Lang: "en-GB"}), ""},
+		{"text", "q=Go", true, page(database.ChirpQuery{Text: "Go"}), ""},
+		{"since_id", "since_id=7", true, page(database.ChirpQuery{SinceID: 7}), ""},
+		{"time window", "since=2024-03-01T00:00:00Z&before=2024-03-02T00:00:00Z", true,
+			page(database.ChirpQuery{Since: since, Before: since.Add(24 * time.Hour)}), ""},
+		{"invalid sort", "sort=up", true, database.ChirpQuery{}, "invalid sort, want asc or desc"},
+		{"invalid author", "author_id=me", true, database.ChirpQuery{}, "invalid author_id"},
+		{"invalid lang", "lang=klingon", true, database.ChirpQuery{}, `unsupported lang "klingon"`},
+		{"text too long", "q=" + strings.Repeat("a", maxChirpLength+1), true, database.ChirpQuery{}, "q must be at most 140 characters"},
+		{"invalid since_id", "since_id=x", true, database.ChirpQuery{}, "invalid since_id"},
+		{"negative since_id", "since_id=-1", true, database.ChirpQuery{}, "invalid since_id"},
+		{"invalid since", "since=yesterday", true, database.ChirpQuery{}, "invalid since"},
+		{"invalid before", "before=2024-03-01", true, database.ChirpQuery{}, "invalid before"},
+		{"empty window", "since=2024-03-01T00:00:00Z&before=2024-03-01T00:00:00Z", true, database.ChirpQuery{}, "since must be before before"},
+		{"zero limit", "limit=0", true, database.ChirpQuery{}, "invalid limit"},
+		{"limit over the cap", "limit=201", true, database.ChirpQuery{}, "limit must be at most 200"},
+		{"invalid offset", "offset=x", true, database.ChirpQuery{}, "invalid offset"},
+		{"negative offset", "offset=-1", true, database.ChirpQuery{}, "invalid offset"},
+	}
+	for _, tt := range tests {
+		q, err := ts.api.chirpQuery(httptest.NewRequest("GET", "/api/chirps?"+tt.query, nil), tt.paginate)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: chirpQuery error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(q, tt.want) {
+			t.Errorf("%s: chirpQuery = %+v, %v, want %+v", tt.name, q, err, tt.want)
+		}
+	}
+}
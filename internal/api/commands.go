@@ -0,0 +1,30 @@
+package api
+
+import (
+	"fmt"
+	"io"
+)
+
+// RunCommand runs the `chirpy <name>` subcommand with args. subcommands
+// work on the database files in the working directory and exit without
+// serving.
+func RunCommand(name string, args []string, out io.Writer) error {
+	switch name {
+	case "compact":
+		return runCompactCommand(args, out)
+	case "purge":
+		return runPurgeCommand(args, out)
+	case "inspect":
+		return runInspectCommand(args, out)
+	case "repair":
+		return runRepairCommand(args, out)
+	case "import-twitter":
+		return runImportTwitterCommand(args, out)
+	case "migrate-db":
+		return runMigrateDBCommand(args, out)
+	case "migrate":
+		return runMigrateCommand(args, out)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// how long a deleted chirp is kept before compaction drops it
+const defaultTombstoneRetentionDays = 30
+
+// compactor is the part of a database that compaction needs
+type compactor interface {
+	CompactChirps(cutoff time.Time) (database.CompactReport, error)
+	CompactUsers(expired func(token string) bool) (database.CompactReport, error)
+}
+
+// compactResult is what both the cli and the endpoint report
+type compactResult struct {
+	Files     []database.CompactReport `json:"files"`
+	Reclaimed int                      `json:"bytes_reclaimed"`
+}
+
+// compactDatabases compacts the chirps and the users, dropping tombstones
+// older than retention and refresh tokens that have expired by now. they
+// share a file, which is rewritten once for each.
+func compactDatabases(db compactor, retention time.Duration, now time.Time) (compactResult, error) {
+	result := compactResult{Files: []database.CompactReport{}}
+
+	report, err := db.CompactChirps(now.Add(-retention))
+	if err != nil {
+		return result, err
+	}
+	result.Files = append(result.Files, report)
+	result.Reclaimed += report.Reclaimed
+
+	report, err = db.CompactUsers(func(token string) bool { return refreshTokenExpired(token, now) })
+	if err != nil {
+		return result, err
+	}
+	result.Files = append(result.Files, report)
+	result.Reclaimed += report.Reclaimed
+
+	return result, nil
+}
+
+// refreshTokenExpired reports whether a stored refresh token is past its expiry at now.
+// the signature isn't checked so a missing or rotated secret can't wipe sessions.
+func refreshTokenExpired(token string, now time.Time) bool {
+	claims := &CustomClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	if err != nil || claims.ExpiresAt == nil {
+		return false
+	}
+	return claims.ExpiresAt.Before(now)
+}
+
+// runCompactCommand implements `chirpy compact` against the database files in the working directory
+func runCompactCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("compact", flag.ContinueOnError)
+	retentionDays := fs.Int("retention-days", defaultTombstoneRetentionDays, "Drop deleted chirps older than this many days")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := OpenStore(".")
+	if err != nil {
+		return err
+	}
+
+	result, err := compactDatabases(db, time.Duration(*retentionDays)*24*time.Hour, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, report := range result.Files {
+		if !report.Compacted {
+			fmt.Fprintf(out, "%s: nothing to reclaim (%d bytes)\n", report.File, report.BytesBefore)
+			continue
+		}
+		fmt.Fprintf(out, "%s: removed %d records, %d -> %d bytes\n", report.File, report.Removed, report.BytesBefore, report.BytesAfter)
+	}
+	fmt.Fprintf(out, "reclaimed %d bytes\n", result.Reclaimed)
+	return nil
+}
+
+// POST /admin/compact
+// compact rewrites the database files without taking the server down.
+// ?retention_days overrides TOMBSTONE_RETENTION_DAYS for this run.
+func (a *apiConfig) compact(w http.ResponseWriter, r *http.Request) {
+	retention := a.settings().retention
+	if v := r.URL.Query().Get("retention_days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			respondWithError(w, http.StatusBadRequest, "invalid retention_days")
+			return
+		}
+		retention = time.Duration(days) * 24 * time.Hour
+	}
+
+	result, err := compactDatabases(a.db, retention, a.clock.Now())
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	resp, err := json.Marshal(result)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
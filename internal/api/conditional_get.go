@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chirpValidators sets the ETag and Last-Modified of the current chirp
+// revision. it reports true when it answered the request itself: 304 when
+// the client's copy is still current, that is If-None-Match lists the ETag
+// or, without If-None-Match, If-Modified-Since is no earlier than the last
+// change, or 500. callers read the revision before the chirps, so an ETag is
+// never newer than the body it goes with. when protected users make the
+// response depend on the viewer, the ETag tells apart what vis hides and
+// Last-Modified is left out, following someone doesn't change it.
+func (a *apiConfig) chirpValidators(w http.ResponseWriter, r *http.Request, vis chirpVisibility) bool {
+	rev, err := a.chirpyDatabase.ChirpRevision()
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return true
+	}
+	etag := strconv.Quote(strconv.Itoa(rev.Revision) + vis.etagSuffix())
+	w.Header().Set("ETag", etag)
+	if vis.varies {
+		w.Header().Add("Vary", "Authorization")
+		rev.ModifiedAt = nil
+	}
+	if rev.ModifiedAt != nil {
+		w.Header().Set("Last-Modified", rev.ModifiedAt.UTC().Format(http.TimeFormat))
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagMatches(inm, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && rev.ModifiedAt != nil {
+		// Last-Modified has whole seconds, compare at that precision
+		since, err := http.ParseTime(ims)
+		if err == nil && !rev.ModifiedAt.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches reports whether the If-None-Match value inm lists etag, weak
+// or not, or is *
+func etagMatches(inm, etag string) bool {
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
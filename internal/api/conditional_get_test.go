@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestConditionalGetChirps(t *testing.T) {
+	setTestEnv(t)
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := start(t)
+			alice := ts.newUser(t, "alice@example.com")
+			chirp := ts.postChirp(t, alice.Token, "first")
+			paths := []string{"/api/chirps", chirpPath(chirp.ID)}
+
+			// validators fetches path and returns its ETag and Last-Modified
+			validators := func(path string) (string, string) {
+				t.Helper()
+				resp := ts.request(t, "GET", path, nil).expect(t, http.StatusOK)
+				etag, modified := resp.header.Get("ETag"), resp.header.Get("Last-Modified")
+				if etag == "" || modified == "" {
+					t.Fatalf("GET %s: ETag %q, Last-Modified %q", path, etag, modified)
+				}
+				return etag, modified
+			}
+			notModified := func(path string, headers ...string) {
+				t.Helper()
+				resp := ts.request(t, "GET", path, nil, headers...).expect(t, http.StatusNotModified)
+				if len(resp.body) != 0 {
+					t.Fatalf("304 of %s has a body: %s", path, resp.body)
+				}
+			}
+
+			etag, modified := validators(paths[0])
+			for _, path := range paths {
+				notModified(path, "If-None-Match", etag)
+				notModified(path, "If-None-Match", `"other", W/`+etag)
+				notModified(path, "If-Modified-Since", modified)
+				ts.request(t, "GET", path, nil, "If-None-Match", `"other"`).expect(t, http.StatusOK)
+				earlier := ts.clock.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+				ts.request(t, "GET", path, nil, "If-Modified-Since", earlier).expect(t, http.StatusOK)
+				// If-None-Match wins over If-Modified-Since
+				ts.request(t, "GET", path, nil, "If-None-Match", `"other"`, "If-Modified-Since", modified).expect(t, http.StatusOK)
+			}
+
+			// every write of the chirps invalidates the ETag
+			writes := []struct {
+				name  string
+				write func()
+			}{
+				{"new chirp", func() { ts.postChirp(t, alice.Token, "second") }},
+				{"edit", func() {
+					ts.request(t, "PUT", chirpPath(chirp.ID), apitypes.UpdateChirpRequest{Body: "first, edited"}, bearer(alice.Token)...).expect(t, http.StatusOK)
+				}},
+				{"delete", func() {
+					ts.request(t, "DELETE", chirpPath(chirp.ID), nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+				}},
+			}
+			seen := map[string]bool{etag: true}
+			for _, w := range writes {
+				w.write()
+				ts.request(t, "GET", "/api/chirps", nil, "If-None-Match", etag).expect(t, http.StatusOK)
+				next, _ := validators("/api/chirps")
+				if seen[next] {
+					t.Fatalf("ETag after %s = %s, seen before", w.name, next)
+				}
+				seen[next] = true
+				notModified("/api/chirps", "If-None-Match", next)
+				etag = next
+			}
+		})
+	}
+}
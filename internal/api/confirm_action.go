@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+const confirmationTTL = 60 * time.Second
+
+// confirmation is a pending destructive action waiting for its second call
+type confirmation struct {
+	action    string
+	params    string
+	expiresAt time.Time
+}
+
+// confirmationStore keeps single-use tokens bound to an action in memory
+type confirmationStore struct {
+	mux    *sync.Mutex
+	ttl    time.Duration
+	tokens map[string]confirmation
+	clock  clock.Clock
+	random io.Reader
+}
+
+func newConfirmationStore(ttl time.Duration, clk clock.Clock, random io.Reader) *confirmationStore {
+	return &confirmationStore{
+		mux:    &sync.Mutex{},
+		ttl:    ttl,
+		tokens: make(map[string]confirmation),
+		clock:  clk,
+		random: random,
+	}
+}
+
+// issue creates a new token bound to action and params
+func (c *confirmationStore) issue(action, params string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(c.random, b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	// drop expired tokens so the map can't grow unbounded
+	now := c.clock.Now()
+	for t, conf := range c.tokens {
+		if now.After(conf.expiresAt) {
+			delete(c.tokens, t)
+		}
+	}
+	c.tokens[token] = confirmation{
+		action:    action,
+		params:    params,
+		expiresAt: now.Add(c.ttl),
+	}
+	return token, nil
+}
+
+// consume reports whether token is valid for action and params.
+// the token is removed either way so it can't be used twice.
+func (c *confirmationStore) consume(token, action, params string) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	conf, ok := c.tokens[token]
+	if !ok {
+		return false
+	}
+	delete(c.tokens, token)
+	return conf.action == action && conf.params == params && c.clock.Now().Before(conf.expiresAt)
+}
+
+// confirmationParams returns the canonical query parameters of the request
+// so a token can't be replayed against a different target.
+func confirmationParams(r *http.Request) string {
+	query := url.Values{}
+	for k, v := range r.URL.Query() {
+		if k == "confirm_token" {
+			continue
+		}
+		query[k] = v
+	}
+	return r.Method + " " + r.URL.Path + "?" + query.Encode()
+}
+
+// requireConfirmation wraps a destructive handler with a two-step confirmation.
+// the first call responds 428 with a token describing what will happen,
+// the second call must send that token in X-Confirm-Token (or ?confirm_token=) to execute.
+func (a *apiConfig) requireConfirmation(action string, describe func(r *http.Request) (string, error), next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := confirmationParams(r)
+
+		token := r.Header.Get("X-Confirm-Token")
+		if token == "" {
+			token = r.URL.Query().Get("confirm_token")
+		}
+		if token != "" {
+			if !a.confirmations.consume(token, action, params) {
+				respondWithError(w, http.StatusForbidden, "Invalid or expired confirmation token")
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		description, err := describe(r)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		token, err = a.confirmations.issue(action, params)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error creating confirmation token")
+			return
+		}
+
+		resp, err := json.Marshal(struct {
+			ConfirmToken string `json:"confirm_token"`
+			Action       string `json:"action"`
+			Description  string `json:"description"`
+			ExpiresIn    int    `json:"expires_in"`
+		}{
+			ConfirmToken: token,
+			Action:       action,
+			Description:  description,
+			ExpiresIn:    int(a.confirmations.ttl.Seconds()),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+			return
+		}
+		w.WriteHeader(http.StatusPreconditionRequired)
+		w.Write(resp)
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// confirmChallenge is the 428 body of requireConfirmation
+type confirmChallenge struct {
+	ConfirmToken string `json:"confirm_token"`
+	Action       string `json:"action"`
+	Description  string `json:"description"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// challenge sends the first call of a confirmed action
+func (ts *testServer) challenge(t *testing.T, method, path string) confirmChallenge {
+	t.Helper()
+	return decode[confirmChallenge](t, ts.request(t, method, path, nil, asAdmin()...).expect(t, http.StatusPreconditionRequired))
+}
+
+func TestConfirmationIssue(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	login := ts.newUser(t, "alice@example.com")
+	ts.postChirp(t, login.Token, "one")
+	deleted := ts.postChirp(t, login.Token, "two")
+	ts.request(t, "DELETE", chirpPath(deleted.ID), nil, bearer(login.Token)...).expect(t, http.StatusOK)
+
+	got := ts.challenge(t, "POST", "/admin/reset/chirps")
+	if got.ConfirmToken == "" || got.Action != "reset-chirps" || got.ExpiresIn != int(confirmationTTL.Seconds()) {
+		t.Fatalf("challenge = %+v", got)
+	}
+	// the deleted chirp waiting for the purge goes too
+	if got.Description != "this will delete 2 chirps" {
+		t.Fatalf("description = %q", got.Description)
+	}
+	// nothing is deleted before the confirmation
+	ts.request(t, "GET", chirpPath(1), nil).expect(t, http.StatusOK)
+
+	// the count is taken when the token is issued
+	ts.postChirp(t, login.Token, "three")
+	ts.request(t, "POST", "/admin/reset/chirps", nil, append(asAdmin(), "X-Confirm-Token", got.ConfirmToken)...).
+		expect(t, http.StatusOK)
+	ts.request(t, "GET", chirpPath(1), nil).expect(t, http.StatusNotFound)
+
+	if got := ts.challenge(t, "POST", "/admin/reset/users").Description; got != "this will delete 0 chirps, 0 lists, 0 follows, 0 syndication sources and 1 user" {
+		t.Fatalf("users description = %q", got)
+	}
+	ts.request(t, "GET", "/app/", nil)
+	if got := ts.challenge(t, "POST", "/admin/reset/all").Description; got != "this will reset 1 fileserver hit and delete 0 chirps, 0 lists, 0 follows, 0 syndication sources and 1 user" {
+		t.Fatalf("all description = %q", got)
+	}
+}
+
+func TestConfirmationTokenRejected(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	ts.newUser(t, "alice@example.com")
+
+	confirm := func(path, token string) testResponse {
+		return ts.request(t, "POST", path, nil, append(asAdmin(), "X-Confirm-Token", token)...)
+	}
+	tests := []struct {
+		name string
+		send func(token string) testResponse
+	}{
+		{"unknown token", func(string) testResponse { return confirm("/admin/reset/users", "not-a-token") }},
+		{"other action", func(token string) testResponse { return confirm("/admin/reset/chirps", token) }},
+		{"other query", func(token string) testResponse { return confirm("/admin/reset/users?full=true", token) }},
+		{"expired", func(token string) testResponse {
+			ts.clock.Advance(confirmationTTL + time.Second)
+			return confirm("/admin/reset/users", token)
+		}},
+		{"used twice", func(token string) testResponse {
+			confirm("/admin/reset/users", token).expect(t, http.StatusOK)
+			return confirm("/admin/reset/users", token)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := ts.challenge(t, "POST", "/admin/reset/users").ConfirmToken
+			resp := tt.send(token).expect(t, http.StatusForbidden)
+			if got := errorOf(t, resp); got != "Invalid or expired confirmation token" {
+				t.Fatalf("error = %q", got)
+			}
+		})
+	}
+}
+
+func TestCountOf(t *testing.T) {
+	for n, want := range map[int]string{0: "0 chirps", 1: "1 chirp", 999: "999 chirps", 1204: "1,204 chirps", 1234567: "1,234,567 chirps"} {
+		if got := countOf(n, "chirps"); got != want {
+			t.Errorf("countOf(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+const defaultDBIndent = 2
+
+// databaseFile holds the chirps and the users, which older versions kept
+// in chirpyDatabase.json and userDatabase.json
+const databaseFile = "database.json"
+
+// dbIndentFromEnv reads DB_JSON_INDENT, the number of spaces the database
+// files are indented with. 0 writes them compact.
+func dbIndentFromEnv(getenv func(string) string) (string, error) {
+	n := defaultDBIndent
+	if v := getenv("DB_JSON_INDENT"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 0 || n > 8 {
+			return "", fmt.Errorf("invalid DB_JSON_INDENT %q: must be between 0 and 8", v)
+		}
+	}
+	return strings.Repeat(" ", n), nil
+}
+
+// setDBIndent makes every db write its file with the configured indent
+func setDBIndent(dbs ...*database.DB) error {
+	indent, err := dbIndentFromEnv(os.Getenv)
+	if err != nil {
+		return err
+	}
+	for _, db := range dbs {
+		db.SetIndent(indent)
+	}
+	return nil
+}
+
+// openDB opens the chirps and users database in dir, merging the two files
+// of older versions into it first
+func openDB(dir string) (*database.DB, error) {
+	merged, err := database.MigrateSplitFiles(filepath.Join(dir, databaseFile),
+		filepath.Join(dir, "chirpyDatabase.json"), filepath.Join(dir, "userDatabase.json"))
+	if err != nil {
+		return nil, fmt.Errorf("merging chirpyDatabase.json and userDatabase.json: %w", err)
+	}
+	if merged {
+		log.Printf("merged chirpyDatabase.json and userDatabase.json into %s", databaseFile)
+	}
+	return database.NewDB(filepath.Join(dir, databaseFile))
+}
+
+// OpenStore opens the chirps and users store DATABASE_URL points to: the
+// json database in dir when it's empty, or sqlite:<path> for a SQLite
+// database. relative paths are resolved against dir. with
+// DATABASE_SECONDARY_URL set as well, writes go to both stores, see
+// database.DualStore.
+func OpenStore(dir string) (database.Store, error) {
+	primary, primaryName, err := openStoreURL(dir, os.Getenv("DATABASE_URL"))
+	if err != nil {
+		return nil, err
+	}
+	secondaryURL := os.Getenv("DATABASE_SECONDARY_URL")
+	if secondaryURL == "" {
+		return primary, nil
+	}
+	verifyEvery := 0
+	if v := os.Getenv("DUAL_WRITE_VERIFY_EVERY"); v != "" {
+		verifyEvery, err = strconv.Atoi(v)
+		if err != nil || verifyEvery < 0 {
+			primary.Close()
+			return nil, fmt.Errorf("invalid DUAL_WRITE_VERIFY_EVERY %q: must be 0 or more", v)
+		}
+	}
+	secondary, secondaryName, err := openStoreURL(dir, secondaryURL)
+	if err != nil {
+		primary.Close()
+		return nil, fmt.Errorf("DATABASE_SECONDARY_URL: %w", err)
+	}
+	if secondaryName == primaryName {
+		primary.Close()
+		secondary.Close()
+		return nil, errors.New("DATABASE_SECONDARY_URL points to the DATABASE_URL store")
+	}
+	return database.NewDualStore(primary, primaryName, secondary, secondaryName, verifyEvery), nil
+}
+
+// openStoreURL opens the store of a DATABASE_URL, "" or json for the json
+// database in dir. it returns the store with a name of the backend and the
+// path it resolved to.
+func openStoreURL(dir, url string) (database.Store, string, error) {
+	if url == "" || url == "json" {
+		db, err := openDB(dir)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := setDBIndent(db); err != nil {
+			db.Close()
+			return nil, "", err
+		}
+		return db, "json:" + filepath.Join(dir, databaseFile), nil
+	}
+	path, err := sqlitePath(url)
+	if err != nil {
+		return nil, "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	s, err := database.OpenSQLite(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return s, "sqlite:" + path, nil
+}
+
+// sqlitePath returns the database path of a sqlite:<path> or
+// sqlite://<path> DATABASE_URL
+func sqlitePath(url string) (string, error) {
+	path, ok := strings.CutPrefix(url, "sqlite:")
+	if !ok {
+		return "", fmt.Errorf("invalid DATABASE_URL %q: only json and sqlite:<path> are supported", url)
+	}
+	path = strings.TrimPrefix(path, "//")
+	if path == "" {
+		return "", errors.New("invalid DATABASE_URL: sqlite: needs a path")
+	}
+	return path, nil
+}
@@ -0,0 +1,635 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// upper bounds in seconds of the database latency histogram buckets
+var dbLatencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// opStats is the counter and histogram of one database operation
+type opStats struct {
+	count   int
+	errors  int
+	sum     float64
+	buckets []int // cumulative counts per dbLatencyBuckets entry
+}
+
+// dbMetrics collects per-operation latency of the database wrappers
+type dbMetrics struct {
+	mux *sync.Mutex
+	ops map[[2]string]*opStats // keyed by database name and operation
+}
+
+func newDBMetrics() *dbMetrics {
+	return &dbMetrics{
+		mux: &sync.Mutex{},
+		ops: make(map[[2]string]*opStats),
+	}
+}
+
+// observe records one call of op against the named database
+func (m *dbMetrics) observe(db, op string, duration time.Duration, err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	key := [2]string{db, op}
+	stats, ok := m.ops[key]
+	if !ok {
+		stats = &opStats{buckets: make([]int, len(dbLatencyBuckets))}
+		m.ops[key] = stats
+	}
+	seconds := duration.Seconds()
+	stats.count++
+	stats.sum += seconds
+	if err != nil {
+		stats.errors++
+	}
+	for i, bound := range dbLatencyBuckets {
+		if seconds <= bound {
+			stats.buckets[i]++
+		}
+	}
+}
+
+// writePrometheus writes the collected metrics in the prometheus text format
+func (m *dbMetrics) writePrometheus(w io.Writer) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	keys := make([][2]string, 0, len(m.ops))
+	for key := range m.ops {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0]+keys[i][1] < keys[j][0]+keys[j][1]
+	})
+
+	fmt.Fprintln(w, "# HELP chirpy_db_operations_total Database operations by result.")
+	fmt.Fprintln(w, "# TYPE chirpy_db_operations_total counter")
+	for _, key := range keys {
+		stats := m.ops[key]
+		fmt.Fprintf(w, "chirpy_db_operations_total{db=%q,op=%q,result=\"ok\"} %d\n", key[0], key[1], stats.count-stats.errors)
+		fmt.Fprintf(w, "chirpy_db_operations_total{db=%q,op=%q,result=\"error\"} %d\n", key[0], key[1], stats.errors)
+	}
+
+	fmt.Fprintln(w, "# HELP chirpy_db_operation_duration_seconds Database operation latency.")
+	fmt.Fprintln(w, "# TYPE chirpy_db_operation_duration_seconds histogram")
+	for _, key := range keys {
+		stats := m.ops[key]
+		for i, bound := range dbLatencyBuckets {
+			fmt.Fprintf(w, "chirpy_db_operation_duration_seconds_bucket{db=%q,op=%q,le=\"%g\"} %d\n", key[0], key[1], bound, stats.buckets[i])
+		}
+		fmt.Fprintf(w, "chirpy_db_operation_duration_seconds_bucket{db=%q,op=%q,le=\"+Inf\"} %d\n", key[0], key[1], stats.count)
+		fmt.Fprintf(w, "chirpy_db_operation_duration_seconds_sum{db=%q,op=%q} %g\n", key[0], key[1], stats.sum)
+		fmt.Fprintf(w, "chirpy_db_operation_duration_seconds_count{db=%q,op=%q} %d\n", key[0], key[1], stats.count)
+	}
+}
+
+// instrumentedDB wraps a database with latency metrics so the database
+// package itself stays free of metrics code.
+type instrumentedDB struct {
+	*database.DB
+	name    string
+	metrics *dbMetrics
+}
+
+func newInstrumentedDB(name string, db *database.DB, metrics *dbMetrics) *instrumentedDB {
+	db.SetFileObserver(func(op string, duration time.Duration, size int, err error) {
+		metrics.observe(name, op, duration, err)
+	})
+	return &instrumentedDB{DB: db, name: name, metrics: metrics}
+}
+
+func (i *instrumentedDB) observe(op string, start time.Time, err error) {
+	i.metrics.observe(i.name, op, time.Since(start), err)
+}
+
+// instrumentedStore wraps the chirps and users store like instrumentedDB
+// wraps the other databases
+type instrumentedStore struct {
+	database.Store
+	name     string
+	metrics  *dbMetrics
+	fileSize *atomic.Int64 // nil unless the store is the json database
+}
+
+func newInstrumentedStore(name string, store database.Store, metrics *dbMetrics) *instrumentedStore {
+	i := &instrumentedStore{
+		Store:   store,
+		name:    name,
+		metrics: metrics,
+	}
+	if db, ok := store.(*database.DB); ok {
+		i.fileSize = &atomic.Int64{}
+		db.SetFileObserver(func(op string, duration time.Duration, size int, err error) {
+			if err == nil {
+				i.fileSize.Store(int64(size))
+			}
+			metrics.observe(name, op, duration, err)
+		})
+	}
+	return i
+}
+
+func (i *instrumentedStore) observe(op string, start time.Time, err error) {
+	i.metrics.observe(i.name, op, time.Since(start), err)
+}
+
+func (i *instrumentedStore) CreateUser(email string, password []byte) (database.User, error) {
+	start := time.Now()
+	user, err := i.Store.CreateUser(email, password)
+	i.observe("CreateUser", start, err)
+	return user, err
+}
+
+func (i *instrumentedStore) GetUser() ([]database.User, error) {
+	start := time.Now()
+	users, err := i.Store.GetUser()
+	i.observe("GetUser", start, err)
+	return users, err
+}
+
+func (i *instrumentedStore) GetUserByEmail(email string) (database.User, error) {
+	start := time.Now()
+	user, err := i.Store.GetUserByEmail(email)
+	i.observe("GetUserByEmail", start, err)
+	return user, err
+}
+
+func (i *instrumentedStore) GetUserByID(ID int) (database.User, error) {
+	start := time.Now()
+	user, err := i.Store.GetUserByID(ID)
+	i.observe("GetUserByID", start, err)
+	return user, err
+}
+
+func (i *instrumentedStore) DeleteUser(ID int, chirps database.AuthorChirps) error {
+	start := time.Now()
+	err := i.Store.DeleteUser(ID, chirps)
+	i.observe("DeleteUser", start, err)
+	return err
+}
+
+func (i *instrumentedStore) UpdateUserDB(ID int, email string, password []byte, expectedVersion *int) (database.User, error) {
+	start := time.Now()
+	user, err := i.Store.UpdateUserDB(ID, email, password, expectedVersion)
+	i.observe("UpdateUserDB", start, err)
+	return user, err
+}
+
+func (i *instrumentedStore) UpgradeUser(ID int) error {
+	start := time.Now()
+	err := i.Store.UpgradeUser(ID)
+	i.observe("UpgradeUser", start, err)
+	return err
+}
+
+func (i *instrumentedStore) RevokeToken(ID int) error {
+	start := time.Now()
+	err := i.Store.RevokeToken(ID)
+	i.observe("RevokeToken", start, err)
+	return err
+}
+
+func (i *instrumentedStore) StoreToken(ID int, token string, family string, expiresAt time.Time) error {
+	start := time.Now()
+	err := i.Store.StoreToken(ID, token, family, expiresAt)
+	i.observe("StoreToken", start, err)
+	return err
+}
+
+func (i *instrumentedStore) RevokeSession(ID int, family string) error {
+	start := time.Now()
+	err := i.Store.RevokeSession(ID, family)
+	i.observe("RevokeSession", start, err)
+	return err
+}
+
+func (i *instrumentedStore) AddSession(ID int, token string, family string, expiresAt time.Time) error {
+	start := time.Now()
+	err := i.Store.AddSession(ID, token, family, expiresAt)
+	i.observe("AddSession", start, err)
+	return err
+}
+
+func (i *instrumentedStore) RotateToken(ID int, presented string, family string, next string) error {
+	start := time.Now()
+	err := i.Store.RotateToken(ID, presented, family, next)
+	i.observe("RotateToken", start, err)
+	return err
+}
+
+func (i *instrumentedStore) LinkUsers(ID int, linkedID int) error {
+	start := time.Now()
+	err := i.Store.LinkUsers(ID, linkedID)
+	i.observe("LinkUsers", start, err)
+	return err
+}
+
+func (i *instrumentedStore) UnlinkUsers(ID int, linkedID int) error {
+	start := time.Now()
+	err := i.Store.UnlinkUsers(ID, linkedID)
+	i.observe("UnlinkUsers", start, err)
+	return err
+}
+
+func (i *instrumentedStore) SetOAuthProvider(ID int, provider string) (database.User, error) {
+	start := time.Now()
+	user, err := i.Store.SetOAuthProvider(ID, provider)
+	i.observe("SetOAuthProvider", start, err)
+	return user, err
+}
+
+func (i *instrumentedStore) LinkIdentity(userID int, provider, providerUserID string) error {
+	start := time.Now()
+	err := i.Store.LinkIdentity(userID, provider, providerUserID)
+	i.observe("LinkIdentity", start, err)
+	return err
+}
+
+func (i *instrumentedStore) GetUserByIdentity(provider, providerUserID string) (database.User, error) {
+	start := time.Now()
+	user, err := i.Store.GetUserByIdentity(provider, providerUserID)
+	i.observe("GetUserByIdentity", start, err)
+	return user, err
+}
+
+func (i *instrumentedStore) UnlinkIdentity(userID int, provider string) error {
+	start := time.Now()
+	err := i.Store.UnlinkIdentity(userID, provider)
+	i.observe("UnlinkIdentity", start, err)
+	return err
+}
+
+func (i *instrumentedStore) SetPendingTOTP(ID int, secret string) error {
+	start := time.Now()
+	err := i.Store.SetPendingTOTP(ID, secret)
+	i.observe("SetPendingTOTP", start, err)
+	return err
+}
+
+func (i *instrumentedStore) EnableTOTP(ID int, backupCodeHashes []string) error {
+	start := time.Now()
+	err := i.Store.EnableTOTP(ID, backupCodeHashes)
+	i.observe("EnableTOTP", start, err)
+	return err
+}
+
+func (i *instrumentedStore) DisableTOTP(ID int) error {
+	start := time.Now()
+	err := i.Store.DisableTOTP(ID)
+	i.observe("DisableTOTP", start, err)
+	return err
+}
+
+func (i *instrumentedStore) UseBackupCode(ID int, hash string) (bool, error) {
+	start := time.Now()
+	used, err := i.Store.UseBackupCode(ID, hash)
+	i.observe("UseBackupCode", start, err)
+	return used, err
+}
+
+func (i *instrumentedStore) CreateChirp(body string, authorID int, lang string, source string) (database.Chirp, error) {
+	start := time.Now()
+	chirp, err := i.Store.CreateChirp(body, authorID, lang, source)
+	i.observe("CreateChirp", start, err)
+	return chirp, err
+}
+
+func (i *instrumentedStore) ImportChirps(authorID int, chirps []database.Chirp) ([]database.Chirp, error) {
+	start := time.Now()
+	imported, err := i.Store.ImportChirps(authorID, chirps)
+	i.observe("ImportChirps", start, err)
+	return imported, err
+}
+
+func (i *instrumentedStore) GetChirps() ([]database.Chirp, error) {
+	start := time.Now()
+	chirps, err := i.Store.GetChirps()
+	i.observe("GetChirps", start, err)
+	return chirps, err
+}
+
+func (i *instrumentedStore) GetChirpyFromID(ID int) (database.Chirp, error) {
+	start := time.Now()
+	chirp, err := i.Store.GetChirpyFromID(ID)
+	i.observe("GetChirpyFromID", start, err)
+	return chirp, err
+}
+
+func (i *instrumentedStore) GetChirpsByAuthorID(authorID int) ([]database.Chirp, error) {
+	start := time.Now()
+	chirps, err := i.Store.GetChirpsByAuthorID(authorID)
+	i.observe("GetChirpsByAuthorID", start, err)
+	return chirps, err
+}
+
+func (i *instrumentedStore) ChirpActivity(authorID int, since time.Time) (map[time.Time]int, error) {
+	start := time.Now()
+	counts, err := i.Store.ChirpActivity(authorID, since)
+	i.observe("ChirpActivity", start, err)
+	return counts, err
+}
+
+func (i *instrumentedStore) DeleteDB(authorID int, ID int) error {
+	start := time.Now()
+	err := i.Store.DeleteDB(authorID, ID)
+	i.observe("DeleteDB", start, err)
+	return err
+}
+
+func (i *instrumentedStore) BumpChirp(authorID int, ID int, cooldown time.Duration) (database.Chirp, error) {
+	start := time.Now()
+	chirp, err := i.Store.BumpChirp(authorID, ID, cooldown)
+	i.observe("BumpChirp", start, err)
+	return chirp, err
+}
+
+func (i *instrumentedStore) UpdateChirp(authorID int, ID int, body string) (database.Chirp, error) {
+	start := time.Now()
+	chirp, err := i.Store.UpdateChirp(authorID, ID, body)
+	i.observe("UpdateChirp", start, err)
+	return chirp, err
+}
+
+func (i *instrumentedStore) RestoreChirp(authorID int, ID int) (database.Chirp, error) {
+	start := time.Now()
+	chirp, err := i.Store.RestoreChirp(authorID, ID)
+	i.observe("RestoreChirp", start, err)
+	return chirp, err
+}
+
+func (i *instrumentedStore) CompactChirps(cutoff time.Time) (database.CompactReport, error) {
+	start := time.Now()
+	report, err := i.Store.CompactChirps(cutoff)
+	i.observe("CompactChirps", start, err)
+	return report, err
+}
+
+func (i *instrumentedStore) CompactUsers(expired func(token string) bool) (database.CompactReport, error) {
+	start := time.Now()
+	report, err := i.Store.CompactUsers(expired)
+	i.observe("CompactUsers", start, err)
+	return report, err
+}
+
+func (i *instrumentedStore) QueryChirps(q database.ChirpQuery) ([]database.Chirp, int, error) {
+	start := time.Now()
+	chirps, total, err := i.Store.QueryChirps(q)
+	i.observe("QueryChirps", start, err)
+	return chirps, total, err
+}
+
+func (i *instrumentedStore) MirrorChirps(authorID int, chirps []database.Chirp) ([]database.Chirp, error) {
+	start := time.Now()
+	mirrored, err := i.Store.MirrorChirps(authorID, chirps)
+	i.observe("MirrorChirps", start, err)
+	return mirrored, err
+}
+
+func (i *instrumentedStore) DeleteChirpsByAuthor(authorID int) (int, error) {
+	start := time.Now()
+	deleted, err := i.Store.DeleteChirpsByAuthor(authorID)
+	i.observe("DeleteChirpsByAuthor", start, err)
+	return deleted, err
+}
+
+func (i *instrumentedStore) ResetChirps() (int, error) {
+	start := time.Now()
+	n, err := i.Store.ResetChirps()
+	i.observe("ResetChirps", start, err)
+	return n, err
+}
+
+func (i *instrumentedStore) ResetUsers() (int, error) {
+	start := time.Now()
+	n, err := i.Store.ResetUsers()
+	i.observe("ResetUsers", start, err)
+	return n, err
+}
+
+func (i *instrumentedStore) UserStats() (database.CollectionStats, error) {
+	start := time.Now()
+	stats, err := i.Store.UserStats()
+	i.observe("UserStats", start, err)
+	return stats, err
+}
+
+func (i *instrumentedStore) ChirpRevision() (database.ChirpRevision, error) {
+	start := time.Now()
+	rev, err := i.Store.ChirpRevision()
+	i.observe("ChirpRevision", start, err)
+	return rev, err
+}
+
+func (i *instrumentedStore) Export(includeSecrets bool) (database.Backup, error) {
+	start := time.Now()
+	backup, err := i.Store.Export(includeSecrets)
+	i.observe("Export", start, err)
+	return backup, err
+}
+
+func (i *instrumentedStore) Import(b database.Backup) error {
+	start := time.Now()
+	err := i.Store.Import(b)
+	i.observe("Import", start, err)
+	return err
+}
+
+func (i *instrumentedStore) ChirpStats() (database.CollectionStats, error) {
+	start := time.Now()
+	stats, err := i.Store.ChirpStats()
+	i.observe("ChirpStats", start, err)
+	return stats, err
+}
+
+func (i *instrumentedDB) CreateList(ownerID int, name, description string, private bool) (database.List, error) {
+	start := time.Now()
+	list, err := i.DB.CreateList(ownerID, name, description, private)
+	i.observe("CreateList", start, err)
+	return list, err
+}
+
+func (i *instrumentedDB) GetList(ID int) (database.List, error) {
+	start := time.Now()
+	list, err := i.DB.GetList(ID)
+	i.observe("GetList", start, err)
+	return list, err
+}
+
+func (i *instrumentedDB) GetListsByOwner(ownerID int) ([]database.List, error) {
+	start := time.Now()
+	lists, err := i.DB.GetListsByOwner(ownerID)
+	i.observe("GetListsByOwner", start, err)
+	return lists, err
+}
+
+func (i *instrumentedDB) AddListMember(ID int, userID int) (database.List, error) {
+	start := time.Now()
+	list, err := i.DB.AddListMember(ID, userID)
+	i.observe("AddListMember", start, err)
+	return list, err
+}
+
+func (i *instrumentedDB) RemoveListMember(ID int, userID int) (database.List, error) {
+	start := time.Now()
+	list, err := i.DB.RemoveListMember(ID, userID)
+	i.observe("RemoveListMember", start, err)
+	return list, err
+}
+
+func (i *instrumentedDB) CreateSyndicationSource(baseURL string, remoteAuthorID, localUserID int) (database.SyndicationSource, error) {
+	start := time.Now()
+	source, err := i.DB.CreateSyndicationSource(baseURL, remoteAuthorID, localUserID)
+	i.observe("CreateSyndicationSource", start, err)
+	return source, err
+}
+
+func (i *instrumentedDB) GetSyndicationSource(ID int) (database.SyndicationSource, error) {
+	start := time.Now()
+	source, err := i.DB.GetSyndicationSource(ID)
+	i.observe("GetSyndicationSource", start, err)
+	return source, err
+}
+
+func (i *instrumentedDB) GetSyndicationSources() ([]database.SyndicationSource, error) {
+	start := time.Now()
+	sources, err := i.DB.GetSyndicationSources()
+	i.observe("GetSyndicationSources", start, err)
+	return sources, err
+}
+
+func (i *instrumentedDB) UpdateSyndicationSource(ID int, update func(source *database.SyndicationSource)) (database.SyndicationSource, error) {
+	start := time.Now()
+	source, err := i.DB.UpdateSyndicationSource(ID, update)
+	i.observe("UpdateSyndicationSource", start, err)
+	return source, err
+}
+
+func (i *instrumentedDB) DeleteSyndicationSource(ID int) (database.SyndicationSource, error) {
+	start := time.Now()
+	source, err := i.DB.DeleteSyndicationSource(ID)
+	i.observe("DeleteSyndicationSource", start, err)
+	return source, err
+}
+
+func (i *instrumentedDB) ResetLists() (int, error) {
+	start := time.Now()
+	n, err := i.DB.ResetLists()
+	i.observe("ResetLists", start, err)
+	return n, err
+}
+
+func (i *instrumentedDB) ResetSyndicationSources() (int, error) {
+	start := time.Now()
+	n, err := i.DB.ResetSyndicationSources()
+	i.observe("ResetSyndicationSources", start, err)
+	return n, err
+}
+
+func (i *instrumentedDB) ListStats() (database.CollectionStats, error) {
+	start := time.Now()
+	stats, err := i.DB.ListStats()
+	i.observe("ListStats", start, err)
+	return stats, err
+}
+
+func (i *instrumentedDB) SyndicationStats() (database.CollectionStats, error) {
+	start := time.Now()
+	stats, err := i.DB.SyndicationStats()
+	i.observe("SyndicationStats", start, err)
+	return stats, err
+}
+
+func (i *instrumentedDB) CreatePublicKey(name, keyHash, prefix string, allowedOrigins []string, perMinute int) (database.PublicKey, error) {
+	start := time.Now()
+	key, err := i.DB.CreatePublicKey(name, keyHash, prefix, allowedOrigins, perMinute)
+	i.observe("CreatePublicKey", start, err)
+	return key, err
+}
+
+func (i *instrumentedDB) GetPublicKeys() ([]database.PublicKey, error) {
+	start := time.Now()
+	keys, err := i.DB.GetPublicKeys()
+	i.observe("GetPublicKeys", start, err)
+	return keys, err
+}
+
+func (i *instrumentedDB) GetPublicKeyByHash(keyHash string) (database.PublicKey, error) {
+	start := time.Now()
+	key, err := i.DB.GetPublicKeyByHash(keyHash)
+	i.observe("GetPublicKeyByHash", start, err)
+	return key, err
+}
+
+func (i *instrumentedDB) RevokePublicKey(ID int) (database.PublicKey, error) {
+	start := time.Now()
+	key, err := i.DB.RevokePublicKey(ID)
+	i.observe("RevokePublicKey", start, err)
+	return key, err
+}
+
+// wantsPrometheus reports whether the metrics request comes from a scraper
+func wantsPrometheus(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return r.URL.Query().Get("format") == "prometheus" ||
+		strings.Contains(accept, "text/plain") ||
+		strings.Contains(accept, "application/openmetrics-text")
+}
+
+// prometheusMetrics writes the database metrics plus record count and
+// file size gauges. counts are read through the unwrapped database so
+// scraping doesn't show up in the operation metrics.
+func (cfg *apiConfig) prometheusMetrics(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	cfg.dbMetrics.writePrometheus(w)
+	cfg.webhooks.writePrometheus(w)
+	cfg.chirpCache.writePrometheus(w)
+	cfg.inFlight.writePrometheus(w)
+	cfg.routeHits.writePrometheus(w)
+	writeEventMetrics(w, cfg.events)
+
+	users, err := cfg.db.Store.GetUser()
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	chirps, err := cfg.chirpyDatabase.Store.GetChirps()
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	fmt.Fprintln(w, "# HELP chirpy_db_records Records stored per collection.")
+	fmt.Fprintln(w, "# TYPE chirpy_db_records gauge")
+	fmt.Fprintf(w, "chirpy_db_records{collection=\"users\"} %d\n", len(users))
+	fmt.Fprintf(w, "chirpy_db_records{collection=\"chirps\"} %d\n", len(chirps))
+
+	if cfg.db.fileSize != nil {
+		fmt.Fprintln(w, "# HELP chirpy_db_file_bytes Serialized size of the database file.")
+		fmt.Fprintln(w, "# TYPE chirpy_db_file_bytes gauge")
+		fmt.Fprintf(w, "chirpy_db_file_bytes{db=%q} %d\n", cfg.db.name, cfg.db.fileSize.Load())
+	}
+
+	fmt.Fprintln(w, "# HELP chirpy_purged_chirps_total Deleted chirps permanently removed by the purge job.")
+	fmt.Fprintln(w, "# TYPE chirpy_purged_chirps_total counter")
+	fmt.Fprintf(w, "chirpy_purged_chirps_total %d\n", cfg.purged.Load())
+
+	degraded := 0
+	if cfg.storageDegraded() {
+		degraded = 1
+	}
+	fmt.Fprintln(w, "# HELP chirpy_storage_degraded Whether a database write last failed with a full disk.")
+	fmt.Fprintln(w, "# TYPE chirpy_storage_degraded gauge")
+	fmt.Fprintf(w, "chirpy_storage_degraded %d\n", degraded)
+}
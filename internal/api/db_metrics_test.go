@@ -0,0 +1,112 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// instrumentationBudget is what the wrapper may add to a call
+const instrumentationBudget = 3 * time.Microsecond
+
+// nopStore answers the benchmarked reads without doing anything, so only
+// the wrapper is measured
+type nopStore struct {
+	database.Store
+}
+
+func (nopStore) GetChirpyFromID(ID int) (database.Chirp, error) {
+	return database.Chirp{ID: ID}, nil
+}
+
+func (nopStore) GetUserByID(ID int) (database.User, error) {
+	return database.User{ID: ID}, nil
+}
+
+// benchStores returns the same seeded json database twice, raw and wrapped
+func benchStores(b *testing.B) map[string]database.Store {
+	stores := make(map[string]database.Store)
+	for _, name := range []string{"raw", "instrumented"} {
+		db, err := database.NewDB(filepath.Join(b.TempDir(), databaseFile))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(db.Close)
+		seedChirps(b, db)
+		stores[name] = db
+		if name == "instrumented" {
+			stores[name] = newInstrumentedStore("database", db, newDBMetrics())
+		}
+	}
+	return stores
+}
+
+func BenchmarkInstrumentedStore(b *testing.B) {
+	for name, store := range benchStores(b) {
+		b.Run(name+"/GetChirpyFromID", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := store.GetChirpyFromID(i%benchChirps + 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/GetUserByID", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := store.GetUserByID(i%benchUsers + 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/GetUserByEmail", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := store.GetUserByEmail(fmt.Sprintf("user-%d@example.com", i%benchUsers+1)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestInstrumentationOverhead(t *testing.T) {
+	// like the latency budget of GET /api/chirps, wall clock time depends on the machine
+	if os.Getenv("CHIRPY_LATENCY_BUDGET") != "1" {
+		t.Skip("instrumentation budget, set CHIRPY_LATENCY_BUDGET=1 to check it")
+	}
+	store := newInstrumentedStore("database", nopStore{}, newDBMetrics())
+	result := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			store.GetChirpyFromID(i)
+		}
+	})
+	if per := time.Duration(result.NsPerOp()); per > instrumentationBudget {
+		t.Fatalf("instrumented call = %s, budget %s", per, instrumentationBudget)
+	}
+}
+
+func TestInstrumentedStoreRecordsCalls(t *testing.T) {
+	metrics := newDBMetrics()
+	store := newInstrumentedStore("database", nopStore{}, metrics)
+	for i := range 3 {
+		store.GetChirpyFromID(i)
+	}
+	store.GetUserByID(1)
+
+	var out strings.Builder
+	metrics.writePrometheus(&out)
+	for _, line := range []string{
+		`chirpy_db_operations_total{db="database",op="GetChirpyFromID",result="ok"} 3`,
+		`chirpy_db_operations_total{db="database",op="GetUserByID",result="ok"} 1`,
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("metrics miss %q:\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// deprecation is a request shape that still works but is going away
+type deprecation struct {
+	field  string    // what the client sends
+	use    string    // what to send instead
+	since  time.Time // when it was deprecated
+	sunset time.Time // from then on requests using it are rejected
+}
+
+// deprecations is every deprecated request shape by ID. handlers report
+// the ones a request uses through a.deprecated.
+var deprecations = map[string]deprecation{
+	"user.expected_version": {
+		field:  "expected_version",
+		use:    "the If-Match header",
+		since:  time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC),
+		sunset: time.Date(2027, 4, 14, 0, 0, 0, 0, time.UTC),
+	},
+	"api.reset": {
+		field:  "/api/reset",
+		use:    "POST /admin/reset/{target}",
+		since:  time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC),
+		sunset: time.Date(2027, 1, 14, 0, 0, 0, 0, time.UTC),
+	},
+}
+
+// deprecated is called by a handler whose request uses the deprecated shape
+// ID. before the sunset it sets the Deprecation and Sunset headers and
+// returns the warning to put in the response. after it, it responds 400
+// and returns false.
+func (a *apiConfig) deprecated(w http.ResponseWriter, ID string) (apitypes.Warning, bool) {
+	d, ok := deprecations[ID]
+	if !ok {
+		panic("unknown deprecation " + ID)
+	}
+	sunset := d.sunset.Format(time.DateOnly)
+	if !a.clock.Now().Before(d.sunset) {
+		msg := fmt.Sprintf("%s was removed on %s, use %s", d.field, sunset, d.use)
+		respondWithErrorCode(w, http.StatusBadRequest, msg, apitypes.ErrorCodeDeprecatedField)
+		return apitypes.Warning{}, false
+	}
+
+	w.Header().Set("Deprecation", "@"+strconv.FormatInt(d.since.Unix(), 10))
+	w.Header().Set("Sunset", d.sunset.Format(http.TimeFormat))
+	return apitypes.Warning{
+		Code:    apitypes.WarningCodeDeprecated,
+		Field:   d.field,
+		Message: fmt.Sprintf("%s is deprecated and will be rejected from %s, use %s", d.field, sunset, d.use),
+		Sunset:  d.sunset,
+	}, true
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+func TestDeprecatedPhases(t *testing.T) {
+	for ID, d := range deprecations {
+		if !d.since.Before(d.sunset) || d.field == "" || d.use == "" {
+			t.Errorf("%s: deprecation %+v needs a field, a replacement and a sunset after since", ID, d)
+		}
+		tests := []struct {
+			name string
+			at   time.Time
+			warn bool
+		}{
+			{"before the deprecation", d.since.Add(-time.Hour), true},
+			{"a second before the sunset", d.sunset.Add(-time.Second), true},
+			{"at the sunset", d.sunset, false},
+			{"after the sunset", d.sunset.Add(30 * 24 * time.Hour), false},
+		}
+		for _, tt := range tests {
+			a := &apiConfig{clock: clock.NewFake(tt.at)}
+			w := httptest.NewRecorder()
+			warning, ok := a.deprecated(w, ID)
+			if ok != tt.warn {
+				t.Errorf("%s %s: deprecated ok = %v, want %v", ID, tt.name, ok, tt.warn)
+				continue
+			}
+			if !tt.warn {
+				got := decode[apitypes.ErrorResponse](t, testResponse{status: w.Code, body: w.Body.Bytes()}.expect(t, http.StatusBadRequest))
+				if got.ErrorCode != apitypes.ErrorCodeDeprecatedField || w.Header().Get("Deprecation") != "" {
+					t.Errorf("%s %s: response %+v with Deprecation %q", ID, tt.name, got, w.Header().Get("Deprecation"))
+				}
+				continue
+			}
+			if warning.Code != apitypes.WarningCodeDeprecated || warning.Field != d.field || !warning.Sunset.Equal(d.sunset) {
+				t.Errorf("%s %s: warning = %+v", ID, tt.name, warning)
+			}
+			if got, want := w.Header().Get("Deprecation"), "@"+strconv.FormatInt(d.since.Unix(), 10); got != want {
+				t.Errorf("%s %s: Deprecation = %q, want %q", ID, tt.name, got, want)
+			}
+			if got, want := w.Header().Get("Sunset"), d.sunset.Format(http.TimeFormat); got != want {
+				t.Errorf("%s %s: Sunset = %q, want %q", ID, tt.name, got, want)
+			}
+		}
+	}
+}
+
+func TestDeprecatedRequestFields(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	login := ts.newUser(t, "alice@example.com")
+	version := 0
+	update := func(req apitypes.UserRequest, headers ...string) testResponse {
+		t.Helper()
+		return ts.request(t, "PUT", "/api/users", req, append(bearer(login.Token), headers...)...)
+	}
+	clean := func(name string, resp testResponse) {
+		t.Helper()
+		user := decode[apitypes.UserResponse](t, resp.expect(t, http.StatusOK))
+		if len(user.Warnings) != 0 || resp.header.Get("Deprecation") != "" || resp.header.Get("Sunset") != "" {
+			t.Fatalf("%s: warnings %+v, Deprecation %q, Sunset %q", name, user.Warnings, resp.header.Get("Deprecation"), resp.header.Get("Sunset"))
+		}
+		version = user.Version
+	}
+
+	// warn phase: the request still works and says what to change
+	resp := update(apitypes.UserRequest{Email: "alice@example.com", Password: testPassword, ExpectedVersion: &version}).expect(t, http.StatusOK)
+	user := decode[apitypes.UserResponse](t, resp)
+	if len(user.Warnings) != 1 || user.Warnings[0].Field != "expected_version" || resp.header.Get("Deprecation") == "" || resp.header.Get("Sunset") == "" {
+		t.Fatalf("warn phase: warnings %+v, headers %v", user.Warnings, resp.header)
+	}
+	version = user.Version
+	reset := decode[resetResponse](t, ts.confirmed(t, "POST", "/api/reset", nil, asAdmin()...).expect(t, http.StatusOK))
+	if len(reset.Warnings) != 1 || reset.Warnings[0].Field != "/api/reset" {
+		t.Fatalf("warn phase of /api/reset: warnings %+v", reset.Warnings)
+	}
+
+	// clean requests never get warnings
+	clean("no version", update(apitypes.UserRequest{Email: "alice@example.com", Password: testPassword}))
+	clean("If-Match", update(apitypes.UserRequest{Email: "alice@example.com", Password: testPassword}, "If-Match", strconv.Quote(strconv.Itoa(version))))
+	reset = decode[resetResponse](t, ts.confirmed(t, "POST", "/admin/reset/metrics", nil, asAdmin()...).expect(t, http.StatusOK))
+	if len(reset.Warnings) != 0 {
+		t.Fatalf("admin reset warnings = %+v", reset.Warnings)
+	}
+
+	// from the sunset on the deprecated shapes are rejected, clean ones still work
+	ts.clock.Advance(deprecations["user.expected_version"].sunset.Sub(ts.clock.Now()))
+	login = ts.login(t, "alice@example.com")
+	got := decode[apitypes.ErrorResponse](t, update(apitypes.UserRequest{Email: "alice@example.com", Password: testPassword, ExpectedVersion: &version}).expect(t, http.StatusBadRequest))
+	if got.ErrorCode != apitypes.ErrorCodeDeprecatedField {
+		t.Fatalf("after the sunset: %+v, want %s", got, apitypes.ErrorCodeDeprecatedField)
+	}
+	got = decode[apitypes.ErrorResponse](t, ts.confirmed(t, "POST", "/api/reset", nil, asAdmin()...).expect(t, http.StatusBadRequest))
+	if got.ErrorCode != apitypes.ErrorCodeDeprecatedField {
+		t.Fatalf("/api/reset after the sunset: %+v, want %s", got, apitypes.ErrorCodeDeprecatedField)
+	}
+	clean("no version after the sunset", update(apitypes.UserRequest{Email: "alice@example.com", Password: testPassword}))
+}
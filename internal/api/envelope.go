@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+const defaultPageLimit = 50
+
+// wantsEnvelope reports whether the client opted in to the list envelope
+// with ?envelope=true. bare responses stay the default until v1.
+func wantsEnvelope(r *http.Request) bool {
+	return r.URL.Query().Get("envelope") == "true"
+}
+
+// newListEnvelope wraps one page of items.
+// total is the size of the whole filtered set, next_cursor is the offset of
+// the next page or empty on the last one.
+func newListEnvelope[T any](page []T, total, limit, offset int) apitypes.ListEnvelope[T] {
+	end := offset + len(page)
+
+	env := apitypes.ListEnvelope[T]{
+		Items:  page,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	if env.Items == nil {
+		env.Items = []T{}
+	}
+	if end < total {
+		env.NextCursor = strconv.Itoa(end)
+	}
+	return env
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/friday1602/chirpy/database"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// respondWithJSON writes payload as json with status code
+func respondWithJSON(w http.ResponseWriter, code int, payload any) {
+	resp, err := json.Marshal(payload)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"Error marshalling json"}`))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(resp)
+}
+
+// respondWithError writes {"error": msg} with status code. every error
+// response goes through it, so clients always get json. msg is shown to
+// the client, internal failures pass a generic one.
+func respondWithError(w http.ResponseWriter, code int, msg string) {
+	respondWithJSON(w, code, apitypes.ErrorResponse{Error: msg})
+}
+
+// respondWithErrorCode writes a json error with a machine readable code
+// so clients can tell failures with the same status apart.
+func respondWithErrorCode(w http.ResponseWriter, code int, msg, errorCode string) {
+	respondWithJSON(w, code, apitypes.ErrorResponse{
+		Error:     msg,
+		ErrorCode: errorCode,
+	})
+}
+
+// missingCredentials names the first empty field of a signup or login
+func missingCredentials(req apitypes.UserRequest) (string, bool) {
+	if strings.TrimSpace(req.Email) == "" {
+		return "email is required", false
+	}
+	if req.Password == "" {
+		return "password is required", false
+	}
+	return "", true
+}
+
+// respondWithDBError answers a failed database call. storage that is
+// temporarily unavailable is a 503 with Retry-After so clients try again,
+// anything else is answered with code and msg.
+func respondWithDBError(w http.ResponseWriter, err error, code int, msg string) {
+	if errors.Is(err, database.ErrStorageUnavailable) {
+		w.Header().Set("Retry-After", "1")
+		respondWithError(w, http.StatusServiceUnavailable, "storage temporarily unavailable, try again")
+		return
+	}
+	respondWithError(w, code, msg)
+}
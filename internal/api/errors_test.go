@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestBadRequestBodies(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := start(t)
+			alice := ts.newUser(t, "alice@example.com")
+			tests := []struct {
+				name   string
+				method string
+				path   string
+				body   string
+				auth   []string
+				status int
+				error  string
+			}{
+				{"signup truncated", "POST", "/api/users", `{"email":`, nil, http.StatusBadRequest, "Error decoding json"},
+				{"signup not json", "POST", "/api/users", `email=bob@example.com`, nil, http.StatusBadRequest, "Error decoding json"},
+				{"signup wrong type", "POST", "/api/users", `{"email":5,"password":"x"}`, nil, http.StatusBadRequest, "Error decoding json"},
+				{"signup empty body", "POST", "/api/users", ``, nil, http.StatusBadRequest, "Error decoding json"},
+				{"signup empty object", "POST", "/api/users", `{}`, nil, http.StatusBadRequest, "email is required"},
+				{"signup blank email", "POST", "/api/users", `{"email":"  ","password":"secret"}`, nil, http.StatusBadRequest, "email is required"},
+				{"signup no password", "POST", "/api/users", `{"email":"bob@example.com"}`, nil, http.StatusBadRequest, "password is required"},
+				{"login truncated", "POST", "/api/login", `{"email":"alice@example.com"`, nil, http.StatusBadRequest, "Error decoding json"},
+				{"login array", "POST", "/api/login", `["alice@example.com"]`, nil, http.StatusBadRequest, "Error decoding json"},
+				{"login no email", "POST", "/api/login", `{"password":"secret"}`, nil, http.StatusBadRequest, "email is required"},
+				{"login no password", "POST", "/api/login", `{"email":"alice@example.com"}`, nil, http.StatusBadRequest, "password is required"},
+				{"chirp truncated", "POST", "/api/chirps", `{"body":"hi`, bearer(alice.Token), http.StatusBadRequest, "Error decoding json"},
+				{"chirp wrong type", "POST", "/api/chirps", `{"body":["hi"]}`, bearer(alice.Token), http.StatusBadRequest, "Error decoding json"},
+				{"chirp no body", "POST", "/api/chirps", `{}`, bearer(alice.Token), http.StatusBadRequest, "body is required"},
+				{"chirp empty body", "POST", "/api/chirps", `{"body":""}`, bearer(alice.Token), http.StatusBadRequest, "body is required"},
+				{"chirp edit truncated", "PUT", "/api/chirps/1", `{"body":`, bearer(alice.Token), http.StatusBadRequest, "Error decoding json"},
+				{"chirp edit bad id", "PUT", "/api/chirps/abc", `{"body":"hi"}`, bearer(alice.Token), http.StatusBadRequest, "invalid chirp id"},
+				{"chirp bad id", "GET", "/api/chirps/abc", ``, nil, http.StatusBadRequest, "invalid chirp id"},
+			}
+			for _, tt := range tests {
+				resp := ts.request(t, tt.method, tt.path, tt.body, tt.auth...)
+				if resp.status != tt.status {
+					t.Errorf("%s: status %d, want %d: %s", tt.name, resp.status, tt.status, resp.body)
+					continue
+				}
+				if got := resp.header.Get("Content-Type"); got != "application/json" {
+					t.Errorf("%s: Content-Type %q, want application/json", tt.name, got)
+				}
+				var body apitypes.ErrorResponse
+				if err := json.Unmarshal(resp.body, &body); err != nil || body.Error != tt.error {
+					t.Errorf("%s: body %s, want error %q", tt.name, resp.body, tt.error)
+				}
+			}
+		})
+	}
+}
+
+func TestRespondWithDBErrorHidesDetails(t *testing.T) {
+	err := fmt.Errorf("loading chirps: %w", &fs.PathError{Op: "open", Path: "/var/lib/chirpy/database.json", Err: fs.ErrPermission})
+	w := httptest.NewRecorder()
+	respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "/var/lib") {
+		t.Fatalf("respondWithDBError = %d %s, want 500 without the path", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("Content-Type %q, want application/json", got)
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// buffered events per subscriber before the bus starts dropping them
+const eventBufferSize = 1024
+
+// subscribeEvents returns the bus the databases publish their changes to,
+// with every subsystem that derives state from them subscribed
+func (a *apiConfig) subscribeEvents() *database.Bus {
+	bus := database.NewBus()
+	// reads right after a write must not get the old chirp, so the cache
+	// is invalidated in the write itself
+	bus.SubscribeSync(func(e database.Event) {
+		switch e.Type {
+		case database.ChirpCreated, database.ChirpDeleted, database.ChirpRestored, database.ChirpUpdated, database.ChirpBumped, database.ChirpAnonymized:
+			a.chirpCache.invalidate(e.ChirpID)
+		case database.CollectionReset:
+			a.chirpCache.clear()
+		}
+	})
+	bus.Subscribe("activity_cache", eventBufferSize, func(e database.Event) {
+		switch e.Type {
+		case database.ChirpCreated, database.ChirpDeleted, database.ChirpRestored, database.ChirpAnonymized, database.UserDeleted:
+			a.activity.invalidate(e.UserID)
+		case database.EventsDropped, database.CollectionReset:
+			a.activity.clear()
+		}
+	})
+	return bus
+}
+
+// writeEventMetrics writes how many events each subscriber lost
+func writeEventMetrics(w io.Writer, bus *database.Bus) {
+	dropped := bus.Dropped()
+	names := make([]string, 0, len(dropped))
+	for name := range dropped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "# HELP chirpy_events_dropped_total Database events a subscriber lost to a full buffer.")
+	fmt.Fprintln(w, "# TYPE chirpy_events_dropped_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "chirpy_events_dropped_total{subscriber=%q} %d\n", name, dropped[name])
+	}
+}
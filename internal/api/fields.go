@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// resourceFields are the fields ?fields= may pick, per resource type
+var resourceFields = map[string][]string{
+	"chirp": {"id", "author_id", "body", "lang", "created_at", "updated_at", "bumped_at", "source", "source_url"},
+	"feed":  {"id", "author_id", "body", "lang", "created_at", "updated_at", "bumped_at", "source", "source_url", "matched_by"},
+}
+
+// requestedFields reads the sparse fieldset of ?fields=id,body for a
+// resource. nil means every field.
+func requestedFields(r *http.Request, resource string) ([]string, error) {
+	v, ok := r.URL.Query()["fields"]
+	if !ok {
+		return nil, nil
+	}
+	fields := []string{}
+	for _, name := range strings.Split(strings.Join(v, ","), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || slices.Contains(fields, name) {
+			continue
+		}
+		if !slices.Contains(resourceFields[resource], name) {
+			return nil, fmt.Errorf("unknown %s field %q", resource, name)
+		}
+		fields = append(fields, name)
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("fields is empty")
+	}
+	return fields, nil
+}
+
+// marshalList marshals a list envelope keeping only the given fields of
+// every item, everything when fields is nil
+func marshalList[T any](env apitypes.ListEnvelope[T], fields []string) ([]byte, error) {
+	if fields == nil {
+		return json.Marshal(env)
+	}
+	items, err := pickFields(env.Items, fields)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(apitypes.ListEnvelope[map[string]json.RawMessage]{
+		Items:      items,
+		Total:      env.Total,
+		Limit:      env.Limit,
+		Offset:     env.Offset,
+		NextCursor: env.NextCursor,
+	})
+}
+
+// marshalItems marshals a bare list like marshalList marshals an envelope
+func marshalItems[T any](list []T, fields []string) ([]byte, error) {
+	if list == nil {
+		list = []T{}
+	}
+	if fields == nil {
+		return json.Marshal(list)
+	}
+	items, err := pickFields(list, fields)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(items)
+}
+
+// pickFields returns every item with only the given fields
+func pickFields[T any](list []T, fields []string) ([]map[string]json.RawMessage, error) {
+	items := make([]map[string]json.RawMessage, 0, len(list))
+	for _, item := range list {
+		b, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(b, &all); err != nil {
+			return nil, err
+		}
+		picked := make(map[string]json.RawMessage, len(fields))
+		for _, name := range fields {
+			// omitempty fields stay left out
+			if v, ok := all[name]; ok {
+				picked[name] = v
+			}
+		}
+		items = append(items, picked)
+	}
+	return items, nil
+}
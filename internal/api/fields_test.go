@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestRequestedFields(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"fields=id,body", []string{"id", "body"}, false},
+		{"fields=%20id%20,,body,id", []string{"id", "body"}, false},
+		{"fields=id&fields=author_id", []string{"id", "author_id"}, false},
+		{"fields=id,password", nil, true},
+		{"fields=matched_by", nil, true}, // a feed field only
+		{"fields=", nil, true},
+		{"fields=,", nil, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/chirps?"+tt.query, nil)
+		got, err := requestedFields(r, "chirp")
+		if !slices.Equal(got, tt.want) || (err != nil) != tt.wantErr || (tt.want == nil) != (got == nil) {
+			t.Errorf("requestedFields(%q) = %q, %v, want %q", tt.query, got, err, tt.want)
+		}
+	}
+	r := httptest.NewRequest("GET", "/api/feed?fields=matched_by", nil)
+	if got, err := requestedFields(r, "feed"); err != nil || !slices.Equal(got, []string{"matched_by"}) {
+		t.Errorf("feed fields = %q, %v", got, err)
+	}
+}
+
+func TestMarshalFields(t *testing.T) {
+	chirps := []apitypes.Chirp{{ID: 1, AuthorID: 2, Body: "hi"}}
+
+	// an omitempty field that is empty stays out rather than coming back null
+	b, err := marshalItems(chirps, []string{"id", "source_url"})
+	if err != nil || string(b) != `[{"id":1}]` {
+		t.Fatalf("marshalItems = %s, %v", b, err)
+	}
+	b, err = marshalItems[apitypes.Chirp](nil, []string{"id"})
+	if err != nil || string(b) != `[]` {
+		t.Fatalf("marshalItems of nothing = %s, %v", b, err)
+	}
+
+	// the envelope is kept whole, only the items are cut
+	b, err = marshalList(newListEnvelope(chirps, 5, 1, 2), []string{"body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env apitypes.ListEnvelope[map[string]any]
+	if err := json.Unmarshal(b, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Total != 5 || env.Limit != 1 || env.Offset != 2 || len(env.Items) != 1 || len(env.Items[0]) != 1 || env.Items[0]["body"] != "hi" {
+		t.Fatalf("marshalList = %s", b)
+	}
+	full, err := marshalList(newListEnvelope(chirps, 5, 1, 2), nil)
+	if want, _ := json.Marshal(newListEnvelope(chirps, 5, 1, 2)); err != nil || string(full) != string(want) {
+		t.Fatalf("marshalList without fields = %s, want %s", full, want)
+	}
+}
+
+func TestFieldsParam(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	ts.postChirp(t, alice.Token, "hello")
+
+	page := decode[apitypes.ListEnvelope[map[string]any]](t, ts.request(t, "GET", "/api/chirps?envelope=true&fields=body,id", nil).expect(t, http.StatusOK))
+	if page.Total != 1 || len(page.Items) != 1 || len(page.Items[0]) != 2 || page.Items[0]["body"] != "hello" {
+		t.Fatalf("page = %+v", page)
+	}
+	resp := ts.request(t, "GET", "/api/chirps?fields=id,email", nil).expect(t, http.StatusBadRequest)
+	if got := errorOf(t, resp); got != `unknown chirp field "email"` {
+		t.Fatalf("error = %q", got)
+	}
+	ts.request(t, "GET", "/api/feed?fields=nope", nil, bearer(alice.Token)...).expect(t, http.StatusBadRequest)
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// seededRandom is a deterministic source of tokens, safe for the
+// concurrent reads of a server
+type seededRandom struct {
+	mux *sync.Mutex
+	rng *rand.Rand
+}
+
+func newSeededRandom(seed int64) seededRandom {
+	return seededRandom{mux: &sync.Mutex{}, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (r seededRandom) Read(p []byte) (int, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.rng.Read(p)
+}
+
+// newFixtureServer starts a server on a copy of testdata/fixture.json, with
+// a fake clock and seeded tokens
+func newFixtureServer(t *testing.T) *testServer {
+	t.Helper()
+	fixture, err := os.ReadFile(filepath.Join("testdata", "fixture.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, databaseFile)
+	if err := os.WriteFile(path, fixture, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestServerWithConfig(t, db, Config{DataDir: dir, Random: newSeededRandom(1)})
+}
+
+// normalizedFields are replaced in golden files, tokens because they
+// change with every claim added and times so fixtures can be regenerated
+var normalizedFields = map[string]string{
+	"token":          "<token>",
+	"refresh_token":  "<token>",
+	"confirm_token":  "<token>",
+	"undo_token":     "<token>",
+	"last_used_at":   "<time>",
+	"expires_at":     "<time>",
+	"created_at":     "<time>",
+	"updated_at":     "<time>",
+	"deleted_at":     "<time>",
+	"totp_setup_uri": "<uri>",
+}
+
+// normalize replaces the values of normalizedFields anywhere in v
+func normalize(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if placeholder, ok := normalizedFields[key]; ok && value != nil && value != "" {
+				v[key] = placeholder
+				continue
+			}
+			v[key] = normalize(value)
+		}
+	case []any:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+	}
+	return v
+}
+
+// golden is what a golden file holds of a response
+type golden struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        any    `json:"body"` // as a string when it isn't json
+}
+
+// checkGolden compares resp to testdata/golden/<name>.json, rewriting the
+// file with -update
+func checkGolden(t *testing.T, name string, resp testResponse) {
+	t.Helper()
+	got := golden{Status: resp.status, ContentType: resp.header.Get("Content-Type")}
+	var body any
+	if err := json.Unmarshal(resp.body, &body); err == nil {
+		got.Body = normalize(body)
+	} else {
+		got.Body = string(resp.body)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(got); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *update {
+		if err := os.WriteFile(path, encoded, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run go test -run TestGolden -update to create it", err)
+	}
+	if !bytes.Equal(encoded, want) {
+		t.Errorf("%s differs from %s, run go test -run TestGolden -update if the change is intended\ngot:\n%s\nwant:\n%s", name, path, encoded, want)
+	}
+}
+
+func TestGoldenResponses(t *testing.T) {
+	setTestEnv(t)
+	ts := newFixtureServer(t)
+	alice := decode[apitypes.LoginResponse](t, ts.request(t, "POST", "/api/login",
+		apitypes.UserRequest{Email: "alice@example.com", Password: testPassword}).expect(t, http.StatusOK))
+
+	// run in order against one server, later cases see what earlier ones wrote
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		body    any
+		headers []string
+	}{
+		{"get_chirps", "GET", "/api/chirps", nil, nil},
+		{"get_chirps_envelope", "GET", "/api/chirps?envelope=true&limit=2", nil, nil},
+		{"get_chirps_by_author", "GET", "/api/chirps?author_id=1&sort=desc", nil, nil},
+		{"get_chirp", "GET", "/api/chirps/3", nil, nil},
+		{"get_deleted_chirp", "GET", "/api/chirps/5", nil, nil},
+		{"get_chirp_bad_id", "GET", "/api/chirps/abc", nil, nil},
+		{"get_users", "GET", "/api/users", nil, nil},
+		{"get_user", "GET", "/api/users/2", nil, nil},
+		{"get_missing_user", "GET", "/api/users/99", nil, nil},
+		{"create_user", "POST", "/api/users", apitypes.UserRequest{Email: "dave@example.com", Password: testPassword}, nil},
+		{"create_user_taken", "POST", "/api/users", apitypes.UserRequest{Email: "ALICE@example.com", Password: testPassword}, nil},
+		{"login", "POST", "/api/login", apitypes.UserRequest{Email: "bob@example.com", Password: testPassword}, nil},
+		{"login_wrong_password", "POST", "/api/login", apitypes.UserRequest{Email: "bob@example.com", Password: "wrong"}, nil},
+		{"create_chirp", "POST", "/api/chirps", apitypes.CreateChirpRequest{Body: "a golden chirp"}, bearer(alice.Token)},
+		{"create_chirp_too_long", "POST", "/api/chirps", apitypes.CreateChirpRequest{Body: strings.Repeat("a", 141)}, bearer(alice.Token)},
+		{"create_chirp_unauthenticated", "POST", "/api/chirps", apitypes.CreateChirpRequest{Body: "no token"}, nil},
+		{"update_chirp", "PUT", "/api/chirps/1", apitypes.UpdateChirpRequest{Body: "first chirp, edited"}, bearer(alice.Token)},
+		{"update_chirp_of_other", "PUT", "/api/chirps/2", apitypes.UpdateChirpRequest{Body: "not mine"}, bearer(alice.Token)},
+		{"get_limits", "GET", "/api/users/me/limits", nil, bearer(alice.Token)},
+		{"refresh", "POST", "/api/refresh", nil, bearer(alice.RefreshToken)},
+		{"delete_chirp", "DELETE", "/api/chirps/1", nil, bearer(alice.Token)},
+		{"get_chirps_after_writes", "GET", "/api/chirps", nil, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			checkGolden(t, c.name, ts.request(t, c.method, c.path, c.body, c.headers...))
+		})
+	}
+}
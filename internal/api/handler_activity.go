@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+const (
+	defaultActivityDays = 90
+	activityCacheTTL    = 10 * time.Minute
+)
+
+// activityBucket is the number of chirps a user posted on one UTC day
+type activityBucket struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+type activityEntry struct {
+	buckets   []activityBucket
+	expiresAt time.Time
+}
+
+// activityCache keeps computed activity per user and day range.
+// entries are dropped when the user's chirps change and otherwise live
+// for 10 minutes.
+type activityCache struct {
+	mux     *sync.Mutex
+	entries map[[2]int]activityEntry // keyed by user ID and days
+	clock   clock.Clock
+}
+
+func newActivityCache(clk clock.Clock) *activityCache {
+	return &activityCache{
+		mux:     &sync.Mutex{},
+		entries: make(map[[2]int]activityEntry),
+		clock:   clk,
+	}
+}
+
+func (c *activityCache) get(userID, days int) ([]activityBucket, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	entry, ok := c.entries[[2]int{userID, days}]
+	if !ok || c.clock.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.buckets, true
+}
+
+func (c *activityCache) put(userID, days int, buckets []activityBucket) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	now := c.clock.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[[2]int{userID, days}] = activityEntry{buckets: buckets, expiresAt: now.Add(activityCacheTTL)}
+}
+
+// invalidate drops the cached activity of the user
+func (c *activityCache) invalidate(userID int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for k := range c.entries {
+		if k[0] == userID {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// clear drops all cached activity
+func (c *activityCache) clear() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	clear(c.entries)
+}
+
+// GET /api/users/{id}/activity
+// userActivity returns the user's chirps per UTC day for the last ?days days
+// (90 by default), oldest first with a bucket for every day
+func (a *apiConfig) userActivity(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	days := defaultActivityDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		days, err = strconv.Atoi(v)
+		if err != nil || days <= 0 {
+			respondWithError(w, http.StatusBadRequest, "invalid days")
+			return
+		}
+		if maxDays := a.settings().caps.maxActivityDays; days > maxDays {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("days must be at most %d", maxDays))
+			return
+		}
+	}
+
+	_, err = a.db.GetUserByID(userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	buckets, ok := a.activity.get(userID, days)
+	if !ok {
+		today := a.clock.Now().UTC().Truncate(24 * time.Hour)
+		first := today.AddDate(0, 0, -(days - 1))
+		counts, err := a.chirpyDatabase.ChirpActivity(userID, first)
+		if err != nil {
+			respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		buckets = make([]activityBucket, 0, days)
+		for day := first; !day.After(today); day = day.AddDate(0, 0, 1) {
+			buckets = append(buckets, activityBucket{Date: day.Format(time.DateOnly), Count: counts[day]})
+		}
+		a.activity.put(userID, days, buckets)
+	}
+
+	resp, err := json.Marshal(buckets)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// userErrStore fails every user lookup with err
+type userErrStore struct {
+	database.Store
+	err error
+}
+
+func (s userErrStore) GetUserByID(ID int) (database.User, error) {
+	return database.User{}, s.err
+}
+
+func TestUserActivityErrors(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	ts.request(t, "GET", "/api/users/99/activity", nil).expect(t, http.StatusNotFound)
+
+	// only a missing user is not found, a failing store is not
+	for err, want := range map[error]int{
+		errors.New("disk on fire"):     http.StatusInternalServerError,
+		database.ErrStorageUnavailable: http.StatusServiceUnavailable,
+		database.ErrUserNotFound:       http.StatusNotFound,
+	} {
+		dir := t.TempDir()
+		db, dbErr := database.NewDB(filepath.Join(dir, databaseFile))
+		if dbErr != nil {
+			t.Fatal(dbErr)
+		}
+		ts := newTestServerWithStore(t, userErrStore{Store: db, err: err}, dir)
+		ts.request(t, "GET", "/api/users/1/activity", nil).expect(t, want)
+	}
+}
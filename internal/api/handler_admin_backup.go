@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// exportChunkSize is how much of a backup is written between shutdown checks
+const exportChunkSize = 32 << 10
+
+// GET /admin/export
+// exportBackup streams the users and chirps as one json document. password
+// hashes, tokens and totp secrets are left out unless include_secrets=true,
+// which is what a backup that can be imported again needs.
+// a shutdown ends the stream instead of waiting out a slow download.
+func (a *apiConfig) exportBackup(w http.ResponseWriter, r *http.Request) {
+	includeSecrets := r.URL.Query().Get("include_secrets") == "true"
+	backup, err := a.db.Export(includeSecrets)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	body, err := json.Marshal(backup)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	body = append(body, '\n')
+	if a.shuttingDown.Err() != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+
+	// the write deadline fails a write blocked on the client, the loop
+	// stops between chunks
+	deadlineSet := make(chan struct{})
+	stop := context.AfterFunc(a.shuttingDown, func() {
+		http.NewResponseController(w).SetWriteDeadline(time.Now())
+		close(deadlineSet)
+	})
+	defer func() {
+		if !stop() {
+			<-deadlineSet
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-backup.json"`)
+	for len(body) > 0 {
+		if a.shuttingDown.Err() != nil {
+			log.Print("export: stopped by shutdown")
+			return
+		}
+		n := min(len(body), exportChunkSize)
+		if _, err := w.Write(body[:n]); err != nil {
+			log.Printf("export: %v", err)
+			return
+		}
+		body = body[n:]
+	}
+}
+
+// describeImport tells the admin how much an import replaces, as counted
+// now. the body is only read by the confirmed call.
+func (a *apiConfig) describeImport(r *http.Request) (string, error) {
+	users, err := a.countCollection("users")
+	if err != nil {
+		return "", err
+	}
+	chirps, err := a.countCollection("chirps")
+	if err != nil {
+		return "", err
+	}
+	return "this will replace " + countOf(users, "users") + " and " + countOf(chirps, "chirps") + " with the backup", nil
+}
+
+// POST /admin/import
+// importBackup replaces the users and chirps with a backup of
+// GET /admin/export?include_secrets=true. a body that doesn't decode or
+// doesn't hold together leaves the current data untouched. it runs behind
+// requireConfirmation.
+func (a *apiConfig) importBackup(w http.ResponseWriter, r *http.Request) {
+	var backup database.Backup
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&backup); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid backup: "+err.Error())
+		return
+	}
+
+	err := a.db.Import(backup)
+	if errors.Is(err, database.ErrInvalidBackup) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	resp, err := json.Marshal(struct {
+		Users  int `json:"users"`
+		Chirps int `json:"chirps"`
+	}{len(backup.Users), len(backup.Chirps)})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// seedBackupData fills ts with users, a live and a deleted chirp and an
+// identity, what a backup has to carry over
+func seedBackupData(t *testing.T, ts *testServer) {
+	t.Helper()
+	alice := ts.newUser(t, "alice@example.com")
+	bob := ts.newUser(t, "bob@example.com")
+	ts.postChirp(t, alice.Token, "kept")
+	deleted := ts.postChirp(t, bob.Token, "deleted")
+	ts.request(t, "DELETE", chirpPath(deleted.ID), nil, bearer(bob.Token)...).expect(t, http.StatusOK)
+	if err := ts.store.LinkIdentity(alice.ID, "github", "42"); err != nil {
+		t.Fatal(err)
+	}
+	ts.postChirp(t, bob.Token, "newest")
+}
+
+// export downloads a backup, with the secrets an import needs
+func (ts *testServer) export(t *testing.T, includeSecrets bool) []byte {
+	t.Helper()
+	path := "/admin/export"
+	if includeSecrets {
+		path += "?include_secrets=true"
+	}
+	return ts.request(t, "GET", path, nil, asAdmin()...).expect(t, http.StatusOK).body
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			source := start(t)
+			seedBackupData(t, source)
+			backup := source.export(t, true)
+
+			// into a server of either backend, the other one included
+			for targetName, startTarget := range map[string]func(testing.TB) *testServer{
+				"json":   newTestServer,
+				"sqlite": newSQLiteTestServer,
+			} {
+				t.Run("to "+targetName, func(t *testing.T) {
+					target := startTarget(t)
+					target.newUser(t, "replaced@example.com")
+
+					resp := target.confirmed(t, "POST", "/admin/import", backup, asAdmin()...).expect(t, http.StatusOK)
+					counts := decode[struct {
+						Users  int `json:"users"`
+						Chirps int `json:"chirps"`
+					}](t, resp)
+					if counts.Users != 2 || counts.Chirps != 3 {
+						t.Fatalf("import counts = %+v, want 2 users and 3 chirps", counts)
+					}
+					if got := target.export(t, true); !bytes.Equal(got, backup) {
+						t.Fatalf("export after the import differs:\n%s\nwant\n%s", got, backup)
+					}
+
+					// the accounts work, and IDs carry on where they left off
+					alice := target.login(t, "alice@example.com")
+					target.request(t, "POST", "/api/login", apitypes.UserRequest{Email: "replaced@example.com", Password: testPassword}).
+						expect(t, http.StatusUnauthorized)
+					if chirp := target.postChirp(t, alice.Token, "after the import"); chirp.ID != 4 {
+						t.Fatalf("chirp after the import got ID %d, want 4", chirp.ID)
+					}
+					if user, err := target.store.GetUserByIdentity("github", "42"); err != nil || user.ID != alice.ID {
+						t.Fatalf("identity after the import = %+v, %v", user, err)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestBackupImportRejected(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	seedBackupData(t, ts)
+	before := ts.export(t, true)
+
+	// without the confirmation nothing is read or replaced
+	first := ts.request(t, "POST", "/admin/import", ts.export(t, true), asAdmin()...).expect(t, http.StatusPreconditionRequired)
+	if got := decode[confirmChallenge](t, first).Description; got != "this will replace 2 users and 3 chirps with the backup" {
+		t.Fatalf("description = %q", got)
+	}
+
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"redacted", ts.export(t, false)},
+		{"not json", []byte(`{"users": [`)},
+		{"unknown field", []byte(`{"users": [], "chirps": [], "extra": true}`)},
+		{"duplicate email", []byte(`{"users": [{"id": 1, "email": "dup@example.com", "password": "aGFzaA=="}, {"id": 2, "email": "DUP@example.com", "password": "aGFzaA=="}], "chirps": []}`)},
+		{"chirp of a missing user", []byte(`{"users": [], "chirps": [{"id": 1, "author_id": 7, "body": "orphan"}], "next_chirp_id": 1}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts.confirmed(t, "POST", "/admin/import", tt.body, asAdmin()...).expect(t, http.StatusBadRequest)
+			if got := ts.export(t, true); !bytes.Equal(got, before) {
+				t.Fatal("a rejected import changed the data")
+			}
+		})
+	}
+}
+
+// stalledWriter is a client that stops reading: writes block until the
+// write deadline is set
+type stalledWriter struct {
+	header   http.Header
+	writing  chan struct{}
+	deadline chan struct{}
+	once     *sync.Once
+}
+
+func newStalledWriter() *stalledWriter {
+	return &stalledWriter{header: http.Header{}, writing: make(chan struct{}, 1), deadline: make(chan struct{}), once: &sync.Once{}}
+}
+
+func (w *stalledWriter) Header() http.Header { return w.header }
+
+func (w *stalledWriter) WriteHeader(int) {}
+
+func (w *stalledWriter) Write(b []byte) (int, error) {
+	select {
+	case w.writing <- struct{}{}:
+	default:
+	}
+	<-w.deadline
+	return 0, os.ErrDeadlineExceeded
+}
+
+func (w *stalledWriter) SetWriteDeadline(time.Time) error {
+	w.once.Do(func() { close(w.deadline) })
+	return nil
+}
+
+func TestExportStopsOnShutdown(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	seedBackupData(t, ts)
+
+	w := newStalledWriter()
+	done := make(chan struct{})
+	go func() {
+		ts.api.exportBackup(w, httptest.NewRequest("GET", "/admin/export", nil))
+		close(done)
+	}()
+	<-w.writing
+	ts.api.startShutdown()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the export kept writing to a stalled client after the shutdown started")
+	}
+
+	// and a new one isn't started
+	ts.request(t, "GET", "/admin/export", nil, asAdmin()...).expect(t, http.StatusServiceUnavailable)
+}
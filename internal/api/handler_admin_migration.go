@@ -0,0 +1,32 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// migrationStatus is the response of GET /admin/migration/status
+type migrationStatus struct {
+	DualWrite bool `json:"dual_write"`
+	*database.MigrationReport
+}
+
+// GET /admin/migration/status
+// migrationStatus reports what the secondary store did differently from the
+// primary since the server started, with DATABASE_SECONDARY_URL set. a
+// secondary without divergences can become the primary.
+func (a *apiConfig) migrationStatus(w http.ResponseWriter, r *http.Request) {
+	status := migrationStatus{}
+	if a.migration != nil {
+		report := a.migration.Report()
+		status = migrationStatus{DualWrite: true, MigrationReport: &report}
+	}
+	resp, err := json.Marshal(status)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// sessionView is the refresh token state of a user as the admin sees it,
+// never the token itself
+type sessionView struct {
+	UserID          int        `json:"user_id"`
+	Email           string     `json:"email"`
+	HasRefreshToken bool       `json:"has_refresh_token"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	// Active is a stored token that is still accepted, tokens stored before
+	// expiries were tracked count as expired
+	Active bool `json:"active"`
+	// OtherSessions counts the unexpired sessions opened by token exchanges
+	OtherSessions int `json:"other_sessions"`
+}
+
+// GET /admin/sessions
+// listSessions shows which users have a refresh token stored, sorted by ID
+func (a *apiConfig) listSessions(w http.ResponseWriter, r *http.Request) {
+	users, err := a.db.GetUser()
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	now := a.clock.Now()
+	sessions := make([]sessionView, 0, len(users))
+	for _, user := range users {
+		hasToken := user.RefreshToken != ""
+		others := 0
+		for _, s := range user.Sessions {
+			if now.Before(s.ExpiresAt) {
+				others++
+			}
+		}
+		sessions = append(sessions, sessionView{
+			UserID:          user.ID,
+			Email:           user.Email,
+			HasRefreshToken: hasToken,
+			ExpiresAt:       user.RefreshTokenExpiresAt,
+			Active:          hasToken && user.RefreshTokenExpiresAt != nil && now.Before(*user.RefreshTokenExpiresAt),
+			OtherSessions:   others,
+		})
+	}
+
+	resp, err := json.Marshal(sessions)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// POST /admin/revoke/{userID}
+// forceRevokeToken revokes the refresh token of a user, e.g. a compromised
+// account. access tokens already handed out stay valid until they expire.
+func (a *apiConfig) forceRevokeToken(w http.ResponseWriter, r *http.Request) {
+	ID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if _, err := a.db.GetUserByID(ID); errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := a.db.RevokeToken(ID); err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Error revoking token")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestAdminSessions(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	bob := ts.newUser(t, "bob@example.com")
+	ts.request(t, "POST", "/api/revoke", nil, bearer(bob.RefreshToken)...).expect(t, http.StatusOK)
+
+	ts.request(t, "GET", "/admin/sessions", nil).expect(t, http.StatusUnauthorized)
+	ts.request(t, "GET", "/admin/sessions", nil, bearer(alice.Token)...).expect(t, http.StatusForbidden)
+	resp := ts.request(t, "GET", "/admin/sessions", nil, asAdmin()...).expect(t, http.StatusOK)
+
+	// neither the tokens nor what is stored of them
+	stored, err := ts.store.GetUserByID(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{alice.RefreshToken, alice.Token, stored.RefreshToken, stored.RefreshTokenFamily} {
+		if secret != "" && bytes.Contains(resp.body, []byte(secret)) {
+			t.Fatalf("sessions show a token: %s", resp.body)
+		}
+	}
+	sessions := decode[[]sessionView](t, resp)
+	if len(sessions) != 2 || !sessions[0].Active || !sessions[0].HasRefreshToken || sessions[0].Email != "alice@example.com" {
+		t.Fatalf("sessions = %+v, want alice active", sessions)
+	}
+	if sessions[1].Active || sessions[1].HasRefreshToken {
+		t.Fatalf("bob's session was revoked, got %+v", sessions[1])
+	}
+}
+
+func TestAdminRevoke(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	path := "/admin/revoke/" + strconv.Itoa(alice.ID)
+
+	// a user can't revoke, not even their own session
+	ts.request(t, "POST", path, nil).expect(t, http.StatusUnauthorized)
+	ts.request(t, "POST", path, nil, bearer(alice.Token)...).expect(t, http.StatusForbidden)
+	refreshed := decode[apitypes.RefreshResponse](t, ts.request(t, "POST", "/api/refresh", nil, bearer(alice.RefreshToken)...).expect(t, http.StatusOK))
+
+	ts.request(t, "POST", "/admin/revoke/nope", nil, asAdmin()...).expect(t, http.StatusBadRequest)
+	ts.request(t, "POST", "/admin/revoke/99", nil, asAdmin()...).expect(t, http.StatusNotFound)
+	ts.request(t, "POST", path, nil, asAdmin()...).expect(t, http.StatusNoContent)
+	ts.request(t, "POST", "/api/refresh", nil, bearer(refreshed.RefreshToken)...).expect(t, http.StatusUnauthorized)
+	// the access token lives until it expires
+	ts.request(t, "GET", "/api/users/me/limits", nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/skip2/go-qrcode"
+)
+
+// sizes of the qr code image in pixels, out of range sizes are clamped
+const (
+	qrDefaultSize = 256
+	qrMinSize     = 128
+	qrMaxSize     = 1024
+)
+
+// GET /api/chirps/{chirpID}/qr.png
+// chirpQRCode returns a png qr code of the chirp's permalink, ?size= pixels
+// wide. the permalink never changes, so the image is cached for a year.
+func (a *apiConfig) chirpQRCode(w http.ResponseWriter, r *http.Request) {
+	ID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+	size := qrDefaultSize
+	if v := r.URL.Query().Get("size"); v != "" {
+		size, err = strconv.Atoi(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid size")
+			return
+		}
+		size = min(max(size, qrMinSize), qrMaxSize)
+	}
+
+	vis, err := a.chirpVisibility(r)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	chirp, err := a.chirpyDatabase.GetChirpyFromID(ID)
+	if errors.Is(err, database.ErrChirpNotFound) || (err == nil && !vis.canSee(chirp.AuthorID)) {
+		respondWithError(w, http.StatusNotFound, database.ErrChirpNotFound.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	png, err := qrcode.Encode(chirpPermalink(r, ID), qrcode.Medium, size)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error encoding qr code")
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	if vis.varies {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	w.Write(png)
+}
+
+// chirpPermalink is the public url of a chirp on the host the request came to
+func chirpPermalink(r *http.Request, ID int) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/api/chirps/" + strconv.Itoa(ID)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"crypto/tls"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestChirpQRCodeSize(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	chirp := ts.postChirp(t, alice.Token, "scan me")
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"default", "", qrDefaultSize},
+		{"smallest", "?size=128", 128},
+		{"in range", "?size=300", 300},
+		{"largest", "?size=1024", 1024},
+		{"clamped up", "?size=10", qrMinSize},
+		{"zero clamped up", "?size=0", qrMinSize},
+		{"negative clamped up", "?size=-500", qrMinSize},
+		{"clamped down", "?size=5000", qrMaxSize},
+	}
+	for _, tt := range tests {
+		resp := ts.request(t, "GET", chirpPath(chirp.ID)+"/qr.png"+tt.query, nil).expect(t, http.StatusOK)
+		if got := resp.header.Get("Content-Type"); got != "image/png" {
+			t.Errorf("%s: Content-Type %q", tt.name, got)
+		}
+		if got := resp.header.Get("Cache-Control"); got != "public, max-age=31536000, immutable" {
+			t.Errorf("%s: Cache-Control %q", tt.name, got)
+		}
+		img, err := png.DecodeConfig(bytes.NewReader(resp.body))
+		if err != nil {
+			t.Fatalf("%s: decoding the png: %v", tt.name, err)
+		}
+		if img.Width != tt.want || img.Height != tt.want {
+			t.Errorf("%s: %dx%d px, want %dx%d", tt.name, img.Width, img.Height, tt.want, tt.want)
+		}
+	}
+
+	ts.request(t, "GET", chirpPath(chirp.ID)+"/qr.png?size=big", nil).expect(t, http.StatusBadRequest)
+	ts.request(t, "GET", "/api/chirps/abc/qr.png", nil).expect(t, http.StatusBadRequest)
+	ts.request(t, "GET", chirpPath(999)+"/qr.png", nil).expect(t, http.StatusNotFound)
+	ts.request(t, "DELETE", chirpPath(chirp.ID), nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+	ts.request(t, "GET", chirpPath(chirp.ID)+"/qr.png", nil).expect(t, http.StatusNotFound)
+}
+
+func TestChirpQRCodeVisibility(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	bob := ts.newUser(t, "bob@example.com")
+	carol := ts.newUser(t, "carol@example.com")
+	secret := ts.postChirp(t, alice.Token, "followers only")
+	ts.request(t, "POST", "/api/users/"+strconv.Itoa(alice.ID)+"/follow", nil, bearer(bob.Token)...).expect(t, http.StatusOK)
+	ts.request(t, "PUT", "/api/users/me/privacy", apitypes.PrivacyRequest{Protected: true}, bearer(alice.Token)...).expect(t, http.StatusOK)
+
+	tests := []struct {
+		name    string
+		headers []string
+		status  int
+	}{
+		{"anonymous", nil, http.StatusNotFound},
+		{"stranger", bearer(carol.Token), http.StatusNotFound},
+		{"follower", bearer(bob.Token), http.StatusOK},
+		{"author", bearer(alice.Token), http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp := ts.request(t, "GET", chirpPath(secret.ID)+"/qr.png?size=200", nil, tt.headers...)
+		if resp.status != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.status, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		// shared caches must not hand a follower's code to anyone else
+		if got := resp.header.Get("Cache-Control"); got != "private, max-age=31536000, immutable" {
+			t.Errorf("%s: Cache-Control %q", tt.name, got)
+		}
+		if img, err := png.DecodeConfig(bytes.NewReader(resp.body)); err != nil || img.Width != 200 {
+			t.Errorf("%s: png %+v, %v, want 200px wide", tt.name, img, err)
+		}
+	}
+}
+
+func TestChirpPermalink(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/chirps/7/qr.png", nil)
+	r.Host = "chirpy.example.com"
+	if got, want := chirpPermalink(r, 7), "http://chirpy.example.com/api/chirps/7"; got != want {
+		t.Fatalf("chirpPermalink = %q, want %q", got, want)
+	}
+	r.TLS = &tls.ConnectionState{}
+	if got, want := chirpPermalink(r, 7), "https://chirpy.example.com/api/chirps/7"; got != want {
+		t.Fatalf("chirpPermalink over tls = %q, want %q", got, want)
+	}
+}
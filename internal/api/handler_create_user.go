@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// create users POST /api/users
+func (a *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
+	//decode request json to user struct
+	userReq := apitypes.UserRequest{}
+	err := json.NewDecoder(r.Body).Decode(&userReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+	if msg, ok := missingCredentials(userReq); !ok {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if !a.emailDomainAllowed(userReq.Email) {
+		respondWithErrorCode(w, http.StatusForbidden, "registration is not open to this email domain", apitypes.ErrorCodeEmailDomainNotAllowed)
+		return
+	}
+
+	// hash the password using bcrypt
+	cost := bcrypt.DefaultCost
+	password, err := bcrypt.GenerateFromPassword([]byte(userReq.Password), cost)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error creating user")
+		return
+	}
+
+	// create new user, the email is checked under the write lock so two
+	// signups with the same email can't both get through
+	createdDB, err := a.db.CreateUser(userReq.Email, password)
+	if errors.Is(err, database.ErrUserExists) {
+		respondWithErrorCode(w, http.StatusConflict, "This Email already exists", apitypes.ErrorCodeEmailTaken)
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	// create completed response with 201 and encoding user data from database
+	// using anonymous struct to response specific field (exclude password)
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(apitypes.UserResponse{
+		Email:       createdDB.Email,
+		ID:          createdDB.ID,
+		IsChirpyRed: createdDB.IsChirpyRed,
+		Version:     createdDB.Version,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error Encoding json")
+		return
+	}
+
+}
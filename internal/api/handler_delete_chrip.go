@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// how long a deleted chirp can be restored with its undo token
+const undoDeleteWindow = 30 * time.Second
+
+// DELETE /api/chirps/{chirpID}
+// deleteChirpyFromID delete chirpy from specific id
+// authoriztion before deletion
+// the chirp is tombstoned and the response carries an undo_token
+// valid for 30 seconds at POST /api/chirps/{chirpID}/undelete
+func (a *apiConfig) deleteChirpyFromID(w http.ResponseWriter, r *http.Request) {
+	chirpID := r.PathValue("chirpID")
+	ID, err := strconv.Atoi(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	token, err := a.validateToken(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if claims, ok := token.Claims.(*CustomClaims); ok {
+		if !isAcessToken(claims.Issuer) {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		userID := claims.UserID
+		err := a.chirpyDatabase.DeleteDB(userID, ID)
+		if errors.Is(err, database.ErrChirpNotFound) {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, database.ErrNotChirpAuthor) {
+			respondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		undoToken, err := a.undoTokens.issue("undelete-chirp", undoParams(userID, ID))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error creating undo token")
+			return
+		}
+		resp, err := json.Marshal(apitypes.DeleteChirpResponse{
+			UndoToken: undoToken,
+			ExpiresIn: int(undoDeleteWindow.Seconds()),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+			return
+		}
+		w.Write(resp)
+	}
+}
+
+// undoParams binds an undo token to the user and the chirp
+func undoParams(userID, chirpID int) string {
+	return fmt.Sprintf("user=%d chirp=%d", userID, chirpID)
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// DELETE /api/users
+// deleteUser deletes the account of the logged in user with their refresh
+// token, and deletes their chirps and follows
+func (a *apiConfig) deleteUser(w http.ResponseWriter, r *http.Request) {
+	token, err := a.validateToken(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || !isAcessToken(claims.Issuer) {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	err = a.db.DeleteUser(claims.UserID, database.DeleteAuthorChirps)
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	// the account is gone either way, a follow left behind only skews counts
+	if err := a.follows.RemoveUserFromFollows(claims.UserID); err != nil {
+		log.Printf("removing the follows of deleted user %d: %v", claims.UserID, err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
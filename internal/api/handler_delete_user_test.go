@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestDeleteUser(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := start(t)
+			alice := ts.newUser(t, "alice@example.com")
+			bob := ts.newUser(t, "bob@example.com")
+			ts.postChirp(t, alice.Token, "going away")
+			kept := ts.postChirp(t, bob.Token, "staying")
+
+			// only a valid access token deletes the account
+			ts.request(t, "DELETE", "/api/users", nil).expect(t, http.StatusUnauthorized)
+			ts.request(t, "DELETE", "/api/users", nil, bearer("not a token")...).expect(t, http.StatusUnauthorized)
+			ts.request(t, "DELETE", "/api/users", nil, bearer(alice.RefreshToken)...).expect(t, http.StatusUnauthorized)
+
+			ts.request(t, "DELETE", "/api/users", nil, bearer(alice.Token)...).expect(t, http.StatusNoContent)
+			ts.request(t, "GET", "/api/users/"+strconv.Itoa(alice.ID), nil).expect(t, http.StatusNotFound)
+			if got := ts.visibleChirps(t); !slices.Equal(got, []int{kept.ID}) {
+				t.Fatalf("chirps after the deletion = %v, want only bob's", got)
+			}
+			// the tokens of the account are dead, the email is free again
+			ts.request(t, "POST", "/api/refresh", nil, bearer(alice.RefreshToken)...).expect(t, http.StatusUnauthorized)
+			ts.request(t, "POST", "/api/chirps", map[string]string{"body": "still here?"}, bearer(alice.Token)...).expect(t, http.StatusUnauthorized)
+			ts.request(t, "DELETE", "/api/users", nil, bearer(alice.Token)...).expect(t, http.StatusUnauthorized)
+			ts.request(t, "POST", "/api/login", map[string]string{"email": "alice@example.com", "password": testPassword}).expect(t, http.StatusUnauthorized)
+			ts.signup(t, "alice@example.com")
+
+			// other accounts are untouched
+			ts.request(t, "GET", chirpPath(kept.ID), nil).expect(t, http.StatusOK)
+			ts.request(t, "POST", "/api/refresh", nil, bearer(bob.RefreshToken)...).expect(t, http.StatusOK)
+		})
+	}
+}
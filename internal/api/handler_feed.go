@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// bumpCooldown is how long a chirp has to wait before it can be bumped again
+const bumpCooldown = 7 * 24 * time.Hour
+
+// POST /api/chirps/{chirpID}/bump
+// bumpChirpy moves an old chirp of the caller back to the top of the
+// followers' feeds, once per chirp every 7 days. the global list and the
+// chirp's own times don't change.
+func (a *apiConfig) bumpChirpy(w http.ResponseWriter, r *http.Request) {
+	ID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+	author, ok := a.chirpAuthor(w, r)
+	if !ok {
+		return
+	}
+
+	chirp, err := a.chirpyDatabase.BumpChirp(author.ID, ID, bumpCooldown)
+	if errors.Is(err, database.ErrChirpNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrNotChirpAuthor) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrBumpTooSoon) {
+		if bumped, err := a.chirpyDatabase.GetChirpyFromID(ID); err == nil && bumped.BumpedAt != nil {
+			wait := bumped.BumpedAt.Add(bumpCooldown).Sub(a.clock.Now())
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		}
+		respondWithError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	resp, err := json.Marshal(chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// GET /api/feed
+// getFeed returns a page of the chirps of the caller, everyone they follow
+// and the hashtags they follow, the latest created or bumped first. edits
+// don't move a chirp. matched_by says which of those put each chirp there.
+func (a *apiConfig) getFeed(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	q, err := a.chirpQuery(r, true)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields, err := requestedFields(r, "feed")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// pending requests aren't follows, so every followed author may be read
+	following, err := a.follows.Following(caller.ID)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	tags, err := a.follows.FollowedHashtags(caller.ID)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	// but a tag may match anyone's chirps
+	vis, err := a.chirpVisibility(r)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	q.AuthorIDs = append(following, caller.ID)
+	q.Hashtags = tags
+	q.HiddenIDs = vis.hidden
+	q.ByActivity = true
+	feed, total, err := a.chirpyDatabase.QueryChirps(q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	items := make([]apitypes.FeedChirp, 0, len(feed))
+	for _, chirp := range feed {
+		item := apitypes.FeedChirp{Chirp: apiChirp(chirp), MatchedBy: []string{}}
+		if slices.Contains(q.AuthorIDs, chirp.AuthorID) {
+			item.MatchedBy = append(item.MatchedBy, apitypes.FeedMatchedByUser)
+		}
+		for _, tag := range database.ChirpHashtags(chirp.Body) {
+			if slices.Contains(tags, tag) {
+				item.MatchedBy = append(item.MatchedBy, "#"+tag)
+			}
+		}
+		items = append(items, item)
+	}
+	resp, err := marshalList(newListEnvelope(items, total, q.Limit, q.Offset), fields)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// maxHashtagLength is the longest hashtag that can be followed, in runes
+const maxHashtagLength = 64
+
+// hashtagFromPath returns the {tag} of the path lowercased without a
+// leading #, or an error when it isn't one hashtag
+func hashtagFromPath(r *http.Request) (string, error) {
+	tag := strings.TrimPrefix(r.PathValue("tag"), "#")
+	tags := database.ChirpHashtags("#" + tag)
+	if len(tags) != 1 || tags[0] != strings.ToLower(tag) || utf8.RuneCountInString(tag) > maxHashtagLength {
+		return "", fmt.Errorf("invalid hashtag, use up to %d letters, digits and underscores", maxHashtagLength)
+	}
+	return tags[0], nil
+}
+
+// POST /api/hashtags/{tag}/follow
+// followHashtag adds the chirps tagged with tag to the caller's feed,
+// following again changes nothing
+func (a *apiConfig) followHashtag(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	tag, err := hashtagFromPath(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	follow, err := a.follows.FollowHashtag(caller.ID, tag)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, apitypes.HashtagFollowResponse{Tag: follow.Tag, CreatedAt: follow.CreatedAt})
+}
+
+// DELETE /api/hashtags/{tag}/follow
+// unfollowHashtag takes tag out of the caller's feed
+func (a *apiConfig) unfollowHashtag(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	tag, err := hashtagFromPath(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	err = a.follows.UnfollowHashtag(caller.ID, tag)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
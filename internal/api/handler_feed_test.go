@@ -0,0 +1,171 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// feedIDs returns the IDs of the caller's feed
+func (ts *testServer) feedIDs(t *testing.T, token string) []int {
+	t.Helper()
+	feed := decode[apitypes.ListEnvelope[apitypes.Chirp]](t, ts.request(t, "GET", "/api/feed", nil, bearer(token)...).expect(t, http.StatusOK))
+	IDs := make([]int, 0, len(feed.Items))
+	for _, chirp := range feed.Items {
+		IDs = append(IDs, chirp.ID)
+	}
+	return IDs
+}
+
+func TestFeedOrdering(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := start(t)
+			alice := ts.newUser(t, "alice@example.com")
+			bob := ts.newUser(t, "bob@example.com")
+			carol := ts.newUser(t, "carol@example.com")
+			ts.request(t, "POST", "/api/users/"+strconv.Itoa(bob.ID)+"/follow", nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+
+			old := ts.postChirp(t, bob.Token, "an old chirp")
+			ts.clock.Advance(time.Minute)
+			mine := ts.postChirp(t, alice.Token, "my own chirp")
+			ts.clock.Advance(time.Minute)
+			stranger := ts.postChirp(t, carol.Token, "nobody follows me")
+			ts.clock.Advance(time.Minute)
+			recent := ts.postChirp(t, bob.Token, "a recent chirp")
+			if got, want := ts.feedIDs(t, alice.Token), []int{recent.ID, mine.ID, old.ID}; !slices.Equal(got, want) {
+				t.Fatalf("feed = %v, want %v without carol's", got, want)
+			}
+
+			// an edit leaves the chirp where it was, everywhere
+			ts.clock.Advance(time.Minute)
+			ts.request(t, "PUT", chirpPath(old.ID), apitypes.UpdateChirpRequest{Body: "an old chirp, edited"}, bearer(bob.Token)...).expect(t, http.StatusOK)
+			if got, want := ts.feedIDs(t, alice.Token), []int{recent.ID, mine.ID, old.ID}; !slices.Equal(got, want) {
+				t.Fatalf("feed after an edit = %v, want %v", got, want)
+			}
+			global := []int{old.ID, mine.ID, stranger.ID, recent.ID}
+			if got := ts.visibleChirps(t); !slices.Equal(got, global) {
+				t.Fatalf("listing after an edit = %v, want %v", got, global)
+			}
+
+			// a bump moves the chirp up the feed only
+			ts.request(t, "POST", chirpPath(old.ID)+"/bump", nil, bearer(alice.Token)...).expect(t, http.StatusForbidden)
+			ts.request(t, "POST", chirpPath(999)+"/bump", nil, bearer(bob.Token)...).expect(t, http.StatusNotFound)
+			bumped := decode[apitypes.Chirp](t, ts.request(t, "POST", chirpPath(old.ID)+"/bump", nil, bearer(bob.Token)...).expect(t, http.StatusOK))
+			if bumped.BumpedAt == nil || !bumped.BumpedAt.Equal(ts.clock.Now()) {
+				t.Fatalf("bumped chirp = %+v", bumped)
+			}
+			if got, want := ts.feedIDs(t, alice.Token), []int{old.ID, recent.ID, mine.ID}; !slices.Equal(got, want) {
+				t.Fatalf("feed after a bump = %v, want %v", got, want)
+			}
+			if got := ts.visibleChirps(t); !slices.Equal(got, global) {
+				t.Fatalf("listing after a bump = %v, want %v", got, global)
+			}
+			if got, want := ts.feedIDs(t, carol.Token), []int{stranger.ID}; !slices.Equal(got, want) {
+				t.Fatalf("feed of carol = %v, want only carol's own", got)
+			}
+			page := decode[apitypes.ListEnvelope[apitypes.Chirp]](t, ts.request(t, "GET", "/api/feed?limit=1&offset=1", nil, bearer(alice.Token)...).expect(t, http.StatusOK))
+			if page.Total != 3 || len(page.Items) != 1 || page.Items[0].ID != recent.ID {
+				t.Fatalf("feed page = %+v, want the second of 3", page)
+			}
+
+			// once a week per chirp
+			ts.clock.Advance(time.Hour)
+			bob = ts.login(t, "bob@example.com")
+			resp := ts.request(t, "POST", chirpPath(old.ID)+"/bump", nil, bearer(bob.Token)...).expect(t, http.StatusTooManyRequests)
+			if got, want := resp.header.Get("Retry-After"), strconv.Itoa(int((bumpCooldown - time.Hour).Seconds())); got != want {
+				t.Fatalf("Retry-After = %q, want %q", got, want)
+			}
+			ts.clock.Advance(bumpCooldown)
+			bob = ts.login(t, "bob@example.com")
+			alice = ts.login(t, "alice@example.com")
+			ts.request(t, "POST", chirpPath(recent.ID)+"/bump", nil, bearer(bob.Token)...).expect(t, http.StatusOK)
+			ts.request(t, "POST", chirpPath(old.ID)+"/bump", nil, bearer(bob.Token)...).expect(t, http.StatusOK)
+			if got, want := ts.feedIDs(t, alice.Token), []int{recent.ID, old.ID, mine.ID}; !slices.Equal(got, want) {
+				t.Fatalf("feed after bumping both = %v, want the higher ID first on a tie", got)
+			}
+			ts.request(t, "GET", "/api/feed", nil).expect(t, http.StatusUnauthorized)
+		})
+	}
+}
+
+func TestFeedHashtags(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := start(t)
+			alice := ts.newUser(t, "alice@example.com")
+			bob := ts.newUser(t, "bob@example.com")
+			carol := ts.newUser(t, "carol@example.com")
+			dave := ts.newUser(t, "dave@example.com")
+			ts.request(t, "PUT", "/api/users/me/privacy", apitypes.PrivacyRequest{Protected: true}, bearer(dave.Token)...).expect(t, http.StatusOK)
+			ts.request(t, "POST", "/api/users/"+strconv.Itoa(bob.ID)+"/follow", nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+
+			follow := decode[apitypes.HashtagFollowResponse](t, ts.request(t, "POST", "/api/hashtags/GoLang/follow", nil, bearer(alice.Token)...).expect(t, http.StatusOK))
+			if follow.Tag != "golang" {
+				t.Fatalf("followed tag = %q, want it lowercased", follow.Tag)
+			}
+			ts.request(t, "POST", "/api/hashtags/%23golang/follow", nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+			ts.request(t, "POST", "/api/hashtags/rust/follow", nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+			for _, tag := range []string{"go-lang", "%23", "two%20words", strings.Repeat("a", 65)} {
+				ts.request(t, "POST", "/api/hashtags/"+tag+"/follow", nil, bearer(alice.Token)...).expect(t, http.StatusBadRequest)
+			}
+
+			both := ts.postChirp(t, bob.Token, "bob on #golang")
+			ts.clock.Advance(time.Minute)
+			tagged := ts.postChirp(t, carol.Token, "carol on #Rust and #golang")
+			ts.clock.Advance(time.Minute)
+			ts.postChirp(t, carol.Token, "carol on #python")
+			protected := ts.postChirp(t, dave.Token, "a protected #golang chirp")
+			ts.clock.Advance(time.Minute)
+			mine := ts.postChirp(t, alice.Token, "untagged")
+
+			feed := decode[apitypes.ListEnvelope[apitypes.FeedChirp]](t, ts.request(t, "GET", "/api/feed", nil, bearer(alice.Token)...).expect(t, http.StatusOK))
+			type item struct {
+				ID        int
+				MatchedBy string
+			}
+			var got []item
+			for _, chirp := range feed.Items {
+				got = append(got, item{chirp.ID, strings.Join(chirp.MatchedBy, " ")})
+			}
+			want := []item{{mine.ID, "user"}, {tagged.ID, "#rust #golang"}, {both.ID, "user #golang"}}
+			if !slices.Equal(got, want) || feed.Total != 3 {
+				t.Fatalf("feed = %v of %d, want %v without dave's or the python chirp", got, feed.Total, want)
+			}
+
+			// a follower of dave sees the tagged chirp too
+			ts.request(t, "POST", "/api/users/"+strconv.Itoa(dave.ID)+"/follow", nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+			ts.request(t, "POST", "/api/follow_requests/"+strconv.Itoa(alice.ID)+"/approve", nil, bearer(dave.Token)...).expect(t, http.StatusOK)
+			if got, want := ts.feedIDs(t, alice.Token), []int{mine.ID, protected.ID, tagged.ID, both.ID}; !slices.Equal(got, want) {
+				t.Fatalf("feed after dave approved = %v, want %v", got, want)
+			}
+
+			page := decode[map[string]any](t, ts.request(t, "GET", "/api/feed?fields=id,matched_by&limit=1", nil, bearer(alice.Token)...).expect(t, http.StatusOK))
+			items := page["items"].([]any)
+			if len(items) != 1 || len(items[0].(map[string]any)) != 2 {
+				t.Fatalf("projected feed = %v, want only id and matched_by", page)
+			}
+
+			ts.request(t, "DELETE", "/api/hashtags/rust/follow", nil, bearer(alice.Token)...).expect(t, http.StatusNoContent)
+			ts.request(t, "DELETE", "/api/hashtags/rust/follow", nil, bearer(alice.Token)...).expect(t, http.StatusNoContent)
+			ts.request(t, "DELETE", "/api/hashtags/golang/follow", nil, bearer(alice.Token)...).expect(t, http.StatusNoContent)
+			if got, want := ts.feedIDs(t, alice.Token), []int{mine.ID, protected.ID, both.ID}; !slices.Equal(got, want) {
+				t.Fatalf("feed after unfollowing the tags = %v, want %v", got, want)
+			}
+			ts.request(t, "POST", "/api/hashtags/golang/follow", nil).expect(t, http.StatusUnauthorized)
+		})
+	}
+}
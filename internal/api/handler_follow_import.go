@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+const (
+	// defaultFollowImportPerMinute is how many follow imports a client can
+	// send a minute, see RATE_LIMIT_FOLLOW_IMPORTS_PER_MINUTE
+	defaultFollowImportPerMinute = 2
+	// followCSVHeader is the first row of an export, imports may leave it out
+	followCSVHeader = "email"
+)
+
+// GET /api/users/me/follows/export
+// exportFollows writes the emails of the users the caller follows as csv,
+// one per row after an email header. pending requests aren't follows yet.
+func (a *apiConfig) exportFollows(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	following, err := a.follows.Following(caller.ID)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	users, err := a.db.GetUser()
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	emails := make(map[int]string, len(users))
+	for _, user := range users {
+		emails[user.ID] = user.Email
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-follows.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{followCSVHeader})
+	for _, ID := range following {
+		// a followee deleted since has no email to carry over
+		if email, ok := emails[ID]; ok {
+			cw.Write([]string{email})
+		}
+	}
+	cw.Flush()
+}
+
+// POST /api/users/me/follows/import
+// importFollows follows every user of a csv like exportFollows writes,
+// matched by the email in the first column, and reports what it did with
+// each row. the follows are stored in one write, importing twice changes
+// nothing.
+func (a *apiConfig) importFollows(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	emails, err := readFollowCSV(r.Body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithErrorCode(w, http.StatusRequestEntityTooLarge, "body too large", apitypes.ErrorCodeBodyTooLarge)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid csv: "+err.Error())
+		return
+	}
+
+	report := make([]apitypes.FollowImportRow, len(emails))
+	var follows []database.Follow
+	var rows []int // the report row of each follow
+	for i, email := range emails {
+		report[i] = apitypes.FollowImportRow{Row: i + 1, Email: email}
+		if !strings.Contains(email, "@") {
+			report[i].Status = apitypes.FollowImportInvalid
+			continue
+		}
+		followee, err := a.db.GetUserByEmail(email)
+		if errors.Is(err, database.ErrUserNotFound) {
+			report[i].Status = apitypes.FollowImportNotFound
+			continue
+		}
+		if err != nil {
+			respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		if followee.ID == caller.ID {
+			report[i].Status = apitypes.FollowImportInvalid
+			continue
+		}
+		follows = append(follows, database.Follow{FolloweeID: followee.ID, Pending: followee.Protected})
+		rows = append(rows, i)
+	}
+
+	stored, existed, err := a.follows.AddFollows(caller.ID, follows)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	for j, follow := range stored {
+		row := &report[rows[j]]
+		switch {
+		case follow.Pending:
+			row.Status = apitypes.FollowImportRequested
+		case existed[j]:
+			row.Status = apitypes.FollowImportAlreadyFollowing
+		default:
+			row.Status = apitypes.FollowImportFollowed
+		}
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+// readFollowCSV returns the first column of every row of a follow csv,
+// trimmed, without the header row when there is one
+func readFollowCSV(body io.Reader) ([]string, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && strings.EqualFold(strings.TrimSpace(records[0][0]), followCSVHeader) {
+		records = records[1:]
+	}
+	emails := make([]string, 0, len(records))
+	for _, record := range records {
+		emails = append(emails, strings.TrimSpace(record[0]))
+	}
+	return emails, nil
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// followWrites returns how many times the follows database file was written
+func (ts *testServer) followWrites() int {
+	m := ts.api.dbMetrics
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if stats := m.ops[[2]string{"follows", "write_file"}]; stats != nil {
+		return stats.count
+	}
+	return 0
+}
+
+func TestFollowImportExport(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	bob := ts.newUser(t, "bob@example.com")
+	carol := ts.newUser(t, "carol@example.com")
+	dave := ts.newUser(t, "dave@example.com")
+	ts.request(t, "PUT", "/api/users/me/privacy", apitypes.PrivacyRequest{Protected: true}, bearer(dave.Token)...).expect(t, http.StatusOK)
+	ts.request(t, "POST", "/api/users/"+strconv.Itoa(bob.ID)+"/follow", nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+
+	writes := ts.followWrites()
+	csv := "email\nbob@example.com\n Carol@Example.com\nnobody@example.com\nnot an email\nalice@example.com\ndave@example.com\ncarol@example.com\n"
+	report := decode[[]apitypes.FollowImportRow](t, ts.request(t, "POST", "/api/users/me/follows/import", csv, bearer(alice.Token)...).expect(t, http.StatusOK))
+	want := []apitypes.FollowImportRow{
+		{Row: 1, Email: "bob@example.com", Status: apitypes.FollowImportAlreadyFollowing},
+		{Row: 2, Email: "Carol@Example.com", Status: apitypes.FollowImportFollowed},
+		{Row: 3, Email: "nobody@example.com", Status: apitypes.FollowImportNotFound},
+		{Row: 4, Email: "not an email", Status: apitypes.FollowImportInvalid},
+		{Row: 5, Email: "alice@example.com", Status: apitypes.FollowImportInvalid},
+		{Row: 6, Email: "dave@example.com", Status: apitypes.FollowImportRequested},
+		{Row: 7, Email: "carol@example.com", Status: apitypes.FollowImportAlreadyFollowing},
+	}
+	if !slices.Equal(report, want) {
+		t.Fatalf("report = %+v, want %+v", report, want)
+	}
+	if got := ts.followWrites() - writes; got != 1 {
+		t.Fatalf("the import wrote the follows %d times, want once", got)
+	}
+	if got := ts.feedIDs(t, alice.Token); len(got) != 0 {
+		t.Fatalf("feed = %v, nobody has chirped", got)
+	}
+	requests := decode[[]apitypes.FollowResponse](t, ts.request(t, "GET", "/api/follow_requests", nil, bearer(dave.Token)...).expect(t, http.StatusOK))
+	if len(requests) != 1 || requests[0].FollowerID != alice.ID {
+		t.Fatalf("requests of dave = %+v, want alice's", requests)
+	}
+
+	// pending requests aren't exported, and the export imports as is
+	resp := ts.request(t, "GET", "/api/users/me/follows/export", nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+	if got, want := string(resp.body), "email\nbob@example.com\ncarol@example.com\n"; got != want {
+		t.Fatalf("export = %q, want %q", got, want)
+	}
+	if got := resp.header.Get("Content-Type"); got != "text/csv" {
+		t.Fatalf("Content-Type = %q", got)
+	}
+	writes = ts.followWrites()
+	report = decode[[]apitypes.FollowImportRow](t, ts.request(t, "POST", "/api/users/me/follows/import", resp.body, bearer(alice.Token)...).expect(t, http.StatusOK))
+	for _, row := range report {
+		if row.Status != apitypes.FollowImportAlreadyFollowing {
+			t.Fatalf("reimport row = %+v, want already following", row)
+		}
+	}
+	if got := ts.followWrites(); got != writes {
+		t.Fatal("a reimport that follows nobody new wrote the follows")
+	}
+
+	// without the header, into a fresh account
+	report = decode[[]apitypes.FollowImportRow](t, ts.request(t, "POST", "/api/users/me/follows/import", "bob@example.com\n", bearer(carol.Token)...).expect(t, http.StatusOK))
+	if len(report) != 1 || report[0].Status != apitypes.FollowImportFollowed {
+		t.Fatalf("report = %+v, want bob followed", report)
+	}
+
+	ts.request(t, "POST", "/api/users/me/follows/import", "email\n\"unterminated\n", bearer(alice.Token)...).expect(t, http.StatusBadRequest)
+	ts.request(t, "POST", "/api/users/me/follows/import", csv).expect(t, http.StatusUnauthorized)
+	ts.request(t, "GET", "/api/users/me/follows/export", nil).expect(t, http.StatusUnauthorized)
+}
+
+func TestFollowImportRateLimit(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("RATE_LIMIT_FOLLOW_IMPORTS_PER_MINUTE", "2")
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+
+	for range 2 {
+		ts.request(t, "POST", "/api/users/me/follows/import", "email\n", bearer(alice.Token)...).expect(t, http.StatusOK)
+	}
+	resp := ts.request(t, "POST", "/api/users/me/follows/import", "email\n", bearer(alice.Token)...).expect(t, http.StatusTooManyRequests)
+	if got := resp.header.Get("Retry-After"); got != "31" {
+		t.Fatalf("Retry-After = %q, want 31", got)
+	}
+	// the export isn't limited
+	ts.request(t, "GET", "/api/users/me/follows/export", nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func newFollowResponse(follow database.Follow) apitypes.FollowResponse {
+	status := apitypes.FollowStatusFollowing
+	if follow.Pending {
+		status = apitypes.FollowStatusPending
+	}
+	return apitypes.FollowResponse{
+		FollowerID: follow.FollowerID,
+		FolloweeID: follow.FolloweeID,
+		Status:     status,
+		CreatedAt:  follow.CreatedAt,
+	}
+}
+
+// POST /api/users/{userID}/follow
+// followUser makes the caller follow a user. following a protected user
+// sends a follow request instead, following again changes nothing.
+func (a *apiConfig) followUser(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	followee, err := a.db.GetUserByID(userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	follow, err := a.follows.AddFollow(caller.ID, followee.ID, followee.Protected)
+	if errors.Is(err, database.ErrFollowSelf) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newFollowResponse(follow))
+}
+
+// DELETE /api/users/{userID}/follow
+// unfollowUser ends the caller's follow of a user or withdraws the request
+func (a *apiConfig) unfollowUser(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	err = a.follows.RemoveFollow(caller.ID, userID)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/follow_requests
+// getFollowRequests lists the requests waiting for the caller's approval, oldest first
+func (a *apiConfig) getFollowRequests(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	requests, err := a.follows.FollowRequests(caller.ID)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	items := make([]apitypes.FollowResponse, 0, len(requests))
+	for _, follow := range requests {
+		items = append(items, newFollowResponse(follow))
+	}
+	respondWithJSON(w, http.StatusOK, items)
+}
+
+// POST /api/follow_requests/{id}/approve
+// approveFollowRequest lets the user with ID follow the caller
+func (a *apiConfig) approveFollowRequest(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	followerID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	follow, err := a.follows.ApproveFollow(caller.ID, followerID)
+	if errors.Is(err, database.ErrFollowNotFound) {
+		respondWithError(w, http.StatusNotFound, "follow request not found")
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newFollowResponse(follow))
+}
+
+// POST /api/follow_requests/{id}/reject
+// rejectFollowRequest drops the request of the user with ID, they can ask again
+func (a *apiConfig) rejectFollowRequest(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	followerID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	err = a.follows.RejectFollow(caller.ID, followerID)
+	if errors.Is(err, database.ErrFollowNotFound) {
+		respondWithError(w, http.StatusNotFound, "follow request not found")
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PUT /api/users/me/privacy
+// updatePrivacy turns protected mode on or off and, when followers_visible
+// is sent, shows or hides the caller's followers and follows. followers the
+// caller already has keep following, requests still pending wait for a
+// decision.
+func (a *apiConfig) updatePrivacy(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var privacyReq apitypes.PrivacyRequest
+	err := json.NewDecoder(r.Body).Decode(&privacyReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+	user, err := a.db.SetProtected(caller.ID, privacyReq.Protected)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if privacyReq.FollowersVisible != nil {
+		err = a.follows.SetFollowsVisible(caller.ID, *privacyReq.FollowersVisible)
+		if err != nil {
+			respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+	}
+	visible, err := a.follows.FollowsVisible(caller.ID)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, apitypes.PrivacyResponse{UserProfile: userProfile(user), FollowersVisible: visible})
+}
+
+// GET /api/users/{userID}/followers
+// getFollowers lists the profiles of the users following a user, by ID
+func (a *apiConfig) getFollowers(w http.ResponseWriter, r *http.Request) {
+	a.listFollows(w, r, a.follows.Followers)
+}
+
+// GET /api/users/{userID}/following
+// getFollowing lists the profiles of the users a user follows, by ID
+func (a *apiConfig) getFollowing(w http.ResponseWriter, r *http.Request) {
+	a.listFollows(w, r, a.follows.FollowingOf)
+}
+
+// listFollows answers with the profiles of the IDs list returns for the
+// viewer and the user of the path. a user who hides their follows gets 403
+// for anyone else.
+func (a *apiConfig) listFollows(w http.ResponseWriter, r *http.Request, list func(viewerID, userID int) ([]int, error)) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	_, err = a.db.GetUserByID(userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	IDs, err := list(a.viewerID(r), userID)
+	if errors.Is(err, database.ErrFollowsHidden) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	users, err := a.db.GetUser()
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	profiles := make([]apitypes.UserProfile, 0, len(IDs))
+	for _, user := range users {
+		if _, ok := slices.BinarySearch(IDs, user.ID); ok {
+			profiles = append(profiles, userProfile(user))
+		}
+	}
+	respondWithJSON(w, http.StatusOK, profiles)
+}
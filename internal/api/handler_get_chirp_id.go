@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// get chirpy from specific ID. responses are served from the chirp cache
+// when they can be, so a hit doesn't touch the database. chirps the viewer
+// may not read are not found, a cache hit is only served when the viewer
+// can see everyone.
+func (a *apiConfig) getChirpyFromID(w http.ResponseWriter, r *http.Request) {
+	chirpID := r.PathValue("chirpID")
+	ID, err := strconv.Atoi(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	vis, err := a.chirpVisibility(r)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if a.chirpValidators(w, r, vis) {
+		return
+	}
+	// a viewer who can't see everyone is answered from the database
+	var resp []byte
+	var gen uint64
+	if len(vis.hidden) == 0 {
+		var ok bool
+		resp, gen, ok = a.chirpCache.get(ID)
+		if ok {
+			w.Write(resp)
+			return
+		}
+	} else {
+		gen = a.chirpCache.bypass()
+	}
+
+	chirp, err := a.chirpyDatabase.GetChirpyFromID(ID)
+	if errors.Is(err, database.ErrChirpNotFound) || (err == nil && !vis.canSee(chirp.AuthorID)) {
+		respondWithError(w, http.StatusNotFound, database.ErrChirpNotFound.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	resp, err = json.Marshal(chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	a.chirpCache.put(ID, resp, gen)
+	w.Write(resp)
+
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// GET /api/chirps
+// getChirpy lists chirps, one page in the envelope or every match as a
+// bare JSON array. bare responses only page when asked to with limit or
+// offset, and tell the total in X-Total-Count.
+func (a *apiConfig) getChirpy(w http.ResponseWriter, r *http.Request) {
+	envelope := wantsEnvelope(r)
+	params := r.URL.Query()
+	paginate := envelope || params.Has("limit") || params.Has("offset")
+	q, err := a.chirpQuery(r, paginate)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields, err := requestedFields(r, "chirp")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	vis, err := a.chirpVisibility(r)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if a.chirpValidators(w, r, vis) {
+		return
+	}
+	q.HiddenIDs = vis.hidden
+	chirps, total, err := a.chirpyDatabase.QueryChirps(q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	if envelope {
+		resp, err := marshalList(newListEnvelope(chirps, total, q.Limit, q.Offset), fields)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+			return
+		}
+		w.Write(resp)
+		return
+	}
+
+	resp, err := marshalItems(chirps, fields)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	if paginate {
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	}
+	w.Write(resp)
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+const (
+	benchUsers  = 1000
+	benchChirps = 10000
+	// getChirpsBudget is what a list request may take at the 99th
+	// percentile without the network. the target is 5ms, the test allows
+	// ten times that for slow machines. wall clock budgets depend on the
+	// machine, so the test only runs with CHIRPY_LATENCY_BUDGET=1.
+	getChirpsBudget = 50 * time.Millisecond
+)
+
+// seedChirps imports benchUsers users and benchChirps chirps in one write
+func seedChirps(tb testing.TB, store database.Store) {
+	tb.Helper()
+	backup := database.Backup{NextUserID: benchUsers, NextChirpID: benchChirps}
+	for id := 1; id <= benchUsers; id++ {
+		backup.Users = append(backup.Users, database.User{ID: id, Email: fmt.Sprintf("user-%d@example.com", id), Password: []byte("hash")})
+	}
+	for id := 1; id <= benchChirps; id++ {
+		createdAt := testEpoch.Add(time.Duration(id) * time.Second)
+		backup.Chirps = append(backup.Chirps, database.Chirp{
+			ID: id, AuthorID: id%benchUsers + 1, Body: fmt.Sprintf("chirp number %d", id), Lang: "en", CreatedAt: &createdAt,
+		})
+	}
+	if err := store.Import(backup); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// getChirpsQueries are the list requests clients send most
+var getChirpsQueries = []struct {
+	name  string
+	query string
+}{
+	{"bare", ""},
+	{"envelope", "?envelope=true"},
+	{"author", "?envelope=true&author_id=42&sort=desc"},
+	{"page", "?envelope=true&limit=100&offset=5000"},
+}
+
+// serveGetChirps sends GET /api/chirps with query through the whole
+// handler, middleware included, without a network round trip
+func serveGetChirps(tb testing.TB, ts *testServer, query string) {
+	rec := httptest.NewRecorder()
+	ts.Config.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/chirps"+query, nil))
+	if rec.Code != http.StatusOK {
+		tb.Fatalf("GET /api/chirps%s = %d: %s", query, rec.Code, rec.Body)
+	}
+}
+
+func BenchmarkGetChirps(b *testing.B) {
+	setTestEnv(b)
+	ts := newTestServer(b)
+	seedChirps(b, ts.store)
+	for _, q := range getChirpsQueries {
+		b.Run(q.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				serveGetChirps(b, ts, q.query)
+			}
+		})
+	}
+}
+
+func TestGetChirpsLatencyBudget(t *testing.T) {
+	if os.Getenv("CHIRPY_LATENCY_BUDGET") != "1" {
+		t.Skip("latency budget, set CHIRPY_LATENCY_BUDGET=1 to check it")
+	}
+	setTestEnv(t)
+	ts := newTestServer(t)
+	seedChirps(t, ts.store)
+	const requests = 200
+	for _, q := range getChirpsQueries {
+		t.Run(q.name, func(t *testing.T) {
+			durations := make([]time.Duration, 0, requests)
+			for range requests {
+				start := time.Now()
+				serveGetChirps(t, ts, q.query)
+				durations = append(durations, time.Since(start))
+			}
+			slices.Sort(durations)
+			if p99 := durations[requests*99/100]; p99 > getChirpsBudget {
+				t.Errorf("p99 of GET /api/chirps%s = %s, budget %s", q.query, p99, getChirpsBudget)
+			}
+		})
+	}
+}
+
+func TestGetChirpsSort(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := start(t)
+			alice := ts.newUser(t, "alice@example.com")
+			bob := ts.newUser(t, "bob@example.com")
+			for i, token := range []string{alice.Token, bob.Token, alice.Token, bob.Token, alice.Token} {
+				ts.postChirp(t, token, "chirp "+strconv.Itoa(i+1))
+			}
+			byAlice := "author_id=" + strconv.Itoa(alice.ID)
+			list := decode[listResponse](t, ts.request(t, "POST", "/api/lists", map[string]any{"name": "bob"}, bearer(alice.Token)...).expect(t, http.StatusCreated))
+			listPath := "/api/lists/" + strconv.Itoa(list.ID) + "/chirps"
+			ts.request(t, "POST", "/api/lists/"+strconv.Itoa(list.ID)+"/members/"+strconv.Itoa(bob.ID), nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+
+			tests := []struct {
+				name   string
+				path   string
+				query  string
+				status int
+				ids    []int
+			}{
+				{"default", "/api/chirps", "", http.StatusOK, []int{1, 2, 3, 4, 5}},
+				{"asc", "/api/chirps", "sort=asc", http.StatusOK, []int{1, 2, 3, 4, 5}},
+				{"desc", "/api/chirps", "sort=desc", http.StatusOK, []int{5, 4, 3, 2, 1}},
+				{"author asc", "/api/chirps", byAlice + "&sort=asc", http.StatusOK, []int{1, 3, 5}},
+				{"author desc", "/api/chirps", byAlice + "&sort=desc", http.StatusOK, []int{5, 3, 1}},
+				{"desc then author", "/api/chirps", "sort=desc&" + byAlice, http.StatusOK, []int{5, 3, 1}},
+				{"desc page", "/api/chirps", "sort=desc&limit=2&offset=1", http.StatusOK, []int{4, 3}},
+				{"empty", "/api/chirps", "sort=", http.StatusOK, []int{1, 2, 3, 4, 5}},
+				{"list default", listPath, "", http.StatusOK, []int{4, 2}},
+				{"list asc", listPath, "sort=asc", http.StatusOK, []int{2, 4}},
+				{"list desc", listPath, "sort=desc", http.StatusOK, []int{4, 2}},
+				{"unknown", "/api/chirps", "sort=newest", http.StatusBadRequest, nil},
+				{"wrong case", "/api/chirps", "sort=DESC", http.StatusBadRequest, nil},
+				{"unknown with author", "/api/chirps", byAlice + "&sort=random", http.StatusBadRequest, nil},
+				{"unknown on a list", listPath, "sort=up", http.StatusBadRequest, nil},
+			}
+			for _, tt := range tests {
+				resp := ts.request(t, "GET", tt.path+"?envelope=true&"+tt.query, nil)
+				if resp.status != tt.status {
+					t.Errorf("%s: status %d, want %d: %s", tt.name, resp.status, tt.status, resp.body)
+					continue
+				}
+				if tt.status != http.StatusOK {
+					if got := decode[apitypes.ErrorResponse](t, resp).Error; got != "invalid sort, want asc or desc" {
+						t.Errorf("%s: error %q", tt.name, got)
+					}
+					continue
+				}
+				page := decode[apitypes.ListEnvelope[apitypes.Chirp]](t, resp)
+				ids := make([]int, 0, len(page.Items))
+				for _, chirp := range page.Items {
+					ids = append(ids, chirp.ID)
+				}
+				if !slices.Equal(ids, tt.ids) {
+					t.Errorf("%s: got %v, want %v", tt.name, ids, tt.ids)
+				}
+			}
+		})
+	}
+}
+
+func TestGetChirpsSearch(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := start(t)
+			alice := ts.newUser(t, "alice@example.com")
+			bob := ts.newUser(t, "bob@example.com")
+			bodies := []struct {
+				token string
+				body  string
+			}{
+				{alice.Token, "L'École est finie"},
+				{bob.Token, "ÉCOLE du soir"},
+				{alice.Token, "東京で会いましょう 🎉"},
+				{bob.Token, "what a kerfuffle"},
+				{alice.Token, "Going home"},
+				{bob.Token, "go team 🎉"},
+			}
+			for _, c := range bodies {
+				ts.postChirp(t, c.token, c.body)
+			}
+			byBob := "&author_id=" + strconv.Itoa(bob.ID)
+
+			tests := []struct {
+				name   string
+				query  string
+				status int
+				ids    []int
+			}{
+				{"accented lowercase", "q=" + url.QueryEscape("école"), http.StatusOK, []int{1, 2}},
+				{"accented uppercase", "q=" + url.QueryEscape("ÉCOLE"), http.StatusOK, []int{1, 2}},
+				{"unaccented doesn't match", "q=ecole", http.StatusOK, []int{}},
+				{"cjk", "q=" + url.QueryEscape("東京"), http.StatusOK, []int{3}},
+				{"emoji", "q=" + url.QueryEscape("🎉"), http.StatusOK, []int{3, 6}},
+				{"original of a filtered word", "q=kerfuffle", http.StatusOK, []int{}},
+				{"filtered form", "q=" + url.QueryEscape("****"), http.StatusOK, []int{4}},
+				{"substring ignoring case", "q=GO", http.StatusOK, []int{5, 6}},
+				{"with author", "q=go" + byBob, http.StatusOK, []int{6}},
+				{"with sort", "q=go&sort=desc", http.StatusOK, []int{6, 5}},
+				{"with a page", "q=" + url.QueryEscape("é") + "&limit=1&offset=1", http.StatusOK, []int{2}},
+				{"empty is no search", "q=", http.StatusOK, []int{1, 2, 3, 4, 5, 6}},
+				{"too long", "q=" + strings.Repeat("é", maxChirpLength+1), http.StatusBadRequest, nil},
+				{"longest", "q=" + strings.Repeat("é", maxChirpLength), http.StatusOK, []int{}},
+			}
+			for _, tt := range tests {
+				resp := ts.request(t, "GET", "/api/chirps?envelope=true&"+tt.query, nil)
+				if resp.status != tt.status {
+					t.Errorf("%s: status %d, want %d: %s", tt.name, resp.status, tt.status, resp.body)
+					continue
+				}
+				if tt.status != http.StatusOK {
+					continue
+				}
+				page := decode[apitypes.ListEnvelope[apitypes.Chirp]](t, resp)
+				ids := make([]int, 0, len(page.Items))
+				for _, chirp := range page.Items {
+					ids = append(ids, chirp.ID)
+				}
+				if !slices.Equal(ids, tt.ids) {
+					t.Errorf("%s: got %v, want %v", tt.name, ids, tt.ids)
+				}
+			}
+		})
+	}
+}
+
+func TestGetChirpsBare(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := start(t)
+			alice := ts.newUser(t, "alice@example.com")
+			bob := ts.newUser(t, "bob@example.com")
+			ts.postChirp(t, alice.Token, "from alice")
+			ts.postChirp(t, bob.Token, "from bob")
+
+			// chirp objects with their author, not just the bodies
+			chirps := decode[[]apitypes.Chirp](t, ts.request(t, "GET", "/api/chirps", nil).expect(t, http.StatusOK))
+			if len(chirps) != 2 || chirps[0].AuthorID != alice.ID || chirps[0].Body != "from alice" || chirps[1].AuthorID != bob.ID {
+				t.Fatalf("GET /api/chirps = %+v", chirps)
+			}
+			byBob := decode[[]apitypes.Chirp](t, ts.request(t, "GET", "/api/chirps?author_id="+strconv.Itoa(bob.ID), nil).expect(t, http.StatusOK))
+			if len(byBob) != 1 || byBob[0].AuthorID != bob.ID {
+				t.Fatalf("chirps of bob = %+v", byBob)
+			}
+
+			// paging and fields work without the envelope too
+			resp := ts.request(t, "GET", "/api/chirps?limit=1&offset=1&fields=id,author_id", nil).expect(t, http.StatusOK)
+			var page []map[string]any
+			if err := json.Unmarshal(resp.body, &page); err != nil || len(page) != 1 || len(page[0]) != 2 || page[0]["author_id"] != float64(bob.ID) {
+				t.Fatalf("page with fields = %s, %v", resp.body, err)
+			}
+			if got := resp.header.Get("X-Total-Count"); got != "2" {
+				t.Fatalf("X-Total-Count = %q, want 2", got)
+			}
+		})
+	}
+}
+
+func TestGetChirpsByAuthorEmpty(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	carol := ts.newUser(t, "carol@example.com")
+	ts.postChirp(t, alice.Token, "only alice chirps")
+
+	// an author without chirps, or no such user at all, is an empty array
+	for _, path := range []string{
+		"/api/chirps?author_id=" + strconv.Itoa(carol.ID),
+		"/api/chirps?author_id=999",
+		"/api/chirps?author_id=" + strconv.Itoa(carol.ID) + "&sort=desc",
+		"/api/chirps?author_id=" + strconv.Itoa(carol.ID) + "&limit=10",
+	} {
+		resp := ts.request(t, "GET", path, nil).expect(t, http.StatusOK)
+		if got := strings.TrimSpace(string(resp.body)); got != "[]" {
+			t.Errorf("GET %s = %q, want []", path, got)
+		}
+	}
+	page := decode[apitypes.ListEnvelope[apitypes.Chirp]](t, ts.request(t, "GET", "/api/chirps?envelope=true&author_id="+strconv.Itoa(carol.ID), nil).expect(t, http.StatusOK))
+	if page.Items == nil || len(page.Items) != 0 || page.Total != 0 {
+		t.Fatalf("envelope of an author without chirps = %+v", page)
+	}
+
+	for _, authorID := range []string{"alice", "1.5", ""} {
+		resp := ts.request(t, "GET", "/api/chirps?author_id="+authorID, nil)
+		if authorID == "" {
+			// an empty filter is no filter
+			resp.expect(t, http.StatusOK)
+			continue
+		}
+		if resp.expect(t, http.StatusBadRequest); errorOf(t, resp) == "" {
+			t.Errorf("author_id=%q: body %s, want an error", authorID, resp.body)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// userProfile is what anyone may see of a user, never the password hash,
+// tokens or TOTP secrets
+func userProfile(user database.User) apitypes.UserProfile {
+	return apitypes.UserProfile{
+		ID:          user.ID,
+		Email:       user.Email,
+		IsChirpyRed: user.IsChirpyRed,
+		Protected:   user.Protected,
+	}
+}
+
+// profileChirps is how many of the latest chirps a profile shows
+const profileChirps = 20
+
+// GET /api/users
+// getUsers lists the profile of every user sorted by ID
+func (a *apiConfig) getUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := a.db.GetUser()
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	profiles := make([]apitypes.UserProfile, 0, len(users))
+	for _, user := range users {
+		profiles = append(profiles, userProfile(user))
+	}
+
+	resp, err := json.Marshal(profiles)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// GET /api/users/{userID}
+// getUserFromID returns the profile of one user with their counts and
+// latest chirps. the chirps of a protected user are left out unless the
+// caller may read them, the follow counts are null when the user hides
+// them from the caller.
+func (a *apiConfig) getUserFromID(w http.ResponseWriter, r *http.Request) {
+	ID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	user, err := a.db.GetUserByID(ID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	viewerID := a.viewerID(r)
+	state, err := a.followStateOf(viewerID, user.ID)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	profile := apitypes.ProfileResponse{
+		UserProfile:   userProfile(user),
+		ChirpsVisible: chirpsVisible(viewerID, user, state),
+		Chirps:        []apitypes.Chirp{},
+	}
+	followers, following, err := a.follows.FollowCounts(viewerID, user.ID)
+	if err != nil && !errors.Is(err, database.ErrFollowsHidden) {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err == nil {
+		profile.FollowerCount, profile.FollowingCount, profile.FollowersVisible = &followers, &following, true
+	}
+	q := database.ChirpQuery{AuthorIDs: []int{user.ID}, Desc: true, Limit: profileChirps}
+	if !profile.ChirpsVisible {
+		q.Limit = 1 // only the count
+	}
+	chirps, total, err := a.chirpyDatabase.QueryChirps(q)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	profile.ChirpCount = total
+	if profile.ChirpsVisible {
+		for _, chirp := range chirps {
+			profile.Chirps = append(profile.Chirps, apiChirp(chirp))
+		}
+	}
+
+	resp, err := json.Marshal(profile)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// apiChirp is chirp as the chirp endpoints return it
+func apiChirp(chirp database.Chirp) apitypes.Chirp {
+	return apitypes.Chirp{
+		AuthorID:  chirp.AuthorID,
+		Body:      chirp.Body,
+		ID:        chirp.ID,
+		Lang:      chirp.Lang,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		BumpedAt:  chirp.BumpedAt,
+		SourceURL: chirp.SourceURL,
+		Source:    chirp.Source,
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// GET /api/users/me/limits
+// userLimits reports the limits the server enforces on the caller. every
+// value is read from what the enforcing code uses so the two can't disagree.
+func (a *apiConfig) userLimits(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limits := apitypes.LimitsResponse{}
+	limits.Chirp.MaxLength = a.checkChirp(apitypes.CreateChirpRequest{}, caller).Limit
+	limits.Chirp.MaxBodyBytes = a.routeBodyLimit("POST", "/api/chirps")
+
+	remaining, windowEnd := a.loginBackoff.remaining(caller.Email)
+	limits.Login.MaxFailures = loginFailureThreshold
+	limits.Login.WindowSeconds = int(loginFailureWindow.Seconds())
+	limits.Login.RemainingFailures = remaining
+	if !windowEnd.IsZero() {
+		limits.Login.WindowEndsAt = &windowEnd
+	}
+
+	caps := a.settings().caps
+	limits.Query.MaxLimit = caps.maxLimit
+	limits.Query.MaxActivityDays = caps.maxActivityDays
+
+	limits.Features = map[string]bool{
+		"chirpy_red": caller.IsChirpyRed,
+	}
+
+	resp, err := json.Marshal(limits)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// POST /api/users/me/link
+// linkAccount links the logged-in account with another account the caller
+// also controls, proven by an access token for that account in the body.
+// a link only allows POST /api/token/exchange between the two accounts.
+func (a *apiConfig) linkAccount(w http.ResponseWriter, r *http.Request) {
+	token, err := a.validateToken(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || !isAcessToken(claims.Issuer) {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	linkReq := struct {
+		Token string `json:"token"`
+	}{}
+	err = json.NewDecoder(r.Body).Decode(&linkReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+
+	otherToken, err := a.parseToken(linkReq.Token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token for linked account")
+		return
+	}
+	otherClaims, ok := otherToken.Claims.(*CustomClaims)
+	if !ok || !isAcessToken(otherClaims.Issuer) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token for linked account")
+		return
+	}
+
+	err = a.db.LinkUsers(claims.UserID, otherClaims.UserID)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("audit: user %d linked account %d", claims.UserID, otherClaims.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /api/users/me/link/{userID}
+// unlinkAccount removes the link in both directions
+func (a *apiConfig) unlinkAccount(w http.ResponseWriter, r *http.Request) {
+	token, err := a.validateToken(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || !isAcessToken(claims.Issuer) {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	linkedID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = a.db.UnlinkUsers(claims.UserID, linkedID)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusNotFound, err.Error())
+		return
+	}
+	log.Printf("audit: user %d unlinked account %d", claims.UserID, linkedID)
+	w.WriteHeader(http.StatusNoContent)
+}
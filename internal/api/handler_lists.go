@@ -0,0 +1,246 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+const (
+	maxListNameLength        = 50
+	maxListDescriptionLength = 160
+)
+
+// listResponse is a list as shown to clients. members are only shown to the owner.
+type listResponse struct {
+	ID          int    `json:"id"`
+	OwnerID     int    `json:"owner_id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Private     bool   `json:"private"`
+	MemberCount int    `json:"member_count"`
+	Members     []int  `json:"members,omitempty"`
+}
+
+func newListResponse(list database.List, viewerID int) listResponse {
+	resp := listResponse{
+		ID:          list.ID,
+		OwnerID:     list.OwnerID,
+		Name:        list.Name,
+		Description: list.Description,
+		Private:     list.Private,
+		MemberCount: len(list.Members),
+	}
+	if viewerID == list.OwnerID {
+		resp.Members = list.Members
+	}
+	return resp
+}
+
+// POST /api/lists
+// createList creates an empty list owned by the caller
+func (a *apiConfig) createList(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	listReq := struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Private     bool   `json:"private"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&listReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+	listReq.Name = strings.TrimSpace(listReq.Name)
+	if listReq.Name == "" || len([]rune(listReq.Name)) > maxListNameLength {
+		respondWithError(w, http.StatusBadRequest, "name must be 1 to 50 characters")
+		return
+	}
+	if len([]rune(listReq.Description)) > maxListDescriptionLength {
+		respondWithError(w, http.StatusBadRequest, "description must be at most 160 characters")
+		return
+	}
+
+	list, err := a.listDatabase.CreateList(caller.ID, listReq.Name, listReq.Description, listReq.Private)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	resp, err := json.Marshal(newListResponse(list, caller.ID))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(resp)
+}
+
+// GET /api/users/me/lists
+// getMyLists returns every list the caller owns
+func (a *apiConfig) getMyLists(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	lists, err := a.listDatabase.GetListsByOwner(caller.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	items := make([]listResponse, 0, len(lists))
+	for _, list := range lists {
+		items = append(items, newListResponse(list, caller.ID))
+	}
+
+	resp, err := json.Marshal(items)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// POST /api/lists/{id}/members/{userID}
+// addListMember adds a user to one of the caller's lists
+func (a *apiConfig) addListMember(w http.ResponseWriter, r *http.Request) {
+	a.changeListMember(w, r, true)
+}
+
+// DELETE /api/lists/{id}/members/{userID}
+// removeListMember removes a user from one of the caller's lists
+func (a *apiConfig) removeListMember(w http.ResponseWriter, r *http.Request) {
+	a.changeListMember(w, r, false)
+}
+
+// changeListMember adds or removes a member. both are idempotent and
+// respond with the list as it is afterwards.
+func (a *apiConfig) changeListMember(w http.ResponseWriter, r *http.Request, add bool) {
+	caller, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	listID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid list id")
+		return
+	}
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	list, err := a.listDatabase.GetList(listID)
+	if err != nil {
+		listError(w, err)
+		return
+	}
+	// private lists of other users don't exist as far as the caller knows
+	if list.OwnerID != caller.ID {
+		if list.Private {
+			respondWithError(w, http.StatusNotFound, "list not found")
+			return
+		}
+		respondWithError(w, http.StatusForbidden, "only the owner can change a list")
+		return
+	}
+
+	if add {
+		if _, err := a.db.GetUserByID(userID); err != nil {
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		list, err = a.listDatabase.AddListMember(listID, userID)
+	} else {
+		list, err = a.listDatabase.RemoveListMember(listID, userID)
+	}
+	if err != nil {
+		listError(w, err)
+		return
+	}
+
+	resp, err := json.Marshal(newListResponse(list, caller.ID))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// GET /api/lists/{id}/chirps
+// getListChirps returns a page of the members' chirps, newest first
+// unless sort=asc. private lists are only visible to their owner.
+func (a *apiConfig) getListChirps(w http.ResponseWriter, r *http.Request) {
+	listID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid list id")
+		return
+	}
+	q, err := a.chirpQuery(r, true)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields, err := requestedFields(r, "chirp")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	list, err := a.listDatabase.GetList(listID)
+	if err != nil {
+		listError(w, err)
+		return
+	}
+	if list.Private {
+		caller, ok := a.accessTokenUser(r)
+		if !ok || caller.ID != list.OwnerID {
+			respondWithError(w, http.StatusNotFound, "list not found")
+			return
+		}
+	}
+
+	// the feed is newest first by default and only has the members' chirps the caller can read
+	vis, err := a.chirpVisibility(r)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	q.AuthorIDs = append([]int{}, list.Members...)
+	q.HiddenIDs = vis.hidden
+	q.Desc = r.URL.Query().Get("sort") != "asc"
+	feed, total, err := a.chirpyDatabase.QueryChirps(q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	resp, err := marshalList(newListEnvelope(feed, total, q.Limit, q.Offset), fields)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// listError maps database errors of the list handlers to responses
+func listError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrListNotFound) {
+		respondWithError(w, http.StatusNotFound, "list not found")
+		return
+	}
+	respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+}
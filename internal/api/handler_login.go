@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyPasswordHash is compared against when the email isn't registered, so
+// unknown accounts take as long as a wrong password
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("chirpy dummy password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// validate user logging in POST /api/login
+func (a *apiConfig) userValidation(w http.ResponseWriter, r *http.Request) {
+	// decode request to struct
+	userReq := apitypes.UserRequest{}
+	err := json.NewDecoder(r.Body).Decode(&userReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+	if msg, ok := missingCredentials(userReq); !ok {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if userReq.ExpiresInSeconds != nil && *userReq.ExpiresInSeconds <= 0 {
+		respondWithError(w, http.StatusBadRequest, "expires_in_seconds must be positive")
+		return
+	}
+
+	// refuse accounts with too many recent failures before touching the
+	// database. the delay runs outside any database lock.
+	if delay, locked := a.loginBackoff.check(userReq.Email); locked {
+		a.sleep(delay)
+		respondWithError(w, http.StatusTooManyRequests, "too many attempts, try later")
+		return
+	}
+
+	// an unknown email gets the same answer as a wrong password
+	user, err := a.db.GetUserByEmail(userReq.Email)
+	if errors.Is(err, database.ErrUserNotFound) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(userReq.Password))
+		a.loginBackoff.fail(userReq.Email)
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	err = bcrypt.CompareHashAndPassword(user.Password, []byte(userReq.Password))
+	if err != nil {
+		a.loginBackoff.fail(userReq.Email)
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	a.loginBackoff.reset(userReq.Email)
+
+	requested := 0
+	if userReq.ExpiresInSeconds != nil {
+		requested = *userReq.ExpiresInSeconds
+	}
+	a.completeLogin(w, user, a.accessTokenTTL(requested))
+}
+
+// completeLogin finishes a login whose first factor succeeded.
+// accounts with 2fa get a challenge to exchange at POST /api/login/totp,
+// which carries accessTTL along, everyone else gets their tokens.
+func (a *apiConfig) completeLogin(w http.ResponseWriter, user database.User, accessTTL time.Duration) {
+	if !user.TOTPEnabled {
+		a.writeLoginResponse(w, user, accessTTL)
+		return
+	}
+	a.writeTOTPChallenge(w, user, accessTTL, 0)
+}
+
+// writeTOTPChallenge answers 401 totp_required with a challenge for user,
+// linkedFrom is the user of a token exchange, 0 for a login
+func (a *apiConfig) writeTOTPChallenge(w http.ResponseWriter, user database.User, accessTTL time.Duration, linkedFrom int) {
+	challenge, err := a.issueTOTPChallenge(user.ID, accessTTL, linkedFrom)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating token")
+		return
+	}
+	resp, err := json.Marshal(struct {
+		Error          string `json:"error"`
+		ErrorCode      string `json:"error_code"`
+		ChallengeToken string `json:"challenge_token"`
+	}{
+		Error:          "TOTP code required",
+		ErrorCode:      "totp_required",
+		ChallengeToken: challenge,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error mashalling json")
+		return
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(resp)
+}
+
+// writeLoginResponse issues and stores a new token pair for the user,
+// the access token valid for accessTTL, and writes the login response body.
+// the session replaces the user's login session.
+func (a *apiConfig) writeLoginResponse(w http.ResponseWriter, user database.User, accessTTL time.Duration) {
+	a.writeSessionResponse(w, user, accessTTL, a.db.StoreToken)
+}
+
+// writeSessionResponse is writeLoginResponse storing the new session with store
+func (a *apiConfig) writeSessionResponse(w http.ResponseWriter, user database.User, accessTTL time.Duration,
+	store func(ID int, token string, family string, expiresAt time.Time) error) {
+	signedStringToken, signedStringRefreshToken, family, err := a.issueTokens(user.ID, accessTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating token")
+		return
+	}
+
+	err = store(user.ID, signedStringRefreshToken, family, a.clock.Now().Add(timeToExpireRefreshToken))
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "error storing refresh token")
+		return
+	}
+
+	resp, err := json.Marshal(apitypes.LoginResponse{
+		Token:        signedStringToken,
+		ExpiresIn:    int(accessTTL.Seconds()),
+		RefreshToken: signedStringRefreshToken,
+		IsChirpyRed:  user.IsChirpyRed,
+		ID:           user.ID,
+		Email:        user.Email,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error mashalling json")
+		return
+	}
+	w.Write(resp)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestLoginUnknownEmailLooksLikeWrongPassword(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	ts.signup(t, "known@example.com")
+
+	attempt := func(email string) (testResponse, time.Duration) {
+		start := time.Now()
+		resp := ts.request(t, "POST", "/api/login", apitypes.UserRequest{Email: email, Password: "wrong password"})
+		return resp, time.Since(start)
+	}
+	wrongPassword, wrongTook := attempt("known@example.com")
+	unknown, unknownTook := attempt("unknown@example.com")
+
+	wrongPassword.expect(t, http.StatusUnauthorized)
+	unknown.expect(t, http.StatusUnauthorized)
+	if !bytes.Equal(unknown.body, wrongPassword.body) {
+		t.Fatalf("unknown email answered %s, wrong password %s", unknown.body, wrongPassword.body)
+	}
+	// both run bcrypt, without the dummy compare the unknown email answers
+	// in well under a millisecond
+	if unknownTook < wrongTook/4 {
+		t.Fatalf("unknown email took %v, wrong password %v", unknownTook, wrongTook)
+	}
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GET /api/oauth/{provider}/login
+// oauthLogin redirects to the provider with a fresh state and pkce challenge
+func (a *apiConfig) oauthLogin(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := a.oauthProviders[name]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown oauth provider")
+		return
+	}
+
+	state, challenge, err := a.oauthStates.start(name, 0)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	http.Redirect(w, r, provider.AuthCodeURL(state, challenge), http.StatusFound)
+}
+
+// GET /api/oauth/{provider}/callback
+// oauthCallback validates the state and fetches the identity from the provider.
+// a link flow attaches the identity to the user who started it, a login flow
+// logs into the account linked to the identity or the account with that
+// email, creating it if needed.
+func (a *apiConfig) oauthCallback(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := a.oauthProviders[name]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown oauth provider")
+		return
+	}
+
+	st, ok := a.oauthStates.finish(r.URL.Query().Get("state"), name)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid oauth state")
+		return
+	}
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing oauth code")
+		return
+	}
+
+	identity, err := provider.Identity(r.Context(), code, st.codeVerifier)
+	if err != nil {
+		log.Printf("oauth %s: %v", name, err)
+		respondWithError(w, http.StatusBadGateway, "Error logging in with "+name)
+		return
+	}
+
+	if st.linkUserID != 0 {
+		err := a.db.LinkIdentity(st.linkUserID, name, identity.ProviderUserID)
+		if errors.Is(err, database.ErrIdentityTaken) {
+			respondWithErrorCode(w, http.StatusConflict, err.Error(), apitypes.ErrorCodeIdentityAlreadyLinked)
+			return
+		}
+		if err != nil {
+			respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if user, err := a.db.GetUserByIdentity(name, identity.ProviderUserID); err == nil {
+		a.completeLogin(w, user, a.accessTokenTTL(0))
+		return
+	}
+
+	user, err := a.db.GetUserByEmail(identity.Email)
+	if err == nil {
+		a.completeLogin(w, user, a.accessTokenTTL(0))
+		return
+	}
+	if !errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	if !a.emailDomainAllowed(identity.Email) {
+		respondWithErrorCode(w, http.StatusForbidden, "registration is not open to this email domain", apitypes.ErrorCodeEmailDomainNotAllowed)
+		return
+	}
+	user, err = a.createOAuthUser(identity, name)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Error creating user")
+		return
+	}
+	a.completeLogin(w, user, a.accessTokenTTL(0))
+}
+
+// createOAuthUser creates an account with a random password nobody knows
+// and links the identity to it.
+// the user can set a real password later with PUT /api/users.
+func (a *apiConfig) createOAuthUser(identity oauthIdentity, provider string) (database.User, error) {
+	random := make([]byte, 32)
+	if _, err := io.ReadFull(a.random, random); err != nil {
+		return database.User{}, err
+	}
+	password, err := bcrypt.GenerateFromPassword(random, bcrypt.DefaultCost)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	user, err := a.db.CreateUser(identity.Email, password)
+	if err != nil {
+		return database.User{}, err
+	}
+	err = a.db.LinkIdentity(user.ID, provider, identity.ProviderUserID)
+	if err != nil {
+		return database.User{}, err
+	}
+	return a.db.SetOAuthProvider(user.ID, provider)
+}
+
+// POST /api/users/me/identities/{provider}/link
+// linkIdentity starts an oauth flow bound to the logged-in user and returns
+// the url to send the user to. the callback attaches the identity.
+func (a *apiConfig) linkIdentity(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	name := r.PathValue("provider")
+	provider, ok := a.oauthProviders[name]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown oauth provider")
+		return
+	}
+
+	state, challenge, err := a.oauthStates.start(name, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	resp, err := json.Marshal(struct {
+		AuthorizeURL string `json:"authorize_url"`
+	}{
+		AuthorizeURL: provider.AuthCodeURL(state, challenge),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// DELETE /api/users/me/identities/{provider}/unlink
+// unlinkIdentity detaches the provider unless it is the last way to log in
+func (a *apiConfig) unlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	err := a.db.UnlinkIdentity(user.ID, r.PathValue("provider"))
+	if errors.Is(err, database.ErrLastLoginMethod) {
+		respondWithErrorCode(w, http.StatusConflict, err.Error(), apitypes.ErrorCodeLastLoginMethod)
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusNotFound, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
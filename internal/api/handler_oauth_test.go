@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// fakeOAuthProvider hands out the identity registered for each code and
+// records the pkce parameters it sees
+type fakeOAuthProvider struct {
+	mux        *sync.Mutex
+	identities map[string]oauthIdentity // by code
+	challenges map[string]string        // code challenge by state
+	verifiers  []string                 // code verifiers passed to Identity
+}
+
+func newFakeOAuthProvider() *fakeOAuthProvider {
+	return &fakeOAuthProvider{
+		mux:        &sync.Mutex{},
+		identities: make(map[string]oauthIdentity),
+		challenges: make(map[string]string),
+	}
+}
+
+func (p *fakeOAuthProvider) AuthCodeURL(state, codeChallenge string) string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.challenges[state] = codeChallenge
+	return "https://provider.example/authorize?state=" + url.QueryEscape(state)
+}
+
+func (p *fakeOAuthProvider) Identity(ctx context.Context, code, codeVerifier string) (oauthIdentity, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.verifiers = append(p.verifiers, codeVerifier)
+	identity, ok := p.identities[code]
+	if !ok {
+		return oauthIdentity{}, errors.New("bad verification code")
+	}
+	return identity, nil
+}
+
+// withFakeOAuth registers a fake provider as "fake" and one as "other"
+func (ts *testServer) withFakeOAuth() *fakeOAuthProvider {
+	provider := newFakeOAuthProvider()
+	ts.api.oauthProviders["fake"] = provider
+	ts.api.oauthProviders["other"] = newFakeOAuthProvider()
+	return provider
+}
+
+// oauthState starts a login at provider and returns the state of the
+// redirect, without following it
+func (ts *testServer) oauthState(t *testing.T, provider string) string {
+	t.Helper()
+	client := *ts.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(ts.URL + "/api/oauth/" + provider + "/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("oauth login answered %d, want a redirect", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("state")
+}
+
+// oauthCallback answers the callback of provider
+func (ts *testServer) oauthCallback(t *testing.T, provider, state, code string) testResponse {
+	t.Helper()
+	return ts.request(t, "GET", "/api/oauth/"+provider+"/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil)
+}
+
+func TestOAuthLoginState(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	provider := ts.withFakeOAuth()
+	provider.identities["good"] = oauthIdentity{ProviderUserID: "1", Email: "alice@example.com"}
+
+	ts.request(t, "GET", "/api/oauth/nope/login", nil).expect(t, http.StatusNotFound)
+	ts.request(t, "GET", "/api/oauth/nope/callback?state=x&code=good", nil).expect(t, http.StatusNotFound)
+
+	tests := []struct {
+		name   string
+		state  func() string
+		code   string
+		status int
+	}{
+		{"no state", func() string { return "" }, "good", http.StatusBadRequest},
+		{"made up state", func() string { return "not-a-state" }, "good", http.StatusBadRequest},
+		{"state of another provider", func() string { return ts.oauthState(t, "other") }, "good", http.StatusBadRequest},
+		{"expired state", func() string {
+			state := ts.oauthState(t, "fake")
+			ts.clock.Advance(oauthStateTTL + time.Second)
+			return state
+		}, "good", http.StatusBadRequest},
+		{"no code", func() string { return ts.oauthState(t, "fake") }, "", http.StatusBadRequest},
+		{"code the provider refuses", func() string { return ts.oauthState(t, "fake") }, "bad", http.StatusBadGateway},
+		{"valid", func() string { return ts.oauthState(t, "fake") }, "good", http.StatusOK},
+	}
+	var used string
+	for _, tt := range tests {
+		state := tt.state()
+		ts.oauthCallback(t, "fake", state, tt.code).expect(t, tt.status)
+		used = state
+	}
+	// a state is good for one callback only
+	ts.oauthCallback(t, "fake", used, "good").expect(t, http.StatusBadRequest)
+
+	// the verifier sent to the provider matches the challenge of the redirect
+	sum := sha256.Sum256([]byte(provider.verifiers[len(provider.verifiers)-1]))
+	if got := base64.RawURLEncoding.EncodeToString(sum[:]); got != provider.challenges[used] {
+		t.Fatalf("pkce verifier hashes to %q, the challenge was %q", got, provider.challenges[used])
+	}
+}
+
+func TestOAuthLoginAccounts(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	provider := ts.withFakeOAuth()
+	bob := ts.newUser(t, "bob@example.com")
+	provider.identities["new"] = oauthIdentity{ProviderUserID: "1", Email: "alice@example.com"}
+	provider.identities["renamed"] = oauthIdentity{ProviderUserID: "1", Email: "alice@elsewhere.example"}
+	provider.identities["bob"] = oauthIdentity{ProviderUserID: "2", Email: "Bob@Example.com"}
+
+	// a new email makes an account without a usable password
+	alice := decode[apitypes.LoginResponse](t, ts.oauthCallback(t, "fake", ts.oauthState(t, "fake"), "new").expect(t, http.StatusOK))
+	ts.request(t, "GET", "/api/users/me/limits", nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+	user, err := ts.store.GetUserByID(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "alice@example.com" || user.OAuthProvider != "fake" || !user.NoPassword {
+		t.Fatalf("oauth user = %+v", user)
+	}
+	ts.request(t, "POST", "/api/login", apitypes.UserRequest{Email: "alice@example.com", Password: testPassword}).expect(t, http.StatusUnauthorized)
+
+	// the identity logs into the same account, whatever its email is now
+	again := decode[apitypes.LoginResponse](t, ts.oauthCallback(t, "fake", ts.oauthState(t, "fake"), "renamed").expect(t, http.StatusOK))
+	if again.ID != alice.ID {
+		t.Fatalf("second oauth login got user %d, want %d", again.ID, alice.ID)
+	}
+
+	// the email of a password account logs into that account
+	existing := decode[apitypes.LoginResponse](t, ts.oauthCallback(t, "fake", ts.oauthState(t, "fake"), "bob").expect(t, http.StatusOK))
+	if existing.ID != bob.ID {
+		t.Fatalf("oauth login with bob's email got user %d, want %d", existing.ID, bob.ID)
+	}
+	if users, err := ts.store.GetUser(); err != nil || len(users) != 2 {
+		t.Fatalf("users = %v, %v, want alice and bob only", users, err)
+	}
+	ts.login(t, "bob@example.com")
+}
+
+func TestOAuthLinkIdentity(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	provider := ts.withFakeOAuth()
+	alice := ts.newUser(t, "alice@example.com")
+	bob := ts.newUser(t, "bob@example.com")
+	provider.identities["gh-alice"] = oauthIdentity{ProviderUserID: "10", Email: "someone@else.example"}
+	provider.identities["gh-bob"] = oauthIdentity{ProviderUserID: "20", Email: "bob@example.com"}
+
+	linkState := func(token string) string {
+		t.Helper()
+		link := decode[struct {
+			AuthorizeURL string `json:"authorize_url"`
+		}](t, ts.request(t, "POST", "/api/users/me/identities/fake/link", nil, bearer(token)...).expect(t, http.StatusOK))
+		u, err := url.Parse(link.AuthorizeURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Query().Get("state")
+	}
+	ts.request(t, "POST", "/api/users/me/identities/fake/link", nil).expect(t, http.StatusUnauthorized)
+	ts.request(t, "POST", "/api/users/me/identities/nope/link", nil, bearer(alice.Token)...).expect(t, http.StatusNotFound)
+
+	// the link state belongs to alice, the identity's email doesn't matter
+	ts.oauthCallback(t, "other", linkState(alice.Token), "gh-alice").expect(t, http.StatusBadRequest)
+	ts.oauthCallback(t, "fake", linkState(alice.Token), "gh-alice").expect(t, http.StatusNoContent)
+	login := decode[apitypes.LoginResponse](t, ts.oauthCallback(t, "fake", ts.oauthState(t, "fake"), "gh-alice").expect(t, http.StatusOK))
+	if login.ID != alice.ID {
+		t.Fatalf("login with the linked identity got user %d, want %d", login.ID, alice.ID)
+	}
+	ts.login(t, "alice@example.com")
+	// linking the same identity again is fine
+	ts.oauthCallback(t, "fake", linkState(alice.Token), "gh-alice").expect(t, http.StatusNoContent)
+
+	// an identity of another account, or a second one of the provider, is refused
+	resp := ts.oauthCallback(t, "fake", linkState(bob.Token), "gh-alice").expect(t, http.StatusConflict)
+	if got := decode[apitypes.ErrorResponse](t, resp).ErrorCode; got != apitypes.ErrorCodeIdentityAlreadyLinked {
+		t.Fatalf("error_code = %q, want %q", got, apitypes.ErrorCodeIdentityAlreadyLinked)
+	}
+	resp = ts.oauthCallback(t, "fake", linkState(alice.Token), "gh-bob").expect(t, http.StatusConflict)
+	if got := decode[apitypes.ErrorResponse](t, resp).ErrorCode; got != apitypes.ErrorCodeIdentityAlreadyLinked {
+		t.Fatalf("error_code = %q, want %q", got, apitypes.ErrorCodeIdentityAlreadyLinked)
+	}
+
+	// alice still has a password, so the identity can go
+	ts.request(t, "DELETE", "/api/users/me/identities/fake/unlink", nil, bearer(alice.Token)...).expect(t, http.StatusNoContent)
+	ts.request(t, "DELETE", "/api/users/me/identities/fake/unlink", nil, bearer(alice.Token)...).expect(t, http.StatusNotFound)
+
+	// an oauth-only account can't drop its only login method
+	provider.identities["gh-carol"] = oauthIdentity{ProviderUserID: "30", Email: "carol@example.com"}
+	carol := decode[apitypes.LoginResponse](t, ts.oauthCallback(t, "fake", ts.oauthState(t, "fake"), "gh-carol").expect(t, http.StatusOK))
+	resp = ts.request(t, "DELETE", "/api/users/me/identities/fake/unlink", nil, bearer(carol.Token)...).expect(t, http.StatusConflict)
+	if got := decode[apitypes.ErrorResponse](t, resp).ErrorCode; got != apitypes.ErrorCodeLastLoginMethod {
+		t.Fatalf("error_code = %q, want %q", got, apitypes.ErrorCodeLastLoginMethod)
+	}
+	ts.request(t, "DELETE", "/api/users/me/identities/fake/unlink", nil).expect(t, http.StatusUnauthorized)
+}
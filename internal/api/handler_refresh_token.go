@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// POST /api/refresh
+// refreshTokenAuth authorizes user with refresh token on the database
+// then sends a new access-token and a new refresh-token to the user.
+// the refresh-token sent is rotated out, sending it again revokes the
+// session since only a stolen copy would still be used. within
+// refreshGraceWindow it's answered with the token it was rotated to instead,
+// that's two tabs refreshing at once.
+func (a *apiConfig) refreshTokenAuth(w http.ResponseWriter, r *http.Request) {
+
+	token, err := a.validateToken(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if claims, ok := token.Claims.(*CustomClaims); ok {
+		if !isRefreshToken(claims.Issuer) {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		a.refreshGrace.mux.Lock()
+		defer a.refreshGrace.mux.Unlock()
+		user, err := a.db.GetUserByID(claims.UserID)
+		if err != nil {
+			respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		// tokens stored without an expiry predate it being tracked
+		if user.RefreshToken == token.Raw && (user.RefreshTokenExpiresAt == nil || !a.clock.Now().Before(*user.RefreshTokenExpiresAt)) {
+			respondWithError(w, http.StatusUnauthorized, "Refresh token expired")
+			return
+		}
+
+		// a login that shortened its access token keeps it short
+		accessTTL := a.accessTokenTTL(claims.AccessTTL)
+		stringToken, err := a.signAccessToken(claims.UserID, accessTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error signstring token")
+			return
+		}
+		if next, ok := a.refreshGrace.lookup(token.Raw); ok && holdsRefreshToken(user, next) {
+			log.Printf("audit: refresh token of user %d sent again within the grace window", claims.UserID)
+			a.writeRefreshResponse(w, stringToken, accessTTL, next)
+			return
+		}
+
+		// tokens from before rotation have no family, they start one
+		family := claims.Family
+		if family == "" {
+			family, err = a.randomTokenID()
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Error signstring token")
+				return
+			}
+		}
+		// the session keeps the expiry of its login
+		nextRefreshToken, err := a.signRefreshToken(claims.UserID, family, accessTTL, claims.ExpiresAt.Time)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error signstring token")
+			return
+		}
+
+		err = a.db.RotateToken(claims.UserID, token.Raw, family, nextRefreshToken)
+		if errors.Is(err, database.ErrRefreshTokenReused) {
+			log.Printf("audit: refresh token of user %d was reused, session revoked", claims.UserID)
+			respondWithError(w, http.StatusUnauthorized, "Invalid Token")
+			return
+		}
+		if errors.Is(err, database.ErrRefreshTokenInvalid) || errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid Token")
+			return
+		}
+		if err != nil {
+			respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		a.refreshGrace.record(token.Raw, nextRefreshToken)
+		a.writeRefreshResponse(w, stringToken, accessTTL, nextRefreshToken)
+	}
+}
+
+// writeRefreshResponse writes the body of a successful refresh
+func (a *apiConfig) writeRefreshResponse(w http.ResponseWriter, token string, accessTTL time.Duration, refreshToken string) {
+	resp, err := json.Marshal(apitypes.RefreshResponse{
+		Token:        token,
+		ExpiresIn:    int(accessTTL.Seconds()),
+		RefreshToken: refreshToken,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestRefreshGraceWindowToleratesHonestRace(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	login := ts.newUser(t, "alice@example.com")
+
+	// every tab sends the same token at once, all of them end up with the
+	// token the first rotation handed out
+	const tabs = 8
+	results := make([]testResponse, tabs)
+	var wg sync.WaitGroup
+	for i := range tabs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = ts.request(t, "POST", "/api/refresh", nil, bearer(login.RefreshToken)...)
+		}()
+	}
+	wg.Wait()
+	next := ""
+	for _, resp := range results {
+		got := decode[apitypes.RefreshResponse](t, resp.expect(t, http.StatusOK))
+		if next == "" {
+			next = got.RefreshToken
+		}
+		if got.RefreshToken != next || got.Token == "" {
+			t.Fatalf("racing tabs got refresh tokens %q and %q", next, got.RefreshToken)
+		}
+	}
+
+	ts.clock.Advance(refreshGraceWindow - 1)
+	if got := ts.refresh(t, login.RefreshToken, http.StatusOK); got.RefreshToken != next {
+		t.Fatal("a token sent again inside the window didn't get the session's current token")
+	}
+	ts.refresh(t, next, http.StatusOK)
+}
+
+func TestRefreshReuseAfterGraceWindowRevokesSession(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	login := ts.newUser(t, "alice@example.com")
+
+	next := ts.refresh(t, login.RefreshToken, http.StatusOK)
+	ts.clock.Advance(refreshGraceWindow)
+	ts.refresh(t, login.RefreshToken, http.StatusUnauthorized)
+	// the whole family goes, the token the victim rotated to included
+	ts.refresh(t, next.RefreshToken, http.StatusUnauthorized)
+
+	// the window doesn't revive a revoked session either
+	relogin := ts.login(t, "alice@example.com")
+	rotated := ts.refresh(t, relogin.RefreshToken, http.StatusOK)
+	ts.request(t, "POST", "/api/revoke", nil, bearer(rotated.RefreshToken)...).expect(t, http.StatusOK)
+	ts.refresh(t, relogin.RefreshToken, http.StatusUnauthorized)
+}
+
+func TestRefreshRotation(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := start(t)
+			login := ts.newUser(t, "alice@example.com")
+
+			seen := map[string]bool{login.RefreshToken: true}
+			chain := []string{login.RefreshToken}
+			for range 3 {
+				ts.clock.Advance(refreshGraceWindow)
+				next := ts.refresh(t, chain[len(chain)-1], http.StatusOK)
+				if seen[next.RefreshToken] {
+					t.Fatal("a rotation handed out a refresh token seen before")
+				}
+				seen[next.RefreshToken] = true
+				chain = append(chain, next.RefreshToken)
+				ts.request(t, "GET", "/api/users/me/limits", nil, bearer(next.Token)...).expect(t, http.StatusOK)
+			}
+
+			// an old token of the chain revokes the session
+			ts.clock.Advance(refreshGraceWindow)
+			ts.refresh(t, chain[1], http.StatusUnauthorized)
+			ts.refresh(t, chain[len(chain)-1], http.StatusUnauthorized)
+			user, err := ts.store.GetUserByID(login.ID)
+			if err != nil || user.RefreshToken != "" {
+				t.Fatalf("after the reuse the stored token is %q, %v", user.RefreshToken, err)
+			}
+		})
+	}
+}
+
+func TestRefreshConcurrentSessions(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+
+	// users refreshing at the same time never trip each other's reuse
+	// detection, and each keeps a working chain
+	const users, rotations = 4, 10
+	logins := make([]apitypes.LoginResponse, users)
+	for i := range users {
+		logins[i] = ts.newUser(t, "user"+string(rune('a'+i))+"@example.com")
+	}
+	var wg sync.WaitGroup
+	for i := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token := logins[i].RefreshToken
+			for range rotations {
+				resp := ts.request(t, "POST", "/api/refresh", nil, bearer(token)...)
+				if resp.status != http.StatusOK {
+					t.Errorf("user %d: refresh answered %d: %s", logins[i].ID, resp.status, resp.body)
+					return
+				}
+				token = decode[apitypes.RefreshResponse](t, resp).RefreshToken
+			}
+			logins[i].RefreshToken = token
+		}()
+	}
+	wg.Wait()
+	for _, login := range logins {
+		ts.refresh(t, login.RefreshToken, http.StatusOK)
+	}
+}
+
+func TestRefreshTokenExpiry(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	login := ts.newUser(t, "alice@example.com")
+
+	// rotations keep the login's expiry, they don't extend the session
+	ts.clock.Advance(timeToExpireRefreshToken - time.Second)
+	rotated := ts.refresh(t, login.RefreshToken, http.StatusOK)
+	ts.clock.Advance(time.Second)
+	resp := ts.request(t, "POST", "/api/refresh", nil, bearer(rotated.RefreshToken)...).expect(t, http.StatusUnauthorized)
+	if got := errorOf(t, resp); got != errTokenExpired.Error() {
+		t.Fatalf("refresh after the expiry: %q, want %q", got, errTokenExpired)
+	}
+}
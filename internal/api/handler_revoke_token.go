@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+)
+
+// POST /api/revoke
+// revokeToken revokes the refresh-token in the database
+// then creates and sends new refresh-token to the user and the database.
+func (a *apiConfig) revokeToken(w http.ResponseWriter, r *http.Request) {
+
+	token, err := a.validateToken(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if claims, ok := token.Claims.(*CustomClaims); ok {
+		if !isRefreshToken(claims.Issuer) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		// revoke the session of the refresh token in the database, the
+		// user's other sessions stay
+		err := a.db.RevokeSession(claims.UserID, claims.Family)
+		if err != nil {
+			respondWithDBError(w, err, http.StatusInternalServerError, "Error revoking token")
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// POST /api/token/exchange
+// exchangeToken issues a normal access/refresh pair for an account linked
+// to the logged-in user. the pair opens a session of its own, so the
+// target's login session keeps working. a target with 2fa answers with a
+// challenge to exchange at POST /api/login/totp first.
+func (a *apiConfig) exchangeToken(w http.ResponseWriter, r *http.Request) {
+	token, err := a.validateToken(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || !isAcessToken(claims.Issuer) {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	exchangeReq := struct {
+		UserID int `json:"user_id"`
+	}{}
+	err = json.NewDecoder(r.Body).Decode(&exchangeReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+
+	user, err := a.db.GetUserByID(claims.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if !slices.Contains(user.LinkedAccounts, exchangeReq.UserID) {
+		respondWithError(w, http.StatusForbidden, "Account is not linked")
+		return
+	}
+	target, err := a.db.GetUserByID(exchangeReq.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if target.TOTPEnabled {
+		log.Printf("audit: user %d asked to exchange token for linked account %d, totp required", user.ID, target.ID)
+		a.writeTOTPChallenge(w, target, a.accessTokenTTL(0), user.ID)
+		return
+	}
+	log.Printf("audit: user %d exchanged token for linked account %d", user.ID, target.ID)
+	a.writeSessionResponse(w, target, a.accessTokenTTL(0), a.db.AddSession)
+}
+
+// linkedExchange finishes the token exchange of a totp challenge issued to
+// linkedFrom, as long as the accounts are still linked
+func (a *apiConfig) linkedExchange(w http.ResponseWriter, target database.User, accessTTL time.Duration, linkedFrom int) {
+	if !slices.Contains(target.LinkedAccounts, linkedFrom) {
+		respondWithError(w, http.StatusForbidden, "Account is not linked")
+		return
+	}
+	log.Printf("audit: user %d exchanged token for linked account %d", linkedFrom, target.ID)
+	a.writeSessionResponse(w, target, accessTTL, a.db.AddSession)
+}
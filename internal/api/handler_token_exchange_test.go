@@ -0,0 +1,118 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// linkAccounts links the account of from to the account of to
+func (ts *testServer) linkAccounts(t *testing.T, from, to apitypes.LoginResponse) {
+	t.Helper()
+	ts.request(t, "POST", "/api/users/me/link", map[string]string{"token": to.Token}, bearer(from.Token)...).
+		expect(t, http.StatusNoContent)
+}
+
+// exchange asks for tokens of userID with the access token
+func (ts *testServer) exchange(t *testing.T, token string, userID int) testResponse {
+	t.Helper()
+	return ts.request(t, "POST", "/api/token/exchange", map[string]int{"user_id": userID}, bearer(token)...)
+}
+
+// refresh rotates a refresh token, failing the test unless it answers status
+func (ts *testServer) refresh(t *testing.T, refreshToken string, status int) apitypes.RefreshResponse {
+	t.Helper()
+	resp := ts.request(t, "POST", "/api/refresh", nil, bearer(refreshToken)...).expect(t, status)
+	if status != http.StatusOK {
+		return apitypes.RefreshResponse{}
+	}
+	return decode[apitypes.RefreshResponse](t, resp)
+}
+
+func TestTokenExchangeKeepsTargetSession(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	bob := ts.newUser(t, "bob@example.com")
+	ts.linkAccounts(t, alice, bob)
+
+	exchanged := decode[apitypes.LoginResponse](t, ts.exchange(t, alice.Token, bob.ID).expect(t, http.StatusOK))
+	if exchanged.ID != bob.ID || exchanged.RefreshToken == bob.RefreshToken {
+		t.Fatalf("exchange = %+v, want new tokens of user %d", exchanged, bob.ID)
+	}
+
+	// bob's own login and the exchanged session rotate independently
+	bobSession := ts.refresh(t, bob.RefreshToken, http.StatusOK)
+	exchangedSession := ts.refresh(t, exchanged.RefreshToken, http.StatusOK)
+
+	// revoking the exchanged session leaves bob logged in
+	ts.request(t, "POST", "/api/revoke", nil, bearer(exchangedSession.RefreshToken)...).expect(t, http.StatusOK)
+	ts.refresh(t, exchangedSession.RefreshToken, http.StatusUnauthorized)
+	bobSession = ts.refresh(t, bobSession.RefreshToken, http.StatusOK)
+
+	// a reused token of the exchanged session doesn't revoke bob's login
+	second := decode[apitypes.LoginResponse](t, ts.exchange(t, alice.Token, bob.ID).expect(t, http.StatusOK))
+	ts.refresh(t, second.RefreshToken, http.StatusOK)
+	ts.clock.Advance(refreshGraceWindow)
+	ts.refresh(t, second.RefreshToken, http.StatusUnauthorized)
+	ts.refresh(t, bobSession.RefreshToken, http.StatusOK)
+
+	carol := ts.newUser(t, "carol@example.com")
+	if got := errorOf(t, ts.exchange(t, alice.Token, carol.ID).expect(t, http.StatusForbidden)); got != "Account is not linked" {
+		t.Fatalf("exchange for an unlinked account: %q", got)
+	}
+}
+
+func TestTokenExchangeRequiresTargetTOTP(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	bob := ts.newUser(t, "bob@example.com")
+	ts.linkAccounts(t, alice, bob)
+
+	const secret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	if err := ts.store.SetPendingTOTP(bob.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.EnableTOTP(bob.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := ts.exchange(t, alice.Token, bob.ID).expect(t, http.StatusUnauthorized)
+	challenge := decode[struct {
+		ErrorCode      string `json:"error_code"`
+		ChallengeToken string `json:"challenge_token"`
+	}](t, resp)
+	if challenge.ErrorCode != "totp_required" || challenge.ChallengeToken == "" {
+		t.Fatalf("exchange for a totp account answered %s", resp.body)
+	}
+
+	ts.request(t, "POST", "/api/login/totp", totpRequest{ChallengeToken: challenge.ChallengeToken, Code: "000000"}).
+		expect(t, http.StatusUnauthorized)
+
+	code, err := totpCode(secret, ts.clock.Now().Unix()/totpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = ts.request(t, "POST", "/api/login/totp", totpRequest{ChallengeToken: challenge.ChallengeToken, Code: code})
+	exchanged := decode[apitypes.LoginResponse](t, resp.expect(t, http.StatusOK))
+	if exchanged.ID != bob.ID {
+		t.Fatalf("totp exchange returned tokens of user %d, want %d", exchanged.ID, bob.ID)
+	}
+	ts.refresh(t, bob.RefreshToken, http.StatusOK)
+	ts.refresh(t, exchanged.RefreshToken, http.StatusOK)
+
+	// unlinking before the challenge is answered stops the exchange
+	resp = ts.exchange(t, alice.Token, bob.ID).expect(t, http.StatusUnauthorized)
+	challenge.ChallengeToken = decode[struct {
+		ChallengeToken string `json:"challenge_token"`
+	}](t, resp).ChallengeToken
+	ts.request(t, "DELETE", "/api/users/me/link/"+strconv.Itoa(bob.ID), nil, bearer(alice.Token)...).expect(t, http.StatusNoContent)
+	ts.clock.Advance(totpPeriod * time.Second)
+	code, _ = totpCode(secret, ts.clock.Now().Unix()/totpPeriod)
+	ts.request(t, "POST", "/api/login/totp", totpRequest{ChallengeToken: challenge.ChallengeToken, Code: code}).
+		expect(t, http.StatusForbidden)
+}
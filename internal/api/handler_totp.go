@@ -0,0 +1,218 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/friday1602/chirpy/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type totpRequest struct {
+	Code           string `json:"code"`
+	Password       string `json:"password"`
+	ChallengeToken string `json:"challenge_token"`
+}
+
+// accessTokenUser returns the user of the access token in the request
+func (a *apiConfig) accessTokenUser(r *http.Request) (database.User, bool) {
+	token, err := a.validateToken(r)
+	if err != nil {
+		return database.User{}, false
+	}
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || !isAcessToken(claims.Issuer) {
+		return database.User{}, false
+	}
+	user, err := a.db.GetUserByID(claims.UserID)
+	if err != nil {
+		return database.User{}, false
+	}
+	return user, true
+}
+
+// checkSecondFactor accepts either a current totp code or an unused backup code
+func (a *apiConfig) checkSecondFactor(user database.User, code string) (bool, error) {
+	if verifyTOTP(user.TOTPSecret, code, a.clock.Now()) {
+		return true, nil
+	}
+	return a.db.UseBackupCode(user.ID, hashBackupCode(code))
+}
+
+// POST /api/users/me/totp/setup
+// setupTOTP generates a secret and stores it pending until verified
+func (a *apiConfig) setupTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	secret, err := generateTOTPSecret(a.random)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating totp secret")
+		return
+	}
+	err = a.db.SetPendingTOTP(user.ID, secret)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, err := json.Marshal(struct {
+		Secret     string `json:"secret"`
+		OTPAuthURL string `json:"otpauth_url"`
+	}{
+		Secret:     secret,
+		OTPAuthURL: totpURL(user.Email, secret),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// POST /api/users/me/totp/verify
+// verifyTOTPSetup activates 2fa once the user proves the authenticator works,
+// responding with the backup codes. they are only shown this once.
+func (a *apiConfig) verifyTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	totpReq := totpRequest{}
+	err := json.NewDecoder(r.Body).Decode(&totpReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+
+	if user.TOTPPendingSecret == "" {
+		respondWithError(w, http.StatusBadRequest, "TOTP setup has not been started")
+		return
+	}
+	if !verifyTOTP(user.TOTPPendingSecret, totpReq.Code, a.clock.Now()) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := generateBackupCodes(a.random)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating backup codes")
+		return
+	}
+	err = a.db.EnableTOTP(user.ID, hashes)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, err := json.Marshal(struct {
+		BackupCodes []string `json:"backup_codes"`
+	}{
+		BackupCodes: codes,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// POST /api/users/me/totp/disable
+// disableTOTP turns 2fa off, requiring both the password and a code
+func (a *apiConfig) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.accessTokenUser(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	totpReq := totpRequest{}
+	err := json.NewDecoder(r.Body).Decode(&totpReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+
+	if !user.TOTPEnabled {
+		respondWithError(w, http.StatusBadRequest, "TOTP is not enabled")
+		return
+	}
+	err = bcrypt.CompareHashAndPassword(user.Password, []byte(totpReq.Password))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	ok, err = a.checkSecondFactor(user, totpReq.Code)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	err = a.db.DisableTOTP(user.ID)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/login/totp
+// loginTOTP exchanges the challenge from POST /api/login and a valid code
+// for the real tokens. failed codes count towards the login backoff.
+func (a *apiConfig) loginTOTP(w http.ResponseWriter, r *http.Request) {
+	totpReq := totpRequest{}
+	err := json.NewDecoder(r.Body).Decode(&totpReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+
+	token, err := a.parseToken(totpReq.ChallengeToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid challenge token")
+		return
+	}
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || !isTOTPChallenge(claims.Issuer) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid challenge token")
+		return
+	}
+	user, err := a.db.GetUserByID(claims.UserID)
+	if err != nil || !user.TOTPEnabled {
+		respondWithError(w, http.StatusUnauthorized, "Invalid challenge token")
+		return
+	}
+
+	if delay, locked := a.loginBackoff.check(user.Email); locked {
+		a.sleep(delay)
+		respondWithError(w, http.StatusTooManyRequests, "too many attempts, try later")
+		return
+	}
+
+	ok, err = a.checkSecondFactor(user, totpReq.Code)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if !ok {
+		a.loginBackoff.fail(user.Email)
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	a.loginBackoff.reset(user.Email)
+
+	if claims.LinkedFrom != 0 {
+		a.linkedExchange(w, user, a.accessTokenTTL(claims.AccessTTL), claims.LinkedFrom)
+		return
+	}
+	a.writeLoginResponse(w, user, a.accessTokenTTL(claims.AccessTTL))
+}
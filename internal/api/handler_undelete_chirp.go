@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// POST /api/chirps/{chirpID}/undelete
+// undeleteChirpy restores a chirp deleted within the undo window.
+// the undo token is single-use and only valid for the same chirp and user.
+func (a *apiConfig) undeleteChirpy(w http.ResponseWriter, r *http.Request) {
+	chirpID := r.PathValue("chirpID")
+	ID, err := strconv.Atoi(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	token, err := a.validateToken(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || !isAcessToken(claims.Issuer) {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	undoReq := struct {
+		UndoToken string `json:"undo_token"`
+	}{}
+	err = json.NewDecoder(r.Body).Decode(&undoReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+
+	if !a.undoTokens.consume(undoReq.UndoToken, "undelete-chirp", undoParams(claims.UserID, ID)) {
+		respondWithError(w, http.StatusForbidden, "Invalid or expired undo token")
+		return
+	}
+
+	chirp, err := a.chirpyDatabase.RestoreChirp(claims.UserID, ID)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusNotFound, err.Error())
+		return
+	}
+
+	resp, err := json.Marshal(chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
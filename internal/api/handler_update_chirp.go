@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// PUT /api/chirps/{chirpID}
+// updateChirpy replaces the body of a chirp. only its author can, and the
+// new body goes through the same checks and filter as creating a chirp.
+func (a *apiConfig) updateChirpy(w http.ResponseWriter, r *http.Request) {
+	ID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+	author, ok := a.chirpAuthor(w, r)
+	if !ok {
+		return
+	}
+
+	req := apitypes.UpdateChirpRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+	verdict := a.checkChirp(apitypes.CreateChirpRequest{Body: req.Body}, author)
+	if !verdict.Valid {
+		respondWithError(w, http.StatusBadRequest, verdict.Error)
+		return
+	}
+
+	chirp, err := a.chirpyDatabase.UpdateChirp(author.ID, ID, verdict.Body)
+	if errors.Is(err, database.ErrChirpNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrNotChirpAuthor) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	resp, err := json.Marshal(chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
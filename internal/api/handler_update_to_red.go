@@ -0,0 +1,129 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// polkaMaxSkew is how far a webhook's timestamp may be from now, so a
+// captured request can't be replayed later
+const polkaMaxSkew = 5 * time.Minute
+
+type webhooksRequest struct {
+	Event string `json:"event"`
+	// Timestamp is when polka sent the call in unix seconds,
+	// the X-Polka-Timestamp header when unset
+	Timestamp int64 `json:"timestamp"`
+	Data      struct {
+		UserID int `json:"user_id"`
+	} `json:"data"`
+}
+
+// polkaAPIKey is the api key polka sends as Authorization: ApiKey <key>.
+// POLKA_API_KEY is still read when POLKA_KEY is unset.
+func polkaAPIKey() string {
+	if key := os.Getenv("POLKA_KEY"); key != "" {
+		return key
+	}
+	return os.Getenv("POLKA_API_KEY")
+}
+
+// POST /api/polka/webhooks
+// upgradeToRedChirpy handles polka webhooks. user.upgraded makes the user
+// Chirpy Red, other events are acknowledged and ignored. every attempt is
+// recorded in a.webhooks so operators can see rejected calls.
+// every check runs before the database is touched, and each rejection
+// has its own error code for polka's logs.
+func (a *apiConfig) upgradeToRedChirpy(w http.ResponseWriter, r *http.Request) {
+	sw := &statusWriter{ResponseWriter: w}
+	attempt := webhookAttempt{Time: a.clock.Now(), Auth: "ok"}
+	defer func() {
+		attempt.Status = sw.status
+		if attempt.Status == 0 {
+			attempt.Status = http.StatusOK
+		}
+		a.webhooks.record(attempt)
+	}()
+
+	apiKey, hasKey := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey ")
+	polkaKey := polkaAPIKey()
+
+	body, err := io.ReadAll(r.Body)
+	attempt.Body = webhookBody(body, polkaKey)
+
+	webhooksReq := webhooksRequest{}
+	if err == nil {
+		err = json.Unmarshal(body, &webhooksReq)
+	}
+	attempt.Event = webhooksReq.Event
+	attempt.UserID = webhooksReq.Data.UserID
+
+	if !hasKey || apiKey == "" {
+		attempt.Auth = "missing"
+		respondWithError(sw, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// an unset key rejects every call
+	if polkaKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(polkaKey)) != 1 {
+		attempt.Auth = "invalid"
+		respondWithError(sw, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	mediaType, _, mediaErr := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaErr != nil || mediaType != "application/json" {
+		respondWithErrorCode(sw, http.StatusUnsupportedMediaType, "Content-Type must be application/json", apitypes.ErrorCodeUnsupportedMediaType)
+		return
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithErrorCode(sw, http.StatusRequestEntityTooLarge, "body too large", apitypes.ErrorCodeBodyTooLarge)
+		return
+	}
+	if err != nil {
+		respondWithErrorCode(sw, http.StatusBadRequest, err.Error(), apitypes.ErrorCodeInvalidJSON)
+		return
+	}
+
+	sentAt := webhooksReq.Timestamp
+	if sentAt == 0 {
+		sentAt, _ = strconv.ParseInt(r.Header.Get("X-Polka-Timestamp"), 10, 64)
+	}
+	if sentAt <= 0 {
+		respondWithErrorCode(sw, http.StatusBadRequest, "timestamp is required", apitypes.ErrorCodeMissingTimestamp)
+		return
+	}
+	if skew := a.clock.Now().Sub(time.Unix(sentAt, 0)).Abs(); skew > polkaMaxSkew {
+		respondWithErrorCode(sw, http.StatusBadRequest, "timestamp is more than 5 minutes off", apitypes.ErrorCodeStaleTimestamp)
+		return
+	}
+
+	if webhooksReq.Event != "user.upgraded" {
+		sw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	err = a.db.UpgradeUser(webhooksReq.Data.UserID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(sw, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithDBError(sw, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	sw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestPolkaWebhook(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	userID := ts.signup(t, "alice@example.com")
+	now := ts.clock.Now()
+
+	// webhook is a polka call for userID sent at sentAt, 0 leaves the timestamp out
+	webhook := func(event string, userID int, sentAt time.Time) string {
+		body := map[string]any{"event": event, "data": map[string]int{"user_id": userID}}
+		if !sentAt.IsZero() {
+			body["timestamp"] = sentAt.Unix()
+		}
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	polkaKey := []string{"Authorization", "ApiKey " + testPolkaKey, "Content-Type", "application/json"}
+	withHeaders := func(headers ...string) []string {
+		return append(append([]string{}, polkaKey...), headers...)
+	}
+
+	rejections := []struct {
+		name    string
+		method  string
+		body    string
+		headers []string
+		status  int
+		code    string
+	}{
+		{"get", "GET", "", polkaKey, http.StatusMethodNotAllowed, ""},
+		{"put", "PUT", webhook("user.upgraded", userID, now), polkaKey, http.StatusMethodNotAllowed, ""},
+		{"no key", "POST", webhook("user.upgraded", userID, now), []string{"Content-Type", "application/json"}, http.StatusUnauthorized, ""},
+		{"wrong key", "POST", webhook("user.upgraded", userID, now), withHeaders("Authorization", "ApiKey wrong"), http.StatusUnauthorized, ""},
+		{"form", "POST", webhook("user.upgraded", userID, now), withHeaders("Content-Type", "application/x-www-form-urlencoded"), http.StatusUnsupportedMediaType, apitypes.ErrorCodeUnsupportedMediaType},
+		{"text", "POST", webhook("user.upgraded", userID, now), withHeaders("Content-Type", "text/plain"), http.StatusUnsupportedMediaType, apitypes.ErrorCodeUnsupportedMediaType},
+		{"too large", "POST", `{"event": "user.upgraded", "padding": "` + strings.Repeat("x", 2<<10) + `"}`, polkaKey, http.StatusRequestEntityTooLarge, apitypes.ErrorCodeBodyTooLarge},
+		{"malformed", "POST", `{"event": `, polkaKey, http.StatusBadRequest, apitypes.ErrorCodeInvalidJSON},
+		{"no timestamp", "POST", webhook("user.upgraded", userID, time.Time{}), polkaKey, http.StatusBadRequest, apitypes.ErrorCodeMissingTimestamp},
+		{"stale", "POST", webhook("user.upgraded", userID, now.Add(-polkaMaxSkew-time.Second)), polkaKey, http.StatusBadRequest, apitypes.ErrorCodeStaleTimestamp},
+		{"from the future", "POST", webhook("user.upgraded", userID, now.Add(polkaMaxSkew+time.Second)), polkaKey, http.StatusBadRequest, apitypes.ErrorCodeStaleTimestamp},
+		{"stale header", "POST", webhook("user.upgraded", userID, time.Time{}), withHeaders("X-Polka-Timestamp", strconv.FormatInt(now.Add(-time.Hour).Unix(), 10)), http.StatusBadRequest, apitypes.ErrorCodeStaleTimestamp},
+		{"unknown user", "POST", webhook("user.upgraded", 99, now), polkaKey, http.StatusNotFound, ""},
+	}
+	for _, c := range rejections {
+		t.Run(c.name, func(t *testing.T) {
+			var body any
+			if c.body != "" {
+				body = c.body
+			}
+			resp := ts.request(t, c.method, "/api/polka/webhooks", body, c.headers...).expect(t, c.status)
+			if c.code != "" {
+				if got := decode[apitypes.ErrorResponse](t, resp).ErrorCode; got != c.code {
+					t.Fatalf("error_code = %q, want %q", got, c.code)
+				}
+			}
+			user, err := ts.store.GetUserByID(userID)
+			if err != nil || user.IsChirpyRed {
+				t.Fatalf("user after a rejected webhook = %+v, %v, want not Chirpy Red", user, err)
+			}
+		})
+	}
+
+	// other events are acknowledged without an upgrade
+	ts.request(t, "POST", "/api/polka/webhooks", webhook("user.downgraded", userID, now), polkaKey...).expect(t, http.StatusNoContent)
+	if user, _ := ts.store.GetUserByID(userID); user.IsChirpyRed {
+		t.Fatal("user.downgraded upgraded the user")
+	}
+
+	// within the skew, in the body or the header
+	ts.request(t, "POST", "/api/polka/webhooks", webhook("user.upgraded", userID, now.Add(-polkaMaxSkew)), polkaKey...).expect(t, http.StatusNoContent)
+	if user, _ := ts.store.GetUserByID(userID); !user.IsChirpyRed {
+		t.Fatal("user.upgraded didn't upgrade the user")
+	}
+	header := withHeaders("X-Polka-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	ts.request(t, "POST", "/api/polka/webhooks", webhook("user.upgraded", userID, time.Time{}), header...).expect(t, http.StatusNoContent)
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// PUT /api/users endpoint
+func (a *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
+	// get token from auth header
+	token, err := a.validateToken(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if claims, ok := token.Claims.(*CustomClaims); ok {
+		if !isAcessToken(claims.Issuer) {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		userReq := apitypes.UserRequest{}
+		err = json.NewDecoder(r.Body).Decode(&userReq)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Error decoding json")
+			return
+		}
+		// both are replaced, saving an empty one would lock the user out
+		if msg, ok := missingCredentials(userReq); !ok {
+			respondWithError(w, http.StatusBadRequest, msg)
+			return
+		}
+		// the allowlist applies to email changes too so it can't be sidestepped after signup
+		if !a.emailDomainAllowed(userReq.Email) {
+			respondWithErrorCode(w, http.StatusForbidden, "registration is not open to this email domain", apitypes.ErrorCodeEmailDomainNotAllowed)
+			return
+		}
+
+		var warnings []apitypes.Warning
+		if userReq.ExpectedVersion != nil {
+			warning, ok := a.deprecated(w, "user.expected_version")
+			if !ok {
+				return
+			}
+			warnings = append(warnings, warning)
+		}
+		// If-Match wins over expected_version when both are sent
+		if v := r.Header.Get("If-Match"); v != "" {
+			version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(v, "W/"), `"`))
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "invalid If-Match")
+				return
+			}
+			userReq.ExpectedVersion = &version
+		}
+
+		cost := bcrypt.DefaultCost
+		password, err := bcrypt.GenerateFromPassword([]byte(userReq.Password), cost)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Error updating password")
+			return
+		}
+		user, err := a.db.UpdateUserDB(claims.UserID, userReq.Email, password, userReq.ExpectedVersion)
+		if errors.Is(err, database.ErrVersionConflict) {
+			respondWithVersionConflict(w, user)
+			return
+		}
+		if errors.Is(err, database.ErrUserExists) {
+			respondWithErrorCode(w, http.StatusConflict, "This Email already exists", apitypes.ErrorCodeEmailTaken)
+			return
+		}
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if err != nil {
+			respondWithDBError(w, err, http.StatusInternalServerError, "Error updating password")
+			return
+		}
+
+		resp, err := json.Marshal(apitypes.UserResponse{
+			Email:       user.Email,
+			ID:          user.ID,
+			IsChirpyRed: user.IsChirpyRed,
+			Version:     user.Version,
+			Warnings:    warnings,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+			return
+		}
+		w.Header().Set("ETag", strconv.Quote(strconv.Itoa(user.Version)))
+		w.Write(resp)
+	} else {
+		respondWithError(w, http.StatusBadRequest, "Unknow Claims type")
+		return
+	}
+
+}
+
+// respondWithVersionConflict answers an update made against an old version
+// with 409 and the user as it is now
+func respondWithVersionConflict(w http.ResponseWriter, user database.User) {
+	resp, err := json.Marshal(apitypes.VersionConflictResponse{
+		Error:     "user was changed since it was read",
+		ErrorCode: apitypes.ErrorCodeVersionConflict,
+		Current: apitypes.UserResponse{
+			Email:       user.Email,
+			ID:          user.ID,
+			IsChirpyRed: user.IsChirpyRed,
+			Version:     user.Version,
+		},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(user.Version)))
+	w.WriteHeader(http.StatusConflict)
+	w.Write(resp)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestUpdateUserKeepsChirpyRed(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := start(t)
+			login := ts.newUser(t, "red@example.com")
+			ts.upgrade(t, login.ID)
+
+			update := apitypes.UserRequest{Email: "still-red@example.com", Password: "a new password"}
+			updated := decode[apitypes.UserResponse](t, ts.request(t, "PUT", "/api/users", update, bearer(login.Token)...).expect(t, http.StatusOK))
+			if !updated.IsChirpyRed || updated.Email != update.Email {
+				t.Fatalf("PUT /api/users = %+v, want Chirpy Red with the new email", updated)
+			}
+
+			// the update leaves the refresh token alone too
+			ts.request(t, "POST", "/api/refresh", nil, bearer(login.RefreshToken)...).expect(t, http.StatusOK)
+
+			relogin := decode[apitypes.LoginResponse](t, ts.request(t, "POST", "/api/login", update).expect(t, http.StatusOK))
+			if !relogin.IsChirpyRed {
+				t.Fatal("login after the update lost is_chirpy_red")
+			}
+			profile := decode[apitypes.UserProfile](t, ts.request(t, "GET", "/api/users/"+strconv.Itoa(login.ID), nil).expect(t, http.StatusOK))
+			if !profile.IsChirpyRed {
+				t.Fatal("profile after the update lost is_chirpy_red")
+			}
+		})
+	}
+}
+
+func TestUpdateUserMissingFields(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	login := ts.newUser(t, "alice@example.com")
+	tests := []struct {
+		name  string
+		body  string
+		error string
+	}{
+		{"empty object", `{}`, "email is required"},
+		{"blank email", `{"email":" ","password":"new password"}`, "email is required"},
+		{"no password", `{"email":"alice@example.com"}`, "password is required"},
+		{"empty password", `{"email":"alice@example.com","password":""}`, "password is required"},
+	}
+	for _, tt := range tests {
+		resp := ts.request(t, "PUT", "/api/users", tt.body, bearer(login.Token)...)
+		if resp.status != http.StatusBadRequest || errorOf(t, resp) != tt.error {
+			t.Errorf("%s: %d %s, want 400 %q", tt.name, resp.status, resp.body, tt.error)
+		}
+	}
+
+	// nothing was saved, the old credentials still log in
+	ts.login(t, "alice@example.com")
+	if user, err := ts.store.GetUserByID(login.ID); err != nil || user.Version != 0 || user.Email != "alice@example.com" {
+		t.Fatalf("user after the rejected updates = %+v, %v", user, err)
+	}
+}
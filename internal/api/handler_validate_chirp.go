@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+const (
+	maxChirpLength     = 140
+	maxRedChirpLength  = 280 // for chirpy red members
+	maxSourceLength    = 30  // runes
+	defaultChirpSource = "web"
+)
+
+var badWords = []string{"kerfuffle", "sharbert", "fornax"}
+
+// cleanChirpBody replaces all profanes with ****
+func cleanChirpBody(body string) string {
+	cleaned, _ := censorChirpBody(body)
+	return cleaned
+}
+
+// censorChirpBody replaces all profanes with **** and returns the
+// bad words it found, each once
+func censorChirpBody(body string) (string, []string) {
+	found := make([]string, 0)
+	stringChirpy := strings.Split(body, " ")
+	for i, word := range stringChirpy {
+		for _, badWord := range badWords {
+			if strings.ToLower(word) == badWord {
+				stringChirpy[i] = "****"
+				if !slices.Contains(found, badWord) {
+					found = append(found, badWord)
+				}
+			}
+		}
+	}
+	return strings.Join(stringChirpy, " "), found
+}
+
+// chirpSource turns the client hint into the label stored on the chirp:
+// control characters are dropped, it is cut to maxSourceLength runes and
+// profanity filtered. no hint is "web".
+func chirpSource(hint string) string {
+	source := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, hint)
+	source = strings.TrimSpace(source)
+	if runes := []rune(source); len(runes) > maxSourceLength {
+		source = strings.TrimSpace(string(runes[:maxSourceLength]))
+	}
+	if source == "" {
+		return defaultChirpSource
+	}
+	return cleanChirpBody(source)
+}
+
+// decodeChirpRequest reads a chirp request body. the X-Chirpy-Client
+// header is the source unless the body names one.
+func decodeChirpRequest(r *http.Request) (apitypes.CreateChirpRequest, error) {
+	req := apitypes.CreateChirpRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, err
+	}
+	if req.Source == "" {
+		req.Source = r.Header.Get("X-Chirpy-Client")
+	}
+	return req, nil
+}
+
+// chirpLimit is the longest chirp author can post, in runes
+func chirpLimit(author database.User) int {
+	if author.IsChirpyRed {
+		return maxRedChirpLength
+	}
+	return maxChirpLength
+}
+
+// checkChirp runs the creation pipeline on a chirp of author without
+// writing anything. creating, editing and the dry run all go through it so
+// they can't disagree.
+func (a *apiConfig) checkChirp(req apitypes.CreateChirpRequest, author database.User) apitypes.ValidateChirpResponse {
+	verdict := apitypes.ValidateChirpResponse{
+		Valid:  true,
+		Length: len([]rune(req.Body)),
+		Limit:  chirpLimit(author),
+		Lang:   a.settings().defaultLang,
+		Source: chirpSource(req.Source),
+	}
+	verdict.Body, verdict.WouldFilter = censorChirpBody(req.Body)
+
+	if strings.TrimSpace(req.Body) == "" {
+		verdict.Valid = false
+		verdict.Error = "body is required"
+	}
+	// check if json body length is more than the limit of the author.
+	if verdict.Length > verdict.Limit && verdict.Valid {
+		verdict.Valid = false
+		verdict.Error = fmt.Sprintf("Chirp is too long, the limit is %d characters", verdict.Limit)
+	}
+	if req.Lang != "" {
+		lang, err := parseChirpLang(req.Lang)
+		if err != nil && verdict.Valid {
+			verdict.Valid = false
+			verdict.Error = err.Error()
+		}
+		verdict.Lang = lang
+	}
+	return verdict
+}
+
+// chirpAuthor returns the user of the access token, writing 401 when there is none
+func (a *apiConfig) chirpAuthor(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	token, err := a.validateToken(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return database.User{}, false
+	}
+
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || !isAcessToken(claims.Issuer) {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return database.User{}, false
+	}
+	// the limits depend on the account, chirpy red members get longer chirps
+	user, err := a.db.GetUserByID(claims.UserID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return database.User{}, false
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return database.User{}, false
+	}
+	return user, true
+}
+
+// validate if chirpy is valid. if valid response json valid body. if not response json error body
+// POST /api/chrips
+func (a *apiConfig) validateChirpy(w http.ResponseWriter, r *http.Request) {
+	author, ok := a.chirpAuthor(w, r)
+	if !ok {
+		return
+	}
+
+	// decode json body and check for error
+	chirpyParam, err := decodeChirpRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+
+	verdict := a.checkChirp(chirpyParam, author)
+	if !verdict.Valid {
+		respondWithError(w, http.StatusBadRequest, verdict.Error)
+		return
+	}
+	createdDB, err := a.chirpyDatabase.CreateChirp(verdict.Body, author.ID, verdict.Lang, verdict.Source)
+	if errors.Is(err, database.ErrUserNotFound) {
+		// the account was deleted since chirpAuthor looked it up
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	// chirp is valid response valid successReponse struct encoded to json
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(&createdDB)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error Encoding json")
+		return
+	}
+
+}
+
+// POST /api/chirps/validate
+// dryRunChirp responds with the verdict creating the chirp would get,
+// without creating it
+func (a *apiConfig) dryRunChirp(w http.ResponseWriter, r *http.Request) {
+	author, ok := a.chirpAuthor(w, r)
+	if !ok {
+		return
+	}
+
+	chirpyParam, err := decodeChirpRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+
+	resp, err := json.Marshal(a.checkChirp(chirpyParam, author))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestChirpLengthLimit(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	regular := ts.newUser(t, "alice@example.com")
+	red := ts.newUser(t, "bob@example.com")
+	ts.upgrade(t, red.ID)
+	regularChirp := ts.postChirp(t, regular.Token, "to be edited")
+	redChirp := ts.postChirp(t, red.Token, "to be edited")
+
+	tests := []struct {
+		name   string
+		login  apitypes.LoginResponse
+		chirp  apitypes.Chirp
+		length int
+		limit  int
+	}{
+		{"regular at the limit", regular, regularChirp, 140, 140},
+		{"regular over the limit", regular, regularChirp, 141, 140},
+		{"regular at the red limit", regular, regularChirp, 280, 140},
+		{"regular over the red limit", regular, regularChirp, 281, 140},
+		{"red at the regular limit", red, redChirp, 140, 280},
+		{"red over the regular limit", red, redChirp, 141, 280},
+		{"red at the limit", red, redChirp, 280, 280},
+		{"red over the limit", red, redChirp, 281, 280},
+	}
+	for _, tt := range tests {
+		// the limit counts runes, not bytes
+		body := strings.Repeat("é", tt.length)
+		ok := tt.length <= tt.limit
+		wantError := fmt.Sprintf("Chirp is too long, the limit is %d characters", tt.limit)
+
+		verdict := decode[apitypes.ValidateChirpResponse](t, ts.request(t, "POST", "/api/chirps/validate", apitypes.CreateChirpRequest{Body: body}, bearer(tt.login.Token)...).expect(t, http.StatusOK))
+		if verdict.Valid != ok || verdict.Length != tt.length || verdict.Limit != tt.limit {
+			t.Errorf("%s: dry run = %+v", tt.name, verdict)
+		}
+
+		// creating and editing agree with the dry run
+		for _, req := range []struct {
+			method, path string
+			body         any
+			status       int
+		}{
+			{"POST", "/api/chirps", apitypes.CreateChirpRequest{Body: body}, http.StatusCreated},
+			{"PUT", chirpPath(tt.chirp.ID), apitypes.UpdateChirpRequest{Body: body}, http.StatusOK},
+		} {
+			resp := ts.request(t, req.method, req.path, req.body, bearer(tt.login.Token)...)
+			switch {
+			case ok && resp.status != req.status:
+				t.Errorf("%s: %s %s = %d, want %d: %s", tt.name, req.method, req.path, resp.status, req.status, resp.body)
+			case !ok && (resp.status != http.StatusBadRequest || errorOf(t, resp) != wantError):
+				t.Errorf("%s: %s %s = %d %s, want 400 %q", tt.name, req.method, req.path, resp.status, resp.body, wantError)
+			}
+		}
+	}
+
+	for _, tt := range []struct {
+		login apitypes.LoginResponse
+		limit int
+	}{{regular, 140}, {red, 280}} {
+		limits := decode[apitypes.LimitsResponse](t, ts.request(t, "GET", "/api/users/me/limits", nil, bearer(tt.login.Token)...).expect(t, http.StatusOK))
+		if limits.Chirp.MaxLength != tt.limit {
+			t.Errorf("limits of user %d: max length %d, want %d", tt.login.ID, limits.Chirp.MaxLength, tt.limit)
+		}
+	}
+}
+
+func TestChirpSource(t *testing.T) {
+	tests := []struct {
+		hint string
+		want string
+	}{
+		{"", "web"},
+		{"  ", "web"},
+		{"\x00\x1b", "web"},
+		{"chirpy-ios", "chirpy-ios"},
+		{" cli\n", "cli"},
+		{"my\tbot", "mybot"},
+		{strings.Repeat("é", maxSourceLength+5), strings.Repeat("é", maxSourceLength)},
+		{"kerfuffle bot", "**** bot"},
+	}
+	for _, tt := range tests {
+		if got := chirpSource(tt.hint); got != tt.want {
+			t.Errorf("chirpSource(%q) = %q, want %q", tt.hint, got, tt.want)
+		}
+	}
+}
+
+func TestChirpSourceStored(t *testing.T) {
+	for name, newServer := range map[string]func(testing.TB) *testServer{"json": newTestServer, "sqlite": newSQLiteTestServer} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := newServer(t)
+			alice := ts.newUser(t, "alice@example.com")
+			headers := append(bearer(alice.Token), "X-Chirpy-Client", "chirpy-ios")
+
+			tests := []struct {
+				name    string
+				req     apitypes.CreateChirpRequest
+				headers []string
+				want    string
+			}{
+				{"no hint", apitypes.CreateChirpRequest{Body: "hi"}, bearer(alice.Token), "web"},
+				{"header", apitypes.CreateChirpRequest{Body: "hi"}, headers, "chirpy-ios"},
+				{"body wins", apitypes.CreateChirpRequest{Body: "hi", Source: "cli"}, headers, "cli"},
+				{"cleaned", apitypes.CreateChirpRequest{Body: "hi", Source: "sharbert\x07"}, bearer(alice.Token), "****"},
+			}
+			for _, tt := range tests {
+				verdict := decode[apitypes.ValidateChirpResponse](t, ts.request(t, "POST", "/api/chirps/validate", tt.req, tt.headers...).expect(t, http.StatusOK))
+				created := decode[apitypes.Chirp](t, ts.request(t, "POST", "/api/chirps", tt.req, tt.headers...).expect(t, http.StatusCreated))
+				stored := decode[apitypes.Chirp](t, ts.request(t, "GET", chirpPath(created.ID), nil).expect(t, http.StatusOK))
+				if verdict.Source != tt.want || created.Source != tt.want || stored.Source != tt.want {
+					t.Errorf("%s: source of the dry run %q, created %q, stored %q, want %q", tt.name, verdict.Source, created.Source, stored.Source, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// corsAllowHeaders are the request headers the handlers read. the *
+	// wildcard isn't honored with credentials, so they are listed.
+	corsAllowHeaders = "Authorization, Content-Type, If-Match, If-None-Match, If-Modified-Since, X-Chirpy-Client, X-Confirm-Token"
+	corsMaxAge       = 10 * time.Minute // how long browsers may cache a preflight
+)
+
+// corsOriginsFromEnv reads CORS_ALLOWED_ORIGINS, the comma separated
+// origins browsers may call the API from, credentials included. unset or *
+// is nil, which lets every origin in without credentials.
+func corsOriginsFromEnv(getenv func(string) string) ([]string, error) {
+	v := strings.TrimSpace(getenv("CORS_ALLOWED_ORIGINS"))
+	if v == "" || v == "*" {
+		return nil, nil
+	}
+	origins := make([]string, 0)
+	for _, o := range strings.Split(v, ",") {
+		if o = strings.TrimSpace(o); o == "" {
+			continue
+		}
+		origin, err := canonicalOrigin(o)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CORS_ALLOWED_ORIGINS entry %q: must be an http or https origin like https://example.com", o)
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
+
+// middlewareCors answers CORS for the allowed origins, echoing the origin
+// back so browsers send credentials along. requests from other origins are
+// rejected with 403, except embeds: public keys carry their own origins,
+// which checkPublicKey answers for. preflights are answered here and never
+// reach the handlers.
+func (a *apiConfig) middlewareCors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		h := w.Header()
+		switch {
+		case a.corsOrigins == nil:
+			h.Set("Access-Control-Allow-Origin", "*")
+		case origin == "":
+			// not a cross-origin request, there's nothing to answer
+		case slices.Contains(a.corsOrigins, strings.ToLower(origin)):
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+			h.Add("Vary", "Origin")
+		default:
+			h.Add("Vary", "Origin")
+			if !publicKeyRequest(r) {
+				respondWithError(w, http.StatusForbidden, "origin not allowed")
+				return
+			}
+			if r.Method == http.MethodOptions {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+		h.Set("Access-Control-Expose-Headers", "X-Total-Count, ETag, Last-Modified, Retry-After")
+
+		if r.Method == http.MethodOptions {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			allowHeaders := corsAllowHeaders
+			if a.corsOrigins == nil {
+				// the wildcard doesn't cover Authorization, which embeds send their public key in
+				allowHeaders = "Authorization, *"
+			}
+			h.Set("Access-Control-Allow-Headers", allowHeaders)
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// publicKeyRequest reports whether r can be an embed calling with a public
+// key. keys only work on GET routes, so that's the only preflight let in
+// for origins not on the list.
+func publicKeyRequest(r *http.Request) bool {
+	if r.Method == http.MethodOptions {
+		return r.Header.Get("Access-Control-Request-Method") == http.MethodGet
+	}
+	return strings.HasPrefix(r.Header.Get("Authorization"), publicKeyScheme)
+}
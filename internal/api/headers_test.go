@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestCorsOriginsFromEnv(t *testing.T) {
+	tests := []struct {
+		env  string
+		want []string
+	}{
+		{"", nil},
+		{"*", nil},
+		{" * ", nil},
+		{"https://app.example.com", []string{"https://app.example.com"}},
+		{"HTTPS://App.Example.com/, http://localhost:5173", []string{"https://app.example.com", "http://localhost:5173"}},
+		{"https://app.example.com,,", []string{"https://app.example.com"}},
+	}
+	for _, tt := range tests {
+		got, err := corsOriginsFromEnv(func(string) string { return tt.env })
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("CORS_ALLOWED_ORIGINS=%q: origins %q, %v, want %q", tt.env, got, err, tt.want)
+		}
+	}
+	for _, env := range []string{"app.example.com", "ftp://app.example.com", "https://app.example.com/path", "https://app.example.com, *"} {
+		if got, err := corsOriginsFromEnv(func(string) string { return env }); err == nil {
+			t.Errorf("CORS_ALLOWED_ORIGINS=%q: origins %q, want an error", env, got)
+		}
+	}
+}
+
+func TestCorsAllowlist(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com, http://localhost:5173")
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	const allowed = "https://app.example.com"
+
+	// preflights are answered without reaching the handlers, so neither
+	// auth nor the route matter
+	for _, path := range []string{"/api/users", chirpPath(1), "/api/no-such-route"} {
+		resp := ts.request(t, "OPTIONS", path, nil, "Origin", allowed, "Access-Control-Request-Method", "PUT",
+			"Access-Control-Request-Headers", "authorization, if-match").expect(t, http.StatusNoContent)
+		for header, want := range map[string]string{
+			"Access-Control-Allow-Origin":      allowed,
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Allow-Methods":     "GET, POST, PUT, DELETE, OPTIONS",
+			"Access-Control-Allow-Headers":     corsAllowHeaders,
+			"Access-Control-Max-Age":           "600",
+			"Vary":                             "Origin",
+		} {
+			if got := resp.header.Get(header); got != want {
+				t.Errorf("OPTIONS %s: %s = %q, want %q", path, header, got, want)
+			}
+		}
+		if len(resp.body) != 0 {
+			t.Errorf("OPTIONS %s: body %q, want none", path, resp.body)
+		}
+	}
+
+	// simple requests echo the origin and still go through auth
+	resp := ts.request(t, "GET", "/api/users/me/limits", nil, append(bearer(alice.Token), "Origin", allowed)...).expect(t, http.StatusOK)
+	if resp.header.Get("Access-Control-Allow-Origin") != allowed || resp.header.Get("Access-Control-Allow-Credentials") != "true" || resp.header.Get("Vary") != "Origin" {
+		t.Fatalf("allowed origin: headers %v", resp.header)
+	}
+	ts.request(t, "GET", "/api/users/me/limits", nil, "Origin", "http://localhost:5173").expect(t, http.StatusUnauthorized)
+
+	// same-origin requests send no Origin and get no CORS answer
+	resp = ts.request(t, "GET", "/api/healthz", nil).expect(t, http.StatusOK)
+	if got := resp.header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("no Origin: Access-Control-Allow-Origin = %q", got)
+	}
+
+	// other origins are turned away before anything runs
+	for _, req := range []struct {
+		method  string
+		headers []string
+	}{
+		{"OPTIONS", []string{"Origin", "https://evil.example.com", "Access-Control-Request-Method", "PUT"}},
+		{"OPTIONS", []string{"Origin", "https://app.example.com.evil.example.com", "Access-Control-Request-Method", "DELETE"}},
+		{"GET", []string{"Origin", "https://evil.example.com"}},
+		{"POST", append(bearer(alice.Token), "Origin", "http://app.example.com")},
+	} {
+		resp := ts.request(t, req.method, "/api/chirps", nil, req.headers...)
+		if resp.status != http.StatusForbidden || errorOf(t, resp) != "origin not allowed" {
+			t.Errorf("%s from %v: %d %s, want 403", req.method, req.headers, resp.status, resp.body)
+		}
+		if got := resp.header.Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("%s from %v: Access-Control-Allow-Origin = %q", req.method, req.headers, got)
+		}
+	}
+	if got := ts.visibleChirps(t); len(got) != 0 {
+		t.Fatalf("a rejected origin created chirps %v", got)
+	}
+
+	// embeds with a public key are left to checkPublicKey
+	ts.request(t, "OPTIONS", "/api/chirps", nil, "Origin", "https://embed.example.com", "Access-Control-Request-Method", "GET").expect(t, http.StatusNoContent)
+	resp = ts.request(t, "GET", "/api/chirps", nil, "Origin", "https://embed.example.com", "Authorization", publicKeyScheme+"not-a-key")
+	if resp.status != http.StatusUnauthorized || errorOf(t, resp) != "invalid public key" {
+		t.Fatalf("embed with a bad key: %d %s, want 401 invalid public key", resp.status, resp.body)
+	}
+}
+
+func TestCorsWildcard(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	resp := ts.request(t, "OPTIONS", "/api/chirps", nil, "Origin", "https://anywhere.example.com", "Access-Control-Request-Method", "POST").expect(t, http.StatusNoContent)
+	if resp.header.Get("Access-Control-Allow-Origin") != "*" || resp.header.Get("Access-Control-Allow-Credentials") != "" || resp.header.Get("Access-Control-Allow-Headers") != "Authorization, *" {
+		t.Fatalf("wildcard preflight: headers %v", resp.header)
+	}
+	resp = ts.request(t, "GET", "/api/healthz", nil, "Origin", "https://anywhere.example.com").expect(t, http.StatusOK)
+	if resp.header.Get("Access-Control-Allow-Origin") != "*" || resp.header.Get("Vary") != "" {
+		t.Fatalf("wildcard request: headers %v", resp.header)
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// routeInFlight counts the requests each route is handling right now
+type routeInFlight struct {
+	mux    *sync.Mutex
+	counts map[string]int // keyed by route pattern
+}
+
+func newRouteInFlight() *routeInFlight {
+	return &routeInFlight{
+		mux:    &sync.Mutex{},
+		counts: make(map[string]int),
+	}
+}
+
+// track counts requests to route while next handles them. routes show
+// up in the metrics from registration on, idle ones at 0.
+func (f *routeInFlight) track(route string, next http.Handler) http.Handler {
+	f.mux.Lock()
+	f.counts[route] = 0
+	f.mux.Unlock()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mux.Lock()
+		f.counts[route]++
+		f.mux.Unlock()
+		defer func() {
+			f.mux.Lock()
+			f.counts[route]--
+			f.mux.Unlock()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// busy returns the routes with requests in flight as "route=count", sorted,
+// and the total
+func (f *routeInFlight) busy() ([]string, int) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	routes := make([]string, 0)
+	total := 0
+	for route, n := range f.counts {
+		if n > 0 {
+			routes = append(routes, fmt.Sprintf("%s=%d", route, n))
+			total += n
+		}
+	}
+	sort.Strings(routes)
+	return routes, total
+}
+
+// logBusy logs what is still running, for the shutdown countdown
+func (f *routeInFlight) logBusy() {
+	routes, total := f.busy()
+	if total > 0 {
+		log.Printf("shutdown: %d requests in flight: %s", total, strings.Join(routes, ", "))
+	}
+}
+
+// writePrometheus writes the in-flight gauges, overall and per route
+func (f *routeInFlight) writePrometheus(w io.Writer) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	routes := make([]string, 0, len(f.counts))
+	total := 0
+	for route, n := range f.counts {
+		routes = append(routes, route)
+		total += n
+	}
+	sort.Strings(routes)
+
+	fmt.Fprintln(w, "# HELP chirpy_http_in_flight_requests Requests being handled by API routes.")
+	fmt.Fprintln(w, "# TYPE chirpy_http_in_flight_requests gauge")
+	fmt.Fprintf(w, "chirpy_http_in_flight_requests %d\n", total)
+
+	fmt.Fprintln(w, "# HELP chirpy_http_route_in_flight_requests Requests each route is handling.")
+	fmt.Fprintln(w, "# TYPE chirpy_http_route_in_flight_requests gauge")
+	for _, route := range routes {
+		fmt.Fprintf(w, "chirpy_http_route_in_flight_requests{route=%q} %d\n", route, f.counts[route])
+	}
+}
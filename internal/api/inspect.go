@@ -0,0 +1,270 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// stored user fields inspect doesn't print
+var redactedUserFields = []string{"password", "refreshToken", "totp_secret", "totp_pending_secret", "totp_backup_codes", "sessions"}
+
+// runInspectCommand implements `chirpy inspect user <id|email>` and
+// `chirpy inspect chirp <id>`, printing the stored record with the state
+// derived from it
+func runInspectCommand(args []string, out io.Writer) error {
+	kind, target, force, err := parseRecordCommand("inspect", args)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockDataDir(force)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var report any
+	switch kind {
+	case "user":
+		report, err = inspectUser(target)
+	case "chirp":
+		report, err = inspectChirp(target)
+	default:
+		err = fmt.Errorf("unknown record type %q, want user or chirp", kind)
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, string(resp))
+	return nil
+}
+
+// runRepairCommand implements `chirpy repair user <id>`, which drops the
+// user's half-written account links
+func runRepairCommand(args []string, out io.Writer) error {
+	kind, target, force, err := parseRecordCommand("repair", args)
+	if err != nil {
+		return err
+	}
+	if kind != "user" {
+		return fmt.Errorf("unknown record type %q, want user", kind)
+	}
+	unlock, err := lockDataDir(force)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	db, err := openInspectDB()
+	if err != nil {
+		return err
+	}
+	if err := setDBIndent(db); err != nil {
+		return err
+	}
+	user, err := findUser(db, target)
+	if err != nil {
+		return err
+	}
+	fixes, err := db.RepairUser(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, fix := range fixes {
+		fmt.Fprintf(out, "fixed: %s\n", fix)
+	}
+	fmt.Fprintf(out, "user %d: %d fixes\n", user.ID, len(fixes))
+	return nil
+}
+
+// parseRecordCommand reads `<user|chirp> <target> [--force]`
+func parseRecordCommand(name string, args []string) (string, string, bool, error) {
+	if len(args) < 2 {
+		return "", "", false, fmt.Errorf("usage: chirpy %s <user|chirp> <id> [--force]", name)
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	force := fs.Bool("force", false, "Run even while a server is using the database files")
+	if err := fs.Parse(args[2:]); err != nil {
+		return "", "", false, err
+	}
+	return args[0], args[1], *force, nil
+}
+
+// openInspectDB opens the json database in the working directory. inspect
+// and repair read its records as stored, they don't support SQLite.
+func openInspectDB() (*database.DB, error) {
+	if os.Getenv("DATABASE_URL") != "" {
+		return nil, errors.New("inspect and repair only work on the json database, unset DATABASE_URL")
+	}
+	return openDB(".")
+}
+
+// lockDataDir takes the data directory lock for an offline command.
+// while a server holds it the command refuses to run unless forced.
+func lockDataDir(force bool) (func(), error) {
+	lock, err := database.LockDir(".")
+	if errors.Is(err, database.ErrLocked) && force {
+		return func() {}, nil
+	}
+	if errors.Is(err, database.ErrLocked) {
+		return nil, errors.New("a server is using the database files, stop it or pass --force")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() { lock.Unlock() }, nil
+}
+
+// findUser looks a user up by ID or email
+func findUser(userDB *database.DB, target string) (database.User, error) {
+	users, err := userDB.GetUser()
+	if err != nil {
+		return database.User{}, err
+	}
+	ID, err := strconv.Atoi(target)
+	for _, user := range users {
+		if (err == nil && user.ID == ID) || user.Email == target {
+			return user, nil
+		}
+	}
+	return database.User{}, fmt.Errorf("no user %q", target)
+}
+
+func inspectUser(target string) (any, error) {
+	db, err := openInspectDB()
+	if err != nil {
+		return nil, err
+	}
+	listDB, err := database.NewListDB("listDatabase.json")
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := findUser(db, target)
+	if err != nil {
+		return nil, err
+	}
+	record, err := redactedRecord(user, redactedUserFields)
+	if err != nil {
+		return nil, err
+	}
+
+	derived := struct {
+		LiveChirps      int                 `json:"live_chirps"`
+		DeletedChirps   int                 `json:"deleted_chirps"`
+		ListsOwned      []int               `json:"lists_owned"`
+		ListMemberships []int               `json:"list_memberships"`
+		Identities      []database.Identity `json:"identities"`
+		Session         *time.Time          `json:"session_expires_at"` // of the stored refresh token
+		OtherSessions   int                 `json:"other_sessions"`     // opened by token exchanges
+		BackupCodesLeft int                 `json:"totp_backup_codes_left"`
+		Problems        []string            `json:"problems"` // what repair fixes
+	}{
+		ListsOwned:      []int{},
+		ListMemberships: []int{},
+		OtherSessions:   len(user.Sessions),
+		BackupCodesLeft: len(user.TOTPBackupCodes),
+	}
+	derived.LiveChirps, derived.DeletedChirps, err = db.CountChirpsByAuthor(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	owned, err := listDB.GetListsByOwner(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range owned {
+		derived.ListsOwned = append(derived.ListsOwned, list.ID)
+	}
+	memberships, err := listDB.GetListsWithMember(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range memberships {
+		derived.ListMemberships = append(derived.ListMemberships, list.ID)
+	}
+	derived.Identities, err = db.GetIdentitiesOfUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if user.RefreshToken != "" {
+		derived.Session = user.RefreshTokenExpiresAt
+	}
+	derived.Problems, err = db.UserProblems(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"user": record, "derived": derived}, nil
+}
+
+func inspectChirp(target string) (any, error) {
+	ID, err := strconv.Atoi(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chirp id %q", target)
+	}
+	db, err := openInspectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	chirp, err := db.GetStoredChirp(ID)
+	if err != nil {
+		return nil, err
+	}
+	derived := struct {
+		State       string     `json:"state"` // live or deleted
+		PurgeAfter  *time.Time `json:"purge_after,omitempty"`
+		AuthorEmail string     `json:"author_email,omitempty"`
+		Problems    []string   `json:"problems"`
+	}{
+		State:    "live",
+		Problems: []string{},
+	}
+	if chirp.DeletedAt != nil {
+		derived.State = "deleted"
+		retention, err := tombstoneRetentionFromEnv(os.Getenv)
+		if err != nil {
+			return nil, err
+		}
+		purgeAfter := chirp.DeletedAt.Add(retention)
+		derived.PurgeAfter = &purgeAfter
+	}
+	author, err := findUser(db, strconv.Itoa(chirp.AuthorID))
+	if err != nil {
+		derived.Problems = append(derived.Problems, fmt.Sprintf("author %d doesn't exist", chirp.AuthorID))
+	} else {
+		derived.AuthorEmail = author.Email
+	}
+
+	return map[string]any{"chirp": chirp, "derived": derived}, nil
+}
+
+// redactedRecord is record as stored with the set fields of redact replaced
+func redactedRecord(record any, redact []string) (map[string]any, error) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]any)
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	for _, name := range redact {
+		if v, ok := fields[name]; ok && v != "" && v != nil {
+			fields[name] = "[REDACTED]"
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// inspect runs `chirpy inspect` and decodes what it printed
+func inspect(t *testing.T, args ...string) map[string]map[string]any {
+	t.Helper()
+	var out bytes.Buffer
+	if err := RunCommand("inspect", args, &out); err != nil {
+		t.Fatalf("inspect %v: %v", args, err)
+	}
+	var report map[string]map[string]any
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("inspect %v printed %q: %v", args, out.String(), err)
+	}
+	return report
+}
+
+func TestInspectCommand(t *testing.T) {
+	setTestEnv(t)
+	chdir(t, t.TempDir())
+	db, err := openDB(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedStore(t, db)
+	if err := db.StoreToken(1, "refresh-secret", "family", testEpoch.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetPendingTOTP(1, rfc6238Secret); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// by email or ID, with the secrets redacted. the password hash is
+	// stored as base64.
+	var out bytes.Buffer
+	if err := RunCommand("inspect", []string{"user", "alice@example.com"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"refresh-secret", rfc6238Secret, "aGFzaA=="} {
+		if strings.Contains(out.String(), secret) {
+			t.Fatalf("inspect printed %s:\n%s", secret, out.String())
+		}
+	}
+	alice := inspect(t, "user", "1")
+	if alice["user"]["email"] != "alice@example.com" || alice["user"]["password"] != "[REDACTED]" || alice["user"]["totp_pending_secret"] != "[REDACTED]" {
+		t.Fatalf("user = %v", alice["user"])
+	}
+	if alice["derived"]["live_chirps"] != float64(2) || alice["derived"]["deleted_chirps"] != float64(1) || alice["derived"]["session_expires_at"] == nil {
+		t.Fatalf("derived = %v", alice["derived"])
+	}
+	bob := inspect(t, "user", "bob@example.com")
+	if identities, _ := bob["derived"]["identities"].([]any); len(identities) != 1 {
+		t.Fatalf("identities of bob = %v", bob["derived"]["identities"])
+	}
+
+	// a deleted chirp shows when it is purged
+	chirp := inspect(t, "chirp", "2")
+	if chirp["derived"]["state"] != "deleted" || chirp["derived"]["author_email"] != "alice@example.com" || chirp["derived"]["purge_after"] == nil {
+		t.Fatalf("derived = %v", chirp["derived"])
+	}
+	if live := inspect(t, "chirp", "1"); live["derived"]["state"] != "live" || live["derived"]["purge_after"] != nil {
+		t.Fatalf("derived = %v", live["derived"])
+	}
+
+	for name, args := range map[string][]string{
+		"no target":     {"user"},
+		"unknown user":  {"user", "carol@example.com"},
+		"unknown chirp": {"chirp", "99"},
+		"bad chirp id":  {"chirp", "first"},
+		"unknown type":  {"list", "1"},
+		"unknown flag":  {"user", "1", "--nope"},
+	} {
+		if err := RunCommand("inspect", args, &out); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	t.Setenv("DATABASE_URL", "sqlite:chirpy.db")
+	if err := RunCommand("inspect", []string{"user", "1"}, &out); err == nil {
+		t.Error("inspect ran on a sqlite DATABASE_URL")
+	}
+}
+
+func TestInspectLocked(t *testing.T) {
+	setTestEnv(t)
+	chdir(t, t.TempDir())
+	db, err := openDB(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedStore(t, db)
+	db.Close()
+
+	lock, err := database.LockDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+	if again, err := database.LockDir("."); err == nil {
+		again.Unlock()
+		t.Skip("no directory locks on this platform")
+	}
+
+	// a running server keeps inspect out unless forced
+	var out bytes.Buffer
+	if err := RunCommand("inspect", []string{"user", "1"}, &out); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("inspect of a locked directory: %v", err)
+	}
+	inspect(t, "user", "1", "--force")
+}
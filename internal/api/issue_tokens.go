@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	timeToExpireAccessToken  = time.Hour           // 1 Hour, unless JWT_EXPIRY_SECONDS says otherwise
+	maxExpireAccessToken     = time.Hour * 24      // 1 Day
+	timeToExpireRefreshToken = time.Hour * 24 * 60 // 60 Days
+)
+
+// accessTokenTTLFromEnv reads JWT_EXPIRY_SECONDS, the lifetime of access
+// tokens. values over a day are capped to a day.
+func accessTokenTTLFromEnv(getenv func(string) string) (time.Duration, error) {
+	v := getenv("JWT_EXPIRY_SECONDS")
+	if v == "" {
+		return timeToExpireAccessToken, nil
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid JWT_EXPIRY_SECONDS %q: must be 1 or more", v)
+	}
+	if seconds > int(maxExpireAccessToken.Seconds()) {
+		log.Printf("warning: JWT_EXPIRY_SECONDS %d is over the maximum, capped to %d", seconds, int(maxExpireAccessToken.Seconds()))
+		return maxExpireAccessToken, nil
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// accessTokenTTL is the lifetime of an access token. a login may ask for
+// requested seconds, 0 for none, which shortens it but never extends it
+// past the configured lifetime.
+func (a *apiConfig) accessTokenTTL(requested int) time.Duration {
+	ttl := a.settings().accessTTL
+	if d := time.Duration(requested) * time.Second; requested > 0 && d < ttl {
+		ttl = d
+	}
+	return ttl
+}
+
+// issueTokens creates a signed access token valid for accessTTL and a
+// refresh token starting a new session for the user.
+// the caller is responsible for storing the refresh token and its family.
+func (a *apiConfig) issueTokens(userID int, accessTTL time.Duration) (string, string, string, error) {
+	signedStringToken, err := a.signAccessToken(userID, accessTTL)
+	if err != nil {
+		return "", "", "", err
+	}
+	family, err := a.randomTokenID()
+	if err != nil {
+		return "", "", "", err
+	}
+	signedStringRefreshToken, err := a.signRefreshToken(userID, family, accessTTL, a.clock.Now().Add(timeToExpireRefreshToken))
+	if err != nil {
+		return "", "", "", err
+	}
+	return signedStringToken, signedStringRefreshToken, family, nil
+}
+
+// signAccessToken creates a signed access token valid for accessTTL
+func (a *apiConfig) signAccessToken(userID int, accessTTL time.Duration) (string, error) {
+	claims := CustomClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy-access",
+			IssuedAt:  jwt.NewNumericDate(a.clock.Now()),
+			ExpiresAt: jwt.NewNumericDate(a.clock.Now().Add(accessTTL)),
+			Subject:   strconv.Itoa(userID),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(signingSecret())
+}
+
+// signRefreshToken creates a signed refresh token of the session family,
+// valid until expiresAt. every token gets an ID of its own so a rotated
+// token never equals the one it replaced. a shortened accessTTL is kept in
+// the token so refreshed access tokens are just as short.
+func (a *apiConfig) signRefreshToken(userID int, family string, accessTTL time.Duration, expiresAt time.Time) (string, error) {
+	tokenID, err := a.randomTokenID()
+	if err != nil {
+		return "", err
+	}
+	claims := CustomClaims{
+		UserID: userID,
+		Family: family,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy-refresh",
+			IssuedAt:  jwt.NewNumericDate(a.clock.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			Subject:   strconv.Itoa(userID),
+			ID:        tokenID,
+		},
+	}
+	if accessTTL < a.settings().accessTTL {
+		claims.AccessTTL = int(accessTTL.Seconds())
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(signingSecret())
+}
+
+// randomTokenID returns 16 random bytes, hex encoded
+func (a *apiConfig) randomTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(a.random, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+const (
+	jobPollInterval = time.Second
+	jobMaxAttempts  = 5
+	jobMaxBackoff   = time.Hour
+)
+
+// jobHandler runs one job. returning an error retries it with backoff.
+type jobHandler func(ctx context.Context, payload json.RawMessage) error
+
+// Jobs is the in-process background job queue.
+// jobs are persisted before they run so a restart doesn't lose them,
+// and a job left running by a crash runs again: execution is at-least-once.
+type Jobs struct {
+	db       *database.DB
+	handlers map[string]jobHandler
+	slots    chan struct{}
+	clock    clock.Clock
+
+	stop     chan struct{}
+	inFlight *sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func newJobs(db *database.DB, workers int, clk clock.Clock) *Jobs {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Jobs{
+		db:       db,
+		handlers: make(map[string]jobHandler),
+		slots:    make(chan struct{}, workers),
+		clock:    clk,
+		stop:     make(chan struct{}),
+		inFlight: &sync.WaitGroup{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Register adds the handler for a job type. it must be called before Start.
+func (j *Jobs) Register(jobType string, handler jobHandler) {
+	j.handlers[jobType] = handler
+}
+
+// Enqueue persists a job to run at runAt
+func (j *Jobs) Enqueue(jobType string, payload any, runAt time.Time) error {
+	if _, ok := j.handlers[jobType]; !ok {
+		return fmt.Errorf("unknown job type %q", jobType)
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = j.db.EnqueueJob(jobType, b, runAt)
+	return err
+}
+
+// EnqueueOnce enqueues a job unless one of the same type is already pending,
+// for recurring jobs that reschedule themselves
+func (j *Jobs) EnqueueOnce(jobType string, payload any, runAt time.Time) error {
+	pending, err := j.db.GetJobs(database.JobPending)
+	if err != nil {
+		return err
+	}
+	for _, job := range pending {
+		if job.Type == jobType {
+			return nil
+		}
+	}
+	return j.Enqueue(jobType, payload, runAt)
+}
+
+// Start polls for due jobs and runs them on the worker pool
+func (j *Jobs) Start(ctx context.Context) error {
+	go func() {
+		for {
+			timer := j.clock.NewTimer(jobPollInterval)
+			select {
+			case <-j.stop:
+				timer.Stop()
+				return
+			case <-timer.C():
+				j.dispatch()
+			}
+		}
+	}()
+	return nil
+}
+
+// dispatch claims as many due jobs as there are free workers
+func (j *Jobs) dispatch() {
+	free := cap(j.slots) - len(j.slots)
+	if free == 0 {
+		return
+	}
+	jobs, err := j.db.ClaimDueJobs(j.clock.Now(), free)
+	if err != nil {
+		log.Printf("jobs: claiming due jobs: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		j.slots <- struct{}{}
+		j.inFlight.Add(1)
+		go func(job database.Job) {
+			defer func() {
+				<-j.slots
+				j.inFlight.Done()
+			}()
+			j.run(job)
+		}(job)
+	}
+}
+
+// run executes one claimed job and records the outcome
+func (j *Jobs) run(job database.Job) {
+	handler, ok := j.handlers[job.Type]
+	if !ok {
+		j.fail(job, fmt.Errorf("no handler for job type %q", job.Type), true)
+		return
+	}
+
+	err := handler(j.ctx, job.Payload)
+	if err != nil {
+		j.fail(job, err, job.Attempts >= jobMaxAttempts)
+		return
+	}
+	if err := j.db.CompleteJob(job.ID); err != nil {
+		log.Printf("jobs: completing job %d: %v", job.ID, err)
+	}
+}
+
+// fail retries the job with exponential backoff or moves it to the dead-letter list
+func (j *Jobs) fail(job database.Job, jobErr error, dead bool) {
+	backoff := min(time.Second<<job.Attempts, jobMaxBackoff)
+	if dead {
+		log.Printf("jobs: job %d (%s) is dead after %d attempts: %v", job.ID, job.Type, job.Attempts, jobErr)
+	}
+	if err := j.db.FailJob(job.ID, jobErr, j.clock.Now().Add(backoff), dead); err != nil {
+		log.Printf("jobs: recording failure of job %d: %v", job.ID, err)
+	}
+}
+
+// Stop stops claiming new jobs and waits for in-flight ones until ctx is done.
+// jobs still running at the deadline are cancelled and will run again after restart.
+func (j *Jobs) Stop(ctx context.Context) error {
+	close(j.stop)
+
+	done := make(chan struct{})
+	go func() {
+		j.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		j.cancel()
+		return ctx.Err()
+	}
+}
+
+// GET /admin/jobs
+// listJobs shows the pending jobs and the dead-letter list
+func (a *apiConfig) listJobs(w http.ResponseWriter, r *http.Request) {
+	pending, err := a.jobs.db.GetJobs(database.JobPending)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	dead, err := a.jobs.db.GetJobs(database.JobDead)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	resp, err := json.Marshal(struct {
+		Pending []database.Job `json:"pending"`
+		Dead    []database.Job `json:"dead"`
+	}{
+		Pending: pending,
+		Dead:    dead,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
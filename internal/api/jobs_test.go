@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+// newTestJobs is a job queue on a fresh file, driven by hand through
+// dispatch instead of Start
+func newTestJobs(t *testing.T) (*Jobs, *clock.Fake, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jobDatabase.json")
+	db, err := database.NewJobDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clock.NewFake(testEpoch)
+	return newJobs(db, 2, clk), clk, path
+}
+
+// runDue dispatches the due jobs and waits for them to finish
+func (j *Jobs) runDue() {
+	j.dispatch()
+	j.inFlight.Wait()
+}
+
+func jobsWithStatus(t *testing.T, j *Jobs, status string) []database.Job {
+	t.Helper()
+	jobs, err := j.db.GetJobs(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jobs
+}
+
+func TestJobsRun(t *testing.T) {
+	j, clk, _ := newTestJobs(t)
+	var got []string
+	j.Register("greet", func(ctx context.Context, payload json.RawMessage) error {
+		var name string
+		if err := json.Unmarshal(payload, &name); err != nil {
+			return err
+		}
+		got = append(got, name)
+		return nil
+	})
+
+	if err := j.Enqueue("nope", nil, clk.Now()); err == nil {
+		t.Fatal("Enqueue accepted a job type without a handler")
+	}
+	if err := j.Enqueue("greet", "alice", clk.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Enqueue("greet", "bob", clk.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// only due jobs run, and a finished job is gone
+	j.runDue()
+	if len(got) != 1 || got[0] != "alice" {
+		t.Fatalf("ran %v, want alice only", got)
+	}
+	if pending := jobsWithStatus(t, j, database.JobPending); len(pending) != 1 {
+		t.Fatalf("pending = %+v, want bob's job", pending)
+	}
+	clk.Advance(time.Minute)
+	j.runDue()
+	if len(got) != 2 || got[1] != "bob" {
+		t.Fatalf("ran %v, want bob next", got)
+	}
+	if pending := jobsWithStatus(t, j, database.JobPending); len(pending) != 0 {
+		t.Fatalf("pending = %+v after every job ran", pending)
+	}
+}
+
+func TestJobsRetry(t *testing.T) {
+	j, clk, _ := newTestJobs(t)
+	runs := 0
+	j.Register("flaky", func(ctx context.Context, payload json.RawMessage) error {
+		runs++
+		return errors.New("upstream is down")
+	})
+	if err := j.Enqueue("flaky", struct{}{}, clk.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// every failure waits twice as long as the one before
+	for attempt := 1; attempt < jobMaxAttempts; attempt++ {
+		j.runDue()
+		pending := jobsWithStatus(t, j, database.JobPending)
+		if runs != attempt || len(pending) != 1 {
+			t.Fatalf("attempt %d: %d runs, pending %+v", attempt, runs, pending)
+		}
+		backoff := time.Second << attempt
+		if got := pending[0].RunAt.Sub(clk.Now()); got != backoff || pending[0].LastError != "upstream is down" {
+			t.Fatalf("attempt %d: retry in %v with %q, want %v", attempt, got, pending[0].LastError, backoff)
+		}
+		clk.Advance(backoff - time.Second)
+		j.runDue()
+		if runs != attempt {
+			t.Fatalf("attempt %d: the job ran again before its backoff", attempt)
+		}
+		clk.Advance(time.Second)
+	}
+
+	// the last attempt moves it to the dead-letter list
+	j.runDue()
+	dead := jobsWithStatus(t, j, database.JobDead)
+	if runs != jobMaxAttempts || len(dead) != 1 || dead[0].Attempts != jobMaxAttempts {
+		t.Fatalf("after %d runs dead = %+v", runs, dead)
+	}
+	clk.Advance(jobMaxBackoff)
+	j.runDue()
+	if runs != jobMaxAttempts {
+		t.Fatal("a dead job ran again")
+	}
+}
+
+func TestJobsEnqueueOnce(t *testing.T) {
+	j, clk, _ := newTestJobs(t)
+	j.Register("purge", func(ctx context.Context, payload json.RawMessage) error { return nil })
+	for range 2 {
+		if err := j.EnqueueOnce("purge", struct{}{}, clk.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pending := jobsWithStatus(t, j, database.JobPending); len(pending) != 1 {
+		t.Fatalf("pending = %+v, want one purge", pending)
+	}
+	j.runDue()
+	if err := j.EnqueueOnce("purge", struct{}{}, clk.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if pending := jobsWithStatus(t, j, database.JobPending); len(pending) != 1 {
+		t.Fatalf("pending = %+v, want the purge enqueued again once it ran", pending)
+	}
+}
+
+func TestJobsStop(t *testing.T) {
+	j, clk, path := newTestJobs(t)
+	started := make(chan struct{})
+	j.Register("slow", func(ctx context.Context, payload json.RawMessage) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := j.Enqueue("slow", struct{}{}, clk.Now()); err != nil {
+		t.Fatal(err)
+	}
+	j.dispatch()
+	<-started
+
+	// the deadline cancels the running job
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := j.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Stop = %v, want the deadline", err)
+	}
+	j.inFlight.Wait()
+
+	// a job still marked running when the process died runs again
+	if _, err := j.db.ClaimDueJobs(clk.Now().Add(time.Hour), 1); err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.NewJobDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := db.GetJobs(database.JobPending)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("pending after a restart = %+v, %v, want the slow job", pending, err)
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+
+	ts.request(t, "GET", "/admin/jobs", nil).expect(t, http.StatusUnauthorized)
+	ts.request(t, "GET", "/admin/jobs", nil, bearer(alice.Token)...).expect(t, http.StatusForbidden)
+	jobs := decode[struct {
+		Pending []database.Job `json:"pending"`
+		Dead    []database.Job `json:"dead"`
+	}](t, ts.request(t, "GET", "/admin/jobs", nil, asAdmin()...).expect(t, http.StatusOK))
+	// the server schedules its recurring jobs on start
+	types := map[string]bool{}
+	for _, job := range jobs.Pending {
+		types[job.Type] = true
+	}
+	if !types[purgeJob] || !types[storageSampleJob] || jobs.Dead == nil || len(jobs.Dead) != 0 {
+		t.Fatalf("jobs = %+v, want the purge and storage sample pending", jobs)
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// limits on the shape of json request bodies. the size cap alone still lets
+// through bodies that are cheap to send and expensive to unmarshal.
+const (
+	maxJSONDepth  = 32
+	maxJSONFields = 1000 // object keys in the whole body
+)
+
+var (
+	errJSONTooDeep       = fmt.Errorf("json nested deeper than %d levels", maxJSONDepth)
+	errJSONTooManyFields = fmt.Errorf("json has more than %d fields", maxJSONFields)
+)
+
+// checkJSONShape walks the tokens of body and fails as soon as it is too
+// deep or has too many fields. malformed json is left for the handler's own
+// decoding to report.
+func checkJSONShape(body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	depth, fields := 0, 0
+	// inObject tracks, per open container, whether it is an object and the
+	// next string token is a key
+	inObject := make([]bool, 0, maxJSONDepth)
+	expectKey := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if depth > maxJSONDepth {
+				return errJSONTooDeep
+			}
+			inObject = append(inObject, tok == json.Delim('{'))
+			expectKey = tok == json.Delim('{')
+			continue
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+			inObject = inObject[:len(inObject)-1]
+		default:
+			if expectKey {
+				fields++
+				if fields > maxJSONFields {
+					return errJSONTooManyFields
+				}
+				expectKey = false
+				continue
+			}
+		}
+		// after a value, an object expects the next key
+		expectKey = len(inObject) > 0 && inObject[len(inObject)-1]
+	}
+}
+
+// guardJSON rejects json bodies that are too deep or have too many fields
+// with 400 before the handler unmarshals them. bodies that don't start
+// like json are passed through untouched.
+func guardJSON(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			// the handler sees the same failure it would have reading the body
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+			next(w, r)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		trimmed := bytes.TrimLeft(body, " \t\r\n")
+		if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+			next(w, r)
+			return
+		}
+		err = checkJSONShape(trimmed)
+		if errors.Is(err, errJSONTooDeep) {
+			respondWithErrorCode(w, http.StatusBadRequest, err.Error(), apitypes.ErrorCodeJSONTooDeep)
+			return
+		}
+		if errors.Is(err, errJSONTooManyFields) {
+			respondWithErrorCode(w, http.StatusBadRequest, err.Error(), apitypes.ErrorCodeJSONTooManyFields)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
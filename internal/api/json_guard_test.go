@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// nested returns n arrays inside each other, closed when closed is set
+func nested(n int, closed bool) string {
+	body := strings.Repeat("[", n)
+	if closed {
+		body += strings.Repeat("]", n)
+	}
+	return body
+}
+
+// nestedObjects returns n objects inside each other around value
+func nestedObjects(n int, value string) string {
+	return strings.Repeat(`{"a":`, n) + value + strings.Repeat("}", n)
+}
+
+// wideObject returns an object with n keys
+func wideObject(n int) string {
+	var b strings.Builder
+	b.WriteString("{")
+	for i := range n {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `"k%d":%d`, i, i)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func TestCheckJSONShape(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want error
+	}{
+		{"flat object", `{"body":"hi","lang":"en"}`, nil},
+		{"deepest arrays allowed", nested(maxJSONDepth, true), nil},
+		{"arrays one too deep", nested(maxJSONDepth+1, true), errJSONTooDeep},
+		{"deepest objects allowed", nestedObjects(maxJSONDepth, "1"), nil},
+		{"objects one too deep", nestedObjects(maxJSONDepth+1, "1"), errJSONTooDeep},
+		{"mixed nesting", strings.Repeat(`[{"a":`, maxJSONDepth/2+1) + "1" + strings.Repeat("}]", maxJSONDepth/2+1), errJSONTooDeep},
+		{"depth goes back down", `[` + nested(maxJSONDepth-1, true) + `,` + nested(maxJSONDepth-1, true) + `]`, nil},
+		{"most fields allowed", wideObject(maxJSONFields), nil},
+		{"one field too many", wideObject(maxJSONFields + 1), errJSONTooManyFields},
+		{"fields of nested objects add up", `[` + wideObject(maxJSONFields/2) + `,` + wideObject(maxJSONFields/2+1) + `]`, errJSONTooManyFields},
+		{"keys after a nested value count", `{"a":{"b":1},` + strings.TrimPrefix(wideObject(maxJSONFields-1), "{"), errJSONTooManyFields},
+		{"array strings aren't fields", `[` + strings.TrimSuffix(strings.Repeat(`"x",`, 5*maxJSONFields), ",") + `]`, nil},
+		{"malformed is left to the handler", `{"body":`, nil},
+		{"unclosed deep arrays", nested(10000, false), errJSONTooDeep},
+	}
+	for _, tt := range tests {
+		if got := checkJSONShape([]byte(tt.body)); got != tt.want {
+			t.Errorf("%s: checkJSONShape = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGuardJSONPathologicalBodies(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	// generated to stay under the body caps, so only the guard stops them
+	tests := []struct {
+		name    string
+		path    string
+		headers []string
+		body    string
+		code    string
+	}{
+		{"10k deep arrays", "/admin/compact", asAdmin(), nested(10000, true), apitypes.ErrorCodeJSONTooDeep},
+		{"10k deep unclosed arrays", "/admin/compact", asAdmin(), nested(10000, false), apitypes.ErrorCodeJSONTooDeep},
+		{"10k deep objects", "/admin/compact", asAdmin(), nestedObjects(10000, "null"), apitypes.ErrorCodeJSONTooDeep},
+		{"50k keys", "/admin/compact", asAdmin(), wideObject(50000), apitypes.ErrorCodeJSONTooManyFields},
+		{"deep chirp", "/api/chirps", bearer(alice.Token), `{"body":` + nested(maxJSONDepth+1, true) + `}`, apitypes.ErrorCodeJSONTooDeep},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		resp := ts.request(t, "POST", tt.path, tt.body, tt.headers...).expect(t, http.StatusBadRequest)
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: rejected after %v", tt.name, elapsed)
+		}
+		if got := decode[apitypes.ErrorResponse](t, resp); got.ErrorCode != tt.code {
+			t.Errorf("%s: error = %+v, want code %s", tt.name, got, tt.code)
+		}
+	}
+
+	// a normal body still reaches the handler
+	ts.request(t, "POST", "/api/chirps", apitypes.CreateChirpRequest{Body: "hello"}, bearer(alice.Token)...).expect(t, http.StatusCreated)
+	// whole database uploads skip the guard
+	resp := ts.request(t, "POST", "/admin/import", wideObject(2*maxJSONFields), asAdmin()...)
+	if got := decode[apitypes.ErrorResponse](t, resp); got.ErrorCode == apitypes.ErrorCodeJSONTooManyFields {
+		t.Fatalf("import was rejected by the guard: %+v", got)
+	}
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// component is a long running part of the server with its own goroutines
+type component interface {
+	// Start starts the component and returns once it is running
+	Start(ctx context.Context) error
+	// Stop stops it, giving up when ctx is done
+	Stop(ctx context.Context) error
+}
+
+type registeredComponent struct {
+	name        string
+	c           component
+	stopTimeout time.Duration
+}
+
+// lifecycle starts components in the order they were registered and stops
+// them in reverse, so everything a component uses is still running while
+// it stops
+type lifecycle struct {
+	components []registeredComponent
+	started    int
+}
+
+// register adds a component. stopTimeout bounds how long stopping it may take.
+func (l *lifecycle) register(name string, c component, stopTimeout time.Duration) {
+	l.components = append(l.components, registeredComponent{name: name, c: c, stopTimeout: stopTimeout})
+}
+
+// start starts every component in order. when one fails the ones already
+// started are stopped again and the error names the failing component.
+func (l *lifecycle) start(ctx context.Context) error {
+	for _, rc := range l.components {
+		if err := rc.c.Start(ctx); err != nil {
+			l.stop()
+			return fmt.Errorf("starting %s: %w", rc.name, err)
+		}
+		l.started++
+	}
+	return nil
+}
+
+// stop stops the started components in reverse order, each within its own
+// timeout. a component whose Stop doesn't return in time is left behind so
+// it can't hold up the rest. it logs a summary and returns the first error.
+func (l *lifecycle) stop() error {
+	var firstErr error
+	summary := make([]string, 0, l.started)
+	for i := l.started - 1; i >= 0; i-- {
+		rc := l.components[i]
+		start := time.Now()
+		err := stopWithin(rc.c, rc.stopTimeout)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("stopping %s: %w", rc.name, err)
+		}
+		result := "ok"
+		if err != nil {
+			result = err.Error()
+		}
+		summary = append(summary, fmt.Sprintf("%s %s in %s", rc.name, result, time.Since(start).Round(time.Millisecond)))
+	}
+	l.started = 0
+	if len(summary) > 0 {
+		log.Printf("shutdown: %s", strings.Join(summary, ", "))
+	}
+	return firstErr
+}
+
+// stopWithin stops c and waits at most timeout for it
+func stopWithin(c component, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Stop(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// httpComponent serves srv, over TLS when it has a TLSConfig. the listener
+// is opened in Start so a port that is taken fails the boot. when serving
+// fails later the error is sent on failed, so Run can shut down.
+type httpComponent struct {
+	srv      *http.Server
+	inFlight *routeInFlight
+	failed   chan<- error
+}
+
+func (h httpComponent) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", h.srv.Addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		var err error
+		if h.srv.TLSConfig != nil {
+			err = h.srv.ServeTLS(ln, "", "")
+		} else {
+			err = h.srv.Serve(ln)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			h.failed <- fmt.Errorf("serving %s: %w", h.srv.Addr, err)
+		}
+	}()
+	return nil
+}
+
+// Stop drains the server, logging the requests still in flight every second
+func (h httpComponent) Stop(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	done := make(chan error, 1)
+	go func() {
+		done <- h.srv.Shutdown(ctx)
+	}()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			h.inFlight.logBusy()
+		}
+	}
+}
+
+// databasesComponent closes the databases on stop, waiting for the writes
+// still in progress in requests or jobs that outlived their own stop timeout
+type databasesComponent []interface{ Close() }
+
+func (d databasesComponent) Start(ctx context.Context) error {
+	return nil
+}
+
+func (d databasesComponent) Stop(ctx context.Context) error {
+	for _, db := range d {
+		db.Close()
+	}
+	return nil
+}
+
+// dirLockComponent holds the lock of the data directory while the server
+// runs, so offline tools can tell the files are in use
+type dirLockComponent struct {
+	dir  string
+	lock *database.DirLock
+}
+
+func (d *dirLockComponent) Start(ctx context.Context) error {
+	lock, err := database.LockDir(d.dir)
+	if errors.Is(err, database.ErrLocked) {
+		return errors.New("another chirpy server is using the database files in this directory")
+	}
+	if err != nil {
+		return err
+	}
+	d.lock = lock
+	return nil
+}
+
+func (d *dirLockComponent) Stop(ctx context.Context) error {
+	return d.lock.Unlock()
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder collects what the fake components were asked to do, in order
+type recorder struct {
+	mux    *sync.Mutex
+	events []string
+}
+
+func newRecorder() *recorder {
+	return &recorder{mux: &sync.Mutex{}}
+}
+
+func (r *recorder) record(event string) {
+	r.mux.Lock()
+	r.events = append(r.events, event)
+	r.mux.Unlock()
+}
+
+func (r *recorder) get() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return slices.Clone(r.events)
+}
+
+// fakeComponent records its starts and stops. a startErr fails Start, a
+// hang blocks Stop until it is closed, whatever its context says.
+type fakeComponent struct {
+	name     string
+	rec      *recorder
+	startErr error
+	hang     chan struct{}
+}
+
+func (f *fakeComponent) Start(ctx context.Context) error {
+	f.rec.record("start " + f.name)
+	return f.startErr
+}
+
+func (f *fakeComponent) Stop(ctx context.Context) error {
+	f.rec.record("stop " + f.name)
+	if f.hang != nil {
+		<-f.hang
+	}
+	return nil
+}
+
+func TestLifecycleOrder(t *testing.T) {
+	rec := newRecorder()
+	var l lifecycle
+	for _, name := range []string{"a", "b", "c"} {
+		l.register(name, &fakeComponent{name: name, rec: rec}, time.Second)
+	}
+	if err := l.start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.stop(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start a", "start b", "start c", "stop c", "stop b", "stop a"}
+	if got := rec.get(); !slices.Equal(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	// stopping again has nothing left to stop
+	if err := l.stop(); err != nil || len(rec.get()) != len(want) {
+		t.Fatalf("second stop: %v, events %v", err, rec.get())
+	}
+}
+
+func TestLifecycleStartFailure(t *testing.T) {
+	rec := newRecorder()
+	var l lifecycle
+	l.register("a", &fakeComponent{name: "a", rec: rec}, time.Second)
+	l.register("b", &fakeComponent{name: "b", rec: rec, startErr: errors.New("port taken")}, time.Second)
+	l.register("c", &fakeComponent{name: "c", rec: rec}, time.Second)
+
+	err := l.start(context.Background())
+	if err == nil || err.Error() != "starting b: port taken" {
+		t.Fatalf("start = %v, want it to name b", err)
+	}
+	// what started is stopped again, what came after never starts
+	want := []string{"start a", "start b", "stop a"}
+	if got := rec.get(); !slices.Equal(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+}
+
+func TestLifecycleHangingStop(t *testing.T) {
+	rec := newRecorder()
+	hang := make(chan struct{})
+	t.Cleanup(func() { close(hang) })
+	var l lifecycle
+	l.register("a", &fakeComponent{name: "a", rec: rec}, time.Second)
+	l.register("b", &fakeComponent{name: "b", rec: rec, hang: hang}, 50*time.Millisecond)
+	l.register("c", &fakeComponent{name: "c", rec: rec}, time.Second)
+	if err := l.start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err := l.stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("stop took %s, b's timeout is 50ms", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.HasPrefix(err.Error(), "stopping b:") {
+		t.Fatalf("stop = %v, want b's deadline", err)
+	}
+	// b is left behind, a still stops after it
+	want := []string{"start a", "start b", "start c", "stop c", "stop b", "stop a"}
+	if got := rec.get(); !slices.Equal(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+}
+
+func TestServerComponentOrder(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	names := make([]string, 0, len(ts.api.lifecycle.components))
+	for _, rc := range ts.api.lifecycle.components {
+		names = append(names, rc.name)
+	}
+	// jobs write to the databases, which live in the locked dir
+	want := []string{"data dir lock", "databases", "jobs"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("components = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPort       = 8080
+	readHeaderTimeout = 5 * time.Second
+)
+
+// ListenAddrFromEnv reads PORT and HOST, the address the server listens on.
+// an unset PORT is 8080, an unset HOST listens on every interface.
+func ListenAddrFromEnv() (string, error) {
+	return listenAddr(os.Getenv)
+}
+
+// listenAddr builds the listen address from PORT and HOST as read by getenv
+func listenAddr(getenv func(string) string) (string, error) {
+	port := defaultPort
+	if v := getenv("PORT"); v != "" {
+		var err error
+		port, err = strconv.Atoi(v)
+		if err != nil || port < 1 || port > 65535 {
+			return "", fmt.Errorf("invalid PORT %q: must be between 1 and 65535", v)
+		}
+	}
+	return net.JoinHostPort(getenv("HOST"), strconv.Itoa(port)), nil
+}
+
+// NewHTTPServer serves handler on addr, with the timeouts chirpy uses.
+// with TLS_CERT_FILE and TLS_KEY_FILE set it serves https, srv.TLSConfig
+// holds the certificate and every response gets Strict-Transport-Security.
+func NewHTTPServer(addr string, handler http.Handler) (*http.Server, error) {
+	tlsConfig, err := tlsConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		handler = middlewareHSTS(handler)
+	}
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}, nil
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string // empty when the env is rejected
+	}{
+		{"defaults", nil, ":8080"},
+		{"port", map[string]string{"PORT": "3000"}, ":3000"},
+		{"lowest port", map[string]string{"PORT": "1"}, ":1"},
+		{"highest port", map[string]string{"PORT": "65535"}, ":65535"},
+		{"host", map[string]string{"HOST": "127.0.0.1"}, "127.0.0.1:8080"},
+		{"host and port", map[string]string{"HOST": "localhost", "PORT": "9000"}, "localhost:9000"},
+		{"ipv6 host", map[string]string{"HOST": "::1", "PORT": "9000"}, "[::1]:9000"},
+		{"port 0", map[string]string{"PORT": "0"}, ""},
+		{"port too high", map[string]string{"PORT": "65536"}, ""},
+		{"negative port", map[string]string{"PORT": "-80"}, ""},
+		{"named port", map[string]string{"PORT": "http"}, ""},
+		{"port with a colon", map[string]string{"PORT": ":8080"}, ""},
+	}
+	for _, tt := range tests {
+		got, err := listenAddr(func(key string) string { return tt.env[key] })
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: listenAddr = %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: listenAddr = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	setTestEnv(t)
+	dir := t.TempDir()
+	store, err := database.NewDB(filepath.Join(dir, databaseFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	srv, err := NewServer(store, Config{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpSrv, err := NewHTTPServer(":0", srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if httpSrv.Addr != ":0" || httpSrv.TLSConfig != nil || httpSrv.ReadHeaderTimeout != readHeaderTimeout {
+		t.Fatalf("NewHTTPServer = %+v", httpSrv)
+	}
+
+	// the handler runs under httptest without a real listener
+	ts := httptest.NewServer(httpSrv.Handler)
+	defer ts.Close()
+	resp, err := ts.Client().Get(ts.URL + "/api/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Strict-Transport-Security") != "" {
+		t.Fatalf("GET /api/healthz = %d, HSTS %q", resp.StatusCode, resp.Header.Get("Strict-Transport-Security"))
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const defaultMaxInFlight = 256
+
+// requestLimiter caps the number of requests handled at once.
+// when every slot is taken the request is shed with 503 right away
+// instead of queueing behind the database mutex.
+type requestLimiter struct {
+	slots    chan struct{}
+	inFlight *atomic.Int64
+	shed     *atomic.Int64
+}
+
+func newRequestLimiter(size int) *requestLimiter {
+	return &requestLimiter{
+		slots:    make(chan struct{}, size),
+		inFlight: &atomic.Int64{},
+		shed:     &atomic.Int64{},
+	}
+}
+
+// maxInFlightFromEnv reads MAX_INFLIGHT_REQUESTS, falling back to 256
+func maxInFlightFromEnv(getenv func(string) string) (int, error) {
+	v := getenv("MAX_INFLIGHT_REQUESTS")
+	if v == "" {
+		return defaultMaxInFlight, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid MAX_INFLIGHT_REQUESTS %q: must be 1 or more", v)
+	}
+	return n, nil
+}
+
+// limit wraps next with the limiter
+func (l *requestLimiter) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.shed.Add(1)
+			w.Header().Set("Retry-After", "1")
+			respondWithError(w, http.StatusServiceUnavailable, "Server is overloaded, try again later")
+			return
+		}
+		l.inFlight.Add(1)
+		defer func() {
+			l.inFlight.Add(-1)
+			<-l.slots
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middlewareLoadShedding applies the limiter to every request except static files
+func (l *requestLimiter) middlewareLoadShedding(next http.Handler) http.Handler {
+	limited := l.limit(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/app/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limited.ServeHTTP(w, r)
+	})
+}
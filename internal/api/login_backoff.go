@@ -0,0 +1,127 @@
+package api
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+const (
+	loginFailureThreshold = 5
+	loginFailureWindow    = 15 * time.Minute
+	loginBackoffStep      = 500 * time.Millisecond
+	loginBackoffMax       = 3 * time.Second
+)
+
+// loginFailures are the failed logins of one account inside the current window
+type loginFailures struct {
+	count       int
+	windowStart time.Time
+}
+
+// loginBackoff tracks failed logins per account in memory.
+// accounts are keyed by email whether they exist or not so the
+// response never tells an attacker which emails are registered.
+type loginBackoff struct {
+	mux       *sync.Mutex
+	accounts  map[string]*loginFailures
+	lastSweep time.Time
+	clock     clock.Clock
+}
+
+func newLoginBackoff(clk clock.Clock) *loginBackoff {
+	return &loginBackoff{
+		mux:      &sync.Mutex{},
+		accounts: make(map[string]*loginFailures),
+		clock:    clk,
+	}
+}
+
+func loginBackoffKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// check reports whether the account is over the threshold and how long
+// to delay the response. every attempt while locked counts as a failure
+// so the delay keeps growing until the cap.
+func (l *loginBackoff) check(email string) (time.Duration, bool) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	f := l.window(loginBackoffKey(email), l.clock.Now())
+	if f == nil || f.count < loginFailureThreshold {
+		return 0, false
+	}
+	f.count++
+	delay := time.Duration(f.count-loginFailureThreshold) * loginBackoffStep
+	return min(delay, loginBackoffMax), true
+}
+
+// fail records a failed login for the account
+func (l *loginBackoff) fail(email string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := l.clock.Now()
+	l.sweep(now)
+
+	key := loginBackoffKey(email)
+	f := l.window(key, now)
+	if f == nil {
+		f = &loginFailures{windowStart: now}
+		l.accounts[key] = f
+	}
+	f.count++
+}
+
+// remaining reports how many more failed logins the account can have
+// before it is locked, and when the current window ends
+func (l *loginBackoff) remaining(email string) (int, time.Time) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	f := l.window(loginBackoffKey(email), l.clock.Now())
+	if f == nil {
+		return loginFailureThreshold, time.Time{}
+	}
+	return max(loginFailureThreshold-f.count, 0), f.windowStart.Add(loginFailureWindow)
+}
+
+// window returns the failures of the account's current window, nil when
+// there is none. caller must hold the lock.
+func (l *loginBackoff) window(key string, now time.Time) *loginFailures {
+	f, ok := l.accounts[key]
+	if !ok || now.Sub(f.windowStart) > loginFailureWindow {
+		return nil
+	}
+	return f
+}
+
+// reset forgets the failures of the account after a successful login
+func (l *loginBackoff) reset(email string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	delete(l.accounts, loginBackoffKey(email))
+}
+
+// sweep evicts expired windows at most once a minute.
+// caller must hold the lock.
+func (l *loginBackoff) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, f := range l.accounts {
+		if now.Sub(f.windowStart) > loginFailureWindow {
+			delete(l.accounts, key)
+		}
+	}
+}
+
+// sleep waits for d on the server's clock
+func (a *apiConfig) sleep(d time.Duration) {
+	<-a.clock.NewTimer(d).C()
+}
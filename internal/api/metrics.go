@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// middlewareMetrics gathers amout of request to the page
+func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg.fileserverHits.Add(1)
+		next.ServeHTTP(w, r)
+	})
+
+}
+
+// routeRow is one route of the admin metrics page
+type routeRow struct {
+	Route    string
+	Requests int64
+	Errors   int64 // 5xx answers
+	AvgMs    string
+}
+
+// routeRows returns the requested routes sorted by pattern
+func routeRows(routes map[string]routeStats) []routeRow {
+	rows := make([]routeRow, 0, len(routes))
+	for route, stats := range routes {
+		row := routeRow{Route: route, Requests: stats.Requests}
+		for code, n := range stats.ByStatus {
+			if code >= 500 {
+				row.Errors += n
+			}
+		}
+		row.AvgMs = strconv.FormatFloat(stats.DurationSeconds*1000/float64(stats.Requests), 'f', 2, 64)
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Route < rows[j].Route })
+	return rows
+}
+
+// metrics prints counts to the body.
+// scrapers asking for text/plain (or ?format=prometheus) get the database metrics instead.
+func (cfg *apiConfig) metrics(w http.ResponseWriter, r *http.Request) {
+	if wantsPrometheus(r) {
+		cfg.prometheusMetrics(w)
+		return
+	}
+
+	data := struct {
+		Hits            int64
+		InFlight        int64
+		Shed            int64
+		StorageDegraded bool
+		Routes          []routeRow
+	}{
+		Hits:            cfg.fileserverHits.Load(),
+		InFlight:        cfg.limiter.inFlight.Load(),
+		Shed:            cfg.limiter.shed.Load(),
+		StorageDegraded: cfg.storageDegraded(),
+		Routes:          routeRows(cfg.routeHits.snapshot()),
+	}
+	tmpl := `
+	<!DOCTYPE html>
+	<html>
+	<body>
+		<h1>Welcome, Chirpy Admin</h1>
+		<p>Chirpy has been visited {{.Hits}} times!</p>
+		<p>In-flight requests: {{.InFlight}}</p>
+		<p>Shed requests: {{.Shed}}</p>
+		{{if .StorageDegraded}}<p>Storage degraded: the disk is full</p>{{end}}
+		<table>
+			<tr><th>Route</th><th>Requests</th><th>5xx</th><th>Avg ms</th></tr>
+			{{range .Routes}}<tr><td>{{.Route}}</td><td>{{.Requests}}</td><td>{{.Errors}}</td><td>{{.AvgMs}}</td></tr>
+			{{end}}
+		</table>
+	</body>
+	
+	</html>
+	`
+	t, err := template.New("admin").Parse(tmpl)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if err := t.Execute(w, data); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+// routeLimit is the in-flight and shed requests of a route with its own limit
+type routeLimit struct {
+	InFlight int64 `json:"in_flight"`
+	Shed     int64 `json:"shed"`
+}
+
+// routeLimits returns the counts of every route limiter by pattern
+func (cfg *apiConfig) routeLimits() map[string]routeLimit {
+	limits := make(map[string]routeLimit, len(cfg.routeLimiters))
+	for pattern, l := range cfg.routeLimiters {
+		limits[pattern] = routeLimit{InFlight: l.inFlight.Load(), Shed: l.shed.Load()}
+	}
+	return limits
+}
+
+// GET /admin/metrics.json
+// metricsJSON is the admin page for machines, with every route's status codes
+func (cfg *apiConfig) metricsJSON(w http.ResponseWriter, r *http.Request) {
+	resp, err := json.Marshal(struct {
+		FileserverHits int64                 `json:"fileserver_hits"`
+		InFlight       int64                 `json:"in_flight"`
+		Shed           int64                 `json:"shed"`
+		Degraded       bool                  `json:"storage_degraded"`
+		Routes         map[string]routeStats `json:"routes"`
+		RouteLimits    map[string]routeLimit `json:"route_limits"`
+	}{
+		FileserverHits: cfg.fileserverHits.Load(),
+		InFlight:       cfg.limiter.inFlight.Load(),
+		Shed:           cfg.limiter.shed.Load(),
+		Degraded:       cfg.storageDegraded(),
+		Routes:         cfg.routeHits.snapshot(),
+		RouteLimits:    cfg.routeLimits(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
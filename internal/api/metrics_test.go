@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestFileserverHitsConcurrent(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	const workers, requests = 8, 25
+
+	// counting and reading the hits at the same time is race free under -race
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range requests {
+				// the file server has no ./app under test, the hit still counts
+				ts.request(t, "GET", "/app/", nil)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range requests {
+				ts.request(t, "GET", "/admin/metrics.json", nil, asAdmin()...).expect(t, http.StatusOK)
+			}
+		}()
+	}
+	wg.Wait()
+
+	metrics := decode[struct {
+		FileserverHits int64 `json:"fileserver_hits"`
+	}](t, ts.request(t, "GET", "/admin/metrics.json", nil, asAdmin()...).expect(t, http.StatusOK))
+	if metrics.FileserverHits != workers*requests {
+		t.Fatalf("fileserver hits = %d, want %d", metrics.FileserverHits, workers*requests)
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// runMigrateDBCommand implements `chirpy migrate-db`, which copies the
+// users and chirps of the json database in the working directory into the
+// SQLite database --to points to. the json file is left as it is, the
+// server switches over once DATABASE_URL points to the new database.
+func runMigrateDBCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate-db", flag.ContinueOnError)
+	to := fs.String("to", os.Getenv("DATABASE_URL"), "SQLite database to copy into, as sqlite:<path>")
+	force := fs.Bool("force", false, "Run even though a server holds the data directory lock")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *to == "" {
+		return errors.New("migrate-db needs --to or DATABASE_URL")
+	}
+	path, err := sqlitePath(*to)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockDataDir(*force)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// older versions only have the split files, openDB merges them
+	found := false
+	for _, name := range []string{databaseFile, "chirpyDatabase.json", "userDatabase.json"} {
+		if _, err := os.Stat(name); err == nil {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no %s to migrate", databaseFile)
+	}
+	db, err := openDB(".")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	store, err := database.OpenSQLite(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	users, chirps, err := store.ImportFile(db)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	fmt.Fprintf(out, "copied %d users and %d chirps from %s to %s\n", users, chirps, databaseFile, path)
+	return nil
+}
+
+// runMigrateCommand implements `chirpy migrate --from json --to sqlite`,
+// the bulk copy before running with DATABASE_SECONDARY_URL. it copies the
+// users, identities and chirps of one store into another, which has to be
+// empty, and checks that the copy exports exactly like the original.
+func runMigrateCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := fs.String("from", "json", "Store to copy from: json, sqlite or sqlite:<path>")
+	to := fs.String("to", "sqlite", "Store to copy into: json, sqlite or sqlite:<path>")
+	force := fs.Bool("force", false, "Run even though a server holds the data directory lock")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fromURL, err := backendURL(*from)
+	if err != nil {
+		return err
+	}
+	toURL, err := backendURL(*to)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockDataDir(*force)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	src, srcName, err := openStoreURL(".", fromURL)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, dstName, err := openStoreURL(".", toURL)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if srcName == dstName {
+		return fmt.Errorf("--from and --to are both %s", srcName)
+	}
+
+	backup, err := copyStore(src, dst)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dstName, err)
+	}
+	fmt.Fprintf(out, "copied and verified %d users, %d identities and %d chirps from %s to %s\n",
+		len(backup.Users), len(backup.Identities), len(backup.Chirps), srcName, dstName)
+	return nil
+}
+
+// backendURL turns a --from or --to store into a DATABASE_URL. a bare
+// sqlite is the SQLite database of DATABASE_URL, or chirpy.db.
+func backendURL(backend string) (string, error) {
+	switch {
+	case backend == "json":
+		return "json", nil
+	case backend == "sqlite":
+		if url := os.Getenv("DATABASE_URL"); strings.HasPrefix(url, "sqlite:") {
+			return url, nil
+		}
+		return "sqlite:chirpy.db", nil
+	case strings.HasPrefix(backend, "sqlite:"):
+		return backend, nil
+	}
+	return "", fmt.Errorf("invalid store %q: must be json, sqlite or sqlite:<path>", backend)
+}
+
+// copyStore copies everything of src into dst, which must be empty, and
+// checks that dst then exports what src does. it returns what was copied.
+func copyStore(src, dst database.Store) (database.Backup, error) {
+	existing, err := dst.Export(false)
+	if err != nil {
+		return database.Backup{}, err
+	}
+	if len(existing.Users) > 0 || len(existing.Chirps) > 0 {
+		return database.Backup{}, database.ErrStoreNotEmpty
+	}
+	backup, err := src.Export(true)
+	if err != nil {
+		return database.Backup{}, err
+	}
+	if err := dst.Import(backup); err != nil {
+		return database.Backup{}, err
+	}
+
+	copied, err := dst.Export(true)
+	if err != nil {
+		return database.Backup{}, err
+	}
+	want, err := json.Marshal(backup)
+	if err != nil {
+		return database.Backup{}, err
+	}
+	got, err := json.Marshal(copied)
+	if err != nil {
+		return database.Backup{}, err
+	}
+	if !bytes.Equal(got, want) {
+		return database.Backup{}, errors.New("the copy doesn't match the original")
+	}
+	return backup, nil
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// seedStore gives s two users, one of them with an identity, and chirps
+// including a deleted one
+func seedStore(t *testing.T, s database.Store) {
+	t.Helper()
+	alice, err := s.CreateUser("alice@example.com", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := s.CreateUser("bob@example.com", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LinkIdentity(bob.ID, "github", "42"); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"first", "second", "third"} {
+		if _, err := s.CreateChirp(body, alice.ID, "en", "web"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DeleteDB(alice.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+	setTestEnv(t)
+	dir := t.TempDir()
+	chdir(t, dir)
+	db, err := openDB(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedStore(t, db)
+	db.Close()
+
+	var out bytes.Buffer
+	if err := RunCommand("migrate", []string{"--from", "json", "--to", "sqlite"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "copied and verified 2 users, 1 identities and 3 chirps") {
+		t.Fatalf("output = %q", out.String())
+	}
+	// the copy keeps IDs, tombstones and counters
+	s, err := database.OpenSQLite(filepath.Join(dir, "chirpy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetChirpyFromID(2); !errors.Is(err, database.ErrChirpNotFound) {
+		t.Fatalf("deleted chirp 2 after the copy: %v", err)
+	}
+	chirp, err := s.CreateChirp("fourth", 1, "en", "web")
+	if err != nil || chirp.ID != 4 {
+		t.Fatalf("new chirp after the copy = %+v, %v, want ID 4", chirp, err)
+	}
+	s.Close()
+
+	// the target has to be empty
+	err = RunCommand("migrate", []string{"--from", "json", "--to", "sqlite"}, &out)
+	if !errors.Is(err, database.ErrStoreNotEmpty) {
+		t.Fatalf("copying into a store with data: %v, want ErrStoreNotEmpty", err)
+	}
+
+	// and back into an empty json database
+	other := t.TempDir()
+	chdir(t, other)
+	out.Reset()
+	err = RunCommand("migrate", []string{"--from", "sqlite:" + filepath.Join(dir, "chirpy.db"), "--to", "json"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "copied and verified 2 users, 1 identities and 4 chirps") {
+		t.Fatalf("output = %q", out.String())
+	}
+
+	for name, args := range map[string][]string{
+		"unknown store": {"--from", "postgres", "--to", "sqlite"},
+		"same store":    {"--from", "json", "--to", "json"},
+	} {
+		if err := RunCommand("migrate", args, &out); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestOpenDualStore(t *testing.T) {
+	setTestEnv(t)
+	dir := t.TempDir()
+	t.Setenv("DATABASE_SECONDARY_URL", "sqlite:chirpy.db")
+	t.Setenv("DUAL_WRITE_VERIFY_EVERY", "1")
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dual, ok := store.(*database.DualStore)
+	if !ok {
+		t.Fatalf("OpenStore = %T, want a *database.DualStore", store)
+	}
+	report := dual.Report()
+	if report.Primary != "json:"+filepath.Join(dir, databaseFile) || report.Secondary != "sqlite:"+filepath.Join(dir, "chirpy.db") {
+		t.Fatalf("stores = %s and %s", report.Primary, report.Secondary)
+	}
+	store.Close()
+
+	for name, env := range map[string][2]string{
+		"same store":        {"json", "1"},
+		"bad verify":        {"sqlite:chirpy.db", "often"},
+		"unknown secondary": {"postgres://db", "0"},
+		"negative verify":   {"sqlite:chirpy.db", "-1"},
+	} {
+		t.Setenv("DATABASE_SECONDARY_URL", env[0])
+		t.Setenv("DUAL_WRITE_VERIFY_EVERY", env[1])
+		if store, err := OpenStore(dir); err == nil {
+			store.Close()
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	status := decode[migrationStatus](t, ts.request(t, "GET", "/admin/migration/status", nil, asAdmin()...).expect(t, http.StatusOK))
+	if status.DualWrite || status.MigrationReport != nil {
+		t.Fatalf("status without a secondary = %+v", status)
+	}
+
+	dir := t.TempDir()
+	db, err := openDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondary, err := database.OpenSQLite(filepath.Join(dir, "chirpy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts = newTestServerWithStore(t, database.NewDualStore(db, "json", secondary, "sqlite", 1), dir)
+	ts.request(t, "GET", "/admin/migration/status", nil).expect(t, http.StatusUnauthorized)
+	alice := ts.newUser(t, "alice@example.com")
+	ts.postChirp(t, alice.Token, "on both")
+	ts.request(t, "GET", "/api/chirps", nil).expect(t, http.StatusOK)
+
+	status = decode[migrationStatus](t, ts.request(t, "GET", "/admin/migration/status", nil, asAdmin()...).expect(t, http.StatusOK))
+	if !status.DualWrite || status.Writes == 0 || status.VerifiedReads == 0 || len(status.Divergences) != 0 {
+		t.Fatalf("status = %+v, want writes and verified reads without divergences", status)
+	}
+
+	// a chirp only the secondary has shows up on the next verified list
+	if _, err := secondary.CreateChirp("only here", alice.ID, "en", "web"); err != nil {
+		t.Fatal(err)
+	}
+	ts.request(t, "GET", "/api/chirps", nil).expect(t, http.StatusOK)
+	status = decode[migrationStatus](t, ts.request(t, "GET", "/admin/migration/status", nil, asAdmin()...).expect(t, http.StatusOK))
+	if status.MismatchedReads == 0 || len(status.Divergences) == 0 || status.Divergences[0].Kind != database.DivergenceReadMismatch {
+		t.Fatalf("status after a divergence = %+v", status)
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+const oauthStateTTL = 10 * time.Minute
+
+// oauthIdentity is what a provider tells us about the user who logged in
+type oauthIdentity struct {
+	ProviderUserID string
+	Email          string // primary verified email
+}
+
+// oauthProvider is an external identity provider.
+// handlers only talk to this interface so adding a provider means
+// implementing it and registering it in newAPIServer.
+type oauthProvider interface {
+	// AuthCodeURL is where the user is redirected to log in
+	AuthCodeURL(state, codeChallenge string) string
+	// Identity exchanges the callback code and fetches the user's identity
+	Identity(ctx context.Context, code, codeVerifier string) (oauthIdentity, error)
+}
+
+// oauthState is a flow started at /api/oauth/{provider}/login, or at
+// /api/users/me/identities/{provider}/link when linkUserID is set
+type oauthState struct {
+	provider     string
+	codeVerifier string
+	linkUserID   int
+	expiresAt    time.Time
+}
+
+// oauthStateStore keeps pending oauth flows in memory keyed by state
+type oauthStateStore struct {
+	mux    *sync.Mutex
+	states map[string]oauthState
+	clock  clock.Clock
+	random io.Reader
+}
+
+func newOAuthStateStore(clk clock.Clock, random io.Reader) *oauthStateStore {
+	return &oauthStateStore{
+		mux:    &sync.Mutex{},
+		states: make(map[string]oauthState),
+		clock:  clk,
+		random: random,
+	}
+}
+
+// start creates a new state and pkce verifier for provider, bound to
+// linkUserID when linking an identity (0 for a plain login).
+// it returns the state and the S256 code challenge.
+func (s *oauthStateStore) start(provider string, linkUserID int) (string, string, error) {
+	state, err := randomURLString(s.random, 32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomURLString(s.random, 32)
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := s.clock.Now()
+	for k, st := range s.states {
+		if now.After(st.expiresAt) {
+			delete(s.states, k)
+		}
+	}
+	s.states[state] = oauthState{
+		provider:     provider,
+		codeVerifier: verifier,
+		linkUserID:   linkUserID,
+		expiresAt:    now.Add(oauthStateTTL),
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	return state, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// finish consumes state, reporting whether it is valid for provider
+func (s *oauthStateStore) finish(state, provider string) (oauthState, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	st, ok := s.states[state]
+	if !ok {
+		return oauthState{}, false
+	}
+	delete(s.states, state)
+	if st.provider != provider || s.clock.Now().After(st.expiresAt) {
+		return oauthState{}, false
+	}
+	return st, true
+}
+
+func randomURLString(random io.Reader, n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(random, b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
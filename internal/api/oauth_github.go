@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// githubProvider logs users in with GitHub
+type githubProvider struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	client       *http.Client
+}
+
+// newGitHubProviderFromEnv reads GITHUB_CLIENT_ID, GITHUB_CLIENT_SECRET and
+// GITHUB_REDIRECT_URL. it reports false when github login isn't configured.
+func newGitHubProviderFromEnv() (*githubProvider, bool) {
+	p := &githubProvider{
+		clientID:     os.Getenv("GITHUB_CLIENT_ID"),
+		clientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
+		redirectURL:  os.Getenv("GITHUB_REDIRECT_URL"),
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+	if p.clientID == "" || p.clientSecret == "" {
+		return nil, false
+	}
+	return p, true
+}
+
+func (p *githubProvider) AuthCodeURL(state, codeChallenge string) string {
+	query := url.Values{}
+	query.Set("client_id", p.clientID)
+	query.Set("scope", "user:email")
+	query.Set("state", state)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	if p.redirectURL != "" {
+		query.Set("redirect_uri", p.redirectURL)
+	}
+	return "https://github.com/login/oauth/authorize?" + query.Encode()
+}
+
+func (p *githubProvider) Identity(ctx context.Context, code, codeVerifier string) (oauthIdentity, error) {
+	accessToken, err := p.exchange(ctx, code, codeVerifier)
+	if err != nil {
+		return oauthIdentity{}, err
+	}
+
+	var ghUser struct {
+		ID int64 `json:"id"`
+	}
+	err = p.get(ctx, "https://api.github.com/user", accessToken, &ghUser)
+	if err != nil {
+		return oauthIdentity{}, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	err = p.get(ctx, "https://api.github.com/user/emails", accessToken, &emails)
+	if err != nil {
+		return oauthIdentity{}, err
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			return oauthIdentity{
+				ProviderUserID: strconv.FormatInt(ghUser.ID, 10),
+				Email:          e.Email,
+			}, nil
+		}
+	}
+	return oauthIdentity{}, errors.New("github account has no verified primary email")
+}
+
+// exchange trades the callback code for a github access token
+func (p *githubProvider) exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("client_id", p.clientID)
+	form.Set("client_secret", p.clientSecret)
+	form.Set("code", code)
+	form.Set("code_verifier", codeVerifier)
+	if p.redirectURL != "" {
+		form.Set("redirect_uri", p.redirectURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://github.com/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokenResp)
+	if err != nil {
+		return "", err
+	}
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("github token exchange failed: %s", tokenResp.Error)
+	}
+	return tokenResp.AccessToken, nil
+}
+
+// get fetches a github api resource into out
+func (p *githubProvider) get(ctx context.Context, apiURL, accessToken string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("github api %s returned %d", apiURL, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
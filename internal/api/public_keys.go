@@ -0,0 +1,281 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+const (
+	publicKeyScheme           = "PublicKey "
+	publicKeyPrefixLen        = 10 // "pk_" and 7 hex characters
+	defaultPublicKeyPerMinute = 60
+	maxPublicKeyPerMinute     = 6000
+)
+
+// keyUsage is what one public key did since the server started
+type keyUsage struct {
+	Requests   int64      `json:"requests"`
+	Throttled  int64      `json:"throttled"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+
+	window   time.Time // start of the current quota minute
+	inWindow int
+}
+
+// publicKeyUsage enforces the per-minute quota of each key in fixed
+// one-minute windows and counts usage for the admin. it is in memory, so a
+// restart starts every quota and counter over.
+type publicKeyUsage struct {
+	mux   *sync.Mutex
+	keys  map[int]*keyUsage
+	clock clock.Clock
+}
+
+func newPublicKeyUsage(clk clock.Clock) *publicKeyUsage {
+	return &publicKeyUsage{
+		mux:   &sync.Mutex{},
+		keys:  make(map[int]*keyUsage),
+		clock: clk,
+	}
+}
+
+// allow counts a request of key ID, it returns false and how long until
+// the quota resets when perMinute requests were already made this minute
+func (u *publicKeyUsage) allow(ID, perMinute int) (bool, time.Duration) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	usage, ok := u.keys[ID]
+	if !ok {
+		usage = &keyUsage{}
+		u.keys[ID] = usage
+	}
+	now := u.clock.Now()
+	if window := now.Truncate(time.Minute); !window.Equal(usage.window) {
+		usage.window = window
+		usage.inWindow = 0
+	}
+	usage.LastUsedAt = &now
+	if usage.inWindow >= perMinute {
+		usage.Throttled++
+		return false, usage.window.Add(time.Minute).Sub(now)
+	}
+	usage.inWindow++
+	usage.Requests++
+	return true, 0
+}
+
+// get returns a copy of the usage of key ID
+func (u *publicKeyUsage) get(ID int) keyUsage {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	if usage, ok := u.keys[ID]; ok {
+		return *usage
+	}
+	return keyUsage{}
+}
+
+func hashPublicKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalOrigin turns an allowed origin into the form browsers send in
+// the Origin header: scheme and host, lowercase, no path
+func canonicalOrigin(origin string) (string, error) {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", errors.New("allowed_origins must be http or https origins like https://example.com")
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), nil
+}
+
+// checkPublicKey authenticates a request made with Authorization: PublicKey.
+// the key has to be live, the request has to come from a page on one of its
+// origins and within its quota. CORS is then answered for that origin only,
+// so browsers on other sites can't read the response even with the key.
+func (a *apiConfig) checkPublicKey(w http.ResponseWriter, r *http.Request, raw string) bool {
+	key, err := a.publicKeys.GetPublicKeyByHash(hashPublicKey(raw))
+	if errors.Is(err, database.ErrPublicKeyNotFound) || (err == nil && key.RevokedAt != nil) {
+		respondWithError(w, http.StatusUnauthorized, "invalid public key")
+		return false
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if !slices.Contains(key.AllowedOrigins, strings.ToLower(origin)) {
+		w.Header().Del("Access-Control-Allow-Origin")
+		respondWithError(w, http.StatusForbidden, "origin not allowed for this public key")
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if !slices.Contains(w.Header().Values("Vary"), "Origin") {
+		w.Header().Add("Vary", "Origin") // unless middlewareCors added it
+	}
+
+	if ok, retry := a.keyUsage.allow(key.ID, key.PerMinute); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+		respondWithError(w, http.StatusTooManyRequests, "public key quota exceeded, try again later")
+		return false
+	}
+	return true
+}
+
+// publicKeyView is a key as the admin sees it, without its hash
+type publicKeyView struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	Prefix         string     `json:"prefix"`
+	AllowedOrigins []string   `json:"allowed_origins"`
+	PerMinute      int        `json:"per_minute"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	Usage          keyUsage   `json:"usage"`
+}
+
+func (a *apiConfig) publicKeyView(key database.PublicKey) publicKeyView {
+	return publicKeyView{
+		ID:             key.ID,
+		Name:           key.Name,
+		Prefix:         key.Prefix,
+		AllowedOrigins: key.AllowedOrigins,
+		PerMinute:      key.PerMinute,
+		CreatedAt:      key.CreatedAt,
+		RevokedAt:      key.RevokedAt,
+		Usage:          a.keyUsage.get(key.ID),
+	}
+}
+
+// POST /admin/public_keys
+// createPublicKey mints a read-only key for embeds. the key is only in this
+// response, the server keeps its hash.
+func (a *apiConfig) createPublicKey(w http.ResponseWriter, r *http.Request) {
+	keyReq := struct {
+		Name           string   `json:"name"`
+		AllowedOrigins []string `json:"allowed_origins"`
+		PerMinute      int      `json:"per_minute"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&keyReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+	keyReq.Name = strings.TrimSpace(keyReq.Name)
+	if keyReq.Name == "" || len(keyReq.Name) > 100 {
+		respondWithError(w, http.StatusBadRequest, "name must be 1 to 100 characters")
+		return
+	}
+	if len(keyReq.AllowedOrigins) == 0 {
+		respondWithError(w, http.StatusBadRequest, "allowed_origins is required")
+		return
+	}
+	origins := make([]string, 0, len(keyReq.AllowedOrigins))
+	for _, o := range keyReq.AllowedOrigins {
+		origin, err := canonicalOrigin(o)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !slices.Contains(origins, origin) {
+			origins = append(origins, origin)
+		}
+	}
+	if keyReq.PerMinute == 0 {
+		keyReq.PerMinute = defaultPublicKeyPerMinute
+	}
+	if keyReq.PerMinute < 0 || keyReq.PerMinute > maxPublicKeyPerMinute {
+		respondWithError(w, http.StatusBadRequest, "per_minute must be between 1 and "+strconv.Itoa(maxPublicKeyPerMinute))
+		return
+	}
+
+	b := make([]byte, 24)
+	if _, err := io.ReadFull(a.random, b); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	raw := "pk_" + hex.EncodeToString(b)
+	key, err := a.publicKeys.CreatePublicKey(keyReq.Name, hashPublicKey(raw), raw[:publicKeyPrefixLen], origins, keyReq.PerMinute)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	resp, err := json.Marshal(struct {
+		Key       string        `json:"key"`
+		PublicKey publicKeyView `json:"public_key"`
+	}{
+		Key:       raw,
+		PublicKey: a.publicKeyView(key),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(resp)
+}
+
+// GET /admin/public_keys
+// listPublicKeys shows every key with its usage since the server started
+func (a *apiConfig) listPublicKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.publicKeys.GetPublicKeys()
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	views := make([]publicKeyView, 0, len(keys))
+	for _, key := range keys {
+		views = append(views, a.publicKeyView(key))
+	}
+
+	resp, err := json.Marshal(views)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// DELETE /admin/public_keys/{id}
+// revokePublicKey stops accepting a key right away
+func (a *apiConfig) revokePublicKey(w http.ResponseWriter, r *http.Request) {
+	ID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid public key id")
+		return
+	}
+	key, err := a.publicKeys.RevokePublicKey(ID)
+	if errors.Is(err, database.ErrPublicKeyNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	resp, err := json.Marshal(a.publicKeyView(key))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
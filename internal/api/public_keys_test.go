@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// createdPublicKey is the answer of POST /admin/public_keys
+type createdPublicKey struct {
+	Key       string        `json:"key"`
+	PublicKey publicKeyView `json:"public_key"`
+}
+
+func TestCanonicalOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   string // empty when the origin is refused
+	}{
+		{"https://Embed.Example.com", "https://embed.example.com"},
+		{"http://localhost:8080/", "http://localhost:8080"},
+		{"https://embed.example.com/page", ""},
+		{"https://embed.example.com?x=1", ""},
+		{"https://user@embed.example.com", ""},
+		{"ftp://embed.example.com", ""},
+		{"embed.example.com", ""},
+	}
+	for _, tt := range tests {
+		got, err := canonicalOrigin(tt.origin)
+		if got != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("canonicalOrigin(%q) = %q, %v, want %q", tt.origin, got, err, tt.want)
+		}
+	}
+}
+
+func TestPublicKeys(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	chirp := ts.postChirp(t, alice.Token, "hello embeds")
+
+	newKey := map[string]any{"name": "blog", "allowed_origins": []string{"https://Embed.Example.com/"}, "per_minute": 3}
+	ts.request(t, "POST", "/admin/public_keys", newKey).expect(t, http.StatusUnauthorized)
+	ts.request(t, "POST", "/admin/public_keys", newKey, bearer(alice.Token)...).expect(t, http.StatusForbidden)
+	ts.request(t, "POST", "/admin/public_keys", map[string]any{"name": "blog"}, asAdmin()...).expect(t, http.StatusBadRequest)
+	ts.request(t, "POST", "/admin/public_keys", map[string]any{"name": "blog", "allowed_origins": []string{"*"}}, asAdmin()...).expect(t, http.StatusBadRequest)
+	created := decode[createdPublicKey](t, ts.request(t, "POST", "/admin/public_keys", newKey, asAdmin()...).expect(t, http.StatusCreated))
+	if created.PublicKey.Prefix != created.Key[:publicKeyPrefixLen] || len(created.PublicKey.AllowedOrigins) != 1 || created.PublicKey.AllowedOrigins[0] != "https://embed.example.com" {
+		t.Fatalf("created key = %+v", created)
+	}
+	// the key itself is only in the create response
+	list := ts.request(t, "GET", "/admin/public_keys", nil, asAdmin()...).expect(t, http.StatusOK)
+	if bytes.Contains(list.body, []byte(created.Key)) {
+		t.Fatalf("the key list shows the key: %s", list.body)
+	}
+
+	embed := []string{"Authorization", publicKeyScheme + created.Key, "Origin", "https://embed.example.com"}
+	resp := ts.request(t, "GET", "/api/chirps", nil, embed...).expect(t, http.StatusOK)
+	if got := resp.header.Get("Access-Control-Allow-Origin"); got != "https://embed.example.com" {
+		t.Fatalf("Access-Control-Allow-Origin = %q, want the key's origin", got)
+	}
+
+	// read only, and only from the key's origins
+	denied := []struct {
+		name    string
+		method  string
+		path    string
+		headers []string
+		status  int
+	}{
+		{"other origin", "GET", "/api/chirps", []string{"Authorization", publicKeyScheme + created.Key, "Origin", "https://evil.example.com"}, http.StatusForbidden},
+		{"no origin", "GET", "/api/chirps", []string{"Authorization", publicKeyScheme + created.Key}, http.StatusForbidden},
+		{"post a chirp", "POST", "/api/chirps", embed, http.StatusForbidden},
+		{"delete a chirp", "DELETE", chirpPath(chirp.ID), embed, http.StatusForbidden},
+		{"admin route", "GET", "/admin/public_keys", embed, http.StatusForbidden},
+		{"made up key", "GET", "/api/chirps", []string{"Authorization", publicKeyScheme + "pk_nope", "Origin", "https://embed.example.com"}, http.StatusUnauthorized},
+	}
+	for _, tt := range denied {
+		resp := ts.request(t, tt.method, tt.path, nil, tt.headers...)
+		if resp.status != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, resp.status, tt.status, resp.body)
+		}
+	}
+	if _, err := ts.store.GetChirpyFromID(chirp.ID); err != nil {
+		t.Fatalf("chirp after the denied requests: %v", err)
+	}
+
+	// three a minute, refused requests don't count
+	for range 2 {
+		ts.request(t, "GET", chirpPath(chirp.ID), nil, embed...).expect(t, http.StatusOK)
+	}
+	resp = ts.request(t, "GET", chirpPath(chirp.ID), nil, embed...).expect(t, http.StatusTooManyRequests)
+	now := ts.clock.Now()
+	if got, want := resp.header.Get("Retry-After"), strconv.Itoa(int(now.Truncate(time.Minute).Add(time.Minute).Sub(now).Seconds())+1); got != want {
+		t.Fatalf("Retry-After = %q, want %q", got, want)
+	}
+	ts.clock.Advance(time.Minute)
+	ts.request(t, "GET", "/api/chirps", nil, embed...).expect(t, http.StatusOK)
+	usage := decode[[]publicKeyView](t, ts.request(t, "GET", "/admin/public_keys", nil, asAdmin()...).expect(t, http.StatusOK))
+	if len(usage) != 1 || usage[0].Usage.Requests != 4 || usage[0].Usage.Throttled != 1 {
+		t.Fatalf("usage = %+v, want 4 requests and 1 throttled", usage)
+	}
+
+	// revoking stops the key right away
+	ts.request(t, "DELETE", "/admin/public_keys/"+strconv.Itoa(created.PublicKey.ID), nil, bearer(alice.Token)...).expect(t, http.StatusForbidden)
+	ts.request(t, "DELETE", "/admin/public_keys/99", nil, asAdmin()...).expect(t, http.StatusNotFound)
+	ts.request(t, "DELETE", "/admin/public_keys/"+strconv.Itoa(created.PublicKey.ID), nil, asAdmin()...).expect(t, http.StatusOK)
+	ts.request(t, "GET", "/api/chirps", nil, embed...).expect(t, http.StatusUnauthorized)
+	// anonymous reads don't need a key
+	ts.request(t, "GET", "/api/chirps", nil).expect(t, http.StatusOK)
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	purgeJob      = "purge.tombstones"
+	purgeInterval = 24 * time.Hour
+)
+
+// tombstoneRetentionFromEnv reads TOMBSTONE_RETENTION_DAYS, how long deleted
+// chirps are kept before the purge job removes them, falling back to 30
+func tombstoneRetentionFromEnv(getenv func(string) string) (time.Duration, error) {
+	v := getenv("TOMBSTONE_RETENTION_DAYS")
+	if v == "" {
+		return defaultTombstoneRetentionDays * 24 * time.Hour, nil
+	}
+	days, err := strconv.Atoi(v)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid TOMBSTONE_RETENTION_DAYS %q", v)
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// purgeTombstones is the job that permanently removes chirps deleted longer
+// than the retention ago and schedules the next run a day later
+func (a *apiConfig) purgeTombstones(ctx context.Context, payload json.RawMessage) error {
+	report, err := a.chirpyDatabase.CompactChirps(a.clock.Now().Add(-a.settings().retention))
+	if err != nil {
+		return err
+	}
+	a.purged.Add(int64(report.Removed))
+	if report.Removed > 0 {
+		log.Printf("purge: removed %d deleted chirps, reclaimed %d bytes", report.Removed, report.Reclaimed)
+	}
+	return a.jobs.EnqueueOnce(purgeJob, struct{}{}, a.clock.Now().Add(purgeInterval))
+}
+
+// runPurgeCommand implements `chirpy purge` against the chirp database in the
+// working directory. --dry-run lists what the next run would remove.
+func runPurgeCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "List the deleted chirps that would be removed without removing them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	chirpyDB, err := OpenStore(".")
+	if err != nil {
+		return err
+	}
+	retention, err := tombstoneRetentionFromEnv(os.Getenv)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-retention)
+
+	if *dryRun {
+		chirps, err := chirpyDB.DeletedChirpsBefore(cutoff)
+		if err != nil {
+			return err
+		}
+		for _, chirp := range chirps {
+			fmt.Fprintf(out, "chirp %d by user %d, deleted %s\n", chirp.ID, chirp.AuthorID, chirp.DeletedAt.Format(time.RFC3339))
+		}
+		fmt.Fprintf(out, "would remove %d deleted chirps\n", len(chirps))
+		return nil
+	}
+
+	report, err := chirpyDB.CompactChirps(cutoff)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "removed %d deleted chirps, reclaimed %d bytes\n", report.Removed, report.Reclaimed)
+	return nil
+}
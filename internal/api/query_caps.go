@@ -0,0 +1,49 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// hard caps on user-controlled query parameters, so no request can ask
+// for unbounded work. operators can tighten them but not raise them.
+const (
+	hardMaxPageLimit    = 200
+	hardMaxActivityDays = 365
+)
+
+// queryCaps are the limits every list endpoint validates its parameters against
+type queryCaps struct {
+	maxLimit        int
+	maxActivityDays int
+}
+
+// queryCapsFromEnv reads QUERY_MAX_LIMIT and QUERY_MAX_ACTIVITY_DAYS,
+// falling back to the hard caps
+func queryCapsFromEnv(getenv func(string) string) (queryCaps, error) {
+	maxLimit, err := capFromEnv(getenv, "QUERY_MAX_LIMIT", hardMaxPageLimit)
+	if err != nil {
+		return queryCaps{}, err
+	}
+	maxActivityDays, err := capFromEnv(getenv, "QUERY_MAX_ACTIVITY_DAYS", hardMaxActivityDays)
+	if err != nil {
+		return queryCaps{}, err
+	}
+	return queryCaps{
+		maxLimit:        maxLimit,
+		maxActivityDays: maxActivityDays,
+	}, nil
+}
+
+// capFromEnv reads a cap that may only be lowered from hardCap
+func capFromEnv(getenv func(string) string, name string, hardCap int) (int, error) {
+	v := getenv(name)
+	if v == "" {
+		return hardCap, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 || n > hardCap {
+		return 0, fmt.Errorf("invalid %s %q: must be between 1 and %d", name, v, hardCap)
+	}
+	return n, nil
+}
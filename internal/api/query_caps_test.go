@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// extremeValues are thrown at every numeric query parameter, next to
+// random ones
+var extremeValues = []string{
+	"0", "-1", "1", "199", "200", "201", "1000", "100000", "2147483648",
+	"9223372036854775807", "99999999999999999999", "-9223372036854775808",
+	"1e9", "5.5", "0x10", " 5", "abc", "",
+}
+
+// paramValues returns the extreme values and n random ones up to 1e12
+func paramValues(rng *rand.Rand, n int) []string {
+	values := append([]string{}, extremeValues...)
+	for range n {
+		values = append(values, strconv.FormatInt(rng.Int63n(1e12)-1e6, 10), strconv.Itoa(rng.Intn(1000)))
+	}
+	return values
+}
+
+// withParam adds param=value to the query of path
+func withParam(path, param, value string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + param + "=" + url.QueryEscape(value)
+}
+
+func TestQueryCapsBoundEveryListEndpoint(t *testing.T) {
+	for name, maxLimit := range map[string]int{"hard caps": hardMaxPageLimit, "tightened": 20} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			t.Setenv("QUERY_MAX_LIMIT", strconv.Itoa(maxLimit))
+			t.Setenv("QUERY_MAX_ACTIVITY_DAYS", "30")
+			ts := newTestServer(t)
+			alice := ts.newUser(t, "alice@example.com")
+			chirps := make([]database.Chirp, 0, 2*hardMaxPageLimit)
+			for i := range 2 * hardMaxPageLimit {
+				chirps = append(chirps, database.Chirp{Body: fmt.Sprintf("chirp %d", i)})
+			}
+			if _, err := ts.store.ImportChirps(alice.ID, chirps); err != nil {
+				t.Fatal(err)
+			}
+			list := decode[struct {
+				ID int `json:"id"`
+			}](t, ts.request(t, "POST", "/api/lists", map[string]string{"name": "mine"}, bearer(alice.Token)...).expect(t, http.StatusCreated))
+			ts.request(t, "POST", fmt.Sprintf("/api/lists/%d/members/%d", list.ID, alice.ID), nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+
+			// capped is where a numeric parameter is capped and what the 400 says
+			type capped struct {
+				max     int
+				message string
+			}
+			limit := capped{maxLimit, fmt.Sprintf("limit must be at most %d", maxLimit)}
+			endpoints := []struct {
+				path   string
+				params map[string]*capped // nil for parameters without a cap
+				page   bool               // responds with a ListEnvelope of chirps
+			}{
+				{"/api/chirps?envelope=true", map[string]*capped{"limit": &limit, "offset": nil, "since_id": nil, "author_id": nil}, true},
+				{"/api/chirps", map[string]*capped{"limit": &limit, "offset": nil}, false},
+				{fmt.Sprintf("/api/lists/%d/chirps", list.ID), map[string]*capped{"limit": &limit, "offset": nil}, true},
+				{fmt.Sprintf("/api/users/%d/activity", alice.ID), map[string]*capped{"days": {30, "days must be at most 30"}}, false},
+				{"/api/chirps/1/qr.png", map[string]*capped{"size": nil}, false},
+			}
+			rng := rand.New(rand.NewSource(1))
+			for _, endpoint := range endpoints {
+				for param, cap := range endpoint.params {
+					for _, value := range paramValues(rng, 20) {
+						path := withParam(endpoint.path, param, value)
+						resp := ts.request(t, "GET", path, nil)
+						switch resp.status {
+						case http.StatusOK:
+						case http.StatusBadRequest:
+							continue
+						default:
+							t.Fatalf("GET %s = %d: %s", path, resp.status, resp.body)
+						}
+
+						n, err := strconv.Atoi(value)
+						if cap != nil && err == nil && n > cap.max {
+							t.Fatalf("GET %s = 200 above the cap of %d", path, cap.max)
+						}
+						if endpoint.page {
+							page := decode[apitypes.ListEnvelope[apitypes.Chirp]](t, resp)
+							if len(page.Items) > maxLimit || page.Limit > maxLimit {
+								t.Fatalf("GET %s returned %d chirps with limit %d, cap %d", path, len(page.Items), page.Limit, maxLimit)
+							}
+						}
+						if param == "size" {
+							img, err := png.DecodeConfig(bytes.NewReader(resp.body))
+							if err != nil || img.Width > qrMaxSize {
+								t.Fatalf("GET %s = %dpx wide, %v, want at most %d", path, img.Width, err, qrMaxSize)
+							}
+						}
+					}
+					// the 400 above the cap states it
+					if cap != nil {
+						path := withParam(endpoint.path, param, strconv.Itoa(cap.max+1))
+						if got := errorOf(t, ts.request(t, "GET", path, nil).expect(t, http.StatusBadRequest)); got != cap.message {
+							t.Fatalf("GET %s: %q, want %q", path, got, cap.message)
+						}
+					}
+				}
+			}
+
+			// search text is as long as a chirp at most
+			ts.request(t, "GET", "/api/chirps?envelope=true&q="+strings.Repeat("a", maxChirpLength), nil).expect(t, http.StatusOK)
+			resp := ts.request(t, "GET", "/api/chirps?envelope=true&q="+strings.Repeat("a", maxChirpLength+1), nil).expect(t, http.StatusBadRequest)
+			if got, want := errorOf(t, resp), fmt.Sprintf("q must be at most %d characters", maxChirpLength); got != want {
+				t.Fatalf("long q: %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestQueryCapsCanOnlyBeTightened(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"QUERY_MAX_LIMIT": strconv.Itoa(hardMaxPageLimit + 1)},
+		{"QUERY_MAX_LIMIT": "0"},
+		{"QUERY_MAX_LIMIT": "many"},
+		{"QUERY_MAX_ACTIVITY_DAYS": strconv.Itoa(hardMaxActivityDays + 1)},
+	} {
+		if _, err := queryCapsFromEnv(func(name string) string { return env[name] }); err == nil {
+			t.Errorf("%v: no error", env)
+		}
+	}
+	caps, err := queryCapsFromEnv(func(name string) string { return map[string]string{"QUERY_MAX_LIMIT": "10"}[name] })
+	if err != nil || caps.maxLimit != 10 || caps.maxActivityDays != hardMaxActivityDays {
+		t.Fatalf("caps = %+v, %v", caps, err)
+	}
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+const (
+	defaultLoginPerMinute = 5
+	defaultChirpPerMinute = 30
+	rateLimitSweep        = time.Minute
+)
+
+// tokenBucket holds the requests one client can still make. it refills
+// continuously up to the per-minute limit.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // when tokens was last refilled
+}
+
+// rateLimiter limits each client IP to perMinute requests with a token
+// bucket, so a client can burst up to the limit and then gets one request
+// every minute/perMinute. buckets are in memory and full ones are dropped
+// by a sweep, a restart starts every client over.
+type rateLimiter struct {
+	mux       *sync.Mutex
+	perMinute int
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	clock     clock.Clock
+}
+
+func newRateLimiter(perMinute int, clk clock.Clock) *rateLimiter {
+	return &rateLimiter{
+		mux:       &sync.Mutex{},
+		perMinute: perMinute,
+		buckets:   make(map[string]*tokenBucket),
+		clock:     clk,
+	}
+}
+
+// allow takes a token of client. it returns false and how long until the
+// next token when the bucket is empty.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := l.clock.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.perMinute), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		perToken := time.Minute / time.Duration(l.perMinute)
+		return false, time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// refill returns the tokens of b at now
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) float64 {
+	elapsed := now.Sub(b.last).Minutes()
+	return min(b.tokens+elapsed*float64(l.perMinute), float64(l.perMinute))
+}
+
+// sweep drops the buckets that filled up again, they are the same as no
+// bucket. it runs at most once a minute. callers hold the lock.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweep {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.perMinute) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimitFromEnv reads a per-minute limit, 0 turns the limiter off
+func rateLimitFromEnv(getenv func(string) string, name string, fallback int) (int, error) {
+	v := getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be 0 or more", name, v)
+	}
+	return n, nil
+}
+
+// newRateLimiterFromEnv returns the limiter of a per-minute limit, nil
+// when it is turned off
+func newRateLimiterFromEnv(getenv func(string) string, name string, fallback int, clk clock.Clock) (*rateLimiter, error) {
+	n, err := rateLimitFromEnv(getenv, name, fallback)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	return newRateLimiter(n, clk), nil
+}
+
+// trustProxyFromEnv reads TRUST_PROXY, which makes the rate limiters key
+// clients by the address the proxy in front of the server saw
+func trustProxyFromEnv(getenv func(string) string) bool {
+	return getenv("TRUST_PROXY") == "true"
+}
+
+// clientIP is the address rate limits are counted against. behind a
+// trusted proxy it's the last X-Forwarded-For entry, the one the proxy
+// added; entries before it come from the client and can be made up.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		forwarded := r.Header.Values("X-Forwarded-For")
+		if len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimit rejects requests over the limit of the client with 429 and a
+// Retry-After in seconds. a nil limiter lets everything through.
+func (a *apiConfig) rateLimit(l *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retry := l.allow(clientIP(r, a.trustProxy)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			respondWithError(w, http.StatusTooManyRequests, "too many requests, try again later")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+func TestLoginRateLimit(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("RATE_LIMIT_LOGIN_PER_MINUTE", "2")
+	ts := newTestServer(t)
+	ts.signup(t, "alice@example.com")
+	login := apitypes.UserRequest{Email: "alice@example.com", Password: testPassword}
+
+	// the whole minute can be used at once
+	ts.request(t, "POST", "/api/login", login).expect(t, http.StatusOK)
+	ts.request(t, "POST", "/api/login", login).expect(t, http.StatusOK)
+	resp := ts.request(t, "POST", "/api/login", login).expect(t, http.StatusTooManyRequests)
+	if got := resp.header.Get("Retry-After"); got != "31" {
+		t.Fatalf("Retry-After = %q, want 31", got)
+	}
+
+	// then one request every half minute
+	ts.clock.Advance(30*time.Second - time.Millisecond)
+	ts.request(t, "POST", "/api/login", login).expect(t, http.StatusTooManyRequests)
+	ts.clock.Advance(time.Millisecond)
+	ts.request(t, "POST", "/api/login", login).expect(t, http.StatusOK)
+	ts.request(t, "POST", "/api/login", login).expect(t, http.StatusTooManyRequests)
+
+	// other routes have their own limits
+	ts.request(t, "GET", "/api/chirps", nil).expect(t, http.StatusOK)
+}
+
+func TestChirpRateLimit(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("RATE_LIMIT_CHIRPS_PER_MINUTE", "2")
+	t.Setenv("TRUST_PROXY", "true")
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	chirp := apitypes.CreateChirpRequest{Body: "again"}
+	from := func(ip string) []string { return append(bearer(alice.Token), "X-Forwarded-For", ip) }
+
+	ts.request(t, "POST", "/api/chirps", chirp, from("203.0.113.5")...).expect(t, http.StatusCreated)
+	ts.request(t, "POST", "/api/chirps", chirp, from("203.0.113.5")...).expect(t, http.StatusCreated)
+	resp := ts.request(t, "POST", "/api/chirps", chirp, from("203.0.113.5")...).expect(t, http.StatusTooManyRequests)
+	if got := resp.header.Get("Retry-After"); got != "31" {
+		t.Fatalf("Retry-After = %q, want 31", got)
+	}
+
+	// the proxy adds the last entry, the ones before it can't dodge the limit
+	ts.request(t, "POST", "/api/chirps", chirp, from("10.0.0.1, 203.0.113.5")...).expect(t, http.StatusTooManyRequests)
+	ts.request(t, "POST", "/api/chirps", chirp, from("203.0.113.5, 198.51.100.7")...).expect(t, http.StatusCreated)
+	ts.request(t, "POST", "/api/chirps", chirp, bearer(alice.Token)...).expect(t, http.StatusCreated)
+}
+
+func TestRateLimitShared(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("RATE_LIMIT_LOGIN_PER_MINUTE", "1")
+	t.Setenv("RATE_LIMIT_CHIRPS_PER_MINUTE", "0")
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+
+	// newUser logged in, so the second login step has no token left
+	// either. a limit of 0 turns the chirp limit off
+	ts.request(t, "POST", "/api/login/totp", map[string]string{"challenge_token": "x", "code": "000000"}).expect(t, http.StatusTooManyRequests)
+	for range 5 {
+		ts.request(t, "POST", "/api/chirps", apitypes.CreateChirpRequest{Body: "unlimited"}, bearer(alice.Token)...).expect(t, http.StatusCreated)
+	}
+	if ts.api.chirpRate != nil {
+		t.Fatal("a limit of 0 still made a chirp limiter")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	l := newRateLimiter(2, clk)
+	l.allow("203.0.113.5")
+	l.allow("198.51.100.7")
+	l.allow("198.51.100.7")
+
+	// a minute on one bucket is full again and dropped, the other is fresh
+	clk.Advance(rateLimitSweep)
+	l.allow("192.0.2.1")
+	if len(l.buckets) != 1 || l.buckets["192.0.2.1"] == nil {
+		t.Fatalf("buckets after the sweep = %v, want only 192.0.2.1", l.buckets)
+	}
+	// a dropped client starts over with a full bucket
+	for range 2 {
+		if ok, _ := l.allow("198.51.100.7"); !ok {
+			t.Fatal("a swept client was refused")
+		}
+	}
+	if ok, retry := l.allow("198.51.100.7"); ok || retry != 30*time.Second {
+		t.Fatalf("allow = %v, %v, want refused for 30s", ok, retry)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remote     string
+		forwarded  []string
+		trustProxy bool
+		want       string
+	}{
+		{"remote address", "192.0.2.1:5555", nil, false, "192.0.2.1"},
+		{"ipv6 remote address", "[2001:db8::1]:5555", nil, false, "2001:db8::1"},
+		{"forwarded ignored", "192.0.2.1:5555", []string{"203.0.113.5"}, false, "192.0.2.1"},
+		{"forwarded trusted", "192.0.2.1:5555", []string{"203.0.113.5"}, true, "203.0.113.5"},
+		{"last hop", "192.0.2.1:5555", []string{"10.0.0.1, 203.0.113.5"}, true, "203.0.113.5"},
+		{"last header", "192.0.2.1:5555", []string{"10.0.0.1", "203.0.113.5"}, true, "203.0.113.5"},
+		{"empty hop", "192.0.2.1:5555", []string{"203.0.113.5, "}, true, "192.0.2.1"},
+		{"no forwarded header", "192.0.2.1:5555", nil, true, "192.0.2.1"},
+		{"no port", "192.0.2.1", nil, false, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/chirps", nil)
+		r.RemoteAddr = tt.remote
+		for _, v := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := clientIP(r, tt.trustProxy); got != tt.want {
+			t.Errorf("%s: clientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"log"
+	"net/http"
+)
+
+// readiness reports OK unless a database is out of disk space
+func (a *apiConfig) readiness(w http.ResponseWriter, r *http.Request) {
+	if a.storageDegraded() {
+		w.Header().Set("Retry-After", "1")
+		respondWithError(w, http.StatusServiceUnavailable, "storage degraded")
+		return
+	}
+	w.Header().Set("Content-Type", "")
+	w.WriteHeader(200)
+	_, err := w.Write([]byte("OK"))
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// storageDegraded reports whether any database's last write hit a full disk.
+// it clears once a write to that database succeeds.
+func (a *apiConfig) storageDegraded() bool {
+	for _, db := range []interface{ StorageDegraded() bool }{a.db, a.listDatabase, a.follows, a.syndication, a.jobs.db} {
+		if db.StorageDegraded() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+// refreshGraceWindow is how long a rotated refresh token is still answered
+// with the token that replaced it. two tabs refreshing at once send the
+// same token, and the slower one gets the new token instead of tripping
+// reuse detection. after the window a rotated token revokes its session.
+const refreshGraceWindow = 10 * time.Second
+
+// rotation is a refresh token rotated during the grace window
+type rotation struct {
+	next string
+	at   time.Time
+}
+
+// refreshGrace remembers the refresh tokens rotated in the last
+// refreshGraceWindow, in memory. refreshes hold its lock from the lookup
+// to the rotation, so a racing request sees the rotation of the one
+// before it instead of the stale token.
+type refreshGrace struct {
+	mux     *sync.Mutex
+	rotated map[string]rotation // by sha256 of the rotated token
+	clock   clock.Clock
+}
+
+func newRefreshGrace(clk clock.Clock) *refreshGrace {
+	return &refreshGrace{
+		mux:     &sync.Mutex{},
+		rotated: make(map[string]rotation),
+		clock:   clk,
+	}
+}
+
+func refreshGraceKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookup returns the token that replaced token when it was rotated inside
+// the window. callers hold the lock.
+func (g *refreshGrace) lookup(token string) (string, bool) {
+	r, ok := g.rotated[refreshGraceKey(token)]
+	if !ok || g.clock.Now().Sub(r.at) >= refreshGraceWindow {
+		return "", false
+	}
+	return r.next, true
+}
+
+// record remembers that token was rotated to next and drops the rotations
+// the window has passed. callers hold the lock.
+func (g *refreshGrace) record(token, next string) {
+	now := g.clock.Now()
+	for key, r := range g.rotated {
+		if now.Sub(r.at) >= refreshGraceWindow {
+			delete(g.rotated, key)
+		}
+	}
+	g.rotated[refreshGraceKey(token)] = rotation{next: next, at: now}
+}
+
+// holdsRefreshToken reports whether token is the current token of one of
+// the user's sessions
+func holdsRefreshToken(user database.User, token string) bool {
+	if user.RefreshToken == token {
+		return true
+	}
+	return slices.ContainsFunc(user.Sessions, func(s database.Session) bool { return s.Token == token })
+}
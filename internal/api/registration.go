@@ -0,0 +1,33 @@
+package api
+
+import (
+	"slices"
+	"strings"
+)
+
+// registrationDomainsFromEnv reads REGISTRATION_EMAIL_DOMAINS, a comma
+// separated list of the email domains allowed to sign up. empty allows all.
+func registrationDomainsFromEnv(getenv func(string) string) []string {
+	var domains []string
+	for _, d := range strings.Split(getenv("REGISTRATION_EMAIL_DOMAINS"), ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// emailDomainAllowed reports whether an account may use email.
+// only the domain part is checked so plus-addressing still passes.
+func (a *apiConfig) emailDomainAllowed(email string) bool {
+	domains := a.settings().signupDomains
+	if len(domains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	return slices.Contains(domains, strings.ToLower(email[at+1:]))
+}
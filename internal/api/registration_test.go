@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestRegistrationDomainsFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"example.com", []string{"example.com"}},
+		{" Example.COM , example.org,, ", []string{"example.com", "example.org"}},
+	}
+	for _, tt := range tests {
+		got := registrationDomainsFromEnv(func(string) string { return tt.value })
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("registrationDomainsFromEnv(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRegistrationAllowlist(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("REGISTRATION_EMAIL_DOMAINS", "example.com, Example.org")
+	ts := newTestServer(t)
+
+	tests := []struct {
+		email   string
+		allowed bool
+	}{
+		{"alice@example.com", true},
+		{"bob@EXAMPLE.ORG", true},
+		{"carol+chirpy@example.com", true},
+		{"dave@example.net", false},
+		{"erin@sub.example.com", false},
+		{"frank@example.com.evil.example", false},
+		{"example.com@evil.example", false},
+	}
+	for _, tt := range tests {
+		resp := ts.request(t, "POST", "/api/users", apitypes.UserRequest{Email: tt.email, Password: testPassword})
+		if tt.allowed {
+			resp.expect(t, http.StatusCreated)
+			continue
+		}
+		resp.expect(t, http.StatusForbidden)
+		if got := decode[apitypes.ErrorResponse](t, resp).ErrorCode; got != apitypes.ErrorCodeEmailDomainNotAllowed {
+			t.Errorf("%s: error_code = %q, want %q", tt.email, got, apitypes.ErrorCodeEmailDomainNotAllowed)
+		}
+	}
+	if _, err := ts.store.GetUserByEmail("dave@example.net"); err == nil {
+		t.Fatal("a refused signup made an account")
+	}
+
+	// an update can't move the account out of the allowlist
+	alice := ts.login(t, "alice@example.com")
+	resp := ts.request(t, "PUT", "/api/users", apitypes.UserRequest{Email: "alice@example.net", Password: testPassword}, bearer(alice.Token)...).expect(t, http.StatusForbidden)
+	if got := decode[apitypes.ErrorResponse](t, resp).ErrorCode; got != apitypes.ErrorCodeEmailDomainNotAllowed {
+		t.Fatalf("update error_code = %q", got)
+	}
+	ts.request(t, "PUT", "/api/users", apitypes.UserRequest{Email: "alice+new@example.org", Password: testPassword}, bearer(alice.Token)...).expect(t, http.StatusOK)
+
+	// nor can an oauth login make one
+	provider := ts.withFakeOAuth()
+	provider.identities["outside"] = oauthIdentity{ProviderUserID: "1", Email: "grace@example.net"}
+	provider.identities["inside"] = oauthIdentity{ProviderUserID: "2", Email: "heidi@example.com"}
+	resp = ts.oauthCallback(t, "fake", ts.oauthState(t, "fake"), "outside").expect(t, http.StatusForbidden)
+	if got := decode[apitypes.ErrorResponse](t, resp).ErrorCode; got != apitypes.ErrorCodeEmailDomainNotAllowed {
+		t.Fatalf("oauth error_code = %q", got)
+	}
+	ts.oauthCallback(t, "fake", ts.oauthState(t, "fake"), "inside").expect(t, http.StatusOK)
+}
+
+func TestRegistrationOpenByDefault(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	ts.signup(t, "anyone@anywhere.example")
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// unmatchedRoute is the route of requests no pattern matched
+const unmatchedRoute = "unmatched"
+
+// SetupLogging makes every log line go through slog, as JSON when
+// LOG_FORMAT=json and as text otherwise
+func SetupLogging() {
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, nil)
+	if os.Getenv("LOG_FORMAT") == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, nil)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// statusRecorder remembers the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the real writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// routeStats is what one route answered since the server started
+type routeStats struct {
+	Requests        int64         `json:"requests"`
+	ByStatus        map[int]int64 `json:"by_status"`
+	DurationSeconds float64       `json:"duration_seconds"` // summed over every request
+}
+
+// routeMetrics counts the requests of each route pattern by status
+type routeMetrics struct {
+	mux    *sync.Mutex
+	routes map[string]*routeStats
+}
+
+func newRouteMetrics() *routeMetrics {
+	return &routeMetrics{
+		mux:    &sync.Mutex{},
+		routes: make(map[string]*routeStats),
+	}
+}
+
+func (m *routeMetrics) observe(route string, status int, duration time.Duration) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	stats, ok := m.routes[route]
+	if !ok {
+		stats = &routeStats{ByStatus: make(map[int]int64)}
+		m.routes[route] = stats
+	}
+	stats.Requests++
+	stats.ByStatus[status]++
+	stats.DurationSeconds += duration.Seconds()
+}
+
+// snapshot returns a copy of the stats of every route that was requested
+func (m *routeMetrics) snapshot() map[string]routeStats {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	routes := make(map[string]routeStats, len(m.routes))
+	for route, stats := range m.routes {
+		byStatus := make(map[int]int64, len(stats.ByStatus))
+		for code, n := range stats.ByStatus {
+			byStatus[code] = n
+		}
+		routes[route] = routeStats{Requests: stats.Requests, ByStatus: byStatus, DurationSeconds: stats.DurationSeconds}
+	}
+	return routes
+}
+
+// writePrometheus writes the request counters and durations per route
+func (m *routeMetrics) writePrometheus(w io.Writer) {
+	routes := m.snapshot()
+	names := make([]string, 0, len(routes))
+	for route := range routes {
+		names = append(names, route)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "# HELP chirpy_http_requests_total Requests answered per route and status code.")
+	fmt.Fprintln(w, "# TYPE chirpy_http_requests_total counter")
+	for _, route := range names {
+		codes := make([]int, 0, len(routes[route].ByStatus))
+		for code := range routes[route].ByStatus {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "chirpy_http_requests_total{route=%q,code=\"%d\"} %d\n", route, code, routes[route].ByStatus[code])
+		}
+	}
+	fmt.Fprintln(w, "# HELP chirpy_http_request_duration_seconds_total Time spent answering requests per route.")
+	fmt.Fprintln(w, "# TYPE chirpy_http_request_duration_seconds_total counter")
+	for _, route := range names {
+		fmt.Fprintf(w, "chirpy_http_request_duration_seconds_total{route=%q} %g\n", route, routes[route].DurationSeconds)
+	}
+}
+
+// middlewareRequestLog logs every request with the pattern mux routes it
+// to, and counts it in the route metrics. it wraps everything else, so
+// shed requests and CORS preflights are logged too.
+func (a *apiConfig) middlewareRequestLog(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = unmatchedRoute
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		duration := time.Since(start)
+
+		a.routeHits.observe(route, rec.status, duration)
+		slog.Info("request",
+			"method", r.Method,
+			"route", route,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", float64(duration.Microseconds())/1000,
+		)
+	})
+}
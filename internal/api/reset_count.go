@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// resetTargets is what each POST /admin/reset/{target} clears, in order.
+// users take every collection that refers to them along.
+var resetTargets = map[string][]string{
+	"metrics": {"metrics"},
+	"chirps":  {"chirps"},
+	"users":   {"chirps", "lists", "follows", "syndication sources", "users"},
+	"all":     {"metrics", "chirps", "lists", "follows", "syndication sources", "users"},
+}
+
+// resetResponse is returned by the reset endpoints
+type resetResponse struct {
+	Reset    string             `json:"reset"`
+	Removed  map[string]int     `json:"removed"`
+	Warnings []apitypes.Warning `json:"warnings,omitempty"`
+}
+
+// legacyResetTarget is what POST /api/reset clears, everything with ?full=true
+func legacyResetTarget(r *http.Request) string {
+	if r.URL.Query().Get("full") == "true" {
+		return "all"
+	}
+	return "metrics"
+}
+
+// describeReset tells the admin what reset is about to do
+func (cfg *apiConfig) describeReset(r *http.Request) (string, error) {
+	return cfg.describeCollections(resetTargets[legacyResetTarget(r)])
+}
+
+// describeCollections tells the admin how many records resetting the named
+// collections removes, as counted now, e.g. "this will delete 1,204 chirps"
+func (cfg *apiConfig) describeCollections(names []string) (string, error) {
+	var resets, deletes []string
+	for _, name := range names {
+		if name == "metrics" {
+			resets = append(resets, countOf(int(cfg.fileserverHits.Load()), "fileserver hits"))
+			continue
+		}
+		n, err := cfg.countCollection(name)
+		if err != nil {
+			return "", err
+		}
+		deletes = append(deletes, countOf(n, name))
+	}
+	var parts []string
+	if len(resets) > 0 {
+		parts = append(parts, "reset "+joinWithAnd(resets))
+	}
+	if len(deletes) > 0 {
+		parts = append(parts, "delete "+joinWithAnd(deletes))
+	}
+	return "this will " + strings.Join(parts, " and "), nil
+}
+
+// countCollection is how many records resetting the named collection
+// removes, deleted chirps waiting for the purge included
+func (cfg *apiConfig) countCollection(name string) (int, error) {
+	var stats database.CollectionStats
+	var err error
+	switch name {
+	case "chirps":
+		stats, err = cfg.chirpyDatabase.ChirpStats()
+	case "lists":
+		stats, err = cfg.listDatabase.ListStats()
+	case "follows":
+		stats.Records, err = cfg.follows.CountFollows()
+	case "syndication sources":
+		stats, err = cfg.syndication.SyndicationStats()
+	case "users":
+		stats, err = cfg.db.UserStats()
+	}
+	return stats.Records, err
+}
+
+// countOf formats n of plural with thousands separators, in the singular
+// for 1
+func countOf(n int, plural string) string {
+	if n == 1 {
+		return "1 " + strings.TrimSuffix(plural, "s")
+	}
+	digits := strconv.Itoa(n)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	return b.String() + " " + plural
+}
+
+// joinWithAnd joins items as "a, b and c"
+func joinWithAnd(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
+
+// POST /api/reset
+// legacyReset is the old dev-only reset of the metrics, or of everything
+// with ?full=true. it is deprecated in favor of POST /admin/reset/{target}.
+func (cfg *apiConfig) legacyReset(w http.ResponseWriter, r *http.Request) {
+	warning, ok := cfg.deprecated(w, "api.reset")
+	if !ok {
+		return
+	}
+	target := legacyResetTarget(r)
+	removed, err := cfg.resetCollections(resetTargets[target])
+	log.Printf("audit: %s reset %s through /api/reset: %v", r.RemoteAddr, target, removed)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	respondWithReset(w, resetResponse{Reset: target, Removed: removed, Warnings: []apitypes.Warning{warning}})
+}
+
+// resetRoute is the handler of POST /admin/reset/{target}, behind a
+// two-step confirmation
+func (cfg *apiConfig) resetRoute(target string) http.HandlerFunc {
+	describe := func(r *http.Request) (string, error) {
+		return cfg.describeCollections(resetTargets[target])
+	}
+	return cfg.requireConfirmation("reset-"+target, describe, func(w http.ResponseWriter, r *http.Request) {
+		removed, err := cfg.resetCollections(resetTargets[target])
+		log.Printf("audit: admin %s reset %s: %v", r.RemoteAddr, target, removed)
+		if err != nil {
+			respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		respondWithReset(w, resetResponse{Reset: target, Removed: removed})
+	})
+}
+
+// resetCollections clears the named collections in order and returns how
+// many records each lost. it stops at the first failure.
+func (cfg *apiConfig) resetCollections(names []string) (map[string]int, error) {
+	removed := make(map[string]int, len(names))
+	for _, name := range names {
+		var n int
+		var err error
+		switch name {
+		case "metrics":
+			n = int(cfg.fileserverHits.Swap(0))
+		case "chirps":
+			n, err = cfg.chirpyDatabase.ResetChirps()
+		case "lists":
+			n, err = cfg.listDatabase.ResetLists()
+		case "follows":
+			n, err = cfg.follows.ResetFollows()
+		case "syndication sources":
+			n, err = cfg.syndication.ResetSyndicationSources()
+		case "users":
+			n, err = cfg.db.ResetUsers()
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed[name] = n
+	}
+	return removed, nil
+}
+
+func respondWithReset(w http.ResponseWriter, reset resetResponse) {
+	resp, err := json.Marshal(reset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
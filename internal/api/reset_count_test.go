@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLegacyReset(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	ts.postChirp(t, alice.Token, "still here")
+	ts.request(t, "GET", "/app/", nil)
+
+	// only POST, and only on the dev platform or with the admin token
+	ts.request(t, "GET", "/api/reset", nil).expect(t, http.StatusMethodNotAllowed)
+	ts.request(t, "GET", "/api/reset", nil, asAdmin()...).expect(t, http.StatusMethodNotAllowed)
+	ts.request(t, "POST", "/api/reset", nil).expect(t, http.StatusForbidden)
+	ts.request(t, "POST", "/api/reset", nil, bearer(alice.Token)...).expect(t, http.StatusForbidden)
+	if hits := ts.api.fileserverHits.Load(); hits != 1 {
+		t.Fatalf("fileserver hits after refused resets = %d, want 1", hits)
+	}
+
+	// the metrics reset leaves the data alone
+	t.Setenv("PLATFORM", "dev")
+	reset := decode[resetResponse](t, ts.confirmed(t, "POST", "/api/reset", nil).expect(t, http.StatusOK))
+	if reset.Reset != "metrics" || ts.api.fileserverHits.Load() != 0 {
+		t.Fatalf("reset = %+v with %d hits left", reset, ts.api.fileserverHits.Load())
+	}
+	ts.request(t, "GET", chirpPath(1), nil).expect(t, http.StatusOK)
+
+	// a token for the metrics reset can't be replayed as a full one
+	first := decode[confirmChallenge](t, ts.request(t, "POST", "/api/reset", nil).expect(t, http.StatusPreconditionRequired))
+	ts.request(t, "POST", "/api/reset?full=true", nil, "X-Confirm-Token", first.ConfirmToken).expect(t, http.StatusForbidden)
+
+	reset = decode[resetResponse](t, ts.confirmed(t, "POST", "/api/reset?full=true", nil).expect(t, http.StatusOK))
+	if reset.Reset != "all" || reset.Removed["chirps"] != 1 || reset.Removed["users"] != 1 {
+		t.Fatalf("full reset = %+v, want 1 chirp and 1 user removed", reset)
+	}
+	ts.request(t, "GET", chirpPath(1), nil).expect(t, http.StatusNotFound)
+	ts.request(t, "POST", "/api/login", map[string]string{"email": "alice@example.com", "password": testPassword}).expect(t, http.StatusUnauthorized)
+}
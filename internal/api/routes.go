@@ -0,0 +1,251 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// authLevel is the authentication a route requires before its handler runs
+type authLevel int
+
+const (
+	authPublic      authLevel = iota // anyone
+	authUser                         // a valid JWT in Authorization: Bearer
+	authRed                          // a valid access token of a Chirpy Red user
+	authAdmin                        // Authorization: ApiKey <ADMIN_TOKEN>
+	authDevPlatform                  // only when PLATFORM=dev, or with the admin token
+	authPublicRead                   // anyone, or Authorization: PublicKey <key> within its origins and quota
+)
+
+const defaultMaxBodyBytes = 1 << 20 // 1 MiB
+
+// route is one entry of the route table
+type route struct {
+	method       string // empty matches every method
+	pattern      string
+	handler      http.HandlerFunc
+	auth         authLevel
+	maxBodyBytes int64        // 0 means defaultMaxBodyBytes
+	rate         *rateLimiter // per client IP, nil means unlimited
+	largeJSON    bool         // skips guardJSON, for admin uploads of whole databases
+	maxInFlight  int          // requests of the route handled at once, 0 means only the global limit
+}
+
+// routes is the table of every API route and what it requires.
+// handlers still check token types themselves, the table only guarantees
+// nothing reaches a protected handler without the right credentials.
+func (a *apiConfig) routes() []route {
+	return []route{
+		{method: "GET", pattern: "/admin/metrics", handler: a.metrics, auth: authAdmin},
+		{method: "GET", pattern: "/admin/metrics.json", handler: a.metricsJSON, auth: authAdmin},
+		{method: "GET", pattern: "/admin/jobs", handler: a.listJobs, auth: authAdmin},
+		{method: "GET", pattern: "/admin/storage", handler: a.storageUsage, auth: authAdmin},
+		{method: "GET", pattern: "/admin/webhooks/incoming", handler: a.incomingWebhooks, auth: authAdmin},
+		{method: "GET", pattern: "/admin/sessions", handler: a.listSessions, auth: authAdmin},
+		{method: "GET", pattern: "/admin/migration/status", handler: a.migrationStatus, auth: authAdmin},
+		{method: "GET", pattern: "/admin/export", handler: a.exportBackup, auth: authAdmin, maxInFlight: 2},
+		{method: "POST", pattern: "/admin/import", handler: a.requireConfirmation("import", a.describeImport, a.importBackup), auth: authAdmin, maxBodyBytes: 256 << 20, largeJSON: true, maxInFlight: 1},
+		{method: "POST", pattern: "/admin/revoke/{userID}", handler: a.forceRevokeToken, auth: authAdmin},
+		{method: "POST", pattern: "/admin/compact", handler: a.compact, auth: authAdmin},
+		{method: "POST", pattern: "/admin/config/reload", handler: a.reloadConfig, auth: authAdmin},
+		{method: "POST", pattern: "/admin/public_keys", handler: a.createPublicKey, auth: authAdmin, maxBodyBytes: 4 << 10},
+		{method: "GET", pattern: "/admin/public_keys", handler: a.listPublicKeys, auth: authAdmin},
+		{method: "DELETE", pattern: "/admin/public_keys/{id}", handler: a.revokePublicKey, auth: authAdmin},
+		{method: "POST", pattern: "/admin/syndication", handler: a.createSyndicationSource, auth: authAdmin, maxBodyBytes: 4 << 10},
+		{method: "GET", pattern: "/admin/syndication", handler: a.listSyndicationSources, auth: authAdmin},
+		{method: "DELETE", pattern: "/admin/syndication/{id}", handler: a.deleteSyndicationSource, auth: authAdmin},
+		{method: "POST", pattern: "/admin/reset/metrics", handler: a.resetRoute("metrics"), auth: authAdmin},
+		{method: "POST", pattern: "/admin/reset/chirps", handler: a.resetRoute("chirps"), auth: authAdmin},
+		{method: "POST", pattern: "/admin/reset/users", handler: a.resetRoute("users"), auth: authAdmin},
+		{method: "POST", pattern: "/admin/reset/all", handler: a.resetRoute("all"), auth: authAdmin},
+		// deprecated, only resets the metrics
+		{method: "POST", pattern: "/api/reset", handler: a.requireConfirmation("reset-metrics", a.describeReset, a.legacyReset), auth: authDevPlatform},
+
+		{method: "GET", pattern: "/api/healthz", handler: a.readiness, auth: authPublic},
+
+		{method: "POST", pattern: "/api/chirps", handler: a.validateChirpy, auth: authUser, maxBodyBytes: 4 << 10, rate: a.chirpRate},
+		{method: "POST", pattern: "/api/chirps/validate", handler: a.dryRunChirp, auth: authUser, maxBodyBytes: 4 << 10},
+		{method: "GET", pattern: "/api/chirps", handler: a.getChirpy, auth: authPublicRead},
+		{method: "GET", pattern: "/api/chirps/{chirpID}", handler: a.getChirpyFromID, auth: authPublicRead},
+		{method: "GET", pattern: "/api/chirps/{chirpID}/qr.png", handler: a.chirpQRCode, auth: authPublic},
+		{method: "PUT", pattern: "/api/chirps/{chirpID}", handler: a.updateChirpy, auth: authUser, maxBodyBytes: 4 << 10},
+		{method: "DELETE", pattern: "/api/chirps/{chirpID}", handler: a.deleteChirpyFromID, auth: authUser},
+		{method: "POST", pattern: "/api/chirps/{chirpID}/undelete", handler: a.undeleteChirpy, auth: authUser, maxBodyBytes: 4 << 10},
+		{method: "POST", pattern: "/api/chirps/{chirpID}/bump", handler: a.bumpChirpy, auth: authUser},
+		{method: "GET", pattern: "/api/feed", handler: a.getFeed, auth: authUser},
+		{method: "POST", pattern: "/api/hashtags/{tag}/follow", handler: a.followHashtag, auth: authUser},
+		{method: "DELETE", pattern: "/api/hashtags/{tag}/follow", handler: a.unfollowHashtag, auth: authUser},
+
+		{method: "POST", pattern: "/api/users", handler: a.createUser, auth: authPublic, maxBodyBytes: 4 << 10},
+		{method: "PUT", pattern: "/api/users", handler: a.updateUser, auth: authUser, maxBodyBytes: 4 << 10},
+		{method: "DELETE", pattern: "/api/users", handler: a.deleteUser, auth: authUser},
+		{method: "GET", pattern: "/api/users", handler: a.getUsers, auth: authPublic},
+		{method: "GET", pattern: "/api/users/{userID}", handler: a.getUserFromID, auth: authPublic},
+		{method: "GET", pattern: "/api/users/{id}/activity", handler: a.userActivity, auth: authPublic},
+		{method: "GET", pattern: "/api/users/me/limits", handler: a.userLimits, auth: authUser},
+		{method: "POST", pattern: "/api/login", handler: a.userValidation, auth: authPublic, maxBodyBytes: 4 << 10, rate: a.loginRate},
+		{method: "POST", pattern: "/api/users/me/link", handler: a.linkAccount, auth: authUser, maxBodyBytes: 4 << 10},
+		{method: "DELETE", pattern: "/api/users/me/link/{userID}", handler: a.unlinkAccount, auth: authUser},
+		{method: "POST", pattern: "/api/token/exchange", handler: a.exchangeToken, auth: authUser, maxBodyBytes: 4 << 10},
+		{method: "POST", pattern: "/api/users/me/totp/setup", handler: a.setupTOTP, auth: authUser},
+		{method: "POST", pattern: "/api/users/me/totp/verify", handler: a.verifyTOTPSetup, auth: authUser, maxBodyBytes: 4 << 10},
+		{method: "POST", pattern: "/api/users/me/totp/disable", handler: a.disableTOTP, auth: authUser, maxBodyBytes: 4 << 10},
+		{method: "POST", pattern: "/api/login/totp", handler: a.loginTOTP, auth: authPublic, maxBodyBytes: 4 << 10, rate: a.loginRate},
+		{method: "GET", pattern: "/api/oauth/{provider}/login", handler: a.oauthLogin, auth: authPublic},
+		{method: "GET", pattern: "/api/oauth/{provider}/callback", handler: a.oauthCallback, auth: authPublic},
+		{method: "POST", pattern: "/api/users/me/identities/{provider}/link", handler: a.linkIdentity, auth: authUser},
+		{method: "DELETE", pattern: "/api/users/me/identities/{provider}/unlink", handler: a.unlinkIdentity, auth: authUser},
+		{method: "PUT", pattern: "/api/users/me/privacy", handler: a.updatePrivacy, auth: authUser, maxBodyBytes: 4 << 10},
+		{method: "GET", pattern: "/api/users/{userID}/followers", handler: a.getFollowers, auth: authPublic},
+		{method: "GET", pattern: "/api/users/{userID}/following", handler: a.getFollowing, auth: authPublic},
+		{method: "POST", pattern: "/api/users/{userID}/follow", handler: a.followUser, auth: authUser},
+		{method: "DELETE", pattern: "/api/users/{userID}/follow", handler: a.unfollowUser, auth: authUser},
+		{method: "GET", pattern: "/api/users/me/follows/export", handler: a.exportFollows, auth: authUser},
+		{method: "POST", pattern: "/api/users/me/follows/import", handler: a.importFollows, auth: authUser, maxBodyBytes: 256 << 10, rate: a.followImportRate},
+		{method: "GET", pattern: "/api/follow_requests", handler: a.getFollowRequests, auth: authUser},
+		{method: "POST", pattern: "/api/follow_requests/{id}/approve", handler: a.approveFollowRequest, auth: authUser},
+		{method: "POST", pattern: "/api/follow_requests/{id}/reject", handler: a.rejectFollowRequest, auth: authUser},
+		{method: "POST", pattern: "/api/lists", handler: a.createList, auth: authUser, maxBodyBytes: 4 << 10},
+		{method: "GET", pattern: "/api/users/me/lists", handler: a.getMyLists, auth: authUser},
+		{method: "POST", pattern: "/api/lists/{id}/members/{userID}", handler: a.addListMember, auth: authUser},
+		{method: "DELETE", pattern: "/api/lists/{id}/members/{userID}", handler: a.removeListMember, auth: authUser},
+		{method: "GET", pattern: "/api/lists/{id}/chirps", handler: a.getListChirps, auth: authPublicRead},
+		{method: "POST", pattern: "/api/refresh", handler: a.refreshTokenAuth, auth: authUser},
+		{method: "POST", pattern: "/api/revoke", handler: a.revokeToken, auth: authUser},
+
+		// polka authenticates with its own api key inside the handler
+		{method: "POST", pattern: "/api/polka/webhooks", handler: a.upgradeToRedChirpy, auth: authPublic, maxBodyBytes: 1 << 10},
+	}
+}
+
+// bodyLimit is the largest request body the route accepts
+func (rt route) bodyLimit() int64 {
+	if rt.maxBodyBytes == 0 {
+		return defaultMaxBodyBytes
+	}
+	return rt.maxBodyBytes
+}
+
+// routeBodyLimit looks up the body limit of a route in the table
+func (a *apiConfig) routeBodyLimit(method, pattern string) int64 {
+	for _, rt := range a.routes() {
+		if rt.method == method && rt.pattern == pattern {
+			return rt.bodyLimit()
+		}
+	}
+	return defaultMaxBodyBytes
+}
+
+// registerRoutes applies the middleware stack each entry asks for and adds it to mux
+func (a *apiConfig) registerRoutes(mux *http.ServeMux, routes []route) {
+	for _, rt := range routes {
+		pattern := rt.pattern
+		if rt.method != "" {
+			pattern = rt.method + " " + rt.pattern
+		}
+		inner := rt.handler
+		if !rt.largeJSON {
+			inner = guardJSON(inner)
+		}
+		limited := limitBody(rt.bodyLimit(), inner)
+		if rt.maxInFlight > 0 {
+			limiter := newRequestLimiter(rt.maxInFlight)
+			a.routeLimiters[pattern] = limiter
+			limited = limiter.limit(limited).ServeHTTP
+		}
+		handler := a.inFlight.track(pattern, a.rateLimit(rt.rate, a.requireAuth(rt.auth, limited)))
+		mux.Handle(pattern, handler)
+	}
+}
+
+// limitBody caps the size of the request body
+func limitBody(maxBytes int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next(w, r)
+	}
+}
+
+// requireAuth rejects requests that don't meet level.
+// missing or invalid credentials are 401, valid but insufficient ones are 403.
+// public keys only ever pass authPublicRead routes.
+func (a *apiConfig) requireAuth(level authLevel, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), publicKeyScheme); ok {
+			if level != authPublicRead {
+				respondWithError(w, http.StatusForbidden, "public keys only grant read access to public chirps")
+				return
+			}
+			if !a.checkPublicKey(w, r, key) {
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		switch level {
+		case authUser:
+			token, err := a.validateToken(r)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			// tokens of deleted accounts are still signed and unexpired
+			if claims, ok := token.Claims.(*CustomClaims); ok {
+				if _, err := a.db.GetUserByID(claims.UserID); errors.Is(err, database.ErrUserNotFound) {
+					respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+					return
+				}
+			}
+		case authRed:
+			token, err := a.validateToken(r)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			claims, ok := token.Claims.(*CustomClaims)
+			if !ok || !isAcessToken(claims.Issuer) {
+				respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+			user, err := a.db.GetUserByID(claims.UserID)
+			if err != nil || !user.IsChirpyRed {
+				respondWithError(w, http.StatusForbidden, "Chirpy Red required")
+				return
+			}
+		case authAdmin:
+			if isAdminRequest(r) {
+				break
+			}
+			// a logged in user is known but not allowed
+			if _, err := a.validateToken(r); err == nil {
+				respondWithError(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		case authDevPlatform:
+			if os.Getenv("PLATFORM") != "dev" && !isAdminRequest(r) {
+				respondWithError(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// isAdminRequest reports whether the request carries the admin token.
+// an unset ADMIN_TOKEN disables admin access entirely.
+func isAdminRequest(r *http.Request) bool {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey ")
+	if adminToken == "" || !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(adminToken)) == 1
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+// wildcard matches the path wildcards of a route pattern
+var wildcard = regexp.MustCompile(`\{[^}]+\}`)
+
+func TestRoutesRejectMissingCredentials(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	user := ts.newUser(t, "alice@example.com")
+
+	for _, rt := range ts.api.routes() {
+		if rt.auth == authPublic || rt.auth == authPublicRead {
+			continue
+		}
+		path := wildcard.ReplaceAllString(rt.pattern, "1")
+		t.Run(rt.method+" "+rt.pattern, func(t *testing.T) {
+			// the dev platform routes are off outside PLATFORM=dev, so
+			// they are forbidden rather than unauthenticated
+			want := http.StatusUnauthorized
+			if rt.auth == authDevPlatform {
+				want = http.StatusForbidden
+			}
+			ts.request(t, rt.method, path, nil).expect(t, want)
+			ts.request(t, rt.method, path, nil, "Authorization", "Bearer not-a-token").expect(t, want)
+			ts.request(t, rt.method, path, nil, "Authorization", "ApiKey wrong-"+testAdminToken).expect(t, want)
+
+			// a public key never passes a protected route, and a user
+			// is known but not allowed past the admin and red ones
+			ts.request(t, rt.method, path, nil, "Authorization", publicKeyScheme+"some-key").expect(t, http.StatusForbidden)
+			if rt.auth != authUser {
+				ts.request(t, rt.method, path, nil, bearer(user.Token)...).expect(t, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestRouteConcurrencyLimit(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+
+	// take every slot of the export limiter as if its requests were running
+	limiter := ts.api.routeLimiters["GET /admin/export"]
+	if limiter == nil {
+		t.Fatal("GET /admin/export has no concurrency limit")
+	}
+	for range cap(limiter.slots) {
+		limiter.slots <- struct{}{}
+	}
+
+	resp := ts.request(t, "GET", "/admin/export", nil, asAdmin()...).expect(t, http.StatusServiceUnavailable)
+	if got := resp.header.Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	// the other routes only share the global limit
+	ts.request(t, "GET", "/admin/metrics.json", nil, asAdmin()...).expect(t, http.StatusOK)
+	// credentials are checked before a slot is taken
+	ts.request(t, "GET", "/admin/export", nil).expect(t, http.StatusUnauthorized)
+
+	metrics := decode[struct {
+		RouteLimits map[string]routeLimit `json:"route_limits"`
+	}](t, ts.request(t, "GET", "/admin/metrics.json", nil, asAdmin()...).expect(t, http.StatusOK))
+	if got := metrics.RouteLimits["GET /admin/export"].Shed; got != 1 {
+		t.Errorf("shed exports = %d, want 1", got)
+	}
+
+	for range cap(limiter.slots) {
+		<-limiter.slots
+	}
+	ts.request(t, "GET", "/admin/export", nil, asAdmin()...).expect(t, http.StatusOK)
+}
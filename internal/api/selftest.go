@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/friday1602/chirpy/client"
+	"github.com/friday1602/chirpy/database"
+)
+
+// RunSelfTest runs a smoke test of the main flows against a second server
+// backed by a temporary database, so the real database is never touched.
+// it goes through the client package so the client is checked against
+// the handlers on every run.
+// it writes a pass/fail report to out and reports whether every step passed.
+func RunSelfTest(out io.Writer) bool {
+	dir, err := os.MkdirTemp("", "chirpy-self-test")
+	if err != nil {
+		fmt.Fprintf(out, "FAIL setup: %v\n", err)
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := selfTestStore(dir)
+	if err != nil {
+		fmt.Fprintf(out, "FAIL setup: %v\n", err)
+		return false
+	}
+	handler, err := NewServer(store, Config{DataDir: dir})
+	if err != nil {
+		fmt.Fprintf(out, "FAIL setup: %v\n", err)
+		return false
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	c := client.New(srv.URL)
+	ctx := context.Background()
+	email, password := "self-test@chirpy.invalid", "self-test-password"
+	var chirp client.Chirp
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"create user", func() error {
+			_, err := c.CreateUser(ctx, email, password)
+			return err
+		}},
+		{"login", func() error {
+			_, err := c.Login(ctx, email, password)
+			return err
+		}},
+		{"post chirp", func() error {
+			var err error
+			chirp, err = c.CreateChirp(ctx, client.CreateChirpRequest{Body: "self-test chirp"})
+			return err
+		}},
+		{"fetch chirp", func() error {
+			_, err := c.GetChirp(ctx, chirp.ID)
+			return err
+		}},
+		{"edit chirp", func() error {
+			_, err := c.UpdateChirp(ctx, chirp.ID, "self-test chirp, edited")
+			return err
+		}},
+		{"delete chirp", func() error {
+			_, err := c.DeleteChirp(ctx, chirp.ID)
+			return err
+		}},
+		{"refresh token", func() error {
+			return c.Refresh(ctx)
+		}},
+		{"revoke token", func() error {
+			return c.Revoke(ctx)
+		}},
+		{"refresh after revoke", func() error {
+			var apiErr *client.Error
+			err := c.Refresh(ctx)
+			if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+				return nil
+			}
+			return fmt.Errorf("got %v, want status 401", err)
+		}},
+	}
+
+	passed := true
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			fmt.Fprintf(out, "FAIL %s: %v\n", step.name, err)
+			passed = false
+			break
+		}
+		fmt.Fprintf(out, "PASS %s\n", step.name)
+	}
+
+	if passed {
+		fmt.Fprintln(out, "self-test passed")
+	} else {
+		fmt.Fprintln(out, "self-test failed")
+	}
+	return passed
+}
+
+// selfTestStore opens a store of the configured backend in dir. it doesn't
+// go through OpenStore, whose absolute sqlite: paths would point back at
+// the real database.
+func selfTestStore(dir string) (database.Store, error) {
+	if os.Getenv("DATABASE_URL") != "" {
+		return database.OpenSQLite(filepath.Join(dir, "selftest.db"))
+	}
+	return openDB(dir)
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunSelfTestLeavesConfiguredDatabaseAlone(t *testing.T) {
+	for name, url := range map[string]string{
+		"json":   "",
+		"sqlite": "sqlite:" + filepath.Join(t.TempDir(), "chirpy.db"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			t.Setenv("DATABASE_URL", url)
+			// the json store is database.json of the working directory
+			dir := t.TempDir()
+			chdir(t, dir)
+
+			var out bytes.Buffer
+			if !RunSelfTest(&out) {
+				t.Fatalf("self-test failed:\n%s", out.String())
+			}
+			report := out.String()
+			for _, step := range []string{"create user", "login", "post chirp", "fetch chirp", "edit chirp",
+				"delete chirp", "refresh token", "revoke token", "refresh after revoke"} {
+				if !strings.Contains(report, "PASS "+step+"\n") {
+					t.Errorf("report has no PASS of %s:\n%s", step, report)
+				}
+			}
+			if !strings.HasSuffix(report, "self-test passed\n") {
+				t.Errorf("report doesn't end with the verdict:\n%s", report)
+			}
+
+			configured := filepath.Join(dir, databaseFile)
+			if url != "" {
+				configured = strings.TrimPrefix(url, "sqlite:")
+			}
+			if _, err := os.Stat(configured); !os.IsNotExist(err) {
+				t.Fatalf("self-test touched the configured database %s: %v", configured, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/clock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type apiConfig struct {
+	fileserverHits   *atomic.Int64
+	db               *instrumentedStore
+	chirpyDatabase   *instrumentedStore  // db again, chirps and users share a store
+	migration        *database.DualStore // nil unless writes go to a secondary store too
+	listDatabase     *instrumentedDB
+	follows          *instrumentedDB
+	syndication      *instrumentedDB
+	publicKeys       *instrumentedDB
+	keyUsage         *publicKeyUsage
+	dbMetrics        *dbMetrics
+	confirmations    *confirmationStore
+	undoTokens       *confirmationStore
+	loginBackoff     *loginBackoff
+	refreshGrace     *refreshGrace
+	limiter          *requestLimiter
+	routeLimiters    map[string]*requestLimiter // by pattern, for routes with their own maxInFlight
+	loginRate        *rateLimiter               // POST /api/login and /api/login/totp
+	chirpRate        *rateLimiter
+	followImportRate *rateLimiter
+	trustProxy       bool                      // key rate limits by X-Forwarded-For
+	corsOrigins      []string                  // nil allows every origin
+	config           *atomic.Pointer[settings] // read through a.settings()
+	activity         *activityCache
+	chirpCache       *chirpCache
+	purged           *atomic.Int64
+	oauthProviders   map[string]oauthProvider
+	oauthStates      *oauthStateStore
+	jobs             *Jobs
+	storageSamples   *database.DB
+	webhooks         *webhookLog
+	events           *database.Bus
+	lifecycle        lifecycle
+	shuttingDown     context.Context // done once the http server starts shutting down
+	startShutdown    context.CancelFunc
+	inFlight         *routeInFlight
+	routeHits        *routeMetrics
+	clock            clock.Clock
+	random           io.Reader // source of tokens, secrets and codes
+}
+
+type CustomClaims struct {
+	UserID int `json:"user_id"`
+	// seconds the login of a totp challenge or refresh token asked its
+	// access tokens to last, 0 for the default
+	AccessTTL int `json:"access_ttl,omitempty"`
+	// Family is the login session of a refresh token, kept when it is rotated
+	Family string `json:"family,omitempty"`
+	// LinkedFrom is the user a totp challenge of a token exchange was
+	// issued to, 0 for logins
+	LinkedFrom int `json:"linked_from,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Config is how NewServer sets up the server. the other settings are read
+// from the environment.
+type Config struct {
+	// DataDir holds the lists, jobs and the other databases besides the
+	// store, and the data dir lock. empty is the working directory.
+	DataDir string
+	// Clock and Random are what everything time or randomness dependent
+	// uses, the real clock and crypto/rand when nil
+	Clock  clock.Clock
+	Random io.Reader
+}
+
+// Server is the chirpy API. it serves every route and owns the store, the
+// other databases and the background jobs, which Run starts and stops.
+type Server struct {
+	api     *apiConfig
+	handler http.Handler
+}
+
+// NewServer wires store, the databases in cfg.DataDir and every route and
+// middleware into a Server. the store is closed when Run returns.
+func NewServer(store database.Store, cfg Config) (*Server, error) {
+	if cfg.DataDir == "" {
+		cfg.DataDir = "."
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real{}
+	}
+	if cfg.Random == nil {
+		cfg.Random = rand.Reader
+	}
+	apiCfg, handler, err := newAPIServer(store, cfg.DataDir, cfg.Clock, cfg.Random)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{api: apiCfg, handler: handler}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Run starts the jobs and serves srv until ctx is done, with a plain http
+// listener on HTTP_REDIRECT_PORT redirecting to it when srv serves https.
+// then it drains the requests and stops everything in reverse order. it
+// returns the error of a component that failed to start or of a listener
+// that stopped serving, errors while stopping are only logged.
+func (s *Server) Run(ctx context.Context, srv *http.Server) error {
+	redirectAddr, err := redirectAddrFromEnv(os.Getenv)
+	if err != nil {
+		return err
+	}
+	failed := make(chan error, 2)
+	// streams like /admin/export end when the drain starts
+	srv.RegisterOnShutdown(s.api.startShutdown)
+	s.api.lifecycle.register("http", httpComponent{srv: srv, inFlight: s.api.inFlight, failed: failed}, 10*time.Second)
+	if redirectAddr != "" && srv.TLSConfig != nil {
+		log.Printf("redirecting http on %s to https", redirectAddr)
+		s.api.lifecycle.register("http redirect", httpComponent{srv: newRedirectServer(redirectAddr, srv.Addr), inFlight: s.api.inFlight, failed: failed}, time.Second)
+	}
+	if err := s.api.lifecycle.start(ctx); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		log.Print("shutdown: started, draining requests")
+	case err = <-failed:
+		log.Printf("shutdown: started, %v", err)
+	}
+	if err := s.api.lifecycle.stop(); err != nil {
+		log.Print(err)
+	}
+	return err
+}
+
+// ReloadSettings reloads the reloadable settings like POST /admin/config/reload
+func (s *Server) ReloadSettings() error {
+	_, err := s.api.reloadSettings()
+	return err
+}
+
+// newAPIServer opens the databases in dataDir and wires them, store and
+// every route and middleware into a single handler. everything time or
+// randomness dependent uses clk and random.
+func newAPIServer(store database.Store, dataDir string, clk clock.Clock, random io.Reader) (*apiConfig, http.Handler, error) {
+	config, err := settingsFromEnv(os.Getenv)
+	if err != nil {
+		return nil, nil, err
+	}
+	maxInFlight, err := maxInFlightFromEnv(os.Getenv)
+	if err != nil {
+		return nil, nil, err
+	}
+	loginRate, err := newRateLimiterFromEnv(os.Getenv, "RATE_LIMIT_LOGIN_PER_MINUTE", defaultLoginPerMinute, clk)
+	if err != nil {
+		return nil, nil, err
+	}
+	chirpRate, err := newRateLimiterFromEnv(os.Getenv, "RATE_LIMIT_CHIRPS_PER_MINUTE", defaultChirpPerMinute, clk)
+	if err != nil {
+		return nil, nil, err
+	}
+	followImportRate, err := newRateLimiterFromEnv(os.Getenv, "RATE_LIMIT_FOLLOW_IMPORTS_PER_MINUTE", defaultFollowImportPerMinute, clk)
+	if err != nil {
+		return nil, nil, err
+	}
+	corsOrigins, err := corsOriginsFromEnv(os.Getenv)
+	if err != nil {
+		return nil, nil, err
+	}
+	chirpCacheSize, err := chirpCacheSizeFromEnv(os.Getenv)
+	if err != nil {
+		return nil, nil, err
+	}
+	mux := http.NewServeMux()
+	apiCfg := &apiConfig{
+		clock:            clk,
+		random:           random,
+		confirmations:    newConfirmationStore(confirmationTTL, clk, random),
+		undoTokens:       newConfirmationStore(undoDeleteWindow, clk, random),
+		loginBackoff:     newLoginBackoff(clk),
+		refreshGrace:     newRefreshGrace(clk),
+		limiter:          newRequestLimiter(maxInFlight),
+		routeLimiters:    make(map[string]*requestLimiter),
+		loginRate:        loginRate,
+		chirpRate:        chirpRate,
+		followImportRate: followImportRate,
+		trustProxy:       trustProxyFromEnv(os.Getenv),
+		corsOrigins:      corsOrigins,
+		config:           &atomic.Pointer[settings]{},
+		activity:         newActivityCache(clk),
+		chirpCache:       newChirpCache(chirpCacheSize),
+		purged:           &atomic.Int64{},
+		fileserverHits:   &atomic.Int64{},
+		dbMetrics:        newDBMetrics(),
+		oauthProviders:   make(map[string]oauthProvider),
+		oauthStates:      newOAuthStateStore(clk, random),
+		webhooks:         newWebhookLog(),
+		inFlight:         newRouteInFlight(),
+		routeHits:        newRouteMetrics(),
+		keyUsage:         newPublicKeyUsage(clk),
+	}
+	apiCfg.shuttingDown, apiCfg.startShutdown = context.WithCancel(context.Background())
+	apiCfg.config.Store(config)
+	if github, ok := newGitHubProviderFromEnv(); ok {
+		apiCfg.oauthProviders["github"] = github
+	}
+	fileServer := http.FileServer(http.Dir("./app"))
+	mux.Handle("/app/", apiCfg.middlewareMetricsInc(http.StripPrefix("/app", fileServer)))
+
+	listDB, err := database.NewListDB(filepath.Join(dataDir, "listDatabase.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	followDB, err := database.NewFollowDB(filepath.Join(dataDir, "followDatabase.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	syndicationDB, err := database.NewSyndicationDB(filepath.Join(dataDir, "syndicationDatabase.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	jobDB, err := database.NewJobDB(filepath.Join(dataDir, "jobDatabase.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	storageDB, err := database.NewStorageDB(filepath.Join(dataDir, "storageDatabase.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKeyDB, err := database.NewPublicKeyDB(filepath.Join(dataDir, "publicKeyDatabase.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	storageDB.SetFileObserver(func(op string, duration time.Duration, size int, err error) {
+		apiCfg.dbMetrics.observe("storage", op, duration, err)
+	})
+	jobDB.SetFileObserver(func(op string, duration time.Duration, size int, err error) {
+		apiCfg.dbMetrics.observe("jobs", op, duration, err)
+	})
+	apiCfg.events = apiCfg.subscribeEvents()
+	store.SetClock(clk)
+	store.SetEventBus(apiCfg.events)
+	for _, db := range []*database.DB{listDB, followDB, syndicationDB, publicKeyDB, jobDB, storageDB} {
+		db.SetClock(clk)
+		db.SetEventBus(apiCfg.events)
+	}
+	err = setDBIndent(listDB, followDB, syndicationDB, publicKeyDB, jobDB, storageDB)
+	if err != nil {
+		return nil, nil, err
+	}
+	apiCfg.jobs = newJobs(jobDB, 4, clk)
+	apiCfg.storageSamples = storageDB
+	apiCfg.migration, _ = store.(*database.DualStore)
+	// users and chirps share one store and its wrapper
+	apiCfg.db = newInstrumentedStore("database", store, apiCfg.dbMetrics)
+	apiCfg.chirpyDatabase = apiCfg.db
+	apiCfg.listDatabase = newInstrumentedDB("lists", listDB, apiCfg.dbMetrics)
+	apiCfg.follows = newInstrumentedDB("follows", followDB, apiCfg.dbMetrics)
+	apiCfg.syndication = newInstrumentedDB("syndication", syndicationDB, apiCfg.dbMetrics)
+	apiCfg.publicKeys = newInstrumentedDB("public_keys", publicKeyDB, apiCfg.dbMetrics)
+	// started in this order by lifecycle.start, stopped in reverse
+	apiCfg.lifecycle.register("data dir lock", &dirLockComponent{dir: dataDir}, time.Second)
+	apiCfg.lifecycle.register("databases", databasesComponent{store, listDB, followDB, syndicationDB, publicKeyDB, jobDB, storageDB}, 5*time.Second)
+	apiCfg.lifecycle.register("jobs", apiCfg.jobs, 10*time.Second)
+	apiCfg.jobs.Register(syndicationPullJob, apiCfg.pullSyndication)
+	apiCfg.jobs.Register(purgeJob, apiCfg.purgeTombstones)
+	apiCfg.jobs.Register(storageSampleJob, apiCfg.sampleStorage)
+	err = apiCfg.jobs.EnqueueOnce(purgeJob, struct{}{}, clk.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	err = apiCfg.jobs.EnqueueOnce(storageSampleJob, struct{}{}, clk.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fileServer = http.FileServer(http.Dir("./app/assets"))
+	mux.Handle("/app/assets/", apiCfg.middlewareMetricsInc(http.StripPrefix("/app/assets", fileServer)))
+
+	apiCfg.registerRoutes(mux, apiCfg.routes())
+
+	corsMux := apiCfg.middlewareCors(apiCfg.limiter.middlewareLoadShedding(mux))
+	return apiCfg, apiCfg.middlewareRequestLog(mux, corsMux), nil
+}
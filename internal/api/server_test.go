@@ -0,0 +1,356 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+const (
+	testJWTSecret  = "test-jwt-secret"
+	testAdminToken = "test-admin-token"
+	testPolkaKey   = "test-polka-key"
+	testPassword   = "correct horse battery staple"
+)
+
+// testEpoch is where the fake clock of every test server starts
+var testEpoch = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// testServer is a Server on a json database in a temp dir, served by
+// httptest. its clock only moves when the test advances it.
+type testServer struct {
+	*httptest.Server
+	api   *apiConfig
+	store database.Store
+	dir   string
+	clock *clock.Fake
+}
+
+// setTestEnv sets the environment every test server reads, clearing the
+// settings a developer's shell might have exported. tests set their own
+// on top after calling it.
+func setTestEnv(t testing.TB) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ADMIN_TOKEN", testAdminToken)
+	t.Setenv("POLKA_KEY", testPolkaKey)
+	// the defaults of 5 logins a minute would trip tests that log in a lot
+	t.Setenv("RATE_LIMIT_LOGIN_PER_MINUTE", "1000")
+	t.Setenv("RATE_LIMIT_CHIRPS_PER_MINUTE", "1000")
+	t.Setenv("RATE_LIMIT_FOLLOW_IMPORTS_PER_MINUTE", "1000")
+	for _, key := range []string{"JWT_SECRETS", "JWT_EXPIRY_SECONDS", "DATABASE_URL", "DATABASE_SECONDARY_URL",
+		"DUAL_WRITE_VERIFY_EVERY", "PLATFORM", "CORS_ALLOWED_ORIGINS", "TRUST_PROXY", "MAX_INFLIGHT_REQUESTS",
+		"TLS_CERT_FILE", "TLS_KEY_FILE", "REGISTRATION_EMAIL_DOMAINS"} {
+		t.Setenv(key, "")
+	}
+}
+
+// chdir makes dir the working directory for the rest of the test, for the
+// commands that open the database files of the working directory
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// newTestServer starts a server on a new json database
+func newTestServer(t testing.TB) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.NewDB(filepath.Join(dir, databaseFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestServerWithStore(t, db, dir)
+}
+
+// newSQLiteTestServer starts a server on a new SQLite database
+func newSQLiteTestServer(t testing.TB) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := database.OpenSQLite(filepath.Join(dir, "chirpy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestServerWithStore(t, store, dir)
+}
+
+// newTestServerWithStore starts a server on store with the other databases
+// in dir. it reads the environment, callers run setTestEnv first.
+func newTestServerWithStore(t testing.TB, store database.Store, dir string) *testServer {
+	t.Helper()
+	return newTestServerWithConfig(t, store, Config{DataDir: dir})
+}
+
+// newTestServerWithConfig starts a server on store configured with cfg,
+// always on a fake clock at testEpoch
+func newTestServerWithConfig(t testing.TB, store database.Store, cfg Config) *testServer {
+	t.Helper()
+	clk := clock.NewFake(testEpoch)
+	cfg.Clock = clk
+	srv, err := NewServer(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &testServer{Server: httptest.NewServer(srv), api: srv.api, store: store, dir: cfg.DataDir, clock: clk}
+	t.Cleanup(func() {
+		ts.Close()
+		store.Close()
+	})
+	return ts
+}
+
+// testResponse is a response read to the end
+type testResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// request sends a request to the test server. body is sent as it is when
+// it's a string or []byte and as json otherwise, headers are key value
+// pairs. bodies get Content-Type: application/json unless headers set one.
+func (ts *testServer) request(t *testing.T, method, path string, body any, headers ...string) testResponse {
+	t.Helper()
+	var reqBody io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reqBody = bytes.NewBufferString(b)
+	case []byte:
+		reqBody = bytes.NewReader(b)
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testResponse{status: resp.StatusCode, header: resp.Header, body: b}
+}
+
+// bearer is the header pair of an access or refresh token
+func bearer(token string) []string {
+	return []string{"Authorization", "Bearer " + token}
+}
+
+// asAdmin is the header pair of the admin token
+func asAdmin() []string {
+	return []string{"Authorization", "ApiKey " + testAdminToken}
+}
+
+// expect fails the test unless resp has status
+func (resp testResponse) expect(t *testing.T, status int) testResponse {
+	t.Helper()
+	if resp.status != status {
+		t.Fatalf("got status %d, want %d: %s", resp.status, status, resp.body)
+	}
+	return resp
+}
+
+// decode unmarshals the body of resp into a T
+func decode[T any](t *testing.T, resp testResponse) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(resp.body, &v); err != nil {
+		t.Fatalf("decoding %s: %v", resp.body, err)
+	}
+	return v
+}
+
+// errorOf returns the error message of a json error response
+func errorOf(t *testing.T, resp testResponse) string {
+	t.Helper()
+	return decode[apitypes.ErrorResponse](t, resp).Error
+}
+
+// signup creates a user and returns its ID
+func (ts *testServer) signup(t *testing.T, email string) int {
+	t.Helper()
+	resp := ts.request(t, "POST", "/api/users", apitypes.UserRequest{Email: email, Password: testPassword})
+	return decode[apitypes.UserResponse](t, resp.expect(t, http.StatusCreated)).ID
+}
+
+// login logs in a user created with signup
+func (ts *testServer) login(t *testing.T, email string) apitypes.LoginResponse {
+	t.Helper()
+	resp := ts.request(t, "POST", "/api/login", apitypes.UserRequest{Email: email, Password: testPassword})
+	return decode[apitypes.LoginResponse](t, resp.expect(t, http.StatusOK))
+}
+
+// newUser signs up and logs in a user
+func (ts *testServer) newUser(t *testing.T, email string) apitypes.LoginResponse {
+	t.Helper()
+	ts.signup(t, email)
+	return ts.login(t, email)
+}
+
+// postChirp creates a chirp with the access token
+func (ts *testServer) postChirp(t *testing.T, token, body string) apitypes.Chirp {
+	t.Helper()
+	resp := ts.request(t, "POST", "/api/chirps", apitypes.CreateChirpRequest{Body: body}, bearer(token)...)
+	return decode[apitypes.Chirp](t, resp.expect(t, http.StatusCreated))
+}
+
+// upgrade makes the user Chirpy Red through the polka webhook
+func (ts *testServer) upgrade(t *testing.T, userID int) {
+	t.Helper()
+	body := map[string]any{"event": "user.upgraded", "timestamp": ts.clock.Now().Unix(), "data": map[string]int{"user_id": userID}}
+	ts.request(t, "POST", "/api/polka/webhooks", body, "Authorization", "ApiKey "+testPolkaKey).expect(t, http.StatusNoContent)
+}
+
+// chirpPath is the path of a chirp
+func chirpPath(ID int) string {
+	return "/api/chirps/" + strconv.Itoa(ID)
+}
+
+// confirmed sends an action behind requireConfirmation twice, the second
+// time with the token the first call returned
+func (ts *testServer) confirmed(t *testing.T, method, path string, body any, headers ...string) testResponse {
+	t.Helper()
+	first := ts.request(t, method, path, body, headers...).expect(t, http.StatusPreconditionRequired)
+	token := decode[struct {
+		ConfirmToken string `json:"confirm_token"`
+	}](t, first).ConfirmToken
+	return ts.request(t, method, path, body, append(headers, "X-Confirm-Token", token)...)
+}
+
+func TestServerFullFlow(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := start(t)
+
+			userID := ts.signup(t, "flow@example.com")
+			login := ts.login(t, "flow@example.com")
+			if login.ID != userID || login.Token == "" || login.RefreshToken == "" {
+				t.Fatalf("login = %+v, want tokens of user %d", login, userID)
+			}
+
+			chirp := ts.postChirp(t, login.Token, "hello from the flow test")
+			if chirp.AuthorID != userID {
+				t.Fatalf("author_id = %d, want %d", chirp.AuthorID, userID)
+			}
+			got := decode[apitypes.Chirp](t, ts.request(t, "GET", chirpPath(chirp.ID), nil).expect(t, http.StatusOK))
+			if got.Body != chirp.Body {
+				t.Fatalf("body = %q, want %q", got.Body, chirp.Body)
+			}
+
+			refreshed := decode[apitypes.RefreshResponse](t,
+				ts.request(t, "POST", "/api/refresh", nil, bearer(login.RefreshToken)...).expect(t, http.StatusOK))
+			if refreshed.RefreshToken == login.RefreshToken {
+				t.Fatal("refresh returned the refresh token it was sent")
+			}
+
+			ts.request(t, "POST", "/api/revoke", nil, bearer(refreshed.RefreshToken)...).expect(t, http.StatusOK)
+			ts.request(t, "POST", "/api/refresh", nil, bearer(refreshed.RefreshToken)...).expect(t, http.StatusUnauthorized)
+
+			// the access token outlives the revoked refresh token
+			ts.request(t, "DELETE", chirpPath(chirp.ID), nil, bearer(refreshed.Token)...).expect(t, http.StatusOK)
+			ts.request(t, "GET", chirpPath(chirp.ID), nil).expect(t, http.StatusNotFound)
+		})
+	}
+}
+
+func TestServerMiddlewareChain(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+
+	// static files are counted and bypass the api middleware
+	for range 3 {
+		ts.request(t, "GET", "/app/", nil)
+	}
+	if hits := ts.api.fileserverHits.Load(); hits != 3 {
+		t.Fatalf("fileserver hits = %d, want 3", hits)
+	}
+
+	resp := ts.request(t, "GET", "/api/healthz", nil).expect(t, http.StatusOK)
+	if got := resp.header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := resp.header.Get("Access-Control-Expose-Headers"); got == "" {
+		t.Fatal("no Access-Control-Expose-Headers on an api response")
+	}
+}
+
+func TestNewServerRejectsBadEnv(t *testing.T) {
+	tests := []struct {
+		key, value string
+	}{
+		{"MAX_INFLIGHT_REQUESTS", "0"},
+		{"RATE_LIMIT_LOGIN_PER_MINUTE", "-1"},
+		{"RATE_LIMIT_CHIRPS_PER_MINUTE", "lots"},
+		{"CORS_ALLOWED_ORIGINS", "app.example.com"},
+		{"CHIRP_CACHE_SIZE", "-5"},
+		{"DB_JSON_INDENT", "9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			setTestEnv(t)
+			t.Setenv(tt.key, tt.value)
+			dir := t.TempDir()
+			store, err := database.NewDB(filepath.Join(dir, databaseFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			// a bad value is an error for the caller, not the end of the process
+			_, err = NewServer(store, Config{DataDir: dir})
+			if err == nil || !strings.Contains(err.Error(), tt.key) {
+				t.Fatalf("NewServer with %s=%q: %v, want an error naming it", tt.key, tt.value, err)
+			}
+		})
+	}
+}
+
+func TestRunRejectsBadRedirectPort(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("HTTP_REDIRECT_PORT", "80000")
+	ts := newTestServer(t)
+	srv := &Server{api: ts.api}
+	err := srv.Run(context.Background(), &http.Server{Addr: "127.0.0.1:0"})
+	if err == nil || !strings.Contains(err.Error(), "HTTP_REDIRECT_PORT") {
+		t.Fatalf("Run = %v, want an error naming HTTP_REDIRECT_PORT", err)
+	}
+}
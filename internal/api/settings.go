@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// settings is the configuration that can be reloaded while the server runs.
+// a reload swaps the whole value, so a handler that reads a.settings() once
+// sees one consistent snapshot for the request.
+type settings struct {
+	caps          queryCaps
+	defaultLang   string
+	signupDomains []string      // email domains allowed to sign up, empty allows every domain
+	retention     time.Duration // how long deleted chirps are kept
+	accessTTL     time.Duration // lifetime of access tokens
+}
+
+// reloadableEnv are the variables settingsFromEnv reads. any other variable
+// of .env is only read at startup.
+var reloadableEnv = []string{
+	"QUERY_MAX_LIMIT",
+	"QUERY_MAX_ACTIVITY_DAYS",
+	"DEFAULT_LANG",
+	"REGISTRATION_EMAIL_DOMAINS",
+	"TOMBSTONE_RETENTION_DAYS",
+	"JWT_EXPIRY_SECONDS",
+}
+
+// settingsFromEnv reads and validates the reloadable settings through getenv
+func settingsFromEnv(getenv func(string) string) (*settings, error) {
+	caps, err := queryCapsFromEnv(getenv)
+	if err != nil {
+		return nil, err
+	}
+	defaultLang, err := defaultLangFromEnv(getenv)
+	if err != nil {
+		return nil, err
+	}
+	retention, err := tombstoneRetentionFromEnv(getenv)
+	if err != nil {
+		return nil, err
+	}
+	accessTTL, err := accessTokenTTLFromEnv(getenv)
+	if err != nil {
+		return nil, err
+	}
+	return &settings{
+		caps:          caps,
+		defaultLang:   defaultLang,
+		signupDomains: registrationDomainsFromEnv(getenv),
+		retention:     retention,
+		accessTTL:     accessTTL,
+	}, nil
+}
+
+// values renders the settings by variable name, for the reload diff
+func (s *settings) values() map[string]string {
+	return map[string]string{
+		"QUERY_MAX_LIMIT":            strconv.Itoa(s.caps.maxLimit),
+		"QUERY_MAX_ACTIVITY_DAYS":    strconv.Itoa(s.caps.maxActivityDays),
+		"DEFAULT_LANG":               s.defaultLang,
+		"REGISTRATION_EMAIL_DOMAINS": strings.Join(s.signupDomains, ","),
+		"TOMBSTONE_RETENTION_DAYS":   strconv.Itoa(int(s.retention / (24 * time.Hour))),
+		"JWT_EXPIRY_SECONDS":         strconv.Itoa(int(s.accessTTL.Seconds())),
+	}
+}
+
+// settings returns the current settings
+func (a *apiConfig) settings() *settings {
+	return a.config.Load()
+}
+
+// reloadReport is what a reload changed and which variables it ignored
+type reloadReport struct {
+	Changed  []string `json:"changed"`
+	Rejected []string `json:"rejected"` // only read at startup, a restart applies them
+}
+
+// reloadSettings re-reads .env and swaps in the reloadable settings it sets,
+// falling back to the environment for the ones it doesn't. invalid values
+// fail the whole reload and the current settings stay.
+func (a *apiConfig) reloadSettings() (reloadReport, error) {
+	report := reloadReport{Changed: []string{}, Rejected: []string{}}
+	file, err := godotenv.Read()
+	if err != nil {
+		return report, fmt.Errorf("reading .env: %w", err)
+	}
+	next, err := settingsFromEnv(func(name string) string {
+		if v, ok := file[name]; ok {
+			return v
+		}
+		return os.Getenv(name)
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for name, v := range file {
+		if !slices.Contains(reloadableEnv, name) && os.Getenv(name) != v {
+			report.Rejected = append(report.Rejected, name)
+		}
+	}
+	sort.Strings(report.Rejected)
+	for _, name := range report.Rejected {
+		log.Printf("config reload: %s changed but needs a restart, ignored", name)
+	}
+
+	prev := a.config.Swap(next)
+	before, after := prev.values(), next.values()
+	for _, name := range reloadableEnv {
+		if before[name] != after[name] {
+			report.Changed = append(report.Changed, name)
+			log.Printf("config reload: %s %q -> %q", name, before[name], after[name])
+		}
+	}
+	return report, nil
+}
+
+// POST /admin/config/reload
+// reloadConfig does what SIGHUP does and reports the result
+func (a *apiConfig) reloadConfig(w http.ResponseWriter, r *http.Request) {
+	report, err := a.reloadSettings()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp, err := json.Marshal(report)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
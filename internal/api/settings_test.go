@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+// writeDotEnv makes env the .env of a new working directory
+func writeDotEnv(t *testing.T, env string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(env), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, dir)
+}
+
+func (ts *testServer) maxLimit(t *testing.T, token string) int {
+	t.Helper()
+	return decode[apitypes.LimitsResponse](t, ts.request(t, "GET", "/api/users/me/limits", nil, bearer(token)...).expect(t, http.StatusOK)).Query.MaxLimit
+}
+
+func TestReloadConfig(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	if got := ts.maxLimit(t, alice.Token); got != hardMaxPageLimit {
+		t.Fatalf("max limit = %d before the reload, want %d", got, hardMaxPageLimit)
+	}
+
+	// startup only variables are reported, unless they didn't change
+	writeDotEnv(t, "QUERY_MAX_LIMIT=5\nDEFAULT_LANG=de\nPORT=9999\nJWT_SECRET="+testJWTSecret+"\n")
+	ts.request(t, "POST", "/admin/config/reload", nil).expect(t, http.StatusUnauthorized)
+	ts.request(t, "POST", "/admin/config/reload", nil, bearer(alice.Token)...).expect(t, http.StatusForbidden)
+	report := decode[reloadReport](t, ts.request(t, "POST", "/admin/config/reload", nil, asAdmin()...).expect(t, http.StatusOK))
+	if !slices.Equal(report.Changed, []string{"QUERY_MAX_LIMIT", "DEFAULT_LANG"}) || !slices.Equal(report.Rejected, []string{"PORT"}) {
+		t.Fatalf("report = %+v", report)
+	}
+	if got := ts.maxLimit(t, alice.Token); got != 5 {
+		t.Fatalf("max limit = %d after the reload, want 5", got)
+	}
+	ts.request(t, "GET", "/api/chirps?limit=6", nil).expect(t, http.StatusBadRequest)
+	if chirp := ts.postChirp(t, alice.Token, "hallo"); chirp.Lang != "de" {
+		t.Fatalf("lang = %q, want the reloaded default de", chirp.Lang)
+	}
+
+	// reloading the same file changes nothing
+	report = decode[reloadReport](t, ts.request(t, "POST", "/admin/config/reload", nil, asAdmin()...).expect(t, http.StatusOK))
+	if len(report.Changed) != 0 {
+		t.Fatalf("second reload changed %v", report.Changed)
+	}
+}
+
+func TestReloadConfigInvalid(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+
+	// one bad value keeps every current setting
+	writeDotEnv(t, "QUERY_MAX_LIMIT=5\nDEFAULT_LANG=nope-not-a-lang\n")
+	ts.request(t, "POST", "/admin/config/reload", nil, asAdmin()...).expect(t, http.StatusBadRequest)
+	if got := ts.maxLimit(t, alice.Token); got != hardMaxPageLimit {
+		t.Fatalf("max limit = %d after a failed reload, want %d", got, hardMaxPageLimit)
+	}
+
+	// SIGHUP reloads through the server the same way
+	server := &Server{api: ts.api}
+	if err := server.ReloadSettings(); err == nil {
+		t.Fatal("ReloadSettings accepted an invalid DEFAULT_LANG")
+	}
+	writeDotEnv(t, "QUERY_MAX_LIMIT=5\n")
+	if err := server.ReloadSettings(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ts.maxLimit(t, alice.Token); got != 5 {
+		t.Fatalf("max limit = %d after ReloadSettings, want 5", got)
+	}
+
+	// without a .env there is nothing to reload
+	chdir(t, t.TempDir())
+	ts.request(t, "POST", "/admin/config/reload", nil, asAdmin()...).expect(t, http.StatusBadRequest)
+	if got := ts.maxLimit(t, alice.Token); got != 5 {
+		t.Fatalf("max limit = %d after a reload without .env, want 5", got)
+	}
+}
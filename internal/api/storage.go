@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+const (
+	storageSampleJob       = "storage.sample"
+	storageSampleInterval  = time.Hour
+	storageSampleRetention = 8 * 24 * time.Hour // the 7d growth plus a day of slack
+)
+
+// storageCollection is one collection of GET /admin/storage. growth is how
+// many bytes the file gained over the window, null until samples reach back
+// that far.
+type storageCollection struct {
+	database.CollectionStats
+	Growth24h *int64 `json:"growth_24h_bytes"`
+	Growth7d  *int64 `json:"growth_7d_bytes"`
+}
+
+// storageStats reads the usage of every collection by name. each
+// database holds its read lock only while it loads its file.
+func (a *apiConfig) storageStats() (map[string]database.CollectionStats, error) {
+	collectors := map[string]func() (database.CollectionStats, error){
+		"users":             a.db.UserStats,
+		"chirps":            a.chirpyDatabase.ChirpStats,
+		a.listDatabase.name: a.listDatabase.ListStats,
+		a.syndication.name:  a.syndication.SyndicationStats,
+		"jobs":              a.jobs.db.JobStats,
+	}
+	stats := make(map[string]database.CollectionStats, len(collectors))
+	for name, collect := range collectors {
+		s, err := collect()
+		if err != nil {
+			return nil, err
+		}
+		stats[name] = s
+	}
+	return stats, nil
+}
+
+// sampleStorage is the job that records the file size of every collection
+// for the growth numbers and schedules the next run an hour later
+func (a *apiConfig) sampleStorage(ctx context.Context, payload json.RawMessage) error {
+	stats, err := a.storageStats()
+	if err != nil {
+		return err
+	}
+	now := a.clock.Now()
+	sample := database.StorageSample{At: now, Bytes: make(map[string]int64, len(stats))}
+	for name, s := range stats {
+		sample.Bytes[name] = s.Bytes
+	}
+	err = a.storageSamples.RecordStorageSample(sample, now.Add(-storageSampleRetention))
+	if err != nil {
+		return err
+	}
+	return a.jobs.EnqueueOnce(storageSampleJob, struct{}{}, now.Add(storageSampleInterval))
+}
+
+// growthSince is how much each collection grew since the newest sample taken
+// at or before since. collections without such a sample are left out.
+func growthSince(samples []database.StorageSample, since time.Time, stats map[string]database.CollectionStats) map[string]int64 {
+	var baseline *database.StorageSample
+	for i := range samples {
+		if samples[i].At.After(since) {
+			break
+		}
+		baseline = &samples[i]
+	}
+	growth := make(map[string]int64)
+	if baseline == nil {
+		return growth
+	}
+	for name, s := range stats {
+		if before, ok := baseline.Bytes[name]; ok {
+			growth[name] = s.Bytes - before
+		}
+	}
+	return growth
+}
+
+// GET /admin/storage
+// storageUsage shows per-collection record counts, file sizes and growth,
+// to size up the json files
+func (a *apiConfig) storageUsage(w http.ResponseWriter, r *http.Request) {
+	stats, err := a.storageStats()
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	samples, err := a.storageSamples.GetStorageSamples()
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	now := a.clock.Now()
+	day := growthSince(samples, now.Add(-24*time.Hour), stats)
+	week := growthSince(samples, now.Add(-7*24*time.Hour), stats)
+	collections := make(map[string]storageCollection, len(stats))
+	for name, s := range stats {
+		c := storageCollection{CollectionStats: s}
+		if g, ok := day[name]; ok {
+			c.Growth24h = &g
+		}
+		if g, ok := week[name]; ok {
+			c.Growth7d = &g
+		}
+		collections[name] = c
+	}
+
+	resp, err := json.Marshal(struct {
+		Collections map[string]storageCollection `json:"collections"`
+		Samples     int                          `json:"samples"`
+	}{
+		Collections: collections,
+		Samples:     len(samples),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/friday1602/chirpy/client"
+	"github.com/friday1602/chirpy/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	syndicationPullJob     = "syndication.pull"
+	syndicationInterval    = 5 * time.Minute
+	syndicationMaxBackoff  = 6 * time.Hour
+	syndicationHTTPTimeout = 10 * time.Second
+)
+
+type syndicationPayload struct {
+	SourceID int `json:"source_id"`
+}
+
+// POST /admin/syndication
+// createSyndicationSource starts mirroring a remote author's chirps to a
+// local shadow account named <remote author id>@<remote host>
+func (a *apiConfig) createSyndicationSource(w http.ResponseWriter, r *http.Request) {
+	sourceReq := struct {
+		BaseURL        string `json:"base_url"`
+		RemoteAuthorID int    `json:"remote_author_id"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&sourceReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding json")
+		return
+	}
+	baseURL, err := url.Parse(strings.TrimRight(sourceReq.BaseURL, "/"))
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		respondWithError(w, http.StatusBadRequest, "base_url must be an http or https url")
+		return
+	}
+	if sourceReq.RemoteAuthorID <= 0 {
+		respondWithError(w, http.StatusBadRequest, "invalid remote_author_id")
+		return
+	}
+
+	shadow, err := a.shadowUser(fmt.Sprintf("%d@%s", sourceReq.RemoteAuthorID, baseURL.Host))
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Error creating shadow account")
+		return
+	}
+	source, err := a.syndication.CreateSyndicationSource(baseURL.String(), sourceReq.RemoteAuthorID, shadow.ID)
+	if errors.Is(err, database.ErrSourceExists) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	err = a.jobs.Enqueue(syndicationPullJob, syndicationPayload{SourceID: source.ID}, source.NextSyncAt)
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	resp, err := json.Marshal(source)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(resp)
+}
+
+// GET /admin/syndication
+// listSyndicationSources shows every source with its sync state
+func (a *apiConfig) listSyndicationSources(w http.ResponseWriter, r *http.Request) {
+	sources, err := a.syndication.GetSyndicationSources()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	resp, err := json.Marshal(sources)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// DELETE /admin/syndication/{id}
+// deleteSyndicationSource stops syncing. mirrored chirps are kept unless
+// ?remove_chirps=true, which deletes them.
+func (a *apiConfig) deleteSyndicationSource(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid source id")
+		return
+	}
+	source, err := a.syndication.DeleteSyndicationSource(sourceID)
+	if errors.Is(err, database.ErrSourceNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	removed := 0
+	if r.URL.Query().Get("remove_chirps") == "true" {
+		removed, err = a.chirpyDatabase.DeleteChirpsByAuthor(source.LocalUserID)
+		if err != nil {
+			respondWithDBError(w, err, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+	}
+
+	resp, err := json.Marshal(struct {
+		RemovedChirps int `json:"removed_chirps"`
+	}{
+		RemovedChirps: removed,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
+
+// shadowUser returns the local account for a remote author, creating it with
+// a random password nobody knows the first time
+func (a *apiConfig) shadowUser(email string) (database.User, error) {
+	user, err := a.db.GetUserByEmail(email)
+	if !errors.Is(err, database.ErrUserNotFound) {
+		return user, err
+	}
+
+	random := make([]byte, 32)
+	if _, err := io.ReadFull(a.random, random); err != nil {
+		return database.User{}, err
+	}
+	password, err := bcrypt.GenerateFromPassword(random, bcrypt.DefaultCost)
+	if err != nil {
+		return database.User{}, err
+	}
+	return a.db.CreateUser(email, password)
+}
+
+// pullSyndication is the job that syncs one source and schedules the next
+// sync. failed syncs back off exponentially instead of failing the job, so a
+// source that is down for a while doesn't end up on the dead-letter list.
+// a deleted source ends the chain.
+func (a *apiConfig) pullSyndication(ctx context.Context, payload json.RawMessage) error {
+	var p syndicationPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	source, err := a.syndication.GetSyndicationSource(p.SourceID)
+	if errors.Is(err, database.ErrSourceNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// a job repeated after a crash finds the source already rescheduled
+	if a.clock.Now().Before(source.NextSyncAt) {
+		return nil
+	}
+
+	lastRemoteID, syncErr := a.syncSource(ctx, source)
+	source, err = a.syndication.UpdateSyndicationSource(source.ID, func(source *database.SyndicationSource) {
+		source.LastRemoteID = lastRemoteID
+		next := syndicationInterval
+		if syncErr != nil {
+			source.Failures++
+			source.LastError = syncErr.Error()
+			next = min(syndicationInterval<<source.Failures, syndicationMaxBackoff)
+		} else {
+			source.Failures = 0
+			source.LastError = ""
+		}
+		source.NextSyncAt = a.clock.Now().Add(next)
+	})
+	if errors.Is(err, database.ErrSourceNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return a.jobs.Enqueue(syndicationPullJob, p, source.NextSyncAt)
+}
+
+// syncSource mirrors the source's chirps newer than LastRemoteID, a page at
+// a time, and returns the highest remote ID mirrored
+func (a *apiConfig) syncSource(ctx context.Context, source database.SyndicationSource) (int, error) {
+	c := client.New(source.BaseURL)
+	c.HTTPClient = &http.Client{Timeout: syndicationHTTPTimeout}
+
+	lastRemoteID := source.LastRemoteID
+	for {
+		page, err := c.ListChirps(ctx, client.ListChirpsOptions{
+			AuthorID: source.RemoteAuthorID,
+			SinceID:  lastRemoteID,
+		})
+		if err != nil {
+			return lastRemoteID, err
+		}
+
+		pageLast := lastRemoteID
+		chirps := make([]database.Chirp, 0, len(page.Items))
+		for _, remote := range page.Items {
+			if remote.ID <= lastRemoteID {
+				continue
+			}
+			lang, err := parseChirpLang(remote.Lang)
+			if err != nil {
+				lang = ""
+			}
+			chirps = append(chirps, database.Chirp{
+				Body:      remote.Body,
+				Lang:      lang,
+				CreatedAt: remote.CreatedAt,
+				SourceURL: source.BaseURL + "/api/chirps/" + strconv.Itoa(remote.ID),
+			})
+			pageLast = max(pageLast, remote.ID)
+		}
+		if _, err := a.chirpyDatabase.MirrorChirps(source.LocalUserID, chirps); err != nil {
+			return lastRemoteID, err
+		}
+		// stop on the last page and on remotes that ignore since_id
+		if page.NextCursor == "" || pageLast == lastRemoteID {
+			return pageLast, nil
+		}
+		lastRemoteID = pageLast
+	}
+}
@@ -0,0 +1,6 @@
+window.YTD.tweet.part0 = [ {
+  "id_str" : "900000000000000001",
+  "text" : "an old tweet from before full_text",
+  "created_at" : "Mon Aug 21 18:30:00 +0000 2017",
+  "retweeted" : false
+} ]
@@ -0,0 +1,18 @@
+﻿window.YTD.tweets.part0 = [
+  {
+    "tweet" : {
+      "id_str" : "1700000000000000001",
+      "full_text" : "hello &amp; welcome to my archive",
+      "created_at" : "Sat Sep 09 10:00:00 +0000 2023",
+      "retweeted" : false
+    }
+  },
+  {
+    "tweet" : {
+      "id_str" : "1700000000000000002",
+      "full_text" : "RT @someone: a tweet of someone else",
+      "created_at" : "Fri Sep 08 10:00:00 +0000 2023",
+      "retweeted" : false
+    }
+  }
+]
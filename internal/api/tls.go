@@ -0,0 +1,78 @@
+package api
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// hstsMaxAge is how long browsers stick to https once they saw it, a year
+const hstsMaxAge = 365 * 24 * 60 * 60
+
+// tlsConfigFromEnv loads the certificate of TLS_CERT_FILE and TLS_KEY_FILE.
+// it returns nil when neither is set, the server then speaks plain http.
+// the pair is loaded here so a bad certificate fails the boot.
+func tlsConfigFromEnv() (*tls.Config, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// redirectAddrFromEnv reads HTTP_REDIRECT_PORT, the plain http port that
+// redirects to https. empty when there's no redirect listener.
+func redirectAddrFromEnv(getenv func(string) string) (string, error) {
+	v := getenv("HTTP_REDIRECT_PORT")
+	if v == "" {
+		return "", nil
+	}
+	port, err := strconv.Atoi(v)
+	if err != nil || port < 1 || port > 65535 {
+		return "", fmt.Errorf("invalid HTTP_REDIRECT_PORT %q: must be between 1 and 65535", v)
+	}
+	return net.JoinHostPort(getenv("HOST"), strconv.Itoa(port)), nil
+}
+
+// middlewareHSTS tells browsers to only use https for the host from now on
+func middlewareHSTS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", "max-age="+strconv.Itoa(hstsMaxAge))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newRedirectServer answers every request on addr with a 301 to the same
+// URL on the https server listening on httpsAddr
+func newRedirectServer(addr, httpsAddr string) *http.Server {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.Trim(r.Host, "[]")
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // ipv6
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	return &http.Server{
+		Addr:              addr,
+		Handler:           redirect,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	totpPeriod          = 30 // seconds per step
+	totpDigits          = 6
+	totpBackupCodeCount = 10
+	totpChallengeTTL    = 5 * time.Minute
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret returns a random base32 secret for an authenticator app
+func generateTOTPSecret(random io.Reader) (string, error) {
+	b := make([]byte, 20)
+	if _, err := io.ReadFull(random, b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpURL builds the otpauth:// url authenticator apps scan as a QR code
+func totpURL(email, secret string) string {
+	label := url.PathEscape("Chirpy:" + email)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", "Chirpy")
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// totpCode computes the RFC 6238 code of secret for the given step
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// verifyTOTP checks code against the current step and one step either side.
+// every candidate is compared in constant time.
+func verifyTOTP(secret, code string, now time.Time) bool {
+	step := now.Unix() / totpPeriod
+	valid := 0
+	for _, s := range []int64{step - 1, step, step + 1} {
+		expected, err := totpCode(secret, s)
+		if err != nil {
+			return false
+		}
+		valid |= subtle.ConstantTimeCompare([]byte(expected), []byte(code))
+	}
+	return valid == 1
+}
+
+// generateBackupCodes returns plain backup codes for the user and their
+// hashes for storage. codes are random so a plain sha256 is enough.
+func generateBackupCodes(random io.Reader) ([]string, []string, error) {
+	codes := make([]string, 0, totpBackupCodeCount)
+	hashes := make([]string, 0, totpBackupCodeCount)
+	for range totpBackupCodeCount {
+		b := make([]byte, 5)
+		if _, err := io.ReadFull(random, b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes = append(codes, code)
+		hashes = append(hashes, hashBackupCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func hashBackupCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTOTPChallenge creates the short-lived token exchanged at POST /api/login/totp.
+// its issuer keeps it from being accepted as an access or refresh token.
+// linkedFrom is the user exchanging a token for userID, 0 for a login.
+func (a *apiConfig) issueTOTPChallenge(userID int, accessTTL time.Duration, linkedFrom int) (string, error) {
+	claims := CustomClaims{
+		UserID:     userID,
+		AccessTTL:  int(accessTTL.Seconds()),
+		LinkedFrom: linkedFrom,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy-totp",
+			IssuedAt:  jwt.NewNumericDate(a.clock.Now()),
+			ExpiresAt: jwt.NewNumericDate(a.clock.Now().Add(totpChallengeTTL)),
+			Subject:   strconv.Itoa(userID),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(signingSecret())
+}
+
+// check if token's type is totp challenge token
+func isTOTPChallenge(claimsIssuer string) bool {
+	return claimsIssuer == "chirpy-totp"
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+	"github.com/friday1602/chirpy/internal/clock"
+)
+
+// rfc6238Secret is the sha1 key of the RFC 6238 test vectors, base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// the RFC 6238 sha1 vectors cut to 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil || got != tt.want {
+			t.Errorf("totpCode at %d = %q, %v, want %q", tt.unix, got, err, tt.want)
+		}
+	}
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("totpCode accepted a secret that isn't base32")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	// 1111111111 is 1s into step 37037037, the code is of that step
+	clk := clock.NewFake(time.Unix(1111111111, 0))
+	code, err := totpCode(rfc6238Secret, clk.Now().Unix()/totpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		skew   time.Duration // how far the server clock is from the code's
+		want   bool
+	}{
+		{"same step", rfc6238Secret, code, 0, true},
+		{"end of the step", rfc6238Secret, code, 28 * time.Second, true},
+		{"one step behind", rfc6238Secret, code, 30 * time.Second, true},
+		{"one step ahead", rfc6238Secret, code, -30 * time.Second, true},
+		{"two steps behind", rfc6238Secret, code, 60 * time.Second, false},
+		{"two steps ahead", rfc6238Secret, code, -61 * time.Second, false},
+		{"wrong code", rfc6238Secret, "123456", 0, false},
+		{"empty code", rfc6238Secret, "", 0, false},
+		{"code with a space", rfc6238Secret, code + " ", 0, false},
+		{"other secret", "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP", code, 0, false},
+		{"invalid secret", "not base32!", code, 0, false},
+	}
+	for _, tt := range tests {
+		if got := verifyTOTP(tt.secret, tt.code, clk.Now().Add(tt.skew)); got != tt.want {
+			t.Errorf("%s: verifyTOTP = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPURL(t *testing.T) {
+	got := totpURL("alice+tag@example.com", rfc6238Secret)
+	want := "otpauth://totp/Chirpy:alice+tag@example.com?issuer=Chirpy&secret=" + rfc6238Secret
+	if got != want {
+		t.Fatalf("totpURL = %q, want %q", got, want)
+	}
+}
+
+// currentTOTP returns the code of secret at the clock of the test server
+func (ts *testServer) currentTOTP(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totpCode(secret, ts.clock.Now().Unix()/totpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// totpChallenge logs in an account with 2fa and returns the challenge token
+func (ts *testServer) totpChallenge(t *testing.T, email string) string {
+	t.Helper()
+	resp := ts.request(t, "POST", "/api/login", apitypes.UserRequest{Email: email, Password: testPassword}).expect(t, http.StatusUnauthorized)
+	challenge := decode[struct {
+		ErrorCode      string `json:"error_code"`
+		ChallengeToken string `json:"challenge_token"`
+	}](t, resp)
+	if challenge.ErrorCode != "totp_required" || challenge.ChallengeToken == "" {
+		t.Fatalf("login of a totp account answered %s", resp.body)
+	}
+	return challenge.ChallengeToken
+}
+
+func TestTOTPEnrolment(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+
+	ts.request(t, "POST", "/api/users/me/totp/verify", totpRequest{Code: "000000"}, bearer(alice.Token)...).expect(t, http.StatusBadRequest)
+	ts.request(t, "POST", "/api/users/me/totp/setup", nil).expect(t, http.StatusUnauthorized)
+	setup := decode[struct {
+		Secret     string `json:"secret"`
+		OTPAuthURL string `json:"otpauth_url"`
+	}](t, ts.request(t, "POST", "/api/users/me/totp/setup", nil, bearer(alice.Token)...).expect(t, http.StatusOK))
+	if len(setup.Secret) != 32 || setup.OTPAuthURL != totpURL("alice@example.com", setup.Secret) {
+		t.Fatalf("setup = %+v", setup)
+	}
+	// pending until verified, login still works without a code
+	ts.login(t, "alice@example.com")
+
+	tests := []struct {
+		name   string
+		code   string
+		status int
+	}{
+		{"wrong code", "000000", http.StatusUnauthorized},
+		{"code of another secret", ts.currentTOTP(t, rfc6238Secret), http.StatusUnauthorized},
+		{"two steps old", func() string {
+			code, _ := totpCode(setup.Secret, ts.clock.Now().Unix()/totpPeriod-2)
+			return code
+		}(), http.StatusUnauthorized},
+		{"one step old", func() string {
+			code, _ := totpCode(setup.Secret, ts.clock.Now().Unix()/totpPeriod-1)
+			return code
+		}(), http.StatusOK},
+	}
+	var backup struct {
+		BackupCodes []string `json:"backup_codes"`
+	}
+	for _, tt := range tests {
+		resp := ts.request(t, "POST", "/api/users/me/totp/verify", totpRequest{Code: tt.code}, bearer(alice.Token)...)
+		resp.expect(t, tt.status)
+		if tt.status == http.StatusOK {
+			backup = decode[struct {
+				BackupCodes []string `json:"backup_codes"`
+			}](t, resp)
+		}
+	}
+	if len(backup.BackupCodes) != totpBackupCodeCount {
+		t.Fatalf("backup codes = %v, want %d", backup.BackupCodes, totpBackupCodeCount)
+	}
+	user, err := ts.store.GetUserByID(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.TOTPEnabled || user.TOTPSecret != setup.Secret || user.TOTPPendingSecret != "" {
+		t.Fatalf("user after verifying = %+v", user)
+	}
+	for _, hash := range user.TOTPBackupCodes {
+		for _, code := range backup.BackupCodes {
+			if strings.Contains(hash, code) {
+				t.Fatal("backup codes are stored in plain text")
+			}
+		}
+	}
+	ts.totpChallenge(t, "alice@example.com")
+}
+
+func TestTOTPLogin(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	ts.newUser(t, "bob@example.com")
+	if err := ts.store.SetPendingTOTP(alice.ID, rfc6238Secret); err != nil {
+		t.Fatal(err)
+	}
+	codes := []string{"aaaaaaaaaa", "bbbbbbbbbb"}
+	if err := ts.store.EnableTOTP(alice.ID, []string{hashBackupCode(codes[0]), hashBackupCode(codes[1])}); err != nil {
+		t.Fatal(err)
+	}
+	bob := ts.login(t, "bob@example.com")
+
+	tests := []struct {
+		name      string
+		challenge func() string
+		code      func() string
+		status    int
+	}{
+		{"wrong code", func() string { return ts.totpChallenge(t, "alice@example.com") }, func() string { return "000000" }, http.StatusUnauthorized},
+		{"no challenge", func() string { return "" }, func() string { return ts.currentTOTP(t, rfc6238Secret) }, http.StatusUnauthorized},
+		{"access token as challenge", func() string { return bob.Token }, func() string { return ts.currentTOTP(t, rfc6238Secret) }, http.StatusUnauthorized},
+		{"current code", func() string { return ts.totpChallenge(t, "alice@example.com") }, func() string { return ts.currentTOTP(t, rfc6238Secret) }, http.StatusOK},
+		{"code of the last step", func() string { return ts.totpChallenge(t, "alice@example.com") }, func() string {
+			code := ts.currentTOTP(t, rfc6238Secret)
+			ts.clock.Advance(totpPeriod * time.Second)
+			return code
+		}, http.StatusOK},
+		{"code two steps old", func() string { return ts.totpChallenge(t, "alice@example.com") }, func() string {
+			code := ts.currentTOTP(t, rfc6238Secret)
+			ts.clock.Advance(2 * totpPeriod * time.Second)
+			return code
+		}, http.StatusUnauthorized},
+		{"backup code", func() string { return ts.totpChallenge(t, "alice@example.com") }, func() string { return codes[0] }, http.StatusOK},
+		{"reused backup code", func() string { return ts.totpChallenge(t, "alice@example.com") }, func() string { return codes[0] }, http.StatusUnauthorized},
+		{"other backup code", func() string { return ts.totpChallenge(t, "alice@example.com") }, func() string { return codes[1] }, http.StatusOK},
+		{"expired challenge", func() string {
+			challenge := ts.totpChallenge(t, "alice@example.com")
+			ts.clock.Advance(totpChallengeTTL + time.Second)
+			return challenge
+		}, func() string { return ts.currentTOTP(t, rfc6238Secret) }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := totpRequest{ChallengeToken: tt.challenge(), Code: tt.code()}
+		resp := ts.request(t, "POST", "/api/login/totp", req)
+		if resp.status != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, resp.status, tt.status, resp.body)
+			continue
+		}
+		if tt.status == http.StatusOK {
+			if login := decode[apitypes.LoginResponse](t, resp); login.ID != alice.ID || login.Token == "" {
+				t.Errorf("%s: login = %+v", tt.name, login)
+			}
+		}
+	}
+}
+
+func TestTOTPDisable(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	ts.request(t, "POST", "/api/users/me/totp/disable", totpRequest{Password: testPassword, Code: "000000"}, bearer(alice.Token)...).expect(t, http.StatusBadRequest)
+	if err := ts.store.SetPendingTOTP(alice.ID, rfc6238Secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.EnableTOTP(alice.ID, []string{hashBackupCode("aaaaaaaaaa")}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		code     string
+		status   int
+	}{
+		{"no password", "", "aaaaaaaaaa", http.StatusUnauthorized},
+		{"wrong password", "wrong", "aaaaaaaaaa", http.StatusUnauthorized},
+		{"no code", testPassword, "", http.StatusUnauthorized},
+		{"wrong code", testPassword, "000000", http.StatusUnauthorized},
+		{"password and code", testPassword, ts.currentTOTP(t, rfc6238Secret), http.StatusNoContent},
+		{"already disabled", testPassword, ts.currentTOTP(t, rfc6238Secret), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		ts.request(t, "POST", "/api/users/me/totp/disable", totpRequest{Password: tt.password, Code: tt.code}, bearer(alice.Token)...).expect(t, tt.status)
+	}
+	// login needs no code again and the secrets are gone
+	ts.login(t, "alice@example.com")
+	user, err := ts.store.GetUserByID(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TOTPEnabled || user.TOTPSecret != "" || len(user.TOTPBackupCodes) != 0 {
+		t.Fatalf("user after disabling = %+v", user)
+	}
+}
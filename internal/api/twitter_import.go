@@ -0,0 +1,291 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+const twitterImportBatchSize = 100
+
+// archiveTweet is the part of an archived tweet we import
+type archiveTweet struct {
+	ID        string
+	Text      string
+	CreatedAt time.Time
+	Retweet   bool
+}
+
+// rawArchiveTweet covers the field names used across archive versions
+type rawArchiveTweet struct {
+	ID        string `json:"id_str"`
+	FullText  string `json:"full_text"`
+	Text      string `json:"text"`
+	CreatedAt string `json:"created_at"`
+	Retweeted bool   `json:"retweeted"`
+	// present on tweets taken from the api rather than the archive
+	RetweetedStatus json.RawMessage `json:"retweeted_status"`
+}
+
+// archives wrap the json array in an assignment like `window.YTD.tweets.part0 = [`
+var archivePrefix = regexp.MustCompile(`^\s*window\.YTD\.[A-Za-z0-9_.]+\s*=\s*`)
+
+// tweet files inside an archive zip: data/tweets.js in current archives,
+// data/tweet.js in older ones, either may be split into -partN files
+var archiveTweetFile = regexp.MustCompile(`^(data/)?tweets?(-part\d+)?\.js$`)
+
+// readTwitterArchive reads the tweets from an archive zip, a tweets.js
+// file or a plain tweet.json export
+func readTwitterArchive(file string) ([]archiveTweet, error) {
+	if strings.EqualFold(path.Ext(file), ".zip") {
+		return readTwitterArchiveZip(file)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseTwitterArchiveFile(b)
+}
+
+func readTwitterArchiveZip(file string) ([]archiveTweet, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var tweets []archiveTweet
+	found := false
+	for _, f := range zr.File {
+		if !archiveTweetFile.MatchString(f.Name) {
+			continue
+		}
+		found = true
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		part, err := parseTwitterArchiveFile(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		tweets = append(tweets, part...)
+	}
+	if !found {
+		return nil, errors.New("no tweets.js or tweet.js in archive")
+	}
+	return tweets, nil
+}
+
+// parseTwitterArchiveFile parses one tweet file. entries are either wrapped
+// as {"tweet": {...}} (current archives) or the tweet object itself.
+func parseTwitterArchiveFile(b []byte) ([]archiveTweet, error) {
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	b = archivePrefix.ReplaceAll(b, nil)
+
+	var entries []json.RawMessage
+	err := json.Unmarshal(b, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("not a tweet archive file: %w", err)
+	}
+
+	tweets := make([]archiveTweet, 0, len(entries))
+	for i, entry := range entries {
+		var wrapped struct {
+			Tweet *rawArchiveTweet `json:"tweet"`
+		}
+		err := json.Unmarshal(entry, &wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		raw := wrapped.Tweet
+		if raw == nil {
+			raw = &rawArchiveTweet{}
+			err = json.Unmarshal(entry, raw)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: %w", i, err)
+			}
+		}
+
+		tweet, err := raw.toArchiveTweet()
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		tweets = append(tweets, tweet)
+	}
+	return tweets, nil
+}
+
+func (raw *rawArchiveTweet) toArchiveTweet() (archiveTweet, error) {
+	text := raw.FullText
+	if text == "" {
+		text = raw.Text
+	}
+	createdAt, err := time.Parse(time.RubyDate, raw.CreatedAt)
+	if err != nil {
+		return archiveTweet{}, fmt.Errorf("invalid created_at %q", raw.CreatedAt)
+	}
+	// archives escape the text as html
+	text = html.UnescapeString(text)
+	return archiveTweet{
+		ID:        raw.ID,
+		Text:      text,
+		CreatedAt: createdAt,
+		Retweet:   raw.Retweeted || len(raw.RetweetedStatus) > 0 || strings.HasPrefix(text, "RT @"),
+	}, nil
+}
+
+// splitChirpBody splits body into chirps of at most maxChirpLength runes,
+// breaking between words where it can
+func splitChirpBody(body string) []string {
+	var parts []string
+	var current []rune
+	for _, word := range strings.Fields(body) {
+		w := []rune(word)
+		for len(w) > maxChirpLength {
+			if len(current) > 0 {
+				parts = append(parts, string(current))
+				current = nil
+			}
+			parts = append(parts, string(w[:maxChirpLength]))
+			w = w[maxChirpLength:]
+		}
+		if len(current) > 0 && len(current)+1+len(w) > maxChirpLength {
+			parts = append(parts, string(current))
+			current = nil
+		}
+		if len(current) > 0 {
+			current = append(current, ' ')
+		}
+		current = append(current, w...)
+	}
+	if len(current) > 0 {
+		parts = append(parts, string(current))
+	}
+	return parts
+}
+
+// twitterImportSummary counts what the import did with each tweet
+type twitterImportSummary struct {
+	Tweets          int
+	Chirps          int
+	SkippedRetweets int
+	SkippedLong     int
+	SkippedEmpty    int
+	Truncated       int
+	Split           int
+}
+
+// tweetsToChirps maps tweets to chirps oldest first according to the
+// long and retweets flags
+func tweetsToChirps(tweets []archiveTweet, long, retweets string) ([]database.Chirp, twitterImportSummary) {
+	sort.SliceStable(tweets, func(i, j int) bool { return tweets[i].CreatedAt.Before(tweets[j].CreatedAt) })
+
+	summary := twitterImportSummary{Tweets: len(tweets)}
+	chirps := make([]database.Chirp, 0, len(tweets))
+	for _, tweet := range tweets {
+		if tweet.Retweet && retweets == "skip" {
+			summary.SkippedRetweets++
+			continue
+		}
+		body := strings.TrimSpace(tweet.Text)
+		if body == "" {
+			summary.SkippedEmpty++
+			continue
+		}
+
+		bodies := []string{body}
+		if len([]rune(body)) > maxChirpLength {
+			switch long {
+			case "skip":
+				summary.SkippedLong++
+				continue
+			case "split":
+				bodies = splitChirpBody(body)
+				summary.Split++
+			default:
+				bodies = []string{string([]rune(body)[:maxChirpLength])}
+				summary.Truncated++
+			}
+		}
+
+		for _, b := range bodies {
+			createdAt := tweet.CreatedAt
+			chirps = append(chirps, database.Chirp{
+				Body:      cleanChirpBody(b),
+				CreatedAt: &createdAt,
+			})
+		}
+	}
+	summary.Chirps = len(chirps)
+	return chirps, summary
+}
+
+// runImportTwitterCommand implements `chirpy import-twitter` against the
+// database files in the working directory
+func runImportTwitterCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("import-twitter", flag.ContinueOnError)
+	file := fs.String("file", "", "Twitter archive zip, tweets.js or tweet.json")
+	userID := fs.Int("user", 0, "ID of the user the chirps are imported for")
+	long := fs.String("long", "truncate", "What to do with tweets over 140 characters: truncate, split or skip")
+	retweets := fs.String("retweets", "skip", "What to do with retweets: skip or convert to plain chirps")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" || *userID <= 0 {
+		return errors.New("import-twitter needs --file and --user")
+	}
+	if *long != "truncate" && *long != "split" && *long != "skip" {
+		return fmt.Errorf("invalid --long %q", *long)
+	}
+	if *retweets != "skip" && *retweets != "convert" {
+		return fmt.Errorf("invalid --retweets %q", *retweets)
+	}
+
+	db, err := OpenStore(".")
+	if err != nil {
+		return err
+	}
+	if _, err := db.GetUserByID(*userID); err != nil {
+		return fmt.Errorf("user %d: %w", *userID, err)
+	}
+
+	tweets, err := readTwitterArchive(*file)
+	if err != nil {
+		return err
+	}
+	chirps, summary := tweetsToChirps(tweets, *long, *retweets)
+
+	for start := 0; start < len(chirps); start += twitterImportBatchSize {
+		end := min(start+twitterImportBatchSize, len(chirps))
+		_, err := db.ImportChirps(*userID, chirps[start:end])
+		if err != nil {
+			return fmt.Errorf("imported %d of %d chirps: %w", start, len(chirps), err)
+		}
+		log.Printf("imported %d/%d chirps", end, len(chirps))
+	}
+
+	fmt.Fprintf(out, "read %d tweets, imported %d chirps\n", summary.Tweets, summary.Chirps)
+	fmt.Fprintf(out, "truncated %d, split %d\n", summary.Truncated, summary.Split)
+	fmt.Fprintf(out, "skipped %d retweets, %d too long, %d empty\n", summary.SkippedRetweets, summary.SkippedLong, summary.SkippedEmpty)
+	return nil
+}
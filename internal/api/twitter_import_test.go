@@ -0,0 +1,235 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// tweetFixture reads testdata/twitter/name
+func tweetFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "twitter", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// writeArchiveZip writes a zip of files, name to contents, to a temp file
+func writeArchiveZip(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, b := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "archive.zip")
+	if err := os.WriteFile(file, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestParseTwitterArchiveFile(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse(time.RubyDate, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	shapes := map[string][]archiveTweet{
+		// current archives: a bom, the window.YTD prefix and wrapped entries
+		"tweets.js": {
+			{ID: "1700000000000000001", Text: "hello & welcome to my archive", CreatedAt: day("Sat Sep 09 10:00:00 +0000 2023")},
+			{ID: "1700000000000000002", Text: "RT @someone: a tweet of someone else", CreatedAt: day("Fri Sep 08 10:00:00 +0000 2023"), Retweet: true},
+		},
+		// older archives: bare entries with text instead of full_text
+		"tweet.js": {
+			{ID: "900000000000000001", Text: "an old tweet from before full_text", CreatedAt: day("Mon Aug 21 18:30:00 +0000 2017")},
+		},
+		// api exports: a plain array, retweets marked by retweeted_status
+		"tweet.json": {
+			{ID: "1600000000000000001", Text: "taken from the api", CreatedAt: day("Wed Dec 07 08:15:00 +0000 2022")},
+			{ID: "1600000000000000002", Text: "a kept retweet", CreatedAt: day("Wed Dec 07 09:15:00 +0000 2022"), Retweet: true},
+			{ID: "1600000000000000003", Text: "   ", CreatedAt: day("Wed Dec 07 10:15:00 +0000 2022")},
+		},
+	}
+	for name, want := range shapes {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseTwitterArchiveFile(tweetFixture(t, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(got, want, func(a, b archiveTweet) bool {
+				return a.ID == b.ID && a.Text == b.Text && a.CreatedAt.Equal(b.CreatedAt) && a.Retweet == b.Retweet
+			}) {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	for name, file := range map[string]string{
+		"not json":      "window.YTD.tweets.part0 = {",
+		"not an array":  `{"tweet": {}}`,
+		"bad timestamp": `[{"tweet": {"id_str": "1", "full_text": "x", "created_at": "yesterday"}}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseTwitterArchiveFile([]byte(file)); err == nil {
+				t.Fatal("no error")
+			}
+		})
+	}
+}
+
+func TestReadTwitterArchiveZip(t *testing.T) {
+	file := writeArchiveZip(t, map[string][]byte{
+		"data/tweets.js":       tweetFixture(t, "tweets.js"),
+		"data/tweets-part1.js": tweetFixture(t, "tweet.js"),
+		"data/like.js":         []byte("window.YTD.like.part0 = [ {} ]"),
+	})
+	tweets, err := readTwitterArchive(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tweets) != 3 {
+		t.Fatalf("read %d tweets of both parts, want 3: %+v", len(tweets), tweets)
+	}
+
+	empty := writeArchiveZip(t, map[string][]byte{"data/like.js": []byte("[]")})
+	if _, err := readTwitterArchive(empty); err == nil || !strings.Contains(err.Error(), "no tweets.js") {
+		t.Fatalf("archive without tweets: %v", err)
+	}
+}
+
+func TestTweetsToChirps(t *testing.T) {
+	start := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	long := strings.Repeat("word ", 40) // 200 runes, 160 of them after trimming
+	tweets := func() []archiveTweet {
+		return []archiveTweet{
+			{ID: "3", Text: long, CreatedAt: start.Add(3 * time.Hour)},
+			{ID: "1", Text: "first", CreatedAt: start.Add(time.Hour)},
+			{ID: "2", Text: "RT @other: theirs", CreatedAt: start.Add(2 * time.Hour), Retweet: true},
+			{ID: "4", Text: " ", CreatedAt: start.Add(4 * time.Hour)},
+		}
+	}
+	bodies := func(chirps []database.Chirp) []string {
+		var b []string
+		for _, c := range chirps {
+			b = append(b, c.Body)
+		}
+		return b
+	}
+	trimmed := strings.TrimSpace(long)
+
+	cases := []struct {
+		long, retweets string
+		bodies         []string
+		summary        twitterImportSummary
+	}{
+		{"truncate", "skip", []string{"first", trimmed[:maxChirpLength]},
+			twitterImportSummary{Tweets: 4, Chirps: 2, SkippedRetweets: 1, SkippedEmpty: 1, Truncated: 1}},
+		{"split", "convert", []string{"first", "RT @other: theirs", strings.Repeat("word ", 27) + "word", strings.Repeat("word ", 11) + "word"},
+			twitterImportSummary{Tweets: 4, Chirps: 4, SkippedEmpty: 1, Split: 1}},
+		{"skip", "skip", []string{"first"},
+			twitterImportSummary{Tweets: 4, Chirps: 1, SkippedRetweets: 1, SkippedLong: 1, SkippedEmpty: 1}},
+	}
+	for _, c := range cases {
+		t.Run(c.long+"/"+c.retweets, func(t *testing.T) {
+			chirps, summary := tweetsToChirps(tweets(), c.long, c.retweets)
+			if got := bodies(chirps); !slices.Equal(got, c.bodies) {
+				t.Fatalf("bodies = %q, want %q", got, c.bodies)
+			}
+			if summary != c.summary {
+				t.Fatalf("summary = %+v, want %+v", summary, c.summary)
+			}
+			// oldest first, with the time of the tweet
+			for i := 1; i < len(chirps); i++ {
+				if chirps[i].CreatedAt.Before(*chirps[i-1].CreatedAt) {
+					t.Fatalf("chirp %d is older than chirp %d", i, i-1)
+				}
+			}
+			if !chirps[0].CreatedAt.Equal(start.Add(time.Hour)) {
+				t.Fatalf("created at %s, want the time of the tweet", chirps[0].CreatedAt)
+			}
+		})
+	}
+}
+
+func TestSplitChirpBody(t *testing.T) {
+	word := strings.Repeat("x", maxChirpLength+10)
+	got := splitChirpBody("short " + word + " tail")
+	want := []string{"short", word[:maxChirpLength], word[maxChirpLength:] + " tail"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for _, part := range splitChirpBody(strings.Repeat("ab ", 200)) {
+		if n := len([]rune(part)); n > maxChirpLength {
+			t.Fatalf("part of %d runes", n)
+		}
+	}
+}
+
+func TestImportTwitterCommand(t *testing.T) {
+	setTestEnv(t)
+	file := writeArchiveZip(t, map[string][]byte{"data/tweets.js": tweetFixture(t, "tweets.js")})
+	dir := t.TempDir()
+	chdir(t, dir)
+	db, err := openDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("alice@example.com", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runImportTwitterCommand([]string{"--file", file, "--user", "1"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"read 2 tweets, imported 1 chirps", "skipped 1 retweets, 0 too long, 0 empty"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("summary has no %q:\n%s", line, out.String())
+		}
+	}
+	reopened, err := openDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirps, err := reopened.GetChirpsByAuthorID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 1 || chirps[0].Body != "hello & welcome to my archive" {
+		t.Fatalf("imported %+v", chirps)
+	}
+
+	for name, args := range map[string][]string{
+		"no file":            {"--user", "1"},
+		"unknown user":       {"--file", file, "--user", "2"},
+		"invalid --long":     {"--file", file, "--user", "1", "--long", "wrap"},
+		"invalid --retweets": {"--file", file, "--user", "1", "--retweets", "quote"},
+	} {
+		if err := runImportTwitterCommand(args, &out); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// validateToken checks validity of token from header.
+// it returns string token if it is valid or error if it is not.
+func (a *apiConfig) validateToken(r *http.Request) (*jwt.Token, error) {
+	authHeader := r.Header.Get("Authorization")
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, errors.New("invalid token")
+	}
+	tokenFromHeader := parts[1]
+
+	return a.parseToken(tokenFromHeader)
+}
+
+var (
+	errTokenSignature = errors.New("invalid token signature")
+	errTokenExpired   = errors.New("token is expired")
+)
+
+// jwtSecrets returns the keys tokens are verified with. JWT_SECRETS is a
+// comma separated list whose first entry signs new tokens, the others keep
+// tokens signed before a rotation valid until they expire. without it
+// JWT_SECRET is the only key.
+func jwtSecrets() []string {
+	secrets := make([]string, 0)
+	for _, secret := range strings.Split(os.Getenv("JWT_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	if len(secrets) == 0 {
+		return []string{os.Getenv("JWT_SECRET")}
+	}
+	return secrets
+}
+
+// signingSecret is the key new tokens are signed with
+func signingSecret() []byte {
+	return []byte(jwtSecrets()[0])
+}
+
+// parseToken checks validity of a raw token string against every key of
+// jwtSecrets. a signature no key matches is errTokenSignature, a token
+// that verifies but is past its expiry errTokenExpired.
+func (a *apiConfig) parseToken(tokenString string) (*jwt.Token, error) {
+	for _, secret := range jwtSecrets() {
+		token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(t *jwt.Token) (interface{}, error) {
+			return []byte(secret), nil
+		}, jwt.WithTimeFunc(a.clock.Now))
+		// the signature is checked before the claims, so only this error
+		// means another key could still verify the token
+		if errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			continue
+		}
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, errTokenExpired
+		}
+		if err != nil {
+			return nil, err
+		}
+		return token, nil
+	}
+	return nil, errTokenSignature
+}
+
+// check if token's type is access token
+// return true if it is access token
+func isAcessToken(claimsIssuer string) bool {
+	return claimsIssuer == "chirpy-access"
+}
+
+// check if token's type is refresh token
+// return true if it is access token
+func isRefreshToken(claimsIssuer string) bool {
+	return claimsIssuer == "chirpy-refresh"
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/friday1602/chirpy/internal/apitypes"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAccessTokenExpiry(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	ts.signup(t, "alice@example.com")
+	short := 60
+	logins := map[string]struct {
+		req apitypes.UserRequest
+		ttl time.Duration
+	}{
+		"default":   {apitypes.UserRequest{Email: "alice@example.com", Password: testPassword}, timeToExpireAccessToken},
+		"shortened": {apitypes.UserRequest{Email: "alice@example.com", Password: testPassword, ExpiresInSeconds: &short}, time.Minute},
+	}
+	for name, login := range logins {
+		t.Run(name, func(t *testing.T) {
+			token := decode[apitypes.LoginResponse](t, ts.request(t, "POST", "/api/login", login.req).expect(t, http.StatusOK)).Token
+			ts.clock.Advance(login.ttl - time.Second)
+			ts.request(t, "GET", "/api/users/me/limits", nil, bearer(token)...).expect(t, http.StatusOK)
+			ts.clock.Advance(time.Second)
+			resp := ts.request(t, "GET", "/api/users/me/limits", nil, bearer(token)...).expect(t, http.StatusUnauthorized)
+			if got := errorOf(t, resp); got != errTokenExpired.Error() {
+				t.Fatalf("expired access token: %q, want %q", got, errTokenExpired)
+			}
+		})
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	ts.signup(t, "alice@example.com")
+	t.Setenv("JWT_SECRETS", "old-secret")
+	old := ts.login(t, "alice@example.com")
+
+	// the new key signs while tokens of the old one keep working
+	t.Setenv("JWT_SECRETS", "new-secret, old-secret")
+	ts.request(t, "GET", "/api/users/me/limits", nil, bearer(old.Token)...).expect(t, http.StatusOK)
+	current := ts.login(t, "alice@example.com")
+	ts.request(t, "GET", "/api/users/me/limits", nil, bearer(current.Token)...).expect(t, http.StatusOK)
+
+	// once the old key is retired only tokens of the new one validate
+	t.Setenv("JWT_SECRETS", "new-secret")
+	ts.request(t, "GET", "/api/users/me/limits", nil, bearer(current.Token)...).expect(t, http.StatusOK)
+	resp := ts.request(t, "GET", "/api/users/me/limits", nil, bearer(old.Token)...).expect(t, http.StatusUnauthorized)
+	if got := errorOf(t, resp); got != errTokenSignature.Error() {
+		t.Fatalf("token of a retired key: %q, want %q", got, errTokenSignature)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer: "chirpy-access", Subject: "1", ExpiresAt: jwt.NewNumericDate(ts.clock.Now().Add(time.Hour)),
+	})
+	signed, err := forged.SignedString([]byte("unknown-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = ts.request(t, "GET", "/api/users/me/limits", nil, bearer(signed)...).expect(t, http.StatusUnauthorized)
+	if got := errorOf(t, resp); got != errTokenSignature.Error() {
+		t.Fatalf("token of an unknown key: %q, want %q", got, errTokenSignature)
+	}
+}
+
+func TestAccessTokenTTLFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration // 0 when the value is rejected
+	}{
+		{"", timeToExpireAccessToken},
+		{"1", time.Second},
+		{"120", 2 * time.Minute},
+		{"86400", maxExpireAccessToken},
+		// over the maximum is capped instead of rejected
+		{"86401", maxExpireAccessToken},
+		{"604800", maxExpireAccessToken},
+		{"99999999999", maxExpireAccessToken},
+		{"0", 0},
+		{"-60", 0},
+		{"1h", 0},
+	}
+	for _, tt := range tests {
+		got, err := accessTokenTTLFromEnv(func(string) string { return tt.value })
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("JWT_EXPIRY_SECONDS=%q: %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("JWT_EXPIRY_SECONDS=%q: %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestOneSecondAccessToken(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("JWT_EXPIRY_SECONDS", "120")
+	ts := newTestServer(t)
+	ts.signup(t, "alice@example.com")
+	login := func(seconds *int) testResponse {
+		t.Helper()
+		return ts.request(t, "POST", "/api/login", apitypes.UserRequest{Email: "alice@example.com", Password: testPassword, ExpiresInSeconds: seconds})
+	}
+
+	// expires_in is the configured lifetime, a login can only shorten it
+	one, longer, zero, negative := 1, 3600, 0, -5
+	if got := decode[apitypes.LoginResponse](t, login(nil).expect(t, http.StatusOK)).ExpiresIn; got != 120 {
+		t.Fatalf("expires_in of a plain login = %d, want 120", got)
+	}
+	if got := decode[apitypes.LoginResponse](t, login(&longer).expect(t, http.StatusOK)).ExpiresIn; got != 120 {
+		t.Fatalf("expires_in asking for more than the maximum = %d, want 120", got)
+	}
+	login(&zero).expect(t, http.StatusBadRequest)
+	login(&negative).expect(t, http.StatusBadRequest)
+
+	session := decode[apitypes.LoginResponse](t, login(&one).expect(t, http.StatusOK))
+	if session.ExpiresIn != 1 {
+		t.Fatalf("expires_in = %d, want 1", session.ExpiresIn)
+	}
+	ts.postChirp(t, session.Token, "quick")
+	ts.clock.Advance(time.Second)
+	protected := []struct {
+		method, path string
+		body         any
+	}{
+		{"POST", "/api/chirps", apitypes.CreateChirpRequest{Body: "too late"}},
+		{"PUT", "/api/chirps/1", apitypes.UpdateChirpRequest{Body: "too late"}},
+		{"DELETE", "/api/chirps/1", nil},
+		{"PUT", "/api/users", apitypes.UserRequest{Email: "alice@example.com", Password: testPassword}},
+		{"GET", "/api/users/me/limits", nil},
+		{"GET", "/api/feed", nil},
+		{"DELETE", "/api/users", nil},
+	}
+	for _, p := range protected {
+		resp := ts.request(t, p.method, p.path, p.body, bearer(session.Token)...).expect(t, http.StatusUnauthorized)
+		if got := errorOf(t, resp); got != errTokenExpired.Error() {
+			t.Errorf("%s %s with an expired token: %q, want %q", p.method, p.path, got, errTokenExpired)
+		}
+	}
+
+	// the refresh token keeps the session as short
+	refreshed := decode[apitypes.RefreshResponse](t, ts.request(t, "POST", "/api/refresh", nil, bearer(session.RefreshToken)...).expect(t, http.StatusOK))
+	if refreshed.ExpiresIn != 1 {
+		t.Fatalf("expires_in after a refresh = %d, want 1", refreshed.ExpiresIn)
+	}
+	ts.request(t, "GET", "/api/users/me/limits", nil, bearer(refreshed.Token)...).expect(t, http.StatusOK)
+	ts.clock.Advance(time.Second)
+	ts.request(t, "GET", "/api/users/me/limits", nil, bearer(refreshed.Token)...).expect(t, http.StatusUnauthorized)
+}
+
+func TestAccessTokenTTLCapped(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("JWT_EXPIRY_SECONDS", "604800")
+	ts := newTestServer(t)
+	login := ts.newUser(t, "alice@example.com")
+	if want := int(maxExpireAccessToken.Seconds()); login.ExpiresIn != want {
+		t.Fatalf("expires_in with a week configured = %d, want %d", login.ExpiresIn, want)
+	}
+	ts.clock.Advance(maxExpireAccessToken)
+	ts.request(t, "GET", "/api/users/me/limits", nil, bearer(login.Token)...).expect(t, http.StatusUnauthorized)
+}
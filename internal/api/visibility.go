@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/friday1602/chirpy/database"
+)
+
+// followState is where a viewer stands with an author in the follow graph
+type followState int
+
+const (
+	notFollowing    followState = iota
+	followRequested             // waiting for a protected author to approve
+	following
+)
+
+// chirpsVisible is the one visibility decision every read path makes:
+// whether viewerID, 0 when anonymous, may read the chirps of author. a
+// protected author's chirps are followers-only, a pending request doesn't
+// make the viewer a follower.
+func chirpsVisible(viewerID int, author database.User, state followState) bool {
+	if !author.Protected {
+		return true
+	}
+	if viewerID == 0 {
+		return false
+	}
+	return viewerID == author.ID || state == following
+}
+
+// followStateOf returns how followerID follows followeeID
+func (a *apiConfig) followStateOf(followerID, followeeID int) (followState, error) {
+	if followerID == 0 {
+		return notFollowing, nil
+	}
+	follow, err := a.follows.GetFollow(followerID, followeeID)
+	if errors.Is(err, database.ErrFollowNotFound) {
+		return notFollowing, nil
+	}
+	if err != nil {
+		return notFollowing, err
+	}
+	if follow.Pending {
+		return followRequested, nil
+	}
+	return following, nil
+}
+
+// viewerID is the user the request is authenticated as, 0 for anonymous
+// requests and for tokens that aren't valid access tokens
+func (a *apiConfig) viewerID(r *http.Request) int {
+	if r.Header.Get("Authorization") == "" {
+		return 0
+	}
+	user, ok := a.accessTokenUser(r)
+	if !ok {
+		return 0
+	}
+	return user.ID
+}
+
+// chirpVisibility is what the viewer of a listing can't see
+type chirpVisibility struct {
+	viewerID int
+	hidden   []int // protected authors whose chirps the viewer can't read
+	varies   bool  // some user is protected, so responses depend on the viewer
+}
+
+// chirpVisibility decides for every protected user whether the viewer of r
+// may read their chirps. without protected users it doesn't look at the
+// request at all.
+func (a *apiConfig) chirpVisibility(r *http.Request) (chirpVisibility, error) {
+	protected, err := a.db.ProtectedUserIDs()
+	if err != nil || len(protected) == 0 {
+		return chirpVisibility{}, err
+	}
+	vis := chirpVisibility{viewerID: a.viewerID(r), varies: true}
+	followed := make(map[int]bool)
+	if vis.viewerID != 0 {
+		IDs, err := a.follows.Following(vis.viewerID)
+		if err != nil {
+			return chirpVisibility{}, err
+		}
+		for _, ID := range IDs {
+			followed[ID] = true
+		}
+	}
+	for _, ID := range protected {
+		state := notFollowing
+		if followed[ID] {
+			state = following
+		}
+		if !chirpsVisible(vis.viewerID, database.User{ID: ID, Protected: true}, state) {
+			vis.hidden = append(vis.hidden, ID)
+		}
+	}
+	return vis, nil
+}
+
+// canSee reports whether the viewer may read the chirps of authorID
+func (vis chirpVisibility) canSee(authorID int) bool {
+	return !slices.Contains(vis.hidden, authorID)
+}
+
+// etagSuffix tells apart the listings of viewers who see different
+// authors, empty when the viewer sees everyone
+func (vis chirpVisibility) etagSuffix() string {
+	if len(vis.hidden) == 0 {
+		return ""
+	}
+	h := fnv.New64a()
+	for _, ID := range vis.hidden {
+		h.Write(strconv.AppendInt(nil, int64(ID), 10))
+		h.Write([]byte{','})
+	}
+	return "-" + strconv.FormatUint(h.Sum64(), 36)
+}
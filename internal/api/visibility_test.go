@@ -0,0 +1,269 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/friday1602/chirpy/database"
+	"github.com/friday1602/chirpy/internal/apitypes"
+)
+
+func TestChirpsVisible(t *testing.T) {
+	const authorID, otherID = 1, 2
+	public := database.User{ID: authorID}
+	protected := database.User{ID: authorID, Protected: true}
+	// every author, viewer and follow state
+	tests := []struct {
+		name     string
+		author   database.User
+		viewerID int
+		state    followState
+		want     bool
+	}{
+		{"public, anonymous", public, 0, notFollowing, true},
+		{"public, anonymous with a stray state", public, 0, following, true},
+		{"public, self", public, authorID, notFollowing, true},
+		{"public, stranger", public, otherID, notFollowing, true},
+		{"public, requested", public, otherID, followRequested, true},
+		{"public, follower", public, otherID, following, true},
+		{"protected, anonymous", protected, 0, notFollowing, false},
+		{"protected, anonymous with a stray state", protected, 0, following, false},
+		{"protected, self", protected, authorID, notFollowing, true},
+		{"protected, self requested", protected, authorID, followRequested, true},
+		{"protected, self following", protected, authorID, following, true},
+		{"protected, stranger", protected, otherID, notFollowing, false},
+		{"protected, requested", protected, otherID, followRequested, false},
+		{"protected, follower", protected, otherID, following, true},
+	}
+	for _, tt := range tests {
+		if got := chirpsVisible(tt.viewerID, tt.author, tt.state); got != tt.want {
+			t.Errorf("%s: chirpsVisible = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// visibleChirps returns the IDs of the chirps GET /api/chirps lists with headers
+func (ts *testServer) visibleChirps(t *testing.T, headers ...string) []int {
+	t.Helper()
+	page := decode[apitypes.ListEnvelope[apitypes.Chirp]](t, ts.request(t, "GET", "/api/chirps?envelope=true", nil, headers...).expect(t, http.StatusOK))
+	IDs := make([]int, 0, len(page.Items))
+	for _, chirp := range page.Items {
+		IDs = append(IDs, chirp.ID)
+	}
+	return IDs
+}
+
+func TestProtectedAccount(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *testServer{
+		"json":   newTestServer,
+		"sqlite": newSQLiteTestServer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setTestEnv(t)
+			ts := start(t)
+			alice := ts.newUser(t, "alice@example.com")
+			bob := ts.newUser(t, "bob@example.com")
+			carol := ts.newUser(t, "carol@example.com")
+			dave := ts.newUser(t, "dave@example.com")
+			secret := ts.postChirp(t, alice.Token, "only for my followers")
+			open := ts.postChirp(t, bob.Token, "for everyone")
+			followPath := "/api/users/" + strconv.Itoa(alice.ID) + "/follow"
+
+			// bob follows before alice protects the account and keeps following
+			follow := decode[apitypes.FollowResponse](t, ts.request(t, "POST", followPath, nil, bearer(bob.Token)...).expect(t, http.StatusOK))
+			if follow.Status != apitypes.FollowStatusFollowing {
+				t.Fatalf("follow of a public account = %+v, want following", follow)
+			}
+			ts.request(t, "POST", "/api/users/"+strconv.Itoa(bob.ID)+"/follow", nil, bearer(bob.Token)...).expect(t, http.StatusBadRequest)
+			profile := decode[apitypes.UserProfile](t, ts.request(t, "PUT", "/api/users/me/privacy",
+				apitypes.PrivacyRequest{Protected: true}, bearer(alice.Token)...).expect(t, http.StatusOK))
+			if !profile.Protected {
+				t.Fatalf("profile after protecting = %+v", profile)
+			}
+			follow = decode[apitypes.FollowResponse](t, ts.request(t, "POST", followPath, nil, bearer(carol.Token)...).expect(t, http.StatusOK))
+			if follow.Status != apitypes.FollowStatusPending {
+				t.Fatalf("follow of a protected account = %+v, want pending", follow)
+			}
+			ts.request(t, "POST", followPath, nil, bearer(dave.Token)...).expect(t, http.StatusOK)
+			// following again doesn't turn bob into a request
+			follow = decode[apitypes.FollowResponse](t, ts.request(t, "POST", followPath, nil, bearer(bob.Token)...).expect(t, http.StatusOK))
+			if follow.Status != apitypes.FollowStatusFollowing {
+				t.Fatalf("bob following again = %+v, want following", follow)
+			}
+
+			// the listing of each viewer, a cache warmed by a follower leaks nothing
+			ts.request(t, "GET", chirpPath(secret.ID), nil, bearer(bob.Token)...).expect(t, http.StatusOK)
+			viewers := []struct {
+				name    string
+				headers []string
+				sees    bool
+			}{
+				{"anonymous", nil, false},
+				{"author", bearer(alice.Token), true},
+				{"follower", bearer(bob.Token), true},
+				{"requested", bearer(carol.Token), false},
+				{"invalid token", bearer("not a token"), false},
+			}
+			for _, v := range viewers {
+				want, status := []int{open.ID}, http.StatusNotFound
+				if v.sees {
+					want, status = []int{secret.ID, open.ID}, http.StatusOK
+				}
+				if got := ts.visibleChirps(t, v.headers...); !slices.Equal(got, want) {
+					t.Errorf("%s: listing = %v, want %v", v.name, got, want)
+				}
+				ts.request(t, "GET", chirpPath(secret.ID), nil, v.headers...).expect(t, status)
+				ts.request(t, "GET", chirpPath(secret.ID)+"/qr.png", nil, v.headers...).expect(t, status)
+				ts.request(t, "GET", chirpPath(open.ID), nil, v.headers...).expect(t, http.StatusOK)
+
+				profile := decode[apitypes.ProfileResponse](t, ts.request(t, "GET", "/api/users/"+strconv.Itoa(alice.ID), nil, v.headers...).expect(t, http.StatusOK))
+				if profile.ChirpCount != 1 || profile.FollowerCount == nil || *profile.FollowerCount != 1 || profile.ChirpsVisible != v.sees || len(profile.Chirps) != len(want)-1 {
+					t.Errorf("%s: profile = %+v, want 1 chirp, 1 follower and chirps shown %v", v.name, profile, v.sees)
+				}
+			}
+
+			// list feeds leave out what the caller can't read
+			list := decode[listResponse](t, ts.request(t, "POST", "/api/lists", map[string]any{"name": "friends"}, bearer(carol.Token)...).expect(t, http.StatusCreated))
+			for _, member := range []int{alice.ID, bob.ID} {
+				ts.request(t, "POST", "/api/lists/"+strconv.Itoa(list.ID)+"/members/"+strconv.Itoa(member), nil, bearer(carol.Token)...).expect(t, http.StatusOK)
+			}
+			feed := decode[apitypes.ListEnvelope[apitypes.Chirp]](t, ts.request(t, "GET", "/api/lists/"+strconv.Itoa(list.ID)+"/chirps", nil, bearer(carol.Token)...).expect(t, http.StatusOK))
+			if feed.Total != 1 || feed.Items[0].ID != open.ID {
+				t.Fatalf("list feed of carol = %+v, want only bob's chirp", feed)
+			}
+
+			// only alice sees and answers the requests, the ETag carol had goes stale
+			ts.request(t, "GET", "/api/follow_requests", nil, bearer(bob.Token)...).expect(t, http.StatusOK)
+			requests := decode[[]apitypes.FollowResponse](t, ts.request(t, "GET", "/api/follow_requests", nil, bearer(alice.Token)...).expect(t, http.StatusOK))
+			if len(requests) != 2 || requests[0].FollowerID != carol.ID || requests[1].FollowerID != dave.ID {
+				t.Fatalf("follow requests = %+v, want carol's and dave's", requests)
+			}
+			etag := ts.request(t, "GET", "/api/chirps", nil, bearer(carol.Token)...).expect(t, http.StatusOK).header.Get("ETag")
+			requestPath := "/api/follow_requests/" + strconv.Itoa(carol.ID)
+			ts.request(t, "POST", requestPath+"/approve", nil, bearer(bob.Token)...).expect(t, http.StatusNotFound)
+			follow = decode[apitypes.FollowResponse](t, ts.request(t, "POST", requestPath+"/approve", nil, bearer(alice.Token)...).expect(t, http.StatusOK))
+			if follow.Status != apitypes.FollowStatusFollowing {
+				t.Fatalf("approved follow = %+v", follow)
+			}
+			ts.request(t, "POST", requestPath+"/approve", nil, bearer(alice.Token)...).expect(t, http.StatusNotFound)
+			ts.request(t, "POST", requestPath+"/reject", nil, bearer(alice.Token)...).expect(t, http.StatusNotFound)
+			resp := ts.request(t, "GET", "/api/chirps", nil, append(bearer(carol.Token), "If-None-Match", etag)...).expect(t, http.StatusOK)
+			if resp.header.Get("ETag") == etag || resp.header.Get("Vary") == "" {
+				t.Fatalf("ETag %s after the approval, Vary %q", etag, resp.header.Get("Vary"))
+			}
+			if got := ts.visibleChirps(t, bearer(carol.Token)...); !slices.Equal(got, []int{secret.ID, open.ID}) {
+				t.Fatalf("listing of carol after the approval = %v", got)
+			}
+
+			// a rejected request is gone, dave can ask again
+			ts.request(t, "POST", "/api/follow_requests/"+strconv.Itoa(dave.ID)+"/reject", nil, bearer(alice.Token)...).expect(t, http.StatusNoContent)
+			ts.request(t, "GET", chirpPath(secret.ID), nil, bearer(dave.Token)...).expect(t, http.StatusNotFound)
+			requests = decode[[]apitypes.FollowResponse](t, ts.request(t, "GET", "/api/follow_requests", nil, bearer(alice.Token)...).expect(t, http.StatusOK))
+			if len(requests) != 0 {
+				t.Fatalf("follow requests after answering = %+v", requests)
+			}
+
+			// unfollowing and deleting the account end the follow
+			ts.request(t, "DELETE", followPath, nil, bearer(carol.Token)...).expect(t, http.StatusNoContent)
+			ts.request(t, "GET", chirpPath(secret.ID), nil, bearer(carol.Token)...).expect(t, http.StatusNotFound)
+			ts.request(t, "DELETE", "/api/users", nil, bearer(bob.Token)...).expect(t, http.StatusNoContent)
+			counts := decode[apitypes.ProfileResponse](t, ts.request(t, "GET", "/api/users/"+strconv.Itoa(alice.ID), nil).expect(t, http.StatusOK))
+			if counts.FollowerCount == nil || *counts.FollowerCount != 0 {
+				t.Fatalf("followers after deleting bob's account = %v", counts.FollowerCount)
+			}
+
+			// unprotected, everyone reads alice again
+			ts.request(t, "PUT", "/api/users/me/privacy", apitypes.PrivacyRequest{}, bearer(alice.Token)...).expect(t, http.StatusOK)
+			if got := ts.visibleChirps(t); !slices.Equal(got, []int{secret.ID}) {
+				t.Fatalf("anonymous listing after unprotecting = %v", got)
+			}
+			ts.request(t, "GET", chirpPath(secret.ID), nil).expect(t, http.StatusOK)
+		})
+	}
+}
+
+func TestFollowersVisible(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+	bob := ts.newUser(t, "bob@example.com")
+	carol := ts.newUser(t, "carol@example.com")
+	ts.request(t, "POST", "/api/users/"+strconv.Itoa(alice.ID)+"/follow", nil, bearer(bob.Token)...).expect(t, http.StatusOK)
+	ts.request(t, "POST", "/api/users/"+strconv.Itoa(carol.ID)+"/follow", nil, bearer(alice.Token)...).expect(t, http.StatusOK)
+	alicePath := "/api/users/" + strconv.Itoa(alice.ID)
+
+	profileIDs := func(resp testResponse) []int {
+		t.Helper()
+		var IDs []int
+		for _, profile := range decode[[]apitypes.UserProfile](t, resp) {
+			IDs = append(IDs, profile.ID)
+		}
+		return IDs
+	}
+	// visible by default, to everyone
+	if got := profileIDs(ts.request(t, "GET", alicePath+"/followers", nil).expect(t, http.StatusOK)); !slices.Equal(got, []int{bob.ID}) {
+		t.Fatalf("followers = %v, want bob", got)
+	}
+	if got := profileIDs(ts.request(t, "GET", alicePath+"/following", nil).expect(t, http.StatusOK)); !slices.Equal(got, []int{carol.ID}) {
+		t.Fatalf("following = %v, want carol", got)
+	}
+
+	// leaving followers_visible out keeps it, protected or not
+	settings := decode[apitypes.PrivacyResponse](t, ts.request(t, "PUT", "/api/users/me/privacy", apitypes.PrivacyRequest{}, bearer(alice.Token)...).expect(t, http.StatusOK))
+	if !settings.FollowersVisible {
+		t.Fatalf("settings = %+v, want followers visible by default", settings)
+	}
+	hidden := false
+	settings = decode[apitypes.PrivacyResponse](t, ts.request(t, "PUT", "/api/users/me/privacy", apitypes.PrivacyRequest{FollowersVisible: &hidden}, bearer(alice.Token)...).expect(t, http.StatusOK))
+	if settings.FollowersVisible || settings.Protected {
+		t.Fatalf("settings = %+v, want followers hidden", settings)
+	}
+	settings = decode[apitypes.PrivacyResponse](t, ts.request(t, "PUT", "/api/users/me/privacy", apitypes.PrivacyRequest{Protected: true}, bearer(alice.Token)...).expect(t, http.StatusOK))
+	if settings.FollowersVisible || !settings.Protected {
+		t.Fatalf("settings = %+v, want followers still hidden", settings)
+	}
+
+	viewers := []struct {
+		name    string
+		headers []string
+		sees    bool
+	}{
+		{"anonymous", nil, false},
+		{"follower", bearer(bob.Token), false},
+		{"followee", bearer(carol.Token), false},
+		{"owner", bearer(alice.Token), true},
+	}
+	for _, v := range viewers {
+		status := http.StatusForbidden
+		if v.sees {
+			status = http.StatusOK
+		}
+		ts.request(t, "GET", alicePath+"/followers", nil, v.headers...).expect(t, status)
+		ts.request(t, "GET", alicePath+"/following", nil, v.headers...).expect(t, status)
+		profile := decode[apitypes.ProfileResponse](t, ts.request(t, "GET", alicePath, nil, v.headers...).expect(t, http.StatusOK))
+		if profile.FollowersVisible != v.sees || (profile.FollowerCount != nil) != v.sees || (profile.FollowingCount != nil) != v.sees {
+			t.Errorf("%s: profile = %+v, want counts shown %v", v.name, profile, v.sees)
+		}
+		if v.sees && (*profile.FollowerCount != 1 || *profile.FollowingCount != 1) {
+			t.Errorf("%s: counts = %d, %d, want 1, 1", v.name, *profile.FollowerCount, *profile.FollowingCount)
+		}
+	}
+	// hidden counts are null on the wire
+	resp := ts.request(t, "GET", alicePath, nil).expect(t, http.StatusOK)
+	if !strings.Contains(string(resp.body), `"follower_count":null`) {
+		t.Fatalf("profile = %s, want a null follower_count", resp.body)
+	}
+	// the follow graph of others is untouched by alice's setting
+	if got := profileIDs(ts.request(t, "GET", "/api/users/"+strconv.Itoa(carol.ID)+"/followers", nil).expect(t, http.StatusOK)); !slices.Equal(got, []int{alice.ID}) {
+		t.Fatalf("followers of carol = %v, want alice", got)
+	}
+
+	visible := true
+	ts.request(t, "PUT", "/api/users/me/privacy", apitypes.PrivacyRequest{FollowersVisible: &visible}, bearer(alice.Token)...).expect(t, http.StatusOK)
+	ts.request(t, "GET", alicePath+"/followers", nil).expect(t, http.StatusOK)
+	ts.request(t, "GET", "/api/users/999/followers", nil).expect(t, http.StatusNotFound)
+	ts.request(t, "GET", "/api/users/x/following", nil).expect(t, http.StatusBadRequest)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	webhookLogSize     = 100
+	webhookBodyPreview = 256 // bytes of the payload kept per attempt
+)
+
+// webhookAttempt is one incoming polka webhook call
+type webhookAttempt struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	UserID int       `json:"user_id"`
+	Auth   string    `json:"auth"` // ok, missing or invalid
+	Status int       `json:"status"`
+	Body   string    `json:"body"`
+}
+
+// webhookLog keeps the last webhookLogSize attempts in a ring buffer
+// and counts every attempt by response status
+type webhookLog struct {
+	mux      *sync.Mutex
+	attempts [webhookLogSize]webhookAttempt
+	next     int
+	full     bool
+	statuses map[int]int
+}
+
+func newWebhookLog() *webhookLog {
+	return &webhookLog{
+		mux:      &sync.Mutex{},
+		statuses: make(map[int]int),
+	}
+}
+
+// record adds an attempt, overwriting the oldest one once the buffer is full
+func (l *webhookLog) record(attempt webhookAttempt) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.attempts[l.next] = attempt
+	l.next = (l.next + 1) % webhookLogSize
+	if l.next == 0 {
+		l.full = true
+	}
+	l.statuses[attempt.Status]++
+}
+
+// recent returns the buffered attempts, newest first
+func (l *webhookLog) recent() []webhookAttempt {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	n := l.next
+	if l.full {
+		n = webhookLogSize
+	}
+	attempts := make([]webhookAttempt, 0, n)
+	for i := 1; i <= n; i++ {
+		attempts = append(attempts, l.attempts[(l.next-i+webhookLogSize)%webhookLogSize])
+	}
+	return attempts
+}
+
+// writePrometheus writes the attempt counters by status
+func (l *webhookLog) writePrometheus(w io.Writer) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	statuses := make([]int, 0, len(l.statuses))
+	for status := range l.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+
+	fmt.Fprintln(w, "# HELP chirpy_polka_webhooks_total Incoming polka webhooks by response status.")
+	fmt.Fprintln(w, "# TYPE chirpy_polka_webhooks_total counter")
+	for _, status := range statuses {
+		fmt.Fprintf(w, "chirpy_polka_webhooks_total{status=\"%d\"} %d\n", status, l.statuses[status])
+	}
+}
+
+// webhookBody is the payload as stored in the log: the api key is
+// redacted and the result is cut to webhookBodyPreview bytes
+func webhookBody(body []byte, apiKey string) string {
+	s := string(body)
+	if apiKey != "" {
+		s = strings.ReplaceAll(s, apiKey, "[REDACTED]")
+	}
+	if len(s) > webhookBodyPreview {
+		s = strings.ToValidUTF8(s[:webhookBodyPreview], "")
+	}
+	return s
+}
+
+// statusWriter remembers the status a handler responded with
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// GET /admin/webhooks/incoming
+// incomingWebhooks shows the last polka webhook attempts, newest first
+func (a *apiConfig) incomingWebhooks(w http.ResponseWriter, r *http.Request) {
+	resp, err := json.Marshal(a.webhooks.recent())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error marshalling json")
+		return
+	}
+	w.Write(resp)
+}
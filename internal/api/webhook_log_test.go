@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWebhookLogRing(t *testing.T) {
+	l := newWebhookLog()
+	if got := l.recent(); len(got) != 0 {
+		t.Fatalf("recent of an empty log = %v", got)
+	}
+	for i := range webhookLogSize + 5 {
+		status := http.StatusNoContent
+		if i%2 == 1 {
+			status = http.StatusUnauthorized
+		}
+		l.record(webhookAttempt{UserID: i, Status: status})
+	}
+
+	// the five oldest are overwritten, the rest come newest first
+	got := l.recent()
+	if len(got) != webhookLogSize {
+		t.Fatalf("recent kept %d attempts, want %d", len(got), webhookLogSize)
+	}
+	for i, attempt := range got {
+		if want := webhookLogSize + 4 - i; attempt.UserID != want {
+			t.Fatalf("recent[%d] is of user %d, want %d", i, attempt.UserID, want)
+		}
+	}
+
+	// the counters cover every attempt, not just the buffered ones
+	var buf bytes.Buffer
+	l.writePrometheus(&buf)
+	for _, line := range []string{
+		`chirpy_polka_webhooks_total{status="204"} 53`,
+		`chirpy_polka_webhooks_total{status="401"} 52`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("metrics are missing %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestWebhookBody(t *testing.T) {
+	if got := webhookBody([]byte(`{"key": "secret", "again": "secret"}`), "secret"); got != `{"key": "[REDACTED]", "again": "[REDACTED]"}` {
+		t.Fatalf("webhookBody = %q, the key isn't redacted", got)
+	}
+	if got := webhookBody([]byte("secret"), ""); got != "secret" {
+		t.Fatalf("webhookBody without a key = %q", got)
+	}
+	// a cut through a multibyte rune drops the partial rune
+	long := strings.Repeat("x", webhookBodyPreview-1) + "é" + strings.Repeat("y", 10)
+	got := webhookBody([]byte(long), "secret")
+	if got != strings.Repeat("x", webhookBodyPreview-1) || !utf8.ValidString(got) {
+		t.Fatalf("webhookBody of a long body = %q", got)
+	}
+}
+
+func TestIncomingWebhooks(t *testing.T) {
+	setTestEnv(t)
+	ts := newTestServer(t)
+	alice := ts.newUser(t, "alice@example.com")
+
+	body := `{"event": "user.downgraded", "data": {"user_id": 1}, "note": "` + testPolkaKey + `", "timestamp": ` + strconv.FormatInt(ts.clock.Now().Unix(), 10) + `}`
+	ts.request(t, "POST", "/api/polka/webhooks", body, "Authorization", "ApiKey "+testPolkaKey, "Content-Type", "application/json").expect(t, http.StatusNoContent)
+	ts.request(t, "POST", "/api/polka/webhooks", body, "Authorization", "ApiKey wrong", "Content-Type", "application/json").expect(t, http.StatusUnauthorized)
+	ts.request(t, "POST", "/api/polka/webhooks", body, "Content-Type", "application/json").expect(t, http.StatusUnauthorized)
+
+	// only the admin reads the log
+	ts.request(t, "GET", "/admin/webhooks/incoming", nil).expect(t, http.StatusUnauthorized)
+	ts.request(t, "GET", "/admin/webhooks/incoming", nil, bearer(alice.Token)...).expect(t, http.StatusForbidden)
+	resp := ts.request(t, "GET", "/admin/webhooks/incoming", nil, asAdmin()...).expect(t, http.StatusOK)
+	if bytes.Contains(resp.body, []byte(testPolkaKey)) {
+		t.Fatalf("the log shows the polka key: %s", resp.body)
+	}
+	var attempts []webhookAttempt
+	if err := json.Unmarshal(resp.body, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		auth   string
+		status int
+	}{{"missing", http.StatusUnauthorized}, {"invalid", http.StatusUnauthorized}, {"ok", http.StatusNoContent}}
+	if len(attempts) != len(want) {
+		t.Fatalf("attempts = %+v, want %d", attempts, len(want))
+	}
+	for i, attempt := range attempts {
+		if attempt.Auth != want[i].auth || attempt.Status != want[i].status || attempt.Event != "user.downgraded" || attempt.UserID != 1 || !strings.Contains(attempt.Body, "[REDACTED]") {
+			t.Fatalf("attempt = %+v", attempt)
+		}
+	}
+
+	resp = ts.request(t, "GET", "/admin/metrics?format=prometheus", nil, asAdmin()...).expect(t, http.StatusOK)
+	if !strings.Contains(string(resp.body), `chirpy_polka_webhooks_total{status="401"} 2`) || !strings.Contains(string(resp.body), `chirpy_polka_webhooks_total{status="204"} 1`) {
+		t.Fatalf("metrics don't count the webhooks by status:\n%s", resp.body)
+	}
+}
@@ -0,0 +1,251 @@
+// Package apitypes holds the request and response bodies of the chirpy API.
+// the server and the client package both use them so they can't drift apart.
+package apitypes
+
+import "time"
+
+// error codes sent in ErrorResponse.ErrorCode
+const (
+	ErrorCodeIdentityAlreadyLinked = "identity_already_linked"
+	ErrorCodeLastLoginMethod       = "last_login_method"
+	ErrorCodeEmailDomainNotAllowed = "email_domain_not_allowed"
+	ErrorCodeVersionConflict       = "version_conflict"
+	ErrorCodeJSONTooDeep           = "json_too_deep"
+	ErrorCodeJSONTooManyFields     = "json_too_many_fields"
+	ErrorCodeDeprecatedField       = "deprecated_field"
+	ErrorCodeEmailTaken            = "email_taken"
+)
+
+// error codes of POST /api/polka/webhooks
+const (
+	ErrorCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrorCodeBodyTooLarge         = "body_too_large"
+	ErrorCodeInvalidJSON          = "invalid_json"
+	ErrorCodeMissingTimestamp     = "missing_timestamp"
+	ErrorCodeStaleTimestamp       = "stale_timestamp"
+)
+
+// warning codes sent in Warning.Code
+const (
+	WarningCodeDeprecated = "deprecated"
+)
+
+// Warning tells the client about something in its request that still works
+// but should change, sent in the warnings array of a response
+type Warning struct {
+	Code    string    `json:"code"`
+	Field   string    `json:"field"`
+	Message string    `json:"message"`
+	Sunset  time.Time `json:"sunset"` // when the request will start failing
+}
+
+// UserRequest is the body of POST /api/users, PUT /api/users and POST /api/login
+type UserRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	// ExpectedVersion makes PUT /api/users fail with 409 if the user changed since.
+	// deprecated, send If-Match instead
+	ExpectedVersion *int `json:"expected_version,omitempty"`
+	// ExpiresInSeconds shortens the access token of POST /api/login, it is
+	// never extended past the configured lifetime
+	ExpiresInSeconds *int `json:"expires_in_seconds,omitempty"`
+}
+
+// UserResponse is a user as returned by POST and PUT /api/users
+type UserResponse struct {
+	Email       string `json:"email"`
+	ID          int    `json:"id"`
+	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Version     int    `json:"version"`
+
+	Warnings []Warning `json:"warnings,omitempty"`
+}
+
+// UserProfile is the public view of a user returned by GET /api/users
+// and GET /api/users/{userID}
+type UserProfile struct {
+	ID          int    `json:"id"`
+	Email       string `json:"email"`
+	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Protected   bool   `json:"protected"` // chirps are only shown to approved followers
+}
+
+// ProfileResponse is returned by GET /api/users/{userID}. the chirp count
+// is shown to everyone, the follow counts unless the user hides them and
+// the latest chirps only to viewers who may read them.
+type ProfileResponse struct {
+	UserProfile
+	ChirpCount       int     `json:"chirp_count"`
+	FollowerCount    *int    `json:"follower_count"`    // null unless FollowersVisible
+	FollowingCount   *int    `json:"following_count"`   // null unless FollowersVisible
+	FollowersVisible bool    `json:"followers_visible"` // the viewer may see the follow counts and lists
+	ChirpsVisible    bool    `json:"chirps_visible"`
+	Chirps           []Chirp `json:"chirps"` // newest first, empty unless ChirpsVisible
+}
+
+// PrivacyRequest is the body of PUT /api/users/me/privacy. a missing
+// followers_visible leaves the setting as it is.
+type PrivacyRequest struct {
+	Protected        bool  `json:"protected"`
+	FollowersVisible *bool `json:"followers_visible,omitempty"`
+}
+
+// PrivacyResponse is returned by PUT /api/users/me/privacy
+type PrivacyResponse struct {
+	UserProfile
+	FollowersVisible bool `json:"followers_visible"` // everyone may see who follows the user and whom they follow
+}
+
+// follow statuses sent in FollowResponse.Status
+const (
+	FollowStatusFollowing = "following"
+	FollowStatusPending   = "pending" // a protected user has yet to approve
+)
+
+// HashtagFollowResponse is returned by POST /api/hashtags/{tag}/follow
+type HashtagFollowResponse struct {
+	Tag       string    `json:"tag"` // lowercase, without the #
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FeedMatchedByUser in FeedChirp.MatchedBy means the author is the caller
+// or someone they follow. followed hashtags are listed as #tag.
+const FeedMatchedByUser = "user"
+
+// FeedChirp is an item of GET /api/feed
+type FeedChirp struct {
+	Chirp
+	MatchedBy []string `json:"matched_by"` // why the chirp is in the feed
+}
+
+// follow import statuses sent in FollowImportRow.Status
+const (
+	FollowImportFollowed         = "followed"
+	FollowImportRequested        = "requested" // sent to a protected user
+	FollowImportAlreadyFollowing = "already_following"
+	FollowImportNotFound         = "not_found"
+	FollowImportInvalid          = "invalid" // not an email, or the caller's own
+)
+
+// FollowImportRow is what POST /api/users/me/follows/import did with one
+// row of the csv, rows count from 1 without the header
+type FollowImportRow struct {
+	Row    int    `json:"row"`
+	Email  string `json:"email"`
+	Status string `json:"status"`
+}
+
+// FollowResponse is an edge of the follow graph, returned by
+// POST /api/users/{userID}/follow, GET /api/follow_requests and
+// POST /api/follow_requests/{id}/approve
+type FollowResponse struct {
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// VersionConflictResponse is the 409 of PUT /api/users, with the user as
+// it is now so the client can merge and retry with the current version
+type VersionConflictResponse struct {
+	Error     string       `json:"error"`
+	ErrorCode string       `json:"error_code"`
+	Current   UserResponse `json:"current"`
+}
+
+// LoginResponse is returned by a successful login
+type LoginResponse struct {
+	Token        string `json:"token"`
+	ExpiresIn    int    `json:"expires_in"` // seconds until the access token expires
+	RefreshToken string `json:"refresh_token"`
+	IsChirpyRed  bool   `json:"is_chirpy_red"`
+	ID           int    `json:"id"`
+	Email        string `json:"email"`
+}
+
+// RefreshResponse is returned by POST /api/refresh
+type RefreshResponse struct {
+	Token        string `json:"token"`
+	ExpiresIn    int    `json:"expires_in"`    // seconds until the access token expires
+	RefreshToken string `json:"refresh_token"` // replaces the refresh token sent, which stops working
+}
+
+// CreateChirpRequest is the body of POST /api/chirps
+type CreateChirpRequest struct {
+	Body string `json:"body"`
+	Lang string `json:"lang,omitempty"`
+	// Source labels the client posting, the X-Chirpy-Client header when unset
+	Source string `json:"source,omitempty"`
+}
+
+// UpdateChirpRequest is the body of PUT /api/chirps/{chirpID}
+type UpdateChirpRequest struct {
+	Body string `json:"body"`
+}
+
+// ValidateChirpResponse is the verdict of POST /api/chirps/validate.
+// Error holds the reason creating the chirp would fail when Valid is false.
+type ValidateChirpResponse struct {
+	Valid       bool     `json:"valid"`
+	Error       string   `json:"error,omitempty"`
+	Length      int      `json:"length"`
+	Limit       int      `json:"limit"`
+	WouldFilter []string `json:"would_filter"`
+	Lang        string   `json:"lang,omitempty"`
+	Source      string   `json:"source"`
+	Body        string   `json:"body"` // the body as it would be stored
+}
+
+// Chirp is a chirp as returned by the chirp endpoints
+type Chirp struct {
+	AuthorID  int        `json:"author_id"`
+	Body      string     `json:"body"`
+	ID        int        `json:"id"`
+	Lang      string     `json:"lang,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"` // set once the body was edited
+	BumpedAt  *time.Time `json:"bumped_at,omitempty"`  // last bump, only feeds order by it
+	SourceURL string     `json:"source_url,omitempty"`
+	Source    string     `json:"source,omitempty"`
+}
+
+// DeleteChirpResponse is returned by DELETE /api/chirps/{chirpID}
+type DeleteChirpResponse struct {
+	UndoToken string `json:"undo_token"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// ListEnvelope is the shared response shape for list endpoints
+type ListEnvelope[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// LimitsResponse is returned by GET /api/users/me/limits, the limits the
+// server enforces on the caller right now
+type LimitsResponse struct {
+	Chirp struct {
+		MaxLength    int   `json:"max_length"`     // runes
+		MaxBodyBytes int64 `json:"max_body_bytes"` // of the POST /api/chirps body
+	} `json:"chirp"`
+	Login struct {
+		MaxFailures       int        `json:"max_failures"`
+		WindowSeconds     int        `json:"window_seconds"`
+		RemainingFailures int        `json:"remaining_failures"`
+		WindowEndsAt      *time.Time `json:"window_ends_at,omitempty"`
+	} `json:"login"`
+	Query struct {
+		MaxLimit        int `json:"max_limit"`
+		MaxActivityDays int `json:"max_activity_days"`
+	} `json:"query"`
+	Features map[string]bool `json:"features"`
+}
+
+// ErrorResponse is the json error body with a machine readable code
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
@@ -1,98 +1,94 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"io/fs"
 	"log"
-	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
-	"github.com/friday1602/chirpy/database"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/friday1602/chirpy/internal/api"
 	"github.com/joho/godotenv"
 )
 
-type apiConfig struct {
-	fileserverHits int
-	db             *database.DB
-	chirpyDatabase *database.DB
-}
-type chripyParams struct {
-	Body string `json:"body"`
-}
-type user struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-}
-
-type CustomClaims struct {
-	UserID int `json:"user_id"`
-	jwt.RegisteredClaims
-}
-
 func main() {
 	dbg := flag.Bool("debug", false, "Enable debug mode")
+	selfTest := flag.Bool("self-test", false, "Run a smoke test against a throwaway database after starting and exit")
 	flag.Parse()
 
-	if *dbg {
-		err := os.Remove("chirpyDatabase.json")
+	// subcommands work on the database files and exit without serving
+	if flag.NArg() > 0 {
+		err := api.RunCommand(flag.Arg(0), flag.Args()[1:], os.Stdout)
 		if err != nil {
 			log.Fatal(err)
 		}
+		return
+	}
 
-		err = os.Remove("userDatabase.json")
-		if err != nil {
-			log.Fatal(err)
+	if *dbg {
+		for _, name := range []string{"database.json", "chirpyDatabase.json", "userDatabase.json", "jobDatabase.json", "listDatabase.json", "followDatabase.json", "syndicationDatabase.json", "storageDatabase.json", "publicKeyDatabase.json"} {
+			err := os.Remove(name)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Fatal(err)
+			}
 		}
 	}
-	
+
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("error loading .env file")
 	}
+	api.SetupLogging()
 
-	mux := http.NewServeMux()
-	apiCfg := &apiConfig{}
-	fileServer := http.FileServer(http.Dir("./app"))
-	mux.Handle("/app/*", apiCfg.middlewareMetricsInc(http.StripPrefix("/app", fileServer))) //* for wildcard
-
-	apiCfg.db, err = database.NewUserDB("userDatabase.json")
+	store, err := api.OpenStore(".")
 	if err != nil {
 		log.Fatal(err)
 	}
-	apiCfg.chirpyDatabase, err = database.NewDB("chirpyDatabase.json")
+	server, err := api.NewServer(store, api.Config{DataDir: "."})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	mux.HandleFunc("GET /admin/metrics", apiCfg.metrics)
-
-	mux.HandleFunc("/api/reset", apiCfg.reset)
-
-	fileServer = http.FileServer(http.Dir("./app/assets"))
-	mux.Handle("/app/assets/", apiCfg.middlewareMetricsInc(http.StripPrefix("/app/assets", fileServer)))
+	addr, err := api.ListenAddrFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv, err := api.NewHTTPServer(addr, server)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("starting server on %s", srv.Addr)
 
-	mux.HandleFunc("GET /api/healthz", readiness)
+	if *selfTest || os.Getenv("SELF_TEST") == "true" {
+		go func() {
+			if srv.TLSConfig != nil {
+				log.Fatal(srv.ListenAndServeTLS("", ""))
+			}
+			log.Fatal(srv.ListenAndServe())
+		}()
+		if !api.RunSelfTest(os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
 
-	mux.HandleFunc("POST /api/chirps", apiCfg.validateChirpy)
-	mux.HandleFunc("GET /api/chirps", apiCfg.getChirpy)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.getChirpyFromID)
-	mux.HandleFunc("POST /api/users", apiCfg.createUser)
-	mux.HandleFunc("POST /api/login", apiCfg.userValidation)
-	mux.HandleFunc("PUT /api/users", apiCfg.updateUser)
-	mux.HandleFunc("POST /api/refresh", apiCfg.refreshTokenAuth)
-	mux.HandleFunc("POST /api/revoke", apiCfg.revokeToken)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.deleteChirpyFromID)
-	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.upgradeToRedChirpy)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	corsMux := middlewareCors(mux)
-	log.Print("starting server on :8080")
-	port := os.Getenv("PORT")
-	srv := http.Server{
-		Addr: ":" + port,
-		Handler: corsMux,
-		ReadHeaderTimeout: 5 * time.Second,
+	// SIGHUP reloads the reloadable settings like POST /admin/config/reload
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := server.ReloadSettings(); err != nil {
+				log.Printf("config reload: %v", err)
+			}
+		}
+	}()
+	if err := server.Run(ctx, srv); err != nil {
+		log.Fatal(err)
 	}
-	err = srv.ListenAndServe()
-	log.Fatal(err)
 }
@@ -1,9 +0,0 @@
-package main
-
-import "net/http"
-
-// reset resets counts
-func (cfg *apiConfig) reset(w http.ResponseWriter, r *http.Request) {
-	cfg.fileserverHits = 0
-}
-